@@ -0,0 +1,566 @@
+package riak
+
+import (
+	"context"
+	"time"
+)
+
+// Bucket errors
+var (
+	ErrBucketSiblings = newClientError("[Bucket] object has siblings")
+)
+
+// BucketOptions are the default request options of a Bucket. Zero values are
+// not sent, so that the properties of the bucket apply.
+type BucketOptions struct {
+	// Quorums of reads and writes, either a number of nodes or one of the
+	// symbolic values QuorumOne, QuorumQuorum, QuorumAll and QuorumDefault
+	R  uint32
+	Pr uint32
+	W  uint32
+	Pw uint32
+	Dw uint32
+
+	// Timeout is the timeout of each request. A context deadline that is
+	// earlier takes precedence.
+	Timeout time.Duration
+
+	// ConflictResolver, if set, resolves siblings when fetching values
+	ConflictResolver ConflictResolver
+
+	// ContentType is the content type PutValue encodes values with, which
+	// must have a registered Codec. If empty, values are stored as JSON.
+	ContentType string
+}
+
+// Bucket executes commands on one bucket with the same request options, so
+// that reads and writes use consistent quorums. Get a Bucket with
+// Cluster.Bucket or Client.Bucket and set its options with WithOptions.
+//
+//	users := cluster.Bucket("default", "users").WithOptions(&BucketOptions{
+//		R:       QuorumQuorum,
+//		W:       QuorumQuorum,
+//		Timeout: 5 * time.Second,
+//	})
+//	response, err := users.Get(ctx, "alice")
+type Bucket struct {
+	executor   Executor
+	bucketType string
+	name       string
+	options    BucketOptions
+}
+
+// NewBucket returns a Bucket executing commands with the executor, usually a
+// Cluster. The options may be nil.
+func NewBucket(executor Executor, bucketType, name string, options *BucketOptions) *Bucket {
+	b := &Bucket{executor: executor, bucketType: bucketType, name: name}
+	if options != nil {
+		b.options = *options
+	}
+	return b
+}
+
+// Bucket returns a Bucket of the Cluster without default request options
+func (c *Cluster) Bucket(bucketType, name string) *Bucket {
+	return NewBucket(c, bucketType, name, nil)
+}
+
+// WithOptions returns a copy of the Bucket with the options replacing its
+// default request options. The ConflictResolver and ContentType of the Bucket,
+// such as the ConflictResolver of a Client, are kept unless the options set
+// them.
+func (b *Bucket) WithOptions(options *BucketOptions) *Bucket {
+	bucket := NewBucket(b.executor, b.bucketType, b.name, options)
+	if bucket.options.ConflictResolver == nil {
+		bucket.options.ConflictResolver = b.options.ConflictResolver
+	}
+	if bucket.options.ContentType == "" {
+		bucket.options.ContentType = b.options.ContentType
+	}
+	return bucket
+}
+
+// BucketType returns the bucket type of the Bucket
+func (b *Bucket) BucketType() string {
+	return b.bucketType
+}
+
+// Name returns the name of the Bucket
+func (b *Bucket) Name() string {
+	return b.name
+}
+
+// timeout returns the Timeout of the options or the time left until the
+// context's deadline, whichever is earlier
+func (b *Bucket) timeout(ctx context.Context) (time.Duration, bool, error) {
+	d, ok, err := timeout(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	if b.options.Timeout > 0 && (!ok || b.options.Timeout < d) {
+		return b.options.Timeout, true, nil
+	}
+	return d, ok, nil
+}
+
+// Get fetches the value of a key. A missing key is not an error: the response
+// has IsNotFound set. Siblings are returned unless the Bucket has a
+// ConflictResolver.
+func (b *Bucket) Get(ctx context.Context, key string) (*FetchValueResponse, error) {
+	builder := NewFetchValueCommandBuilder().
+		WithBucketType(b.bucketType).
+		WithBucket(b.name).
+		WithKey(key)
+	if b.options.R > 0 {
+		builder.WithR(b.options.R)
+	}
+	if b.options.Pr > 0 {
+		builder.WithPr(b.options.Pr)
+	}
+	if b.options.ConflictResolver != nil {
+		builder.WithConflictResolver(b.options.ConflictResolver)
+	}
+	if d, ok, err := b.timeout(ctx); err != nil {
+		return nil, err
+	} else if ok {
+		builder.WithTimeout(d)
+	}
+	cmd, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
+	if err = executeContext(ctx, b.executor, cmd); err != nil {
+		return nil, err
+	}
+	return cmd.(*FetchValueCommand).Response, nil
+}
+
+// GetValue fetches the value of a key and decodes it into v with the codec of
+// its content type. It returns false if the key does not exist or was deleted,
+// and ErrBucketSiblings if the value has siblings left by the
+// ConflictResolver.
+func (b *Bucket) GetValue(ctx context.Context, key string, v interface{}) (bool, error) {
+	response, err := b.Get(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	if response.IsNotFound || len(response.Values) == 0 {
+		return false, nil
+	}
+	if len(response.Values) > 1 {
+		return false, ErrBucketSiblings
+	}
+	if response.Values[0].IsTombstone {
+		return false, nil
+	}
+	return true, response.Values[0].Decode(v)
+}
+
+// Put stores the value under a key, using the VClock of the value if it was
+// fetched. If key is empty, Riak generates one, which is returned in the
+// response.
+func (b *Bucket) Put(ctx context.Context, key string, value *Object) (*StoreValueResponse, error) {
+	builder := NewStoreValueCommandBuilder().
+		WithBucketType(b.bucketType).
+		WithBucket(b.name).
+		WithContent(value)
+	if key != "" {
+		builder.WithKey(key)
+	}
+	if b.options.W > 0 {
+		builder.WithW(b.options.W)
+	}
+	if b.options.Pw > 0 {
+		builder.WithPw(b.options.Pw)
+	}
+	if b.options.Dw > 0 {
+		builder.WithDw(b.options.Dw)
+	}
+	if d, ok, err := b.timeout(ctx); err != nil {
+		return nil, err
+	} else if ok {
+		builder.WithTimeout(d)
+	}
+	cmd, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
+	if err = executeContext(ctx, b.executor, cmd); err != nil {
+		return nil, err
+	}
+	return cmd.(*StoreValueCommand).Response, nil
+}
+
+// PutValue stores v under a key, encoded with the codec of the ContentType of
+// the options. If key is empty, Riak generates one, which is returned in the
+// response.
+func (b *Bucket) PutValue(ctx context.Context, key string, v interface{}) (*StoreValueResponse, error) {
+	contentType := b.options.ContentType
+	if contentType == "" {
+		contentType = ContentTypeJSON
+	}
+	value, err := NewObjectFrom(v, contentType)
+	if err != nil {
+		return nil, err
+	}
+	return b.Put(ctx, key, value)
+}
+
+// Delete deletes the value of a key
+func (b *Bucket) Delete(ctx context.Context, key string) error {
+	builder := NewDeleteValueCommandBuilder().
+		WithBucketType(b.bucketType).
+		WithBucket(b.name).
+		WithKey(key)
+	if b.options.R > 0 {
+		builder.WithR(b.options.R)
+	}
+	if b.options.Pr > 0 {
+		builder.WithPr(b.options.Pr)
+	}
+	if b.options.W > 0 {
+		builder.WithW(b.options.W)
+	}
+	if b.options.Pw > 0 {
+		builder.WithPw(b.options.Pw)
+	}
+	if b.options.Dw > 0 {
+		builder.WithDw(b.options.Dw)
+	}
+	if d, ok, err := b.timeout(ctx); err != nil {
+		return err
+	} else if ok {
+		builder.WithTimeout(d)
+	}
+	cmd, err := builder.Build()
+	if err != nil {
+		return err
+	}
+	return executeContext(ctx, b.executor, cmd)
+}
+
+// ListKeys lists the keys of the bucket. Listing keys traverses every key
+// stored in the cluster, so it should not be used in production.
+func (b *Bucket) ListKeys(ctx context.Context) ([]string, error) {
+	builder := NewListKeysCommandBuilder().
+		WithBucketType(b.bucketType).
+		WithBucket(b.name)
+	if d, ok, err := b.timeout(ctx); err != nil {
+		return nil, err
+	} else if ok {
+		builder.WithTimeout(d)
+	}
+	cmd, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
+	if err = executeContext(ctx, b.executor, cmd); err != nil {
+		return nil, err
+	}
+	return cmd.(*ListKeysCommand).Response.Keys, nil
+}
+
+// QueryIndex returns the keys of the objects with the key in the secondary
+// index, e.g. "email_bin"
+func (b *Bucket) QueryIndex(ctx context.Context, index, key string) ([]*SecondaryIndexQueryResult, error) {
+	return b.queryIndex(ctx, NewSecondaryIndexQueryCommandBuilder().
+		WithIndexName(index).
+		WithIndexKey(key))
+}
+
+// QueryIndexRange returns the keys and index keys of the objects with an
+// index key between min and max, inclusive, in the secondary index
+func (b *Bucket) QueryIndexRange(ctx context.Context, index, min, max string) ([]*SecondaryIndexQueryResult, error) {
+	return b.queryIndex(ctx, NewSecondaryIndexQueryCommandBuilder().
+		WithIndexName(index).
+		WithRange(min, max).
+		WithReturnKeyAndIndex(true))
+}
+
+func (b *Bucket) queryIndex(ctx context.Context, builder *SecondaryIndexQueryCommandBuilder) ([]*SecondaryIndexQueryResult, error) {
+	builder.WithBucketType(b.bucketType).WithBucket(b.name)
+	if d, ok, err := b.timeout(ctx); err != nil {
+		return nil, err
+	} else if ok {
+		builder.WithTimeout(d)
+	}
+	cmd, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
+	if err = executeContext(ctx, b.executor, cmd); err != nil {
+		return nil, err
+	}
+	return cmd.(*SecondaryIndexQueryCommand).Response.Results, nil
+}
+
+// Counter returns a handle on the counter data type at the key. The bucket
+// type must have the counter datatype.
+func (b *Bucket) Counter(key string) *CounterHandle {
+	return &CounterHandle{bucket: b, key: key}
+}
+
+// Set returns a handle on the set data type at the key. The bucket type must
+// have the set datatype.
+func (b *Bucket) Set(key string) *SetHandle {
+	return &SetHandle{bucket: b, key: key}
+}
+
+// Map returns a handle on the map data type at the key. The bucket type must
+// have the map datatype.
+func (b *Bucket) Map(key string) *MapHandle {
+	return &MapHandle{bucket: b, key: key}
+}
+
+// CounterHandle is a counter data type, see Bucket.Counter
+type CounterHandle struct {
+	bucket *Bucket
+	key    string
+}
+
+// Value fetches the value of the counter, which is zero if it does not exist
+func (h *CounterHandle) Value(ctx context.Context) (int64, error) {
+	b := h.bucket
+	builder := NewFetchCounterCommandBuilder().
+		WithBucketType(b.bucketType).
+		WithBucket(b.name).
+		WithKey(h.key)
+	if b.options.R > 0 {
+		builder.WithR(b.options.R)
+	}
+	if b.options.Pr > 0 {
+		builder.WithPr(b.options.Pr)
+	}
+	if d, ok, err := b.timeout(ctx); err != nil {
+		return 0, err
+	} else if ok {
+		builder.WithTimeout(d)
+	}
+	cmd, err := builder.Build()
+	if err != nil {
+		return 0, err
+	}
+	if err = executeContext(ctx, b.executor, cmd); err != nil {
+		return 0, err
+	}
+	return cmd.(*FetchCounterCommand).Response.CounterValue, nil
+}
+
+// Increment adds delta, which may be negative, to the counter and returns its
+// new value
+func (h *CounterHandle) Increment(ctx context.Context, delta int64) (int64, error) {
+	b := h.bucket
+	builder := NewUpdateCounterCommandBuilder().
+		WithBucketType(b.bucketType).
+		WithBucket(b.name).
+		WithKey(h.key).
+		WithIncrement(delta).
+		WithReturnBody(true)
+	if b.options.W > 0 {
+		builder.WithW(b.options.W)
+	}
+	if b.options.Pw > 0 {
+		builder.WithPw(b.options.Pw)
+	}
+	if b.options.Dw > 0 {
+		builder.WithDw(b.options.Dw)
+	}
+	if d, ok, err := b.timeout(ctx); err != nil {
+		return 0, err
+	} else if ok {
+		builder.WithTimeout(d)
+	}
+	cmd, err := builder.Build()
+	if err != nil {
+		return 0, err
+	}
+	if err = executeContext(ctx, b.executor, cmd); err != nil {
+		return 0, err
+	}
+	return cmd.(*UpdateCounterCommand).Response.CounterValue, nil
+}
+
+// SetHandle is a set data type, see Bucket.Set
+type SetHandle struct {
+	bucket *Bucket
+	key    string
+}
+
+func (h *SetHandle) fetch(ctx context.Context) (*FetchSetResponse, error) {
+	b := h.bucket
+	builder := NewFetchSetCommandBuilder().
+		WithBucketType(b.bucketType).
+		WithBucket(b.name).
+		WithKey(h.key)
+	if b.options.R > 0 {
+		builder.WithR(b.options.R)
+	}
+	if b.options.Pr > 0 {
+		builder.WithPr(b.options.Pr)
+	}
+	if d, ok, err := b.timeout(ctx); err != nil {
+		return nil, err
+	} else if ok {
+		builder.WithTimeout(d)
+	}
+	cmd, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
+	if err = executeContext(ctx, b.executor, cmd); err != nil {
+		return nil, err
+	}
+	return cmd.(*FetchSetCommand).Response, nil
+}
+
+func (h *SetHandle) update(ctx context.Context, setContext []byte, adds, removals [][]byte) ([][]byte, error) {
+	b := h.bucket
+	builder := NewUpdateSetCommandBuilder().
+		WithBucketType(b.bucketType).
+		WithBucket(b.name).
+		WithKey(h.key).
+		WithContext(setContext).
+		WithAdditions(adds...).
+		WithRemovals(removals...).
+		WithReturnBody(true)
+	if b.options.W > 0 {
+		builder.WithW(b.options.W)
+	}
+	if b.options.Pw > 0 {
+		builder.WithPw(b.options.Pw)
+	}
+	if b.options.Dw > 0 {
+		builder.WithDw(b.options.Dw)
+	}
+	if d, ok, err := b.timeout(ctx); err != nil {
+		return nil, err
+	} else if ok {
+		builder.WithTimeout(d)
+	}
+	cmd, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
+	if err = executeContext(ctx, b.executor, cmd); err != nil {
+		return nil, err
+	}
+	return cmd.(*UpdateSetCommand).Response.SetValue, nil
+}
+
+// Members fetches the members of the set, which is empty if it does not exist
+func (h *SetHandle) Members(ctx context.Context) ([][]byte, error) {
+	response, err := h.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return response.SetValue, nil
+}
+
+// Add adds the values to the set and returns its new members
+func (h *SetHandle) Add(ctx context.Context, values ...[]byte) ([][]byte, error) {
+	return h.update(ctx, nil, values, nil)
+}
+
+// Remove removes the values from the set and returns its new members. Riak
+// requires the context of the set to remove values, so it is fetched first.
+func (h *SetHandle) Remove(ctx context.Context, values ...[]byte) ([][]byte, error) {
+	response, err := h.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return h.update(ctx, response.Context, nil, values)
+}
+
+// MapHandle is a map data type, see Bucket.Map
+type MapHandle struct {
+	bucket *Bucket
+	key    string
+}
+
+func (h *MapHandle) fetch(ctx context.Context) (*FetchMapResponse, error) {
+	b := h.bucket
+	builder := NewFetchMapCommandBuilder().
+		WithBucketType(b.bucketType).
+		WithBucket(b.name).
+		WithKey(h.key)
+	if b.options.R > 0 {
+		builder.WithR(b.options.R)
+	}
+	if b.options.Pr > 0 {
+		builder.WithPr(b.options.Pr)
+	}
+	if d, ok, err := b.timeout(ctx); err != nil {
+		return nil, err
+	} else if ok {
+		builder.WithTimeout(d)
+	}
+	cmd, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
+	if err = executeContext(ctx, b.executor, cmd); err != nil {
+		return nil, err
+	}
+	return cmd.(*FetchMapCommand).Response, nil
+}
+
+// Value fetches the map, which is nil if it does not exist
+func (h *MapHandle) Value(ctx context.Context) (*Map, error) {
+	response, err := h.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if response.IsNotFound {
+		return nil, nil
+	}
+	return response.Map, nil
+}
+
+// Update applies the operations added by update to the map and returns the
+// new map. If update removes anything, the context of the map, which Riak
+// requires for removals, is fetched first.
+//
+//	m, err := client.Map("maps", "users", "alice").Update(ctx, func(op *MapOperation) {
+//		op.SetRegister("name", []byte("Alice"))
+//		op.IncrementCounter("logins", 1)
+//	})
+func (h *MapHandle) Update(ctx context.Context, update func(op *MapOperation)) (*Map, error) {
+	b := h.bucket
+	op := &MapOperation{}
+	update(op)
+	builder := NewUpdateMapCommandBuilder().
+		WithBucketType(b.bucketType).
+		WithBucket(b.name).
+		WithKey(h.key).
+		WithMapOperation(op).
+		WithReturnBody(true)
+	if op.hasRemoves(true) {
+		response, err := h.fetch(ctx)
+		if err != nil {
+			return nil, err
+		}
+		builder.WithContext(response.Context)
+	}
+	if b.options.W > 0 {
+		builder.WithW(b.options.W)
+	}
+	if b.options.Pw > 0 {
+		builder.WithPw(b.options.Pw)
+	}
+	if b.options.Dw > 0 {
+		builder.WithDw(b.options.Dw)
+	}
+	if d, ok, err := b.timeout(ctx); err != nil {
+		return nil, err
+	} else if ok {
+		builder.WithTimeout(d)
+	}
+	cmd, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
+	if err = executeContext(ctx, b.executor, cmd); err != nil {
+		return nil, err
+	}
+	return cmd.(*UpdateMapCommand).Response.Map, nil
+}
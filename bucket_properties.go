@@ -0,0 +1,301 @@
+package riak
+
+import (
+	"fmt"
+	"strings"
+
+	rpbRiak "github.com/basho/riak-go-client/rpb/riak"
+)
+
+// Symbolic quorum values, which may be used for the R, Pr, W, Pw, Dw and Rw bucket properties
+// instead of a number of nodes
+const (
+	QuorumOne     uint32 = 0xfffffffe
+	QuorumQuorum  uint32 = 0xfffffffd
+	QuorumAll     uint32 = 0xfffffffc
+	QuorumDefault uint32 = 0xfffffffb
+)
+
+func quorumString(q uint32) string {
+	switch q {
+	case QuorumOne:
+		return "one"
+	case QuorumQuorum:
+		return "quorum"
+	case QuorumAll:
+		return "all"
+	case QuorumDefault:
+		return "default"
+	default:
+		return fmt.Sprint(q)
+	}
+}
+
+func (r ReplMode) String() string {
+	switch r {
+	case FALSE:
+		return "false"
+	case REALTIME:
+		return "realtime"
+	case FULLSYNC:
+		return "fullsync"
+	case TRUE:
+		return "true"
+	default:
+		return fmt.Sprintf("ReplMode(%d)", int32(r))
+	}
+}
+
+// BucketProperties are the properties of a bucket or bucket type. The properties fetched with
+// FetchBucketPropsCommand or FetchBucketTypePropsCommand can be modified and stored again with
+// StoreBucketPropsCommandBuilder.WithProperties or StoreBucketTypePropsCommandBuilder.WithProperties
+// without losing any of them.
+//
+// Quorum properties hold either a number of nodes or one of the symbolic values QuorumOne,
+// QuorumQuorum, QuorumAll and QuorumDefault.
+type BucketProperties struct {
+	NVal          uint32
+	AllowMult     bool
+	LastWriteWins bool
+	OldVClock     uint32
+	YoungVClock   uint32
+	BigVClock     uint32
+	SmallVClock   uint32
+	R             uint32
+	Pr            uint32
+	W             uint32
+	Pw            uint32
+	Dw            uint32
+	Rw            uint32
+	BasicQuorum   bool
+	NotFoundOk    bool
+	Search        bool
+	Consistent    bool
+	WriteOnce     bool
+	Repl          ReplMode
+	Backend       string
+	SearchIndex   string
+	DataType      string
+	PreCommit     []*CommitHook
+	PostCommit    []*CommitHook
+	ChashKeyFun   *ModFun
+	LinkFun       *ModFun
+}
+
+func newBucketProperties(rpbBucketProps *rpbRiak.RpbBucketProps) BucketProperties {
+	props := BucketProperties{
+		NVal:          rpbBucketProps.GetNVal(),
+		AllowMult:     rpbBucketProps.GetAllowMult(),
+		LastWriteWins: rpbBucketProps.GetLastWriteWins(),
+		OldVClock:     rpbBucketProps.GetOldVclock(),
+		YoungVClock:   rpbBucketProps.GetYoungVclock(),
+		BigVClock:     rpbBucketProps.GetBigVclock(),
+		SmallVClock:   rpbBucketProps.GetSmallVclock(),
+		R:             rpbBucketProps.GetR(),
+		Pr:            rpbBucketProps.GetPr(),
+		W:             rpbBucketProps.GetW(),
+		Pw:            rpbBucketProps.GetPw(),
+		Dw:            rpbBucketProps.GetDw(),
+		Rw:            rpbBucketProps.GetRw(),
+		BasicQuorum:   rpbBucketProps.GetBasicQuorum(),
+		NotFoundOk:    rpbBucketProps.GetNotfoundOk(),
+		Search:        rpbBucketProps.GetSearch(),
+		Consistent:    rpbBucketProps.GetConsistent(),
+		WriteOnce:     rpbBucketProps.GetWriteOnce(),
+		Repl:          ReplMode(rpbBucketProps.GetRepl()),
+		Backend:       string(rpbBucketProps.GetBackend()),
+		SearchIndex:   searchIndexFrom(rpbBucketProps.GetSearchIndex()),
+		DataType:      string(rpbBucketProps.GetDatatype()),
+	}
+	if rpbBucketProps.GetHasPrecommit() {
+		props.PreCommit = getHooksFrom(rpbBucketProps.Precommit)
+	}
+	if rpbBucketProps.GetHasPostcommit() {
+		props.PostCommit = getHooksFrom(rpbBucketProps.Postcommit)
+	}
+	if rpbBucketProps.ChashKeyfun != nil {
+		props.ChashKeyFun = getFunFrom(rpbBucketProps.ChashKeyfun)
+	}
+	if rpbBucketProps.Linkfun != nil {
+		props.LinkFun = getFunFrom(rpbBucketProps.Linkfun)
+	}
+	return props
+}
+
+// dontIndex is the search index of buckets that are not associated with an index
+const dontIndex = "_dont_index_"
+
+func searchIndexFrom(searchIndex []byte) string {
+	if string(searchIndex) == dontIndex {
+		return ""
+	}
+	return string(searchIndex)
+}
+
+// setRpbBucketProps sets every property in rpbBucketProps. The commit hooks are replaced, and
+// cleared if there are none. An empty SearchIndex removes the association with a search index.
+// An empty Backend, nil functions and the properties that can only be set when a bucket type is
+// created (Consistent, WriteOnce and DataType) are left unset unless they have a value, since Riak
+// can not clear them or rejects any change to them.
+func (props *BucketProperties) setRpbBucketProps(rpbBucketProps *rpbRiak.RpbBucketProps) {
+	// NB: copy, so that later changes to props do not change the request
+	p := *props
+	rpbBucketProps.NVal = &p.NVal
+	rpbBucketProps.AllowMult = &p.AllowMult
+	rpbBucketProps.LastWriteWins = &p.LastWriteWins
+	rpbBucketProps.OldVclock = &p.OldVClock
+	rpbBucketProps.YoungVclock = &p.YoungVClock
+	rpbBucketProps.BigVclock = &p.BigVClock
+	rpbBucketProps.SmallVclock = &p.SmallVClock
+	rpbBucketProps.R = &p.R
+	rpbBucketProps.Pr = &p.Pr
+	rpbBucketProps.W = &p.W
+	rpbBucketProps.Pw = &p.Pw
+	rpbBucketProps.Dw = &p.Dw
+	rpbBucketProps.Rw = &p.Rw
+	rpbBucketProps.BasicQuorum = &p.BasicQuorum
+	rpbBucketProps.NotfoundOk = &p.NotFoundOk
+	rpbBucketProps.Search = &p.Search
+	repl := rpbRiak.RpbBucketProps_RpbReplMode(p.Repl)
+	rpbBucketProps.Repl = &repl
+
+	hasPrecommit, hasPostcommit := true, true
+	rpbBucketProps.HasPrecommit = &hasPrecommit
+	rpbBucketProps.Precommit = toRpbCommitHooks(p.PreCommit)
+	rpbBucketProps.HasPostcommit = &hasPostcommit
+	rpbBucketProps.Postcommit = toRpbCommitHooks(p.PostCommit)
+
+	if p.Backend != "" {
+		rpbBucketProps.Backend = []byte(p.Backend)
+	}
+	if p.SearchIndex != "" {
+		rpbBucketProps.SearchIndex = []byte(p.SearchIndex)
+	} else {
+		rpbBucketProps.SearchIndex = []byte(dontIndex)
+	}
+	if p.ChashKeyFun != nil {
+		rpbBucketProps.ChashKeyfun = toRpbModFun(p.ChashKeyFun)
+	}
+	if p.LinkFun != nil {
+		rpbBucketProps.Linkfun = toRpbModFun(p.LinkFun)
+	}
+	if p.Consistent {
+		rpbBucketProps.Consistent = &p.Consistent
+	}
+	if p.WriteOnce {
+		rpbBucketProps.WriteOnce = &p.WriteOnce
+	}
+	if p.DataType != "" {
+		rpbBucketProps.Datatype = []byte(p.DataType)
+	}
+}
+
+func toRpbCommitHooks(hooks []*CommitHook) []*rpbRiak.RpbCommitHook {
+	rpbHooks := make([]*rpbRiak.RpbCommitHook, len(hooks))
+	for i, hook := range hooks {
+		rpbHooks[i] = toRpbCommitHook(hook)
+	}
+	return rpbHooks
+}
+
+func toRpbModFun(modFun *ModFun) *rpbRiak.RpbModFun {
+	if modFun == nil {
+		return nil
+	}
+	return &rpbRiak.RpbModFun{
+		Module:   []byte(modFun.Module),
+		Function: []byte(modFun.Function),
+	}
+}
+
+// BucketPropertyChange is a bucket property that differs between two BucketProperties. Name is
+// the name Riak uses for the property, e.g. "n_val", and the values are formatted for display.
+type BucketPropertyChange struct {
+	Name string
+	From string
+	To   string
+}
+
+func (c BucketPropertyChange) String() string {
+	return fmt.Sprintf("%s: %s -> %s", c.Name, c.From, c.To)
+}
+
+func stringOrNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
+
+func modFunString(modFun *ModFun) string {
+	if modFun == nil {
+		return "none"
+	}
+	return modFun.Module + ":" + modFun.Function
+}
+
+func hooksString(hooks []*CommitHook) string {
+	names := make([]string, len(hooks))
+	for i, hook := range hooks {
+		if hook.ModFun != nil {
+			names[i] = modFunString(hook.ModFun)
+		} else {
+			names[i] = hook.Name
+		}
+	}
+	return "[" + strings.Join(names, ", ") + "]"
+}
+
+var bucketPropertyFormatters = []struct {
+	name   string
+	format func(props *BucketProperties) string
+}{
+	{"n_val", func(p *BucketProperties) string { return fmt.Sprint(p.NVal) }},
+	{"allow_mult", func(p *BucketProperties) string { return fmt.Sprint(p.AllowMult) }},
+	{"last_write_wins", func(p *BucketProperties) string { return fmt.Sprint(p.LastWriteWins) }},
+	{"old_vclock", func(p *BucketProperties) string { return fmt.Sprint(p.OldVClock) }},
+	{"young_vclock", func(p *BucketProperties) string { return fmt.Sprint(p.YoungVClock) }},
+	{"big_vclock", func(p *BucketProperties) string { return fmt.Sprint(p.BigVClock) }},
+	{"small_vclock", func(p *BucketProperties) string { return fmt.Sprint(p.SmallVClock) }},
+	{"r", func(p *BucketProperties) string { return quorumString(p.R) }},
+	{"pr", func(p *BucketProperties) string { return quorumString(p.Pr) }},
+	{"w", func(p *BucketProperties) string { return quorumString(p.W) }},
+	{"pw", func(p *BucketProperties) string { return quorumString(p.Pw) }},
+	{"dw", func(p *BucketProperties) string { return quorumString(p.Dw) }},
+	{"rw", func(p *BucketProperties) string { return quorumString(p.Rw) }},
+	{"basic_quorum", func(p *BucketProperties) string { return fmt.Sprint(p.BasicQuorum) }},
+	{"notfound_ok", func(p *BucketProperties) string { return fmt.Sprint(p.NotFoundOk) }},
+	{"search", func(p *BucketProperties) string { return fmt.Sprint(p.Search) }},
+	{"consistent", func(p *BucketProperties) string { return fmt.Sprint(p.Consistent) }},
+	{"write_once", func(p *BucketProperties) string { return fmt.Sprint(p.WriteOnce) }},
+	{"repl", func(p *BucketProperties) string { return p.Repl.String() }},
+	{"backend", func(p *BucketProperties) string { return stringOrNone(p.Backend) }},
+	{"search_index", func(p *BucketProperties) string { return stringOrNone(p.SearchIndex) }},
+	{"datatype", func(p *BucketProperties) string { return stringOrNone(p.DataType) }},
+	{"precommit", func(p *BucketProperties) string { return hooksString(p.PreCommit) }},
+	{"postcommit", func(p *BucketProperties) string { return hooksString(p.PostCommit) }},
+	{"chash_keyfun", func(p *BucketProperties) string { return modFunString(p.ChashKeyFun) }},
+	{"linkfun", func(p *BucketProperties) string { return modFunString(p.LinkFun) }},
+}
+
+// Diff returns the properties that differ between a and b, in a fixed order. A nil argument is
+// treated as BucketProperties with every property unset.
+//
+// Storing b does not apply every change: Riak can not clear the backend, chash_keyfun and linkfun,
+// so a change to none leaves them unchanged, and it rejects changes to consistent, write_once and
+// datatype once a bucket type is created.
+func Diff(a, b *BucketProperties) []BucketPropertyChange {
+	if a == nil {
+		a = &BucketProperties{}
+	}
+	if b == nil {
+		b = &BucketProperties{}
+	}
+	var changes []BucketPropertyChange
+	for _, f := range bucketPropertyFormatters {
+		if from, to := f.format(a), f.format(b); from != to {
+			changes = append(changes, BucketPropertyChange{Name: f.name, From: from, To: to})
+		}
+	}
+	return changes
+}
@@ -0,0 +1,107 @@
+package riak
+
+import (
+	"reflect"
+	"testing"
+
+	rpbRiak "github.com/basho/riak-go-client/rpb/riak"
+)
+
+func TestBucketPropertiesRoundTrip(t *testing.T) {
+	props := BucketProperties{
+		NVal:        5,
+		AllowMult:   true,
+		OldVClock:   86400,
+		R:           QuorumQuorum,
+		Pr:          0,
+		W:           QuorumAll,
+		Dw:          2,
+		Rw:          QuorumDefault,
+		NotFoundOk:  true,
+		Consistent:  true,
+		WriteOnce:   true,
+		Repl:        FULLSYNC,
+		Backend:     "leveldb",
+		SearchIndex: "index",
+		DataType:    "set",
+		PreCommit:   []*CommitHook{{Name: "validate"}},
+		PostCommit:  []*CommitHook{{ModFun: &ModFun{Module: "m", Function: "f"}}},
+		ChashKeyFun: &ModFun{Module: "riak_core_util", Function: "chash_std_keyfun"},
+		LinkFun:     &ModFun{Module: "riak_kv_wm_link_walker", Function: "mapreduce_linkfun"},
+	}
+	rpbBucketProps := &rpbRiak.RpbBucketProps{}
+	props.setRpbBucketProps(rpbBucketProps)
+	if expected, actual := props, newBucketProperties(rpbBucketProps); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestBucketPropertiesLeavesImmutablePropertiesUnset(t *testing.T) {
+	rpbBucketProps := &rpbRiak.RpbBucketProps{}
+	(&BucketProperties{NVal: 3}).setRpbBucketProps(rpbBucketProps)
+	if rpbBucketProps.Consistent != nil || rpbBucketProps.WriteOnce != nil || rpbBucketProps.Datatype != nil {
+		t.Error("expected consistent, write_once and datatype to be unset")
+	}
+	if rpbBucketProps.Backend != nil || rpbBucketProps.Linkfun != nil {
+		t.Error("expected backend and linkfun to be unset")
+	}
+	if expected, actual := true, rpbBucketProps.GetHasPrecommit(); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestBucketPropertiesClearsSearchIndex(t *testing.T) {
+	rpbBucketProps := &rpbRiak.RpbBucketProps{}
+	(&BucketProperties{NVal: 3}).setRpbBucketProps(rpbBucketProps)
+	if expected, actual := "_dont_index_", string(rpbBucketProps.GetSearchIndex()); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := "", newBucketProperties(rpbBucketProps).SearchIndex; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestStoreBucketPropsWithProperties(t *testing.T) {
+	props := &BucketProperties{NVal: 3, W: QuorumOne}
+	cmd, err := NewStoreBucketPropsCommandBuilder().
+		WithBucket("b").
+		WithProperties(props).
+		WithNVal(5).
+		WithRepl(REALTIME).
+		Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	// NB: changes after WithProperties must not change the command
+	props.W = QuorumAll
+	rpbBucketProps := cmd.(*StoreBucketPropsCommand).protobuf.GetProps()
+	if expected, actual := uint32(5), rpbBucketProps.GetNVal(); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := QuorumOne, rpbBucketProps.GetW(); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := rpbRiak.RpbBucketProps_REALTIME, rpbBucketProps.GetRepl(); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestDiffBucketProperties(t *testing.T) {
+	a := &BucketProperties{NVal: 3, W: QuorumQuorum, PreCommit: []*CommitHook{{Name: "validate"}}}
+	b := &BucketProperties{NVal: 5, W: 2, LinkFun: &ModFun{Module: "m", Function: "f"}}
+	expected := []BucketPropertyChange{
+		{Name: "n_val", From: "3", To: "5"},
+		{Name: "w", From: "quorum", To: "2"},
+		{Name: "precommit", From: "[validate]", To: "[]"},
+		{Name: "linkfun", From: "none", To: "m:f"},
+	}
+	if actual := Diff(a, b); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if changes := Diff(a, a); len(changes) != 0 {
+		t.Errorf("expected no changes, got %v", changes)
+	}
+	if expected, actual := "n_val: 3 -> 5", expected[0].String(); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
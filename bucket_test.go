@@ -0,0 +1,170 @@
+package riak
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestBucketAppliesDefaultOptions(t *testing.T) {
+	executor := NewMockExecutor()
+	executor.On(&FetchValueCommand{}).Respond(&FetchValueResponse{IsNotFound: true})
+	executor.On(&StoreValueCommand{}).Respond(&StoreValueResponse{})
+	executor.On(&UpdateMapCommand{}).Respond(&UpdateMapResponse{})
+	bucket := NewBucket(executor, "default", "users", nil).WithOptions(&BucketOptions{
+		R:       QuorumQuorum,
+		Pr:      1,
+		W:       QuorumAll,
+		Dw:      2,
+		Timeout: time.Second,
+	})
+	ctx := context.Background()
+	if _, err := bucket.Get(ctx, "alice"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := bucket.PutValue(ctx, "alice", map[string]string{"name": "Alice"}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := bucket.Map("alice").Update(ctx, func(op *MapOperation) {
+		op.SetFlag("admin", true)
+	}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	executed := executor.Executed()
+	if expected, actual := 3, len(executed); expected != actual {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+	fetch := executed[0].(*FetchValueCommand).protobuf
+	if expected, actual := QuorumQuorum, fetch.GetR(); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := uint32(1), fetch.GetPr(); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := uint32(1000), fetch.GetTimeout(); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	store := executed[1].(*StoreValueCommand)
+	if expected, actual := QuorumAll, store.protobuf.GetW(); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := uint32(2), store.protobuf.GetDw(); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if store.protobuf.Pw != nil {
+		t.Errorf("expected pw to be unset, got %v", store.protobuf.GetPw())
+	}
+	if expected, actual := ContentTypeJSON, store.value.ContentType; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	update := executed[2].(*UpdateMapCommand).protobuf
+	if expected, actual := QuorumAll, update.GetW(); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestBucketTimeoutUsesEarlierDeadline(t *testing.T) {
+	bucket := NewBucket(nil, "default", "users", &BucketOptions{Timeout: time.Minute})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if d, ok, err := bucket.timeout(ctx); err != nil || !ok || d > time.Second {
+		t.Errorf("expected at most %v, got %v, %v, %v", time.Second, d, ok, err)
+	}
+	if d, ok, err := bucket.timeout(context.Background()); err != nil || !ok || d != time.Minute {
+		t.Errorf("expected %v, got %v, %v, %v", time.Minute, d, ok, err)
+	}
+}
+
+func TestBucketGetPutValue(t *testing.T) {
+	server, cluster := startTestServerCluster(t)
+	defer stopTestServerCluster(server, cluster)
+	bucket := cluster.Bucket("default", "users").WithOptions(&BucketOptions{ContentType: ContentTypeGob})
+	ctx := context.Background()
+	var name string
+	if found, err := bucket.GetValue(ctx, "alice", &name); err != nil || found {
+		t.Errorf("expected not found, got %v, %v", found, err)
+	}
+	if _, err := bucket.PutValue(ctx, "alice", "Alice"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if found, err := bucket.GetValue(ctx, "alice", &name); err != nil || !found {
+		t.Fatalf("expected found, got %v, %v", found, err)
+	}
+	if expected, actual := "Alice", name; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if err := bucket.Delete(ctx, "alice"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if found, err := bucket.GetValue(ctx, "alice", &name); err != nil || found {
+		t.Errorf("expected not found, got %v, %v", found, err)
+	}
+}
+
+func TestBucketListKeysAndQueryIndex(t *testing.T) {
+	server, cluster := startTestServerCluster(t)
+	defer stopTestServerCluster(server, cluster)
+	bucket := cluster.Bucket("default", "users")
+	ctx := context.Background()
+	for _, user := range []struct{ key, country string }{{"alice", "nl"}, {"bob", "uk"}, {"carol", "nl"}} {
+		value := &Object{ContentType: ContentTypeText, Value: []byte(user.key)}
+		value.AddToIndex("country_bin", user.country)
+		if _, err := bucket.Put(ctx, user.key, value); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	keys, err := bucket.ListKeys(ctx)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	sort.Strings(keys)
+	if expected, actual := "[alice bob carol]", fmt.Sprint(keys); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	results, err := bucket.QueryIndex(ctx, "country_bin", "nl")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	var matched []string
+	for _, result := range results {
+		matched = append(matched, string(result.ObjectKey))
+	}
+	sort.Strings(matched)
+	if expected, actual := "[alice carol]", fmt.Sprint(matched); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	if results, err = bucket.QueryIndexRange(ctx, "country_bin", "o", "z"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := 1, len(results); expected != actual {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := "bob", string(results[0].ObjectKey); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := "uk", string(results[0].IndexKey); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestBucketDataTypes(t *testing.T) {
+	server, cluster := startTestServerCluster(t)
+	defer stopTestServerCluster(server, cluster)
+	if err := server.CreateBucketType("counters", "counter"); err != nil {
+		t.Fatal(err.Error())
+	}
+	bucket := cluster.Bucket("counters", "visits")
+	ctx := context.Background()
+	if _, err := bucket.Counter("home").Increment(ctx, 2); err != nil {
+		t.Fatal(err.Error())
+	}
+	if value, err := bucket.Counter("home").Value(ctx); err != nil || value != 2 {
+		t.Errorf("expected 2, got %v, %v", value, err)
+	}
+}
@@ -1,4 +1,4 @@
-.PHONY: all install-deps lint unit-test integration-test test fmt help
+.PHONY: all install-deps lint unit-test integration-test test fuzz fmt help
 
 all: install-deps lint test
 
@@ -16,6 +16,15 @@ integration-test: lint
 
 test: unit-test integration-test
 
+FUZZTIME ?= 30s
+FUZZ_TARGETS = FuzzMaybeRiakError FuzzRpbValidateResp FuzzDecodeRiakMessage \
+	FuzzCommandOnSuccess FuzzFromRpbContent FuzzParsePbResponse FuzzMsgpackDecode
+
+fuzz:
+	for target in $(FUZZ_TARGETS); do \
+		go test -run XXX -fuzz "^$$target$$" -fuzztime $(FUZZTIME) github.com/basho/riak-go-client || exit 1; \
+	done
+
 fmt:
 	gofmt -s -w .
 
@@ -29,5 +38,6 @@ help:
 	@echo ' test             - Run unit & integration tests  '
 	@echo ' unit-test        - Run unit tests                '
 	@echo ' integration-test - Run integration tests         '
+	@echo ' fuzz             - Run fuzz tests (Go 1.18+)     '
 	@echo '--------------------------------------------------'
 	@echo ''
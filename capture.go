@@ -0,0 +1,435 @@
+package riak
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	rpbRiak "github.com/basho/riak-go-client/rpb/riak"
+	rpbRiakDT "github.com/basho/riak-go-client/rpb/riak_dt"
+	rpbRiakKV "github.com/basho/riak-go-client/rpb/riak_kv"
+	rpbRiakSCH "github.com/basho/riak-go-client/rpb/riak_search"
+	rpbRiakYZ "github.com/basho/riak-go-client/rpb/riak_yokozuna"
+	proto "github.com/golang/protobuf/proto"
+)
+
+// Capture errors
+var (
+	ErrCaptureFormat = errors.New("[Capture] not a PB capture file")
+)
+
+// captureMagic identifies the capture file format and version
+var captureMagic = []byte("RIAKPBC1")
+
+// CaptureDirection identifies whether a captured frame was sent to or received from Riak
+type CaptureDirection byte
+
+// Captured frame directions
+const (
+	CaptureSent CaptureDirection = iota + 1
+	CaptureReceived
+)
+
+func (d CaptureDirection) String() string {
+	switch d {
+	case CaptureSent:
+		return "->"
+	case CaptureReceived:
+		return "<-"
+	default:
+		return "??"
+	}
+}
+
+// CaptureFrame is a single PB frame exchanged with a Riak node. Data is the raw
+// protobuf message and does not include the length prefix or the message code.
+type CaptureFrame struct {
+	Timestamp time.Time
+	Node      string
+	Conn      uint64
+	Direction CaptureDirection
+	Code      byte
+	Data      []byte
+}
+
+// Message decodes the raw protobuf data of the frame using the message type
+// for its code. A nil message is returned for codes that have no body.
+func (f *CaptureFrame) Message() (msg proto.Message, err error) {
+	var ok bool
+	if msg, ok = rpbMessageFor(f.Code); !ok {
+		err = fmt.Errorf("[Capture] unknown message code: %d", f.Code)
+		return
+	}
+	if msg != nil {
+		err = proto.Unmarshal(f.Data, msg)
+	}
+	return
+}
+
+// String returns the frame as readable text, decoding the protobuf message if possible
+func (f *CaptureFrame) String() string {
+	prefix := fmt.Sprintf("%s %s #%d %v %s(%d)",
+		f.Timestamp.UTC().Format(time.RFC3339Nano), f.Node, f.Conn, f.Direction, rpbCodeName(f.Code), f.Code)
+	msg, err := f.Message()
+	if err != nil {
+		return fmt.Sprintf("%s undecodable: %s (% x)", prefix, err.Error(), f.Data)
+	}
+	if msg == nil {
+		return prefix
+	}
+	return fmt.Sprintf("%s %s", prefix, proto.CompactTextString(msg))
+}
+
+// Capture records every PB frame written to and read from Riak connections
+// to an io.Writer. It is safe for concurrent use by many connections. Set
+// NodeOptions.Capture to use it.
+//
+// NB: the password in RpbAuthReq is never written to a capture
+type Capture struct {
+	mtx           sync.Mutex
+	w             io.Writer
+	wroteHeader   bool
+	err           error
+	nextConnIndex uint64
+}
+
+// NewCapture returns a Capture that writes frames to w
+func NewCapture(w io.Writer) *Capture {
+	return &Capture{w: w}
+}
+
+// Err returns the first error encountered while writing the capture
+func (c *Capture) Err() error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.err
+}
+
+func (c *Capture) newConnId() uint64 {
+	return atomic.AddUint64(&c.nextConnIndex, 1)
+}
+
+// record writes a frame consisting of message code and protobuf data
+func (c *Capture) record(node string, connId uint64, direction CaptureDirection, frame []byte) {
+	if len(frame) == 0 {
+		return
+	}
+	f := &CaptureFrame{
+		Timestamp: time.Now(),
+		Node:      node,
+		Conn:      connId,
+		Direction: direction,
+		Code:      frame[0],
+		Data:      frame[1:],
+	}
+	if f.Code == rpbCode_RpbAuthReq {
+		f.Data = redactAuthReq(f.Data)
+	}
+	c.WriteFrame(f)
+}
+
+// WriteFrame appends a frame to the capture, for instance one returned by ReplayCapture
+func (c *Capture) WriteFrame(f *CaptureFrame) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.err != nil {
+		return
+	}
+	buf := new(bytes.Buffer)
+	if !c.wroteHeader {
+		buf.Write(captureMagic)
+		c.wroteHeader = true
+	}
+	writeCaptureFrame(buf, f)
+	if _, c.err = c.w.Write(buf.Bytes()); c.err != nil {
+		logError("[Capture]", "error writing capture: '%s'", c.err.Error())
+	}
+}
+
+func writeCaptureFrame(buf *bytes.Buffer, f *CaptureFrame) {
+	binary.Write(buf, binary.BigEndian, f.Timestamp.UnixNano())
+	binary.Write(buf, binary.BigEndian, f.Conn)
+	buf.WriteByte(byte(f.Direction))
+	binary.Write(buf, binary.BigEndian, uint16(len(f.Node)))
+	buf.WriteString(f.Node)
+	binary.Write(buf, binary.BigEndian, uint32(len(f.Data)+1))
+	buf.WriteByte(f.Code)
+	buf.Write(f.Data)
+}
+
+func redactAuthReq(data []byte) []byte {
+	authReq := &rpbRiak.RpbAuthReq{}
+	if err := proto.Unmarshal(data, authReq); err != nil {
+		return nil
+	}
+	authReq.Password = []byte("REDACTED")
+	redacted, err := proto.Marshal(authReq)
+	if err != nil {
+		return nil
+	}
+	return redacted
+}
+
+// CaptureReader reads frames written by a Capture
+type CaptureReader struct {
+	r          *bufio.Reader
+	readHeader bool
+}
+
+// NewCaptureReader returns a CaptureReader that reads frames from r
+func NewCaptureReader(r io.Reader) *CaptureReader {
+	return &CaptureReader{r: bufio.NewReader(r)}
+}
+
+// Next returns the next frame in the capture, or io.EOF when there are no more frames
+func (cr *CaptureReader) Next() (f *CaptureFrame, err error) {
+	if !cr.readHeader {
+		magic := make([]byte, len(captureMagic))
+		if _, err = io.ReadFull(cr.r, magic); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = ErrCaptureFormat
+			}
+			return
+		}
+		if !bytes.Equal(magic, captureMagic) {
+			err = ErrCaptureFormat
+			return
+		}
+		cr.readHeader = true
+	}
+
+	var header struct {
+		Timestamp int64
+		Conn      uint64
+		Direction CaptureDirection
+		NodeLen   uint16
+	}
+	if err = binary.Read(cr.r, binary.BigEndian, &header); err != nil {
+		return
+	}
+	node := make([]byte, header.NodeLen)
+	if _, err = io.ReadFull(cr.r, node); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	var frameLen uint32
+	if err = binary.Read(cr.r, binary.BigEndian, &frameLen); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if frameLen == 0 {
+		return nil, ErrCaptureFormat
+	}
+	frame := make([]byte, frameLen)
+	if _, err = io.ReadFull(cr.r, frame); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	f = &CaptureFrame{
+		Timestamp: time.Unix(0, header.Timestamp),
+		Node:      string(node),
+		Conn:      header.Conn,
+		Direction: header.Direction,
+		Code:      frame[0],
+		Data:      frame[1:],
+	}
+	return
+}
+
+// ReadCapture reads every frame from a capture
+func ReadCapture(r io.Reader) (frames []*CaptureFrame, err error) {
+	cr := NewCaptureReader(r)
+	for {
+		var f *CaptureFrame
+		if f, err = cr.Next(); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return
+		}
+		frames = append(frames, f)
+	}
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+var rpbCodeNames = map[byte]string{
+	rpbCode_RpbErrorResp:                "RpbErrorResp",
+	rpbCode_RpbPingReq:                  "RpbPingReq",
+	rpbCode_RpbPingResp:                 "RpbPingResp",
+	rpbCode_RpbGetClientIdReq:           "RpbGetClientIdReq",
+	rpbCode_RpbGetClientIdResp:          "RpbGetClientIdResp",
+	rpbCode_RpbSetClientIdReq:           "RpbSetClientIdReq",
+	rpbCode_RpbSetClientIdResp:          "RpbSetClientIdResp",
+	rpbCode_RpbGetServerInfoReq:         "RpbGetServerInfoReq",
+	rpbCode_RpbGetServerInfoResp:        "RpbGetServerInfoResp",
+	rpbCode_RpbGetReq:                   "RpbGetReq",
+	rpbCode_RpbGetResp:                  "RpbGetResp",
+	rpbCode_RpbPutReq:                   "RpbPutReq",
+	rpbCode_RpbPutResp:                  "RpbPutResp",
+	rpbCode_RpbDelReq:                   "RpbDelReq",
+	rpbCode_RpbDelResp:                  "RpbDelResp",
+	rpbCode_RpbListBucketsReq:           "RpbListBucketsReq",
+	rpbCode_RpbListBucketsResp:          "RpbListBucketsResp",
+	rpbCode_RpbListKeysReq:              "RpbListKeysReq",
+	rpbCode_RpbListKeysResp:             "RpbListKeysResp",
+	rpbCode_RpbGetBucketReq:             "RpbGetBucketReq",
+	rpbCode_RpbGetBucketResp:            "RpbGetBucketResp",
+	rpbCode_RpbSetBucketReq:             "RpbSetBucketReq",
+	rpbCode_RpbSetBucketResp:            "RpbSetBucketResp",
+	rpbCode_RpbMapRedReq:                "RpbMapRedReq",
+	rpbCode_RpbMapRedResp:               "RpbMapRedResp",
+	rpbCode_RpbIndexReq:                 "RpbIndexReq",
+	rpbCode_RpbIndexResp:                "RpbIndexResp",
+	rpbCode_RpbSearchQueryReq:           "RpbSearchQueryReq",
+	rpbCode_RpbSearchQueryResp:          "RpbSearchQueryResp",
+	rpbCode_RpbResetBucketReq:           "RpbResetBucketReq",
+	rpbCode_RpbResetBucketResp:          "RpbResetBucketResp",
+	rpbCode_RpbGetBucketTypeReq:         "RpbGetBucketTypeReq",
+	rpbCode_RpbSetBucketTypeReq:         "RpbSetBucketTypeReq",
+	rpbCode_RpbGetBucketKeyPreflistReq:  "RpbGetBucketKeyPreflistReq",
+	rpbCode_RpbGetBucketKeyPreflistResp: "RpbGetBucketKeyPreflistResp",
+	rpbCode_RpbCSBucketReq:              "RpbCSBucketReq",
+	rpbCode_RpbCSBucketResp:             "RpbCSBucketResp",
+	rpbCode_RpbCounterUpdateReq:         "RpbCounterUpdateReq",
+	rpbCode_RpbCounterUpdateResp:        "RpbCounterUpdateResp",
+	rpbCode_RpbCounterGetReq:            "RpbCounterGetReq",
+	rpbCode_RpbCounterGetResp:           "RpbCounterGetResp",
+	rpbCode_RpbYokozunaIndexGetReq:      "RpbYokozunaIndexGetReq",
+	rpbCode_RpbYokozunaIndexGetResp:     "RpbYokozunaIndexGetResp",
+	rpbCode_RpbYokozunaIndexPutReq:      "RpbYokozunaIndexPutReq",
+	rpbCode_RpbYokozunaIndexDeleteReq:   "RpbYokozunaIndexDeleteReq",
+	rpbCode_RpbYokozunaSchemaGetReq:     "RpbYokozunaSchemaGetReq",
+	rpbCode_RpbYokozunaSchemaGetResp:    "RpbYokozunaSchemaGetResp",
+	rpbCode_RpbYokozunaSchemaPutReq:     "RpbYokozunaSchemaPutReq",
+	rpbCode_DtFetchReq:                  "DtFetchReq",
+	rpbCode_DtFetchResp:                 "DtFetchResp",
+	rpbCode_DtUpdateReq:                 "DtUpdateReq",
+	rpbCode_DtUpdateResp:                "DtUpdateResp",
+	rpbCode_RpbAuthReq:                  "RpbAuthReq",
+	rpbCode_RpbAuthResp:                 "RpbAuthResp",
+	rpbCode_RpbStartTls:                 "RpbStartTls",
+}
+
+func rpbCodeName(code byte) string {
+	if name, ok := rpbCodeNames[code]; ok {
+		return name
+	}
+	return "Unknown"
+}
+
+// rpbMessageFor returns a new, empty protobuf message for the message code. The
+// message is nil for codes that have no body, and ok is false for unknown codes.
+func rpbMessageFor(code byte) (msg proto.Message, ok bool) {
+	ok = true
+	switch code {
+	case rpbCode_RpbErrorResp:
+		msg = &rpbRiak.RpbErrorResp{}
+	case rpbCode_RpbPingReq, rpbCode_RpbPingResp:
+	case rpbCode_RpbGetClientIdReq, rpbCode_RpbSetClientIdResp:
+	case rpbCode_RpbGetClientIdResp:
+		msg = &rpbRiakKV.RpbGetClientIdResp{}
+	case rpbCode_RpbSetClientIdReq:
+		msg = &rpbRiakKV.RpbSetClientIdReq{}
+	case rpbCode_RpbGetServerInfoReq:
+	case rpbCode_RpbGetServerInfoResp:
+		msg = &rpbRiak.RpbGetServerInfoResp{}
+	case rpbCode_RpbGetReq:
+		msg = &rpbRiakKV.RpbGetReq{}
+	case rpbCode_RpbGetResp:
+		msg = &rpbRiakKV.RpbGetResp{}
+	case rpbCode_RpbPutReq:
+		msg = &rpbRiakKV.RpbPutReq{}
+	case rpbCode_RpbPutResp:
+		msg = &rpbRiakKV.RpbPutResp{}
+	case rpbCode_RpbDelReq:
+		msg = &rpbRiakKV.RpbDelReq{}
+	case rpbCode_RpbDelResp:
+	case rpbCode_RpbListBucketsReq:
+		msg = &rpbRiakKV.RpbListBucketsReq{}
+	case rpbCode_RpbListBucketsResp:
+		msg = &rpbRiakKV.RpbListBucketsResp{}
+	case rpbCode_RpbListKeysReq:
+		msg = &rpbRiakKV.RpbListKeysReq{}
+	case rpbCode_RpbListKeysResp:
+		msg = &rpbRiakKV.RpbListKeysResp{}
+	case rpbCode_RpbGetBucketReq:
+		msg = &rpbRiak.RpbGetBucketReq{}
+	case rpbCode_RpbGetBucketResp:
+		msg = &rpbRiak.RpbGetBucketResp{}
+	case rpbCode_RpbSetBucketReq:
+		msg = &rpbRiak.RpbSetBucketReq{}
+	case rpbCode_RpbSetBucketResp:
+	case rpbCode_RpbMapRedReq:
+		msg = &rpbRiakKV.RpbMapRedReq{}
+	case rpbCode_RpbMapRedResp:
+		msg = &rpbRiakKV.RpbMapRedResp{}
+	case rpbCode_RpbIndexReq:
+		msg = &rpbRiakKV.RpbIndexReq{}
+	case rpbCode_RpbIndexResp:
+		msg = &rpbRiakKV.RpbIndexResp{}
+	case rpbCode_RpbSearchQueryReq:
+		msg = &rpbRiakSCH.RpbSearchQueryReq{}
+	case rpbCode_RpbSearchQueryResp:
+		msg = &rpbRiakSCH.RpbSearchQueryResp{}
+	case rpbCode_RpbResetBucketReq:
+		msg = &rpbRiak.RpbResetBucketReq{}
+	case rpbCode_RpbResetBucketResp:
+	case rpbCode_RpbGetBucketTypeReq:
+		msg = &rpbRiak.RpbGetBucketTypeReq{}
+	case rpbCode_RpbSetBucketTypeReq:
+		msg = &rpbRiak.RpbSetBucketTypeReq{}
+	case rpbCode_RpbGetBucketKeyPreflistReq:
+		msg = &rpbRiakKV.RpbGetBucketKeyPreflistReq{}
+	case rpbCode_RpbGetBucketKeyPreflistResp:
+		msg = &rpbRiakKV.RpbGetBucketKeyPreflistResp{}
+	case rpbCode_RpbCSBucketReq:
+		msg = &rpbRiakKV.RpbCSBucketReq{}
+	case rpbCode_RpbCSBucketResp:
+		msg = &rpbRiakKV.RpbCSBucketResp{}
+	case rpbCode_RpbCounterUpdateReq:
+		msg = &rpbRiakKV.RpbCounterUpdateReq{}
+	case rpbCode_RpbCounterUpdateResp:
+		msg = &rpbRiakKV.RpbCounterUpdateResp{}
+	case rpbCode_RpbCounterGetReq:
+		msg = &rpbRiakKV.RpbCounterGetReq{}
+	case rpbCode_RpbCounterGetResp:
+		msg = &rpbRiakKV.RpbCounterGetResp{}
+	case rpbCode_RpbYokozunaIndexGetReq:
+		msg = &rpbRiakYZ.RpbYokozunaIndexGetReq{}
+	case rpbCode_RpbYokozunaIndexGetResp:
+		msg = &rpbRiakYZ.RpbYokozunaIndexGetResp{}
+	case rpbCode_RpbYokozunaIndexPutReq:
+		msg = &rpbRiakYZ.RpbYokozunaIndexPutReq{}
+	case rpbCode_RpbYokozunaIndexDeleteReq:
+		msg = &rpbRiakYZ.RpbYokozunaIndexDeleteReq{}
+	case rpbCode_RpbYokozunaSchemaGetReq:
+		msg = &rpbRiakYZ.RpbYokozunaSchemaGetReq{}
+	case rpbCode_RpbYokozunaSchemaGetResp:
+		msg = &rpbRiakYZ.RpbYokozunaSchemaGetResp{}
+	case rpbCode_RpbYokozunaSchemaPutReq:
+		msg = &rpbRiakYZ.RpbYokozunaSchemaPutReq{}
+	case rpbCode_DtFetchReq:
+		msg = &rpbRiakDT.DtFetchReq{}
+	case rpbCode_DtFetchResp:
+		msg = &rpbRiakDT.DtFetchResp{}
+	case rpbCode_DtUpdateReq:
+		msg = &rpbRiakDT.DtUpdateReq{}
+	case rpbCode_DtUpdateResp:
+		msg = &rpbRiakDT.DtUpdateResp{}
+	case rpbCode_RpbAuthReq:
+		msg = &rpbRiak.RpbAuthReq{}
+	case rpbCode_RpbAuthResp, rpbCode_RpbStartTls:
+	default:
+		ok = false
+	}
+	return
+}
@@ -0,0 +1,303 @@
+package riak
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	rpbRiak "github.com/basho/riak-go-client/rpb/riak"
+	proto "github.com/golang/protobuf/proto"
+)
+
+// Capture replay errors
+var (
+	ErrCaptureTlsReplay = errors.New("[Capture] connections that started TLS cannot be replayed")
+)
+
+// captureExchange is a recorded request and the responses that followed it on
+// the same connection
+type captureExchange struct {
+	request   *CaptureFrame
+	responses []*CaptureFrame
+	used      bool
+}
+
+type captureConnKey struct {
+	node string
+	conn uint64
+}
+
+// captureConversations groups frames into per-connection exchanges, ordered
+// by the first appearance of each connection in the capture
+func captureConversations(frames []*CaptureFrame) [][]*captureExchange {
+	var order []captureConnKey
+	conversations := make(map[captureConnKey][]*captureExchange)
+	for _, f := range frames {
+		key := captureConnKey{f.Node, f.Conn}
+		exchanges, seen := conversations[key]
+		if !seen {
+			order = append(order, key)
+		}
+		switch f.Direction {
+		case CaptureSent:
+			exchanges = append(exchanges, &captureExchange{request: f})
+		case CaptureReceived:
+			if len(exchanges) == 0 {
+				logWarn("[Capture]", "ignoring response with no request: %v", f)
+				continue
+			}
+			last := exchanges[len(exchanges)-1]
+			last.responses = append(last.responses, f)
+		}
+		conversations[key] = exchanges
+	}
+	rv := make([][]*captureExchange, 0, len(order))
+	for _, key := range order {
+		rv = append(rv, conversations[key])
+	}
+	return rv
+}
+
+func writeCaptureFrameTo(w io.Writer, code byte, data []byte) error {
+	buf := make([]byte, 5+len(data))
+	binary.BigEndian.PutUint32(buf, uint32(len(data)+1))
+	buf[4] = code
+	copy(buf[5:], data)
+	_, err := w.Write(buf)
+	return err
+}
+
+func readCaptureFrameFrom(r io.Reader) (code byte, data []byte, err error) {
+	sizeBuf := make([]byte, 4)
+	if _, err = io.ReadFull(r, sizeBuf); err != nil {
+		return
+	}
+	size := binary.BigEndian.Uint32(sizeBuf)
+	if size == 0 {
+		err = ErrZeroLength
+		return
+	}
+	frame := make([]byte, size)
+	if _, err = io.ReadFull(r, frame); err != nil {
+		return
+	}
+	return frame[0], frame[1:], nil
+}
+
+// ReplayCapture sends the requests recorded in frames to the Riak node at
+// address. Each recorded connection is replayed, in order, on its own
+// connection, reading as many responses for each request as were recorded.
+// The frames exchanged during the replay are returned so that they can be
+// compared with the capture or written to a new one.
+func ReplayCapture(address string, frames []*CaptureFrame, timeout time.Duration) (replayed []*CaptureFrame, err error) {
+	if timeout == 0 {
+		timeout = defaultRequestTimeout
+	}
+	for i, conversation := range captureConversations(frames) {
+		var conn net.Conn
+		if conn, err = net.DialTimeout("tcp", address, timeout); err != nil {
+			return
+		}
+		replayed, err = replayConversation(conn, address, uint64(i+1), conversation, timeout, replayed)
+		conn.Close()
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// CaptureMismatch is a response received during a replay that differs from
+// the recorded one. Expected is nil if no such response was recorded.
+type CaptureMismatch struct {
+	Expected *CaptureFrame
+	Got      *CaptureFrame
+}
+
+// CompareReplay compares the responses in replayed, as returned by
+// ReplayCapture for frames, with the recorded responses of the same
+// conversation and request, and returns those that differ. Recorded
+// connections are replayed one at a time, so the frames of different
+// connections are compared by conversation rather than by their order in the
+// capture.
+func CompareReplay(frames, replayed []*CaptureFrame) []CaptureMismatch {
+	conversations := captureConversations(frames)
+	// NB: ReplayCapture numbers the connections by conversation, starting at 1
+	exchanges := make(map[uint64]int)
+	responses := make(map[uint64]int)
+	var mismatches []CaptureMismatch
+	for _, f := range replayed {
+		switch f.Direction {
+		case CaptureSent:
+			exchanges[f.Conn]++
+			responses[f.Conn] = 0
+		case CaptureReceived:
+			var expected *CaptureFrame
+			conv, exchange, response := int(f.Conn)-1, exchanges[f.Conn]-1, responses[f.Conn]
+			responses[f.Conn]++
+			if conv >= 0 && conv < len(conversations) && exchange >= 0 && exchange < len(conversations[conv]) {
+				if recorded := conversations[conv][exchange].responses; response < len(recorded) {
+					expected = recorded[response]
+				}
+			}
+			if expected == nil || expected.Code != f.Code || !bytes.Equal(expected.Data, f.Data) {
+				mismatches = append(mismatches, CaptureMismatch{Expected: expected, Got: f})
+			}
+		}
+	}
+	return mismatches
+}
+
+func replayConversation(conn net.Conn, address string, connId uint64, conversation []*captureExchange, timeout time.Duration, replayed []*CaptureFrame) ([]*CaptureFrame, error) {
+	for _, exchange := range conversation {
+		if exchange.request.Code == rpbCode_RpbStartTls {
+			return replayed, ErrCaptureTlsReplay
+		}
+		conn.SetDeadline(time.Now().Add(timeout))
+		if err := writeCaptureFrameTo(conn, exchange.request.Code, exchange.request.Data); err != nil {
+			return replayed, err
+		}
+		replayed = append(replayed, &CaptureFrame{
+			Timestamp: time.Now(),
+			Node:      address,
+			Conn:      connId,
+			Direction: CaptureSent,
+			Code:      exchange.request.Code,
+			Data:      exchange.request.Data,
+		})
+		for range exchange.responses {
+			conn.SetDeadline(time.Now().Add(timeout))
+			code, data, err := readCaptureFrameFrom(conn)
+			if err != nil {
+				return replayed, err
+			}
+			replayed = append(replayed, &CaptureFrame{
+				Timestamp: time.Now(),
+				Node:      address,
+				Conn:      connId,
+				Direction: CaptureReceived,
+				Code:      code,
+				Data:      data,
+			})
+			if code == rpbCode_RpbErrorResp {
+				break
+			}
+		}
+	}
+	return replayed, nil
+}
+
+// CaptureServer is a fake Riak node that answers requests with the responses
+// recorded in a capture. A request is answered with the responses that
+// followed the first not yet used identical request in the capture, or the
+// last used one if every identical request has been used. Requests that were
+// never recorded are answered with an RpbErrorResp.
+type CaptureServer struct {
+	mtx       sync.Mutex
+	exchanges []*captureExchange
+	listener  net.Listener
+	conns     map[net.Conn]bool
+	wg        sync.WaitGroup
+}
+
+// StartCaptureServer starts a CaptureServer listening on address, for
+// instance "127.0.0.1:0", that replays the responses recorded in frames
+func StartCaptureServer(address string, frames []*CaptureFrame) (*CaptureServer, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	s := &CaptureServer{
+		listener: listener,
+		conns:    make(map[net.Conn]bool),
+	}
+	for _, conversation := range captureConversations(frames) {
+		s.exchanges = append(s.exchanges, conversation...)
+	}
+	s.wg.Add(1)
+	go s.accept()
+	return s, nil
+}
+
+// Addr returns the address the server is listening on
+func (s *CaptureServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close stops the server and closes every client connection
+func (s *CaptureServer) Close() error {
+	err := s.listener.Close()
+	s.mtx.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mtx.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *CaptureServer) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mtx.Lock()
+		s.conns[conn] = true
+		s.mtx.Unlock()
+		s.wg.Add(1)
+		go s.serve(conn)
+	}
+}
+
+func (s *CaptureServer) serve(conn net.Conn) {
+	defer func() {
+		s.mtx.Lock()
+		delete(s.conns, conn)
+		s.mtx.Unlock()
+		conn.Close()
+		s.wg.Done()
+	}()
+	for {
+		code, data, err := readCaptureFrameFrom(conn)
+		if err != nil {
+			return
+		}
+		for _, response := range s.responsesFor(code, data) {
+			if err = writeCaptureFrameTo(conn, response.Code, response.Data); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *CaptureServer) responsesFor(code byte, data []byte) []*CaptureFrame {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	var lastUsed *captureExchange
+	for _, exchange := range s.exchanges {
+		if exchange.request.Code != code || !bytes.Equal(exchange.request.Data, data) {
+			continue
+		}
+		if !exchange.used {
+			exchange.used = true
+			return exchange.responses
+		}
+		lastUsed = exchange
+	}
+	if lastUsed != nil {
+		return lastUsed.responses
+	}
+	errResp := &rpbRiak.RpbErrorResp{
+		Errmsg:  []byte(fmt.Sprintf("no recorded response for %s(%d)", rpbCodeName(code), code)),
+		Errcode: proto.Uint32(0),
+	}
+	errData, _ := proto.Marshal(errResp)
+	return []*CaptureFrame{{Code: rpbCode_RpbErrorResp, Data: errData}}
+}
@@ -0,0 +1,273 @@
+package riak
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	rpbRiak "github.com/basho/riak-go-client/rpb/riak"
+	rpbRiakKV "github.com/basho/riak-go-client/rpb/riak_kv"
+	proto "github.com/golang/protobuf/proto"
+)
+
+func captureFrame(direction CaptureDirection, code byte, msg proto.Message) *CaptureFrame {
+	f := &CaptureFrame{
+		Timestamp: time.Now(),
+		Node:      "127.0.0.1:8087",
+		Conn:      1,
+		Direction: direction,
+		Code:      code,
+	}
+	if msg != nil {
+		var err error
+		if f.Data, err = proto.Marshal(msg); err != nil {
+			panic(err)
+		}
+	}
+	return f
+}
+
+func TestCaptureRecordsConnectionFrames(t *testing.T) {
+	addr, err := net.ResolveTCPAddr("tcp4", "127.0.0.1:8087")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	buf := new(bytes.Buffer)
+	opts := &connectionOptions{
+		remoteAddress: addr,
+		dialFunc:      pipeDialFunc,
+		capture:       NewCapture(buf),
+	}
+	conn, err := newConnection(opts)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := conn.connect(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer conn.close()
+	if err := conn.execute(&PingCommand{}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	frames, err := ReadCapture(buf)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := 2, len(frames); expected != actual {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := CaptureSent, frames[0].Direction; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := rpbCode_RpbPingReq, frames[0].Code; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := CaptureReceived, frames[1].Direction; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := rpbCode_RpbPingResp, frames[1].Code; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	for _, f := range frames {
+		if expected, actual := "127.0.0.1:8087", f.Node; expected != actual {
+			t.Errorf("expected %v, got %v", expected, actual)
+		}
+		if expected, actual := uint64(1), f.Conn; expected != actual {
+			t.Errorf("expected %v, got %v", expected, actual)
+		}
+		if f.Timestamp.IsZero() {
+			t.Error("expected non-zero timestamp")
+		}
+	}
+}
+
+func TestCaptureFrameString(t *testing.T) {
+	f := captureFrame(CaptureSent, rpbCode_RpbGetReq, &rpbRiakKV.RpbGetReq{
+		Bucket: []byte("bucket"),
+		Key:    []byte("key"),
+	})
+	s := f.String()
+	for _, expected := range []string{"127.0.0.1:8087", "RpbGetReq(9)", `bucket:"bucket"`, `key:"key"`} {
+		if !strings.Contains(s, expected) {
+			t.Errorf("expected '%s' to contain '%s'", s, expected)
+		}
+	}
+}
+
+func TestCaptureRedactsAuthPassword(t *testing.T) {
+	authReq := &rpbRiak.RpbAuthReq{
+		User:     []byte("riakuser"),
+		Password: []byte("secret"),
+	}
+	data, err := proto.Marshal(authReq)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	buf := new(bytes.Buffer)
+	capture := NewCapture(buf)
+	capture.record("127.0.0.1:8087", 1, CaptureSent, append([]byte{rpbCode_RpbAuthReq}, data...))
+	if bytes.Contains(buf.Bytes(), []byte("secret")) {
+		t.Error("expected password to be redacted")
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("riakuser")) {
+		t.Error("expected user to be captured")
+	}
+}
+
+func TestReadCaptureRejectsOtherFormats(t *testing.T) {
+	if _, err := ReadCapture(strings.NewReader("not a capture file")); err != ErrCaptureFormat {
+		t.Errorf("expected %v, got %v", ErrCaptureFormat, err)
+	}
+}
+
+func TestCaptureServerReplaysResponses(t *testing.T) {
+	cmd, err := NewFetchValueCommandBuilder().
+		WithBucket("bucket").
+		WithKey("key").
+		Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	getReq, err := cmd.constructPbRequest()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	getResp := &rpbRiakKV.RpbGetResp{
+		Content: []*rpbRiakKV.RpbContent{
+			{Value: []byte("sibling 1")},
+			{Value: []byte("sibling 2")},
+		},
+		Vclock: []byte("vclock"),
+	}
+	frames := []*CaptureFrame{
+		captureFrame(CaptureSent, rpbCode_RpbPingReq, nil),
+		captureFrame(CaptureReceived, rpbCode_RpbPingResp, nil),
+		captureFrame(CaptureSent, rpbCode_RpbGetReq, getReq),
+		captureFrame(CaptureReceived, rpbCode_RpbGetResp, getResp),
+	}
+	server, err := StartCaptureServer("127.0.0.1:0", frames)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer server.Close()
+
+	node, err := NewNode(&NodeOptions{RemoteAddress: server.Addr()})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := node.start(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer node.stop()
+
+	if _, err := node.execute(cmd); err != nil {
+		t.Fatal(err.Error())
+	}
+	fcmd := cmd.(*FetchValueCommand)
+	if expected, actual := 2, len(fcmd.Response.Values); expected != actual {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := "sibling 2", string(fcmd.Response.Values[1].Value); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	cmd, err = NewFetchValueCommandBuilder().
+		WithBucket("bucket").
+		WithKey("other").
+		Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := node.execute(cmd); err == nil {
+		t.Error("expected error for a request that was not recorded")
+	} else if _, ok := err.(RiakError); !ok {
+		t.Errorf("expected RiakError, got %v", err)
+	}
+}
+
+func TestReplayCaptureAgainstServer(t *testing.T) {
+	frames := []*CaptureFrame{
+		captureFrame(CaptureSent, rpbCode_RpbPingReq, nil),
+		captureFrame(CaptureReceived, rpbCode_RpbPingResp, nil),
+		captureFrame(CaptureSent, rpbCode_RpbListKeysReq, &rpbRiakKV.RpbListKeysReq{Bucket: []byte("bucket")}),
+		captureFrame(CaptureReceived, rpbCode_RpbListKeysResp, &rpbRiakKV.RpbListKeysResp{Keys: [][]byte{[]byte("k1")}}),
+		captureFrame(CaptureReceived, rpbCode_RpbListKeysResp, &rpbRiakKV.RpbListKeysResp{Done: proto.Bool(true)}),
+	}
+	server, err := StartCaptureServer("127.0.0.1:0", frames)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer server.Close()
+
+	replayed, err := ReplayCapture(server.Addr(), frames, time.Second)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := len(frames), len(replayed); expected != actual {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+	for i, f := range frames {
+		if expected, actual := f.Code, replayed[i].Code; expected != actual {
+			t.Errorf("expected %v, got %v", expected, actual)
+		}
+		if !bytes.Equal(f.Data, replayed[i].Data) {
+			t.Errorf("expected %v, got %v", f.Data, replayed[i].Data)
+		}
+	}
+}
+
+func TestCompareReplayOfInterleavedConnections(t *testing.T) {
+	onConn := func(conn uint64, f *CaptureFrame) *CaptureFrame {
+		f.Conn = conn
+		return f
+	}
+	frames := []*CaptureFrame{
+		// NB: a response with no request is ignored, and must not shift the comparison
+		onConn(3, captureFrame(CaptureReceived, rpbCode_RpbPingResp, nil)),
+		onConn(1, captureFrame(CaptureSent, rpbCode_RpbPingReq, nil)),
+		onConn(2, captureFrame(CaptureSent, rpbCode_RpbListKeysReq, &rpbRiakKV.RpbListKeysReq{Bucket: []byte("bucket")})),
+		onConn(2, captureFrame(CaptureReceived, rpbCode_RpbListKeysResp, &rpbRiakKV.RpbListKeysResp{Keys: [][]byte{[]byte("k1")}})),
+		onConn(1, captureFrame(CaptureReceived, rpbCode_RpbPingResp, nil)),
+		onConn(2, captureFrame(CaptureReceived, rpbCode_RpbListKeysResp, &rpbRiakKV.RpbListKeysResp{Done: proto.Bool(true)})),
+		onConn(1, captureFrame(CaptureSent, rpbCode_RpbListBucketsReq, &rpbRiakKV.RpbListBucketsReq{})),
+		onConn(1, captureFrame(CaptureReceived, rpbCode_RpbListBucketsResp, &rpbRiakKV.RpbListBucketsResp{Buckets: [][]byte{[]byte("bucket")}})),
+	}
+	server, err := StartCaptureServer("127.0.0.1:0", frames)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer server.Close()
+
+	replayed, err := ReplayCapture(server.Addr(), frames, time.Second)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if mismatches := CompareReplay(frames, replayed); len(mismatches) != 0 {
+		t.Errorf("expected no mismatches, got %v", mismatches)
+	}
+
+	var changed *CaptureFrame
+	for _, f := range replayed {
+		if f.Direction == CaptureReceived && f.Code == rpbCode_RpbListBucketsResp {
+			changed = f
+		}
+	}
+	if changed == nil {
+		t.Fatal("expected a replayed RpbListBucketsResp")
+	}
+	changed.Data = []byte("different")
+	replayed = append(replayed, &CaptureFrame{Conn: changed.Conn, Direction: CaptureReceived, Code: rpbCode_RpbPingResp})
+	mismatches := CompareReplay(frames, replayed)
+	if expected, actual := 2, len(mismatches); expected != actual {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := frames[7], mismatches[0].Expected; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if mismatches[1].Expected != nil {
+		t.Errorf("expected unexpected response, got %v", mismatches[1].Expected)
+	}
+}
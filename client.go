@@ -1,36 +1,143 @@
 package riak
 
 import (
-	"net"
+	"context"
+	"strings"
 )
 
-// Client object contains your active connection to Riak and the debug flag
+// ClientOptions are the options of a Client
+type ClientOptions struct {
+	// Executor executes the commands of the Client, usually a Cluster. If nil,
+	// a Cluster of the nodes at RemoteAddresses is created, which is started by
+	// NewClient and stopped by Client.Stop.
+	Executor Executor
+
+	// RemoteAddresses are the addresses of the Riak nodes, e.g.
+	// "127.0.0.1:8087", used when Executor is nil. If empty, the default node
+	// address is used.
+	RemoteAddresses []string
+
+	// ConflictResolver, if set, resolves siblings when fetching values
+	ConflictResolver ConflictResolver
+}
+
+// Client is a high-level API to Riak. Its methods build and execute the
+// commands, and return their responses. Each method takes a context; its
+// deadline is sent to Riak as the request timeout, and the method returns the
+// context's error as soon as it is done.
+//
+//	client, err := NewClient(&ClientOptions{RemoteAddresses: []string{"127.0.0.1:8087"}})
+//	if err != nil {
+//		return err
+//	}
+//	defer client.Stop()
+//	count, err := client.Counter("counters", "visits", "home").Increment(ctx, 1)
 type Client struct {
-	conn  *connection
-	debug bool
+	executor Executor
+	cluster  *Cluster
+	resolver ConflictResolver
+}
+
+// NewClient returns a Client executing commands with the Executor of the
+// options, or with a new, started Cluster. The options may be nil.
+func NewClient(options *ClientOptions) (*Client, error) {
+	if options == nil {
+		options = &ClientOptions{}
+	}
+	c := &Client{
+		executor: options.Executor,
+		resolver: options.ConflictResolver,
+	}
+	if c.executor == nil {
+		nodes := make([]*Node, 0, len(options.RemoteAddresses))
+		for _, addr := range options.RemoteAddresses {
+			node, err := NewNode(&NodeOptions{RemoteAddress: addr})
+			if err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, node)
+		}
+		cluster, err := NewCluster(&ClusterOptions{Nodes: nodes})
+		if err != nil {
+			return nil, err
+		}
+		if err = cluster.Start(); err != nil {
+			return nil, err
+		}
+		c.executor, c.cluster = cluster, cluster
+	}
+	return c, nil
 }
 
-// New generates a new Client object using an address string in the form of
-// "127.0.0.1:8098" with default connectionOptions
+// New returns a started Client of the Riak nodes at addrs, a comma separated
+// list of addresses such as "127.0.0.1:8087"
 func New(addrs string) (*Client, error) {
-	addr, err := net.ResolveTCPAddr("tcp", addrs)
-	if err != nil {
-		return nil, err
+	return NewClient(&ClientOptions{RemoteAddresses: strings.Split(addrs, ",")})
+}
+
+// Stop stops the Cluster created by NewClient. A Client using the Executor of
+// its options leaves it running.
+func (c *Client) Stop() error {
+	if c.cluster == nil {
+		return nil
 	}
-	opts := &connectionOptions{remoteAddress: addr}
-	conn, err := newConnection(opts)
+	return c.cluster.Stop()
+}
+
+// Bucket returns a Bucket of the Client, which resolves siblings with the
+// ConflictResolver of the Client
+func (c *Client) Bucket(bucketType, name string) *Bucket {
+	return NewBucket(c.executor, bucketType, name, &BucketOptions{ConflictResolver: c.resolver})
+}
+
+// Get fetches the value of a key. A missing key is not an error: the response
+// has IsNotFound set. Siblings are returned unless the Client has a
+// ConflictResolver.
+func (c *Client) Get(ctx context.Context, bucketType, bucket, key string) (*FetchValueResponse, error) {
+	return c.Bucket(bucketType, bucket).Get(ctx, key)
+}
+
+// Put stores the value under a key, using the VClock of the value if it was
+// fetched. If key is empty, Riak generates one, which is returned in the
+// response.
+func (c *Client) Put(ctx context.Context, bucketType, bucket, key string, value *Object) (*StoreValueResponse, error) {
+	return c.Bucket(bucketType, bucket).Put(ctx, key, value)
+}
+
+// Delete deletes the value of a key
+func (c *Client) Delete(ctx context.Context, bucketType, bucket, key string) error {
+	return c.Bucket(bucketType, bucket).Delete(ctx, key)
+}
+
+// Search queries a search index, returning the matching documents
+func (c *Client) Search(ctx context.Context, index, query string) (*SearchResponse, error) {
+	cmd, err := NewSearchCommandBuilder().
+		WithIndexName(index).
+		WithQuery(query).
+		Build()
 	if err != nil {
 		return nil, err
 	}
-	client := &Client{
-		conn:  conn,
-		debug: true,
+	if err = executeContext(ctx, c.executor, cmd); err != nil {
+		return nil, err
 	}
-	return client, nil
+	return cmd.(*SearchCommand).Response, nil
+}
+
+// Counter returns a handle on the counter data type at the key. The bucket
+// type must have the counter datatype.
+func (c *Client) Counter(bucketType, bucket, key string) *CounterHandle {
+	return c.Bucket(bucketType, bucket).Counter(key)
+}
+
+// Set returns a handle on the set data type at the key. The bucket type must
+// have the set datatype.
+func (c *Client) Set(bucketType, bucket, key string) *SetHandle {
+	return c.Bucket(bucketType, bucket).Set(key)
 }
 
-// Debug controls the debug flag for the Client object, allowing debug messages
-// to be written to the logs
-func (c *Client) Debug(debug bool) {
-	c.debug = debug
+// Map returns a handle on the map data type at the key. The bucket type must
+// have the map datatype.
+func (c *Client) Map(bucketType, bucket, key string) *MapHandle {
+	return c.Bucket(bucketType, bucket).Map(key)
 }
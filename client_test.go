@@ -0,0 +1,210 @@
+package riak
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func startTestClient(t *testing.T) (*Client, func()) {
+	server, cluster := startTestServerCluster(t)
+	for name, datatype := range map[string]string{"counters": "counter", "sets": "set", "maps": "map"} {
+		if err := server.CreateBucketType(name, datatype); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	client, err := NewClient(&ClientOptions{Executor: cluster})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return client, func() {
+		stopTestServerCluster(server, cluster)
+	}
+}
+
+func TestClientGetPutDelete(t *testing.T) {
+	client, stop := startTestClient(t)
+	defer stop()
+	ctx := context.Background()
+
+	response, err := client.Get(ctx, "default", "users", "alice")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !response.IsNotFound {
+		t.Error("expected not found")
+	}
+
+	value := &Object{ContentType: "text/plain", Value: []byte("Alice")}
+	if _, err = client.Put(ctx, "default", "users", "alice", value); err != nil {
+		t.Fatal(err.Error())
+	}
+	if response, err = client.Get(ctx, "default", "users", "alice"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := 1, len(response.Values); expected != actual {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := "Alice", string(response.Values[0].Value); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	stored, err := client.Put(ctx, "default", "users", "", value)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if stored.GeneratedKey == "" {
+		t.Error("expected generated key")
+	}
+
+	if err = client.Delete(ctx, "default", "users", "alice"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if response, err = client.Get(ctx, "default", "users", "alice"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if !response.IsNotFound {
+		t.Error("expected not found")
+	}
+}
+
+func TestClientCounter(t *testing.T) {
+	client, stop := startTestClient(t)
+	defer stop()
+	ctx := context.Background()
+	counter := client.Counter("counters", "visits", "home")
+	if value, err := counter.Value(ctx); err != nil || value != 0 {
+		t.Errorf("expected 0, got %v, %v", value, err)
+	}
+	if value, err := counter.Increment(ctx, 5); err != nil || value != 5 {
+		t.Errorf("expected 5, got %v, %v", value, err)
+	}
+	if value, err := counter.Increment(ctx, -2); err != nil || value != 3 {
+		t.Errorf("expected 3, got %v, %v", value, err)
+	}
+	if value, err := counter.Value(ctx); err != nil || value != 3 {
+		t.Errorf("expected 3, got %v, %v", value, err)
+	}
+}
+
+func TestClientSet(t *testing.T) {
+	client, stop := startTestClient(t)
+	defer stop()
+	ctx := context.Background()
+	set := client.Set("sets", "tags", "post")
+	if _, err := set.Add(ctx, []byte("go"), []byte("riak")); err != nil {
+		t.Fatal(err.Error())
+	}
+	members, err := set.Remove(ctx, []byte("go"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := [][]byte{[]byte("riak")}, members; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+	if members, err = set.Members(ctx); err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := [][]byte{[]byte("riak")}, members; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+}
+
+func TestClientMap(t *testing.T) {
+	client, stop := startTestClient(t)
+	defer stop()
+	ctx := context.Background()
+	m := client.Map("maps", "users", "alice")
+	if value, err := m.Value(ctx); err != nil || value != nil {
+		t.Errorf("expected nil, got %v, %v", value, err)
+	}
+	value, err := m.Update(ctx, func(op *MapOperation) {
+		op.SetRegister("name", []byte("Alice"))
+		op.SetFlag("admin", true)
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := "Alice", string(value.Registers["name"]); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	// NB: removals need the context of the map, which Update fetches
+	if value, err = m.Update(ctx, func(op *MapOperation) {
+		op.RemoveFlag("admin")
+	}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, ok := value.Flags["admin"]; ok {
+		t.Error("expected flag to be removed")
+	}
+	if value, err = m.Value(ctx); err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := "Alice", string(value.Registers["name"]); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestClientSearch(t *testing.T) {
+	executor := NewMockExecutor()
+	executor.On(&SearchCommand{}).Respond(&SearchResponse{NumFound: 1})
+	client, err := NewClient(&ClientOptions{Executor: executor})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	response, err := client.Search(context.Background(), "users", "name_s:Alice")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := uint32(1), response.NumFound; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestClientReturnsContextError(t *testing.T) {
+	client, stop := startTestClient(t)
+	defer stop()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	time.Sleep(5 * time.Millisecond)
+	if _, err := client.Get(ctx, "default", "users", "alice"); err != context.DeadlineExceeded {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestClientCreatesCluster(t *testing.T) {
+	server, cluster := startTestServerCluster(t)
+	defer stopTestServerCluster(server, cluster)
+	client, err := New(server.Addr())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err = client.Get(context.Background(), "default", "users", "alice"); err != nil {
+		t.Error(err.Error())
+	}
+	if err = client.Stop(); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestClientBucketOptionsKeepConflictResolver(t *testing.T) {
+	executor := NewMockExecutor()
+	executor.On(&FetchValueCommand{}).Respond(&FetchValueResponse{IsNotFound: true})
+	resolver := NewLastModifiedResolver()
+	client, err := NewClient(&ClientOptions{Executor: executor, ConflictResolver: resolver})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	bucket := client.Bucket("default", "users").WithOptions(&BucketOptions{R: QuorumQuorum})
+	if _, err = bucket.Get(context.Background(), "alice"); err != nil {
+		t.Fatal(err.Error())
+	}
+	cmd := executor.Executed()[0].(*FetchValueCommand)
+	if cmd.resolver == nil {
+		t.Error("expected the conflict resolver of the client")
+	}
+	if expected, actual := QuorumQuorum, cmd.protobuf.GetR(); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
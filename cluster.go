@@ -12,6 +12,13 @@ const (
 	clusterShutdown
 )
 
+// Executor is implemented by types that execute Commands, such as Cluster.
+// Application code that depends on an Executor rather than a *Cluster can be
+// unit tested with a MockExecutor.
+type Executor interface {
+	Execute(command Command) error
+}
+
 // ClusterOptions object contains your pool of Node objects and the NodeManager
 // If the NodeManager is not defined, the defaultNodeManager is used
 type ClusterOptions struct {
@@ -141,6 +148,10 @@ func (c *Cluster) Execute(command Command) (err error) {
 			command.decrementRemainingTries()
 		}
 	}
+	if err == nil && !executed {
+		// NB: every node was unavailable, e.g. health checking
+		err = ErrNoNodesAvailable
+	}
 	// NB: do *not* call command.onError here as it will have been called in connection
 	// TODO
 	// if !executed and command has remaining tries, queue command?
@@ -149,6 +160,51 @@ func (c *Cluster) Execute(command Command) (err error) {
 	return
 }
 
+// ServerVersions fetches the Riak version of every node in the Cluster, keyed by node address.
+// Each node records its version and from then on refuses commands that need a later version of
+// Riak. Nodes that could not be queried are left out of the result, and the last error is returned.
+func (c *Cluster) ServerVersions() (versions map[string]string, err error) {
+	versions = make(map[string]string, len(c.nodes))
+	for _, node := range c.nodes {
+		cmd := &FetchServerInfoCommand{}
+		executed, nodeErr := node.execute(cmd)
+		if nodeErr == nil && !executed {
+			nodeErr = newClientError(fmt.Sprintf("[Cluster] node %v is not available", node.addr))
+		}
+		if nodeErr != nil {
+			logDebug("[Cluster]", "could not fetch server info of node %v: %v", node, nodeErr)
+			err = nodeErr
+			continue
+		}
+		versions[node.addr.String()] = cmd.Response.ServerVersion
+	}
+	return
+}
+
+// ExecuteOnEachNode executes a command on each available node of the Cluster,
+// without retrying on other nodes, for instance to check that every node sees
+// a change. newCommand builds the command for each node, and done is called
+// with the command and the error of executing it. Unavailable nodes, such as
+// nodes being health checked, are skipped. Iteration stops at the first error
+// returned by newCommand or done.
+func (c *Cluster) ExecuteOnEachNode(newCommand func() (Command, error), done func(cmd Command, err error) error) error {
+	for _, node := range c.nodes {
+		cmd, err := newCommand()
+		if err != nil {
+			return err
+		}
+		executed, err := node.execute(cmd)
+		if !executed && err == nil {
+			logDebug("[Cluster]", "skipping unavailable node %v", node)
+			continue
+		}
+		if err = done(cmd, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func optNodes(nodes []*Node) (rv []*Node, err error) {
 	if nodes == nil {
 		nodes = make([]*Node, 0)
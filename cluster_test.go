@@ -1,8 +1,13 @@
 package riak
 
 import (
+	"errors"
 	"fmt"
+	"reflect"
 	"testing"
+	"time"
+
+	"github.com/basho/riak-go-client/riaktest"
 )
 
 func TestCreateClusterWithDefaultOptions(t *testing.T) {
@@ -75,3 +80,103 @@ func ExampleNewCluster() {
 	fmt.Println(cluster.nodes[0].addr.String())
 	// Output: 127.0.0.1:8087
 }
+
+func TestClusterRetriesAfterOverload(t *testing.T) {
+	server, proxy, node := newProxiedNode(t, &NodeOptions{})
+	defer proxy.Close()
+	defer server.Close()
+	cluster, err := NewCluster(&ClusterOptions{
+		Nodes:             []*Node{node},
+		ExecutionAttempts: 3,
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := cluster.Start(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer cluster.Stop()
+	proxy.Inject(riaktest.ErrorResponse("overload", 0), 2)
+	cmd := &PingCommand{}
+	if err := cluster.Execute(cmd); err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := true, cmd.Successful(); expected != actual {
+		t.Errorf("expected %v, got: %v", expected, actual)
+	}
+}
+
+func TestClusterReturnsErrorWhenNoNodeIsAvailable(t *testing.T) {
+	server, proxy, node := newProxiedNode(t, &NodeOptions{
+		HealthCheckInterval: time.Minute,
+	})
+	defer proxy.Close()
+	defer server.Close()
+	cluster, err := NewCluster(&ClusterOptions{
+		Nodes: []*Node{node},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := cluster.Start(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer cluster.Stop()
+	proxy.Inject(riaktest.DropMidFrame(), 1)
+	if err := cluster.Execute(&PingCommand{}); err != ErrNoNodesAvailable {
+		t.Errorf("expected %v, got: %v", ErrNoNodesAvailable, err)
+	}
+}
+
+func TestClusterExecutesOnEachNode(t *testing.T) {
+	var nodes []*Node
+	for i := 0; i < 2; i++ {
+		server, err := riaktest.NewServer()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		defer server.Close()
+		server.SetServerVersion(fmt.Sprintf("2.%d.0", i))
+		node, err := NewNode(&NodeOptions{RemoteAddress: server.Addr()})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		nodes = append(nodes, node)
+	}
+	cluster, err := NewCluster(&ClusterOptions{Nodes: nodes})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := cluster.Start(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer cluster.Stop()
+
+	var versions []string
+	err = cluster.ExecuteOnEachNode(func() (Command, error) {
+		return NewFetchServerInfoCommandBuilder().Build()
+	}, func(cmd Command, err error) error {
+		if err == nil {
+			versions = append(versions, cmd.(*FetchServerInfoCommand).Response.ServerVersion)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := []string{"2.0.0", "2.1.0"}, versions; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got: %v", expected, actual)
+	}
+
+	calls := 0
+	stop := errors.New("stop")
+	err = cluster.ExecuteOnEachNode(func() (Command, error) {
+		return &PingCommand{}, nil
+	}, func(cmd Command, err error) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("expected to stop after the first node, got: %v, %v", err, calls)
+	}
+}
@@ -0,0 +1,184 @@
+package riak
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"reflect"
+	"strings"
+	"sync"
+
+	proto "github.com/golang/protobuf/proto"
+)
+
+// Content types of the built-in codecs
+const (
+	ContentTypeText     = "text/plain"
+	ContentTypeJSON     = "application/json"
+	ContentTypeGob      = "application/x-gob"
+	ContentTypeProtobuf = "application/x-protobuf"
+	ContentTypeMsgpack  = "application/x-msgpack"
+)
+
+// ContentEncodingGzip is the content encoding of gzip compressed values
+const ContentEncodingGzip = "gzip"
+
+// Codec encodes values to and decodes values from the bytes stored in Riak
+// for a content type
+type Codec interface {
+	Encode(v interface{}) ([]byte, error)
+	Decode(data []byte, v interface{}) error
+}
+
+var (
+	codecsMtx sync.RWMutex
+	codecs    = map[string]Codec{
+		ContentTypeText:           textCodec{},
+		ContentTypeJSON:           jsonCodec{},
+		ContentTypeGob:            gobCodec{},
+		ContentTypeProtobuf:       protobufCodec{},
+		"application/protobuf":    protobufCodec{},
+		ContentTypeMsgpack:        msgpackCodec{},
+		"application/msgpack":     msgpackCodec{},
+		"application/vnd.msgpack": msgpackCodec{},
+	}
+)
+
+// RegisterCodec sets the codec used by Object.Decode, Object.Encode and
+// NewObjectFrom for the content type, replacing any codec registered for it.
+// Parameters of the content type, such as a charset, are ignored.
+func RegisterCodec(contentType string, codec Codec) {
+	codecsMtx.Lock()
+	defer codecsMtx.Unlock()
+	codecs[mediaType(contentType)] = codec
+}
+
+// CodecFor returns the codec registered for the content type, if any
+func CodecFor(contentType string) (Codec, bool) {
+	codecsMtx.RLock()
+	defer codecsMtx.RUnlock()
+	codec, ok := codecs[mediaType(contentType)]
+	return codec, ok
+}
+
+// mediaType returns the content type without parameters, in lower case
+func mediaType(contentType string) string {
+	if t, _, err := mime.ParseMediaType(contentType); err == nil {
+		return t
+	}
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
+
+func codecFor(contentType string) (Codec, error) {
+	codec, ok := CodecFor(contentType)
+	if !ok {
+		return nil, newClientError(fmt.Sprintf("[Codec] no codec registered for content type '%s'", contentType))
+	}
+	return codec, nil
+}
+
+// compress applies the content encoding to data. Content encodings other than
+// gzip are left to the application.
+func compress(contentEncoding string, data []byte) ([]byte, error) {
+	if !strings.EqualFold(contentEncoding, ContentEncodingGzip) {
+		return data, nil
+	}
+	buf := new(bytes.Buffer)
+	w := gzip.NewWriter(buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress reverses compress
+func decompress(contentEncoding string, data []byte) ([]byte, error) {
+	if !strings.EqualFold(contentEncoding, ContentEncodingGzip) {
+		return data, nil
+	}
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// textCodec stores strings and byte slices as they are
+type textCodec struct{}
+
+func (textCodec) Encode(v interface{}) ([]byte, error) {
+	switch t := v.(type) {
+	case string:
+		return []byte(t), nil
+	case []byte:
+		return t, nil
+	case fmt.Stringer:
+		return []byte(t.String()), nil
+	}
+	return nil, newClientError(fmt.Sprintf("[Codec] can not encode %v as %s", reflect.TypeOf(v), ContentTypeText))
+}
+
+func (textCodec) Decode(data []byte, v interface{}) error {
+	switch t := v.(type) {
+	case *string:
+		*t = string(data)
+	case *[]byte:
+		*t = append([]byte(nil), data...)
+	default:
+		return newClientError(fmt.Sprintf("[Codec] can not decode %s into %v", ContentTypeText, reflect.TypeOf(v)))
+	}
+	return nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Decode(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Encode(v interface{}) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Decode(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) Encode(v interface{}) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, newClientError(fmt.Sprintf("[Codec] can not encode %v as %s", reflect.TypeOf(v), ContentTypeProtobuf))
+	}
+	return proto.Marshal(msg)
+}
+
+func (protobufCodec) Decode(data []byte, v interface{}) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return newClientError(fmt.Sprintf("[Codec] can not decode %s into %v", ContentTypeProtobuf, reflect.TypeOf(v)))
+	}
+	return proto.Unmarshal(data, msg)
+}
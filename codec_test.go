@@ -0,0 +1,233 @@
+package riak
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	rpbRiakKV "github.com/basho/riak-go-client/rpb/riak_kv"
+	proto "github.com/golang/protobuf/proto"
+)
+
+type codecTestUser struct {
+	Name    string            `msgpack:"name"`
+	Age     int               `msgpack:"age"`
+	Emails  []string          `msgpack:"emails,omitempty"`
+	Scores  map[string]uint16 `msgpack:"scores"`
+	Avatar  []byte            `msgpack:"avatar"`
+	Admin   bool              `msgpack:"admin"`
+	Balance float64           `msgpack:"balance"`
+	Secret  string            `msgpack:"-"`
+}
+
+func newCodecTestUser() *codecTestUser {
+	return &codecTestUser{
+		Name:    "alice",
+		Age:     42,
+		Emails:  []string{"alice@example.com"},
+		Scores:  map[string]uint16{"chess": 1800, "go": 3},
+		Avatar:  []byte{0, 1, 2},
+		Admin:   true,
+		Balance: -12.5,
+	}
+}
+
+func TestObjectRoundTripsThroughCodecs(t *testing.T) {
+	for _, contentType := range []string{ContentTypeJSON, ContentTypeGob, ContentTypeMsgpack} {
+		o, err := NewObjectFrom(newCodecTestUser(), contentType)
+		if err != nil {
+			t.Fatalf("%s: %v", contentType, err)
+		}
+		if expected, actual := contentType, o.ContentType; expected != actual {
+			t.Errorf("expected %v, got %v", expected, actual)
+		}
+		user := &codecTestUser{}
+		if err := o.Decode(user); err != nil {
+			t.Fatalf("%s: %v", contentType, err)
+		}
+		if expected, actual := newCodecTestUser(), user; !reflect.DeepEqual(expected, actual) {
+			t.Errorf("%s: expected %v, got %v", contentType, expected, actual)
+		}
+	}
+}
+
+func TestObjectRoundTripsThroughProtobufCodec(t *testing.T) {
+	msg := &rpbRiakKV.RpbGetReq{Bucket: []byte("bucket"), Key: []byte("key")}
+	o, err := NewObjectFrom(msg, ContentTypeProtobuf)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	decoded := &rpbRiakKV.RpbGetReq{}
+	if err := o.Decode(decoded); err != nil {
+		t.Fatal(err.Error())
+	}
+	if !proto.Equal(msg, decoded) {
+		t.Errorf("expected %v, got %v", msg, decoded)
+	}
+	if _, err := NewObjectFrom("not a message", ContentTypeProtobuf); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestObjectRoundTripsThroughTextCodec(t *testing.T) {
+	o, err := NewObjectFrom("this is a value in Riak", "text/plain; charset=utf-8")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := "this is a value in Riak", string(o.Value); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	var s string
+	if err := o.Decode(&s); err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := "this is a value in Riak", s; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestObjectGzipContentEncoding(t *testing.T) {
+	o := &Object{
+		ContentType:     ContentTypeJSON,
+		ContentEncoding: ContentEncodingGzip,
+	}
+	if err := o.Encode(newCodecTestUser()); err != nil {
+		t.Fatal(err.Error())
+	}
+	r, err := gzip.NewReader(bytes.NewReader(o.Value))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !strings.Contains(string(data), `"alice"`) {
+		t.Errorf("expected JSON value, got %s", data)
+	}
+	user := &codecTestUser{}
+	if err := o.Decode(user); err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := "alice", user.Name; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestObjectWithoutCodec(t *testing.T) {
+	o := &Object{ContentType: "application/x-unknown", Value: []byte("value")}
+	var s string
+	if err := o.Decode(&s); err == nil {
+		t.Error("expected error")
+	} else if _, ok := err.(ClientError); !ok {
+		t.Errorf("expected ClientError, got %v", err)
+	}
+}
+
+type upperCodec struct{}
+
+func (upperCodec) Encode(v interface{}) ([]byte, error) {
+	return []byte(strings.ToUpper(v.(string))), nil
+}
+
+func (upperCodec) Decode(data []byte, v interface{}) error {
+	*(v.(*string)) = strings.ToLower(string(data))
+	return nil
+}
+
+func TestRegisterCodec(t *testing.T) {
+	RegisterCodec("Application/X-Upper", upperCodec{})
+	if _, ok := CodecFor("application/x-upper; charset=utf-8"); !ok {
+		t.Fatal("expected codec")
+	}
+	o, err := NewObjectFrom("value", "application/x-upper")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := "VALUE", string(o.Value); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestMsgpackEncoding(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected []byte
+	}{
+		{nil, []byte{0xc0}},
+		{true, []byte{0xc3}},
+		{1, []byte{0x01}},
+		{-1, []byte{0xff}},
+		{-33, []byte{0xd0, 0xdf}},
+		{256, []byte{0xcd, 0x01, 0x00}},
+		{1.5, []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{"abc", []byte{0xa3, 'a', 'b', 'c'}},
+		{[]byte{1}, []byte{0xc4, 0x01, 0x01}},
+		{[]int{1, 2}, []byte{0x92, 0x01, 0x02}},
+		{map[string]int{"b": 2, "a": 1}, []byte{0x82, 0xa1, 'a', 0x01, 0xa1, 'b', 0x02}},
+	}
+	for _, test := range tests {
+		data, err := msgpackCodec{}.Encode(test.value)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if expected, actual := test.expected, data; !bytes.Equal(expected, actual) {
+			t.Errorf("%v: expected %x, got %x", test.value, expected, actual)
+		}
+	}
+}
+
+func TestMsgpackDecodesIntoInterface(t *testing.T) {
+	data, err := msgpackCodec{}.Encode(newCodecTestUser())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	var v interface{}
+	if err := (msgpackCodec{}).Decode(data, &v); err != nil {
+		t.Fatal(err.Error())
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected map[string]interface{}, got %T", v)
+	}
+	if expected, actual := "alice", m["name"]; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := uint64(42), m["age"]; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := -12.5, m["balance"]; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if _, ok := m["Secret"]; ok {
+		t.Error("expected ignored field not to be encoded")
+	}
+}
+
+func TestMsgpackRejectsMalformedData(t *testing.T) {
+	tests := [][]byte{
+		{},
+		{0xa3, 'a'},                    // truncated string
+		{0xdd, 0xff, 0xff, 0xff, 0xff}, // array longer than the data
+		{0xc1},                         // never used
+		{0x01, 0x02},                   // trailing data
+	}
+	for _, data := range tests {
+		var v interface{}
+		if err := (msgpackCodec{}).Decode(data, &v); err == nil {
+			t.Errorf("%x: expected error", data)
+		}
+	}
+	var small int8
+	if err := (msgpackCodec{}).Decode([]byte{0xcd, 0x01, 0x00}, &small); err == nil {
+		t.Error("expected overflow error")
+	}
+	deep := bytes.Repeat([]byte{0x91}, msgpackMaxDepth+1)
+	var v interface{}
+	if err := (msgpackCodec{}).Decode(append(deep, 0xc0), &v); err == nil {
+		t.Error("expected depth error")
+	}
+}
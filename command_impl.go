@@ -6,6 +6,11 @@ type CommandImpl struct {
 	remainingTries byte
 }
 
+// impl gives access to the CommandImpl embedded in a Command
+func (cmd *CommandImpl) impl() *CommandImpl {
+	return cmd
+}
+
 func (cmd *CommandImpl) Successful() bool {
 	return cmd.Success == true
 }
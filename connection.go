@@ -1,6 +1,8 @@
 package riak
 
 import (
+	"bufio"
+	"context"
 	"crypto/tls"
 	"encoding/binary"
 	"errors"
@@ -26,12 +28,45 @@ type AuthOptions struct {
 	TlsConfig *tls.Config
 }
 
+// FrameSizeError is returned when Riak responds with a frame larger than the maximum frame size
+// configured for the node. The connection is closed when this happens, since the rest of the
+// frame can not be read.
+type FrameSizeError struct {
+	Size uint32
+	Max  uint32
+}
+
+func (e FrameSizeError) Error() string {
+	return fmt.Sprintf("[Connection] frame size %d exceeds maximum frame size %d", e.Size, e.Max)
+}
+
+// frameStreamer is implemented by commands that can consume a response frame larger than the
+// maximum frame size without holding the whole frame in memory. No field other than the streamed
+// values may be larger than the maximum frame size.
+type frameStreamer interface {
+	streamsFrames() bool
+	decodeFrameStream(r *bufio.Reader, size uint32, maxFieldSize uint32) (proto.Message, error)
+}
+
+// DialFunc is used to establish the underlying network connection to a Riak
+// node instead of the default TCP dialer. Its signature matches
+// net.Dialer.DialContext, so proxy dialers and in-memory transports such as
+// net.Pipe can be used. The context has the connect timeout as its deadline,
+// which the DialFunc must honor: the node's connection pool is locked while it
+// dials.
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
 type connectionOptions struct {
 	remoteAddress  *net.TCPAddr
 	connectTimeout time.Duration
 	requestTimeout time.Duration
 	healthCheck    Command
 	authOptions    *AuthOptions
+	dialer         *net.Dialer
+	dialFunc       DialFunc
+	capture        *Capture
+	maxFrameSize   uint32
+	clientId       string
 }
 
 type connState byte
@@ -40,6 +75,7 @@ const (
 	connInactive connState = iota
 	connTlsStarting
 	connActive
+	connAbandoned // NB: a streaming command stopped reading its stream
 )
 
 type connection struct {
@@ -49,6 +85,12 @@ type connection struct {
 	requestTimeout time.Duration
 	healthCheck    Command
 	authOptions    *AuthOptions
+	dialer         *net.Dialer
+	dialFunc       DialFunc
+	capture        *Capture
+	captureId      uint64
+	maxFrameSize   uint32
+	clientId       string
 	sizeBuf        []byte
 	active         bool
 	inFlight       bool
@@ -69,25 +111,54 @@ func newConnection(options *connectionOptions) (*connection, error) {
 	if options.requestTimeout == 0 {
 		options.requestTimeout = defaultRequestTimeout
 	}
-	return &connection{
+	c := &connection{
 		addr:           options.remoteAddress,
 		connectTimeout: options.connectTimeout,
 		requestTimeout: options.requestTimeout,
 		healthCheck:    options.healthCheck,
 		authOptions:    options.authOptions,
+		dialer:         options.dialer,
+		dialFunc:       options.dialFunc,
+		capture:        options.capture,
+		maxFrameSize:   options.maxFrameSize,
+		clientId:       options.clientId,
 		sizeBuf:        make([]byte, 4),
 		inFlight:       false,
 		lastUsed:       time.Now(),
 		state:          connInactive,
-	}, nil
+	}
+	if c.capture != nil {
+		c.captureId = c.capture.newConnId()
+	}
+	return c, nil
 }
 
-func (c *connection) connect() (err error) {
-	dialer := &net.Dialer{
-		Timeout:   c.connectTimeout,
-		KeepAlive: time.Second * 30,
+func (c *connection) dial() (net.Conn, error) {
+	if c.dialFunc != nil {
+		ctx := context.Background()
+		if c.connectTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, c.connectTimeout)
+			defer cancel()
+		}
+		return c.dialFunc(ctx, "tcp", c.addr.String())
+	}
+	// NB: copy the template so that the connect timeout default does not
+	// modify the Dialer shared by every connection to this node
+	var dialer net.Dialer
+	if c.dialer == nil {
+		dialer.KeepAlive = thirtySeconds
+	} else {
+		dialer = *c.dialer
 	}
-	c.conn, err = dialer.Dial("tcp", c.addr.String()) // NB: SetNoDelay() is true by default for TCP connections
+	if dialer.Timeout == 0 {
+		dialer.Timeout = c.connectTimeout
+	}
+	return dialer.Dial("tcp", c.addr.String()) // NB: SetNoDelay() is true by default for TCP connections
+}
+
+func (c *connection) connect() (err error) {
+	c.conn, err = c.dial()
 	if err != nil {
 		logError("[Connection]", "error when dialing %s: '%s'", c.addr.String(), err.Error())
 		c.close()
@@ -98,6 +169,12 @@ func (c *connection) connect() (err error) {
 			return
 		}
 		c.state = connActive
+		if err = c.storeClientId(); err != nil {
+			c.state = connInactive
+			logError("[Connection]", "error when setting client id: '%s'", err.Error())
+			c.close()
+			return
+		}
 		if c.healthCheck != nil {
 			if err = c.execute(c.healthCheck); err != nil || !c.healthCheck.Successful() {
 				c.state = connInactive
@@ -138,6 +215,19 @@ func (c *connection) startTls() (err error) {
 	return
 }
 
+// storeClientId sets the configured client id, after authentication so that
+// the request is allowed
+func (c *connection) storeClientId() error {
+	if c.clientId == "" {
+		return nil
+	}
+	cmd, err := NewStoreClientIdCommandBuilder().WithClientId(c.clientId).Build()
+	if err != nil {
+		return err
+	}
+	return c.execute(cmd)
+}
+
 func (c *connection) available() bool {
 	defer func() {
 		if err := recover(); err != nil {
@@ -147,6 +237,12 @@ func (c *connection) available() bool {
 	return (c.conn != nil && (c.state == connTlsStarting || c.state == connActive))
 }
 
+// abandoned returns whether the connection was left mid-stream by a streaming
+// command, which does not mean that Riak is unhealthy
+func (c *connection) abandoned() bool {
+	return c.state == connAbandoned
+}
+
 func (c *connection) close() (err error) {
 	if c.conn != nil {
 		err = c.conn.Close()
@@ -183,25 +279,54 @@ func (c *connection) execute(cmd Command) (err error) {
 	var response []byte
 	var decoded proto.Message
 	for {
-		response, err = c.read() // NB: response *will* have entire pb message
-		if err != nil {
+		var size uint32
+		if size, err = c.readSize(); err != nil {
 			cmd.onError(err)
 			return
 		}
 
-		// Maybe translate RpbErrorResp into golang error
-		if err = maybeRiakError(response); err != nil {
-			cmd.onError(err)
-			return
-		}
+		if c.maxFrameSize > 0 && size > c.maxFrameSize {
+			fs, ok := cmd.(frameStreamer)
+			if !ok || !fs.streamsFrames() {
+				c.state = connInactive
+				err = FrameSizeError{Size: size, Max: c.maxFrameSize}
+				cmd.onError(err)
+				return
+			}
+			if decoded, err = c.readStream(cmd, fs, size); err != nil {
+				c.state = connInactive
+				cmd.onError(err)
+				return
+			}
+		} else {
+			response, err = c.readData(size) // NB: response *will* have entire pb message
+			if err != nil {
+				cmd.onError(err)
+				return
+			}
 
-		if decoded, err = decodeRiakMessage(cmd, response); err != nil {
-			cmd.onError(err)
-			return
+			// Maybe translate RpbErrorResp into golang error
+			if err = maybeRiakError(response); err != nil {
+				cmd.onError(err)
+				return
+			}
+
+			if decoded, err = decodeRiakMessage(cmd, response); err != nil {
+				// NB: an unexpected message means the connection is out of sync
+				// with the command, so it must not be used again
+				c.state = connInactive
+				cmd.onError(err)
+				return
+			}
 		}
 
 		err = cmd.onSuccess(decoded)
 		if err != nil {
+			if sc, ok := cmd.(StreamingCommand); ok && !sc.Done() {
+				// NB: the rest of the stream is still to be read, so the
+				// connection must not be used again
+				c.state = connAbandoned
+			}
 			cmd.onError(err)
 			return
 		}
@@ -228,36 +353,83 @@ func (c *connection) setReadDeadline() {
  * TODO: as coded, this will read one full pb message from Riak, or error in doing so
  * review for accuracy as well as error conditions
  */
-func (c *connection) read() (data []byte, err error) {
+func (c *connection) readSize() (size uint32, err error) {
 	if !c.available() {
 		err = ErrCannotRead
 		return
 	}
 	c.setReadDeadline()
+	// TODO error conditions http://golang.org/pkg/io/#ReadFull, like EOF conditions
+	if _, err = io.ReadFull(c.conn, c.sizeBuf); err != nil {
+		c.state = connInactive
+		return
+	}
+	size = binary.BigEndian.Uint32(c.sizeBuf)
+	return
+}
+
+func (c *connection) readData(messageLength uint32) (data []byte, err error) {
+	// TODO: investigate using a bytes.Buffer on c instead of
+	// always making a new byte slice, more in-line with Node.js client
+	data = make([]byte, messageLength)
+	c.setReadDeadline()
 	var count int
 	// TODO error conditions http://golang.org/pkg/io/#ReadFull, like EOF conditions
-	if count, err = io.ReadFull(c.conn, c.sizeBuf); err == nil && count == 4 {
-		messageLength := binary.BigEndian.Uint32(c.sizeBuf)
-		// TODO: investigate using a bytes.Buffer on c instead of
-		// always making a new byte slice, more in-line with Node.js client
-		data = make([]byte, messageLength)
-		c.setReadDeadline()
-		// TODO error conditions http://golang.org/pkg/io/#ReadFull, like EOF conditions
-		count, err = io.ReadFull(c.conn, data)
-		if err != nil && err == syscall.EPIPE {
-			c.close()
-		} else if uint32(count) != messageLength {
-			err = fmt.Errorf("[Connection] message length: %d, only read: %d", messageLength, count)
-		}
+	count, err = io.ReadFull(c.conn, data)
+	if err != nil && err == syscall.EPIPE {
+		c.close()
+	} else if uint32(count) != messageLength {
+		err = fmt.Errorf("[Connection] message length: %d, only read: %d", messageLength, count)
 	}
 	if err != nil {
 		// TODO why not close() ?
 		c.state = connInactive
 		data = nil
+	} else if c.capture != nil {
+		c.capture.record(c.addr.String(), c.captureId, CaptureReceived, data)
+	}
+	return
+}
+
+// readStream hands an oversized frame to the command as it is read from the connection. The
+// read deadline is extended before each read, since large frames may take longer than the
+// request timeout to arrive.
+//
+// NB: streamed frames are not recorded by a Capture
+func (c *connection) readStream(cmd Command, fs frameStreamer, size uint32) (decoded proto.Message, err error) {
+	if size == 0 {
+		err = ErrZeroLength
+		return
+	}
+	lr := &io.LimitedReader{R: deadlineReader{c}, N: int64(size)}
+	r := bufio.NewReader(lr)
+	var code byte
+	if code, err = r.ReadByte(); err != nil {
+		return
+	}
+	if code != cmd.getResponseCode() {
+		// an RpbErrorResp or unexpected message can not be streamed
+		err = FrameSizeError{Size: size, Max: c.maxFrameSize}
+		return
+	}
+	if decoded, err = fs.decodeFrameStream(r, size-1, c.maxFrameSize); err != nil {
+		return
+	}
+	if r.Buffered() > 0 || lr.N > 0 {
+		err = fmt.Errorf("[Connection] message length: %d, not entirely decoded", size)
 	}
 	return
 }
 
+type deadlineReader struct {
+	c *connection
+}
+
+func (r deadlineReader) Read(p []byte) (int, error) {
+	r.c.setReadDeadline()
+	return r.c.conn.Read(p)
+}
+
 func (c *connection) write(data []byte) (err error) {
 	if !c.available() {
 		err = ErrCannotWrite
@@ -279,6 +451,10 @@ func (c *connection) write(data []byte) (err error) {
 	if count != len(data) {
 		c.state = connInactive
 		err = fmt.Errorf("[Connection] data length: %d, only wrote: %d", len(data), count)
+		return
+	}
+	if c.capture != nil && len(data) > 4 {
+		c.capture.record(c.addr.String(), c.captureId, CaptureSent, data[4:])
 	}
 	return
 }
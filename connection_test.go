@@ -1,8 +1,12 @@
 package riak
 
 import (
+	"context"
+	"encoding/binary"
+	"io"
 	"net"
 	"testing"
+	"time"
 )
 
 func TestCreateConnection(t *testing.T) {
@@ -81,3 +85,172 @@ func TestEnsureDefaultConnectionValues(t *testing.T) {
 		t.Error(err.Error())
 	}
 }
+
+// pipeServer answers every request frame with an empty response using the
+// next message code, which is correct for RpbPingReq / RpbPingResp
+func pipeServer(conn net.Conn) {
+	defer conn.Close()
+	sizeBuf := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(conn, sizeBuf); err != nil {
+			return
+		}
+		data := make([]byte, binary.BigEndian.Uint32(sizeBuf))
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return
+		}
+		if _, err := conn.Write(buildRiakMessage(data[0]+1, nil)); err != nil {
+			return
+		}
+	}
+}
+
+func pipeDialFunc(ctx context.Context, network, address string) (net.Conn, error) {
+	client, server := net.Pipe()
+	go pipeServer(server)
+	return client, nil
+}
+
+func TestConnectionUsesDialFunc(t *testing.T) {
+	addr, err := net.ResolveTCPAddr("tcp4", "127.0.0.1:8087")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	var dialedNetwork, dialedAddress string
+	opts := &connectionOptions{
+		remoteAddress: addr,
+		dialFunc: func(ctx context.Context, network, address string) (net.Conn, error) {
+			dialedNetwork, dialedAddress = network, address
+			return pipeDialFunc(ctx, network, address)
+		},
+	}
+	conn, err := newConnection(opts)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := conn.connect(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer conn.close()
+	if expected, actual := "tcp", dialedNetwork; expected != actual {
+		t.Errorf("expected %v, got: %v", expected, actual)
+	}
+	if expected, actual := "127.0.0.1:8087", dialedAddress; expected != actual {
+		t.Errorf("expected %v, got: %v", expected, actual)
+	}
+	cmd := &PingCommand{}
+	if err := conn.execute(cmd); err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := true, cmd.Successful(); expected != actual {
+		t.Errorf("expected %v, got: %v", expected, actual)
+	}
+}
+
+func TestConnectionPassesConnectTimeoutToDialFunc(t *testing.T) {
+	addr, err := net.ResolveTCPAddr("tcp4", "127.0.0.1:8087")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	opts := &connectionOptions{
+		remoteAddress:  addr,
+		connectTimeout: 10 * time.Millisecond,
+		dialFunc: func(ctx context.Context, network, address string) (net.Conn, error) {
+			if _, ok := ctx.Deadline(); !ok {
+				t.Error("expected a deadline")
+			}
+			// a dial that hangs until the connect timeout
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+	conn, err := newConnection(opts)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := conn.connect(); err == nil {
+		conn.close()
+		t.Error("expected error")
+	}
+}
+
+func TestConnectionDoesNotModifyDialerTemplate(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer ln.Close()
+	go func() {
+		if c, err := ln.Accept(); err == nil {
+			c.Close()
+		}
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	dialer := &net.Dialer{KeepAlive: time.Minute}
+	opts := &connectionOptions{
+		remoteAddress:  addr,
+		connectTimeout: time.Second,
+		dialer:         dialer,
+	}
+	conn, err := newConnection(opts)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := conn.connect(); err != nil {
+		t.Fatal(err.Error())
+	}
+	conn.close()
+	if expected, actual := time.Duration(0), dialer.Timeout; expected != actual {
+		t.Errorf("expected %v, got: %v", expected, actual)
+	}
+}
+
+func TestConnectionRejectsFrameLargerThanMaxFrameSize(t *testing.T) {
+	addr, err := net.ResolveTCPAddr("tcp4", "127.0.0.1:8087")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	opts := &connectionOptions{
+		remoteAddress: addr,
+		maxFrameSize:  1024,
+		dialFunc: func(ctx context.Context, network, address string) (net.Conn, error) {
+			client, server := net.Pipe()
+			go func() {
+				defer server.Close()
+				sizeBuf := make([]byte, 4)
+				if _, err := io.ReadFull(server, sizeBuf); err != nil {
+					return
+				}
+				if _, err := io.ReadFull(server, make([]byte, binary.BigEndian.Uint32(sizeBuf))); err != nil {
+					return
+				}
+				// a corrupt length prefix that would allocate nearly 4GB
+				server.Write([]byte{0xff, 0xff, 0xff, 0xf0, rpbCode_RpbPingResp})
+			}()
+			return client, nil
+		},
+	}
+	conn, err := newConnection(opts)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := conn.connect(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer conn.close()
+	cmd := &PingCommand{}
+	err = conn.execute(cmd)
+	if fse, ok := err.(FrameSizeError); !ok {
+		t.Fatalf("expected FrameSizeError, got %v", err)
+	} else {
+		if expected, actual := uint32(0xfffffff0), fse.Size; expected != actual {
+			t.Errorf("expected %v, got: %v", expected, actual)
+		}
+		if expected, actual := uint32(1024), fse.Max; expected != actual {
+			t.Errorf("expected %v, got: %v", expected, actual)
+		}
+	}
+	if expected, actual := false, conn.available(); expected != actual {
+		t.Errorf("expected %v, got: %v", expected, actual)
+	}
+}
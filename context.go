@@ -0,0 +1,48 @@
+package riak
+
+import (
+	"context"
+	"time"
+)
+
+// executeContext executes the command, returning early with the context's
+// error if it is done first. The command is not cancelled: it keeps running
+// until Riak responds or the request times out, which is why callers also send
+// the context's deadline to Riak as the request timeout.
+func executeContext(ctx context.Context, executor Executor, cmd Command) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- executor.Execute(cmd)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// timeout returns the time left until the context's deadline, if any, as a
+// request timeout. Riak timeouts are whole milliseconds, so less than a
+// millisecond left is rounded up to one. If the deadline has passed, the
+// context's error is returned instead.
+func timeout(ctx context.Context) (time.Duration, bool, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, false, nil
+	}
+	d := time.Until(deadline)
+	if d <= 0 {
+		if err := ctx.Err(); err != nil {
+			return 0, false, err
+		}
+		return 0, false, context.DeadlineExceeded
+	}
+	if d < time.Millisecond {
+		d = time.Millisecond
+	}
+	return d, true, nil
+}
@@ -0,0 +1,50 @@
+package riak
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTimeoutWithoutDeadline(t *testing.T) {
+	if d, ok, err := timeout(context.Background()); d != 0 || ok || err != nil {
+		t.Errorf("expected no timeout, got %v, %v, %v", d, ok, err)
+	}
+}
+
+func TestTimeoutRoundsUpToOneMillisecond(t *testing.T) {
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(100*time.Microsecond))
+	defer cancel()
+	d, ok, err := timeout(ctx)
+	if err == context.DeadlineExceeded {
+		t.Skip("deadline passed before the timeout was computed")
+	}
+	if err != nil || !ok {
+		t.Fatalf("expected timeout, got %v, %v", ok, err)
+	}
+	if expected, actual := time.Millisecond, d; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestTimeoutReturnsErrorAfterDeadline(t *testing.T) {
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	if _, ok, err := timeout(ctx); ok || err != context.DeadlineExceeded {
+		t.Errorf("expected %v, got %v, %v", context.DeadlineExceeded, ok, err)
+	}
+}
+
+func TestMapperDoesNotSendExpiredTimeout(t *testing.T) {
+	executor := NewMockExecutor()
+	m := NewMapper(executor, nil)
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	user := &mapperTestUser{Email: "alice@example.com"}
+	if err := m.Get(ctx, user); err != context.DeadlineExceeded {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	if expected, actual := 0, len(executor.Executed()); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	rpbRiakDT "github.com/basho/riak-go-client/rpb/riak_dt"
+	rpbRiakKV "github.com/basho/riak-go-client/rpb/riak_kv"
 	proto "github.com/golang/protobuf/proto"
 )
 
@@ -25,6 +26,10 @@ func (cmd *UpdateCounterCommand) Name() string {
 	return "UpdateCounter"
 }
 
+func (cmd *UpdateCounterCommand) minimumVersion() Version {
+	return version2_0
+}
+
 func (cmd *UpdateCounterCommand) constructPbRequest() (proto.Message, error) {
 	return cmd.protobuf, nil
 }
@@ -169,6 +174,10 @@ func (cmd *FetchCounterCommand) Name() string {
 	return "FetchCounter"
 }
 
+func (cmd *FetchCounterCommand) minimumVersion() Version {
+	return version2_0
+}
+
 func (cmd *FetchCounterCommand) constructPbRequest() (proto.Message, error) {
 	return cmd.protobuf, nil
 }
@@ -282,6 +291,267 @@ func (builder *FetchCounterCommandBuilder) Build() (Command, error) {
 	return &FetchCounterCommand{protobuf: builder.protobuf}, nil
 }
 
+// UpdateLegacyCounter
+// RpbCounterUpdateReq
+// RpbCounterUpdateResp
+
+// UpdateLegacyCounterCommand is used to increment or decrement a Riak 1.4 counter, stored in a
+// bucket of the default bucket type with allow_mult set to true. New counters should use the
+// counter data type and UpdateCounterCommand.
+type UpdateLegacyCounterCommand struct {
+	CommandImpl
+	Response *UpdateLegacyCounterResponse
+	protobuf *rpbRiakKV.RpbCounterUpdateReq
+}
+
+// Name identifies this command
+func (cmd *UpdateLegacyCounterCommand) Name() string {
+	return "UpdateLegacyCounter"
+}
+
+func (cmd *UpdateLegacyCounterCommand) minimumVersion() Version {
+	return version1_4
+}
+
+func (cmd *UpdateLegacyCounterCommand) constructPbRequest() (proto.Message, error) {
+	return cmd.protobuf, nil
+}
+
+func (cmd *UpdateLegacyCounterCommand) onSuccess(msg proto.Message) error {
+	cmd.Success = true
+	response := &UpdateLegacyCounterResponse{}
+	if msg != nil {
+		if rpbCounterUpdateResp, ok := msg.(*rpbRiakKV.RpbCounterUpdateResp); ok {
+			response.CounterValue = rpbCounterUpdateResp.GetValue()
+		} else {
+			return fmt.Errorf("[UpdateLegacyCounterCommand] could not convert %v to RpbCounterUpdateResp", reflect.TypeOf(msg))
+		}
+	}
+	cmd.Response = response
+	return nil
+}
+
+func (cmd *UpdateLegacyCounterCommand) getRequestCode() byte {
+	return rpbCode_RpbCounterUpdateReq
+}
+
+func (cmd *UpdateLegacyCounterCommand) getResponseCode() byte {
+	return rpbCode_RpbCounterUpdateResp
+}
+
+func (cmd *UpdateLegacyCounterCommand) getResponseProtobufMessage() proto.Message {
+	return &rpbRiakKV.RpbCounterUpdateResp{}
+}
+
+// UpdateLegacyCounterResponse is the object containing the response. CounterValue is only set
+// when the command was built WithReturnValue(true).
+type UpdateLegacyCounterResponse struct {
+	CounterValue int64
+}
+
+type UpdateLegacyCounterCommandBuilder struct {
+	protobuf *rpbRiakKV.RpbCounterUpdateReq
+}
+
+// NewUpdateLegacyCounterCommandBuilder is a factory function for generating the command builder struct
+func NewUpdateLegacyCounterCommandBuilder() *UpdateLegacyCounterCommandBuilder {
+	return &UpdateLegacyCounterCommandBuilder{
+		protobuf: &rpbRiakKV.RpbCounterUpdateReq{
+			Amount: proto.Int64(0),
+		},
+	}
+}
+
+// WithBucket sets the bucket to be used by the command. Legacy counters are always in the default
+// bucket type.
+func (builder *UpdateLegacyCounterCommandBuilder) WithBucket(bucket string) *UpdateLegacyCounterCommandBuilder {
+	builder.protobuf.Bucket = []byte(bucket)
+	return builder
+}
+
+// WithKey sets the key to be used by the command to read / write values
+func (builder *UpdateLegacyCounterCommandBuilder) WithKey(key string) *UpdateLegacyCounterCommandBuilder {
+	builder.protobuf.Key = []byte(key)
+	return builder
+}
+
+// WithIncrement defines the increment the Counter value is to be increased / decreased by
+func (builder *UpdateLegacyCounterCommandBuilder) WithIncrement(increment int64) *UpdateLegacyCounterCommandBuilder {
+	builder.protobuf.Amount = &increment
+	return builder
+}
+
+// WithW sets the number of nodes that must report back a successful write in order for then
+// command operation to be considered a success by Riak. If ommitted, the bucket default is used.
+//
+// See http://basho.com/posts/technical/riaks-config-behaviors-part-2/
+func (builder *UpdateLegacyCounterCommandBuilder) WithW(w uint32) *UpdateLegacyCounterCommandBuilder {
+	builder.protobuf.W = &w
+	return builder
+}
+
+// WithPw sets the number of primary nodes (N) that must report back a successful write in order for
+// the command operation to be considered a success by Riak.  If ommitted, the bucket default is
+// used.
+//
+// See http://basho.com/posts/technical/riaks-config-behaviors-part-2/
+func (builder *UpdateLegacyCounterCommandBuilder) WithPw(pw uint32) *UpdateLegacyCounterCommandBuilder {
+	builder.protobuf.Pw = &pw
+	return builder
+}
+
+// WithDw (durable writes) sets the number of nodes that must report back a successful write to
+// backend storage in order for the command operation to be considered a success by Riak
+//
+// See http://basho.com/posts/technical/riaks-config-behaviors-part-2/
+func (builder *UpdateLegacyCounterCommandBuilder) WithDw(dw uint32) *UpdateLegacyCounterCommandBuilder {
+	builder.protobuf.Dw = &dw
+	return builder
+}
+
+// WithReturnValue sets Riak to return the counter value within its response after completing the
+// write operation
+func (builder *UpdateLegacyCounterCommandBuilder) WithReturnValue(returnValue bool) *UpdateLegacyCounterCommandBuilder {
+	builder.protobuf.Returnvalue = &returnValue
+	return builder
+}
+
+// Build validates the configuration options provided then builds the command
+func (builder *UpdateLegacyCounterCommandBuilder) Build() (Command, error) {
+	if builder.protobuf == nil {
+		panic("builder.protobuf must not be nil")
+	}
+	if builder.protobuf.GetBucket() == nil {
+		return nil, ErrBucketRequired
+	}
+	if builder.protobuf.GetKey() == nil {
+		return nil, ErrKeyRequired
+	}
+	return &UpdateLegacyCounterCommand{protobuf: builder.protobuf}, nil
+}
+
+// FetchLegacyCounter
+// RpbCounterGetReq
+// RpbCounterGetResp
+
+// FetchLegacyCounterCommand is used to fetch the value of a Riak 1.4 counter
+type FetchLegacyCounterCommand struct {
+	CommandImpl
+	Response *FetchLegacyCounterResponse
+	protobuf *rpbRiakKV.RpbCounterGetReq
+}
+
+// Name identifies this command
+func (cmd *FetchLegacyCounterCommand) Name() string {
+	return "FetchLegacyCounter"
+}
+
+func (cmd *FetchLegacyCounterCommand) minimumVersion() Version {
+	return version1_4
+}
+
+func (cmd *FetchLegacyCounterCommand) constructPbRequest() (proto.Message, error) {
+	return cmd.protobuf, nil
+}
+
+func (cmd *FetchLegacyCounterCommand) onSuccess(msg proto.Message) error {
+	cmd.Success = true
+	response := &FetchLegacyCounterResponse{IsNotFound: true}
+	if msg != nil {
+		if rpbCounterGetResp, ok := msg.(*rpbRiakKV.RpbCounterGetResp); ok {
+			if rpbCounterGetResp.Value != nil {
+				response.IsNotFound = false
+				response.CounterValue = rpbCounterGetResp.GetValue()
+			}
+		} else {
+			return fmt.Errorf("[FetchLegacyCounterCommand] could not convert %v to RpbCounterGetResp", reflect.TypeOf(msg))
+		}
+	}
+	cmd.Response = response
+	return nil
+}
+
+func (cmd *FetchLegacyCounterCommand) getRequestCode() byte {
+	return rpbCode_RpbCounterGetReq
+}
+
+func (cmd *FetchLegacyCounterCommand) getResponseCode() byte {
+	return rpbCode_RpbCounterGetResp
+}
+
+func (cmd *FetchLegacyCounterCommand) getResponseProtobufMessage() proto.Message {
+	return &rpbRiakKV.RpbCounterGetResp{}
+}
+
+type FetchLegacyCounterResponse struct {
+	IsNotFound   bool
+	CounterValue int64
+}
+
+type FetchLegacyCounterCommandBuilder struct {
+	protobuf *rpbRiakKV.RpbCounterGetReq
+}
+
+// NewFetchLegacyCounterCommandBuilder is a factory function for generating the command builder struct
+func NewFetchLegacyCounterCommandBuilder() *FetchLegacyCounterCommandBuilder {
+	return &FetchLegacyCounterCommandBuilder{protobuf: &rpbRiakKV.RpbCounterGetReq{}}
+}
+
+// WithBucket sets the bucket to be used by the command. Legacy counters are always in the default
+// bucket type.
+func (builder *FetchLegacyCounterCommandBuilder) WithBucket(bucket string) *FetchLegacyCounterCommandBuilder {
+	builder.protobuf.Bucket = []byte(bucket)
+	return builder
+}
+
+// WithKey sets the key to be used by the command to read / write values
+func (builder *FetchLegacyCounterCommandBuilder) WithKey(key string) *FetchLegacyCounterCommandBuilder {
+	builder.protobuf.Key = []byte(key)
+	return builder
+}
+
+// WithR sets the number of nodes that must report back a successful read in order for the
+// command operation to be considered a success by Riak. If ommitted, the bucket default is used.
+//
+// See http://basho.com/posts/technical/riaks-config-behaviors-part-2/
+func (builder *FetchLegacyCounterCommandBuilder) WithR(r uint32) *FetchLegacyCounterCommandBuilder {
+	builder.protobuf.R = &r
+	return builder
+}
+
+// WithPr sets the number of primary nodes (N) that must be read from in order for the command
+// operation to be considered a success by Riak. If ommitted, the bucket default is used.
+//
+// See http://basho.com/posts/technical/riaks-config-behaviors-part-2/
+func (builder *FetchLegacyCounterCommandBuilder) WithPr(pr uint32) *FetchLegacyCounterCommandBuilder {
+	builder.protobuf.Pr = &pr
+	return builder
+}
+
+func (builder *FetchLegacyCounterCommandBuilder) WithNotFoundOk(notFoundOk bool) *FetchLegacyCounterCommandBuilder {
+	builder.protobuf.NotfoundOk = &notFoundOk
+	return builder
+}
+
+func (builder *FetchLegacyCounterCommandBuilder) WithBasicQuorum(basicQuorum bool) *FetchLegacyCounterCommandBuilder {
+	builder.protobuf.BasicQuorum = &basicQuorum
+	return builder
+}
+
+// Build validates the configuration options provided then builds the command
+func (builder *FetchLegacyCounterCommandBuilder) Build() (Command, error) {
+	if builder.protobuf == nil {
+		panic("builder.protobuf must not be nil")
+	}
+	if builder.protobuf.GetBucket() == nil {
+		return nil, ErrBucketRequired
+	}
+	if builder.protobuf.GetKey() == nil {
+		return nil, ErrKeyRequired
+	}
+	return &FetchLegacyCounterCommand{protobuf: builder.protobuf}, nil
+}
+
 // UpdateSet
 // DtUpdateReq
 // DtUpdateResp
@@ -297,6 +567,10 @@ func (cmd *UpdateSetCommand) Name() string {
 	return "UpdateSet"
 }
 
+func (cmd *UpdateSetCommand) minimumVersion() Version {
+	return version2_0
+}
+
 func (cmd *UpdateSetCommand) constructPbRequest() (proto.Message, error) {
 	return cmd.protobuf, nil
 }
@@ -452,6 +726,10 @@ func (cmd *FetchSetCommand) Name() string {
 	return "FetchSet"
 }
 
+func (cmd *FetchSetCommand) minimumVersion() Version {
+	return version2_0
+}
+
 func (cmd *FetchSetCommand) constructPbRequest() (proto.Message, error) {
 	return cmd.protobuf, nil
 }
@@ -584,6 +862,10 @@ func (cmd *UpdateMapCommand) Name() string {
 	return "UpdateMap"
 }
 
+func (cmd *UpdateMapCommand) minimumVersion() Version {
+	return version2_0
+}
+
 func (cmd *UpdateMapCommand) constructPbRequest() (proto.Message, error) {
 	pbMapOp := &rpbRiakDT.MapOp{}
 	populate(cmd.op, pbMapOp)
@@ -676,7 +958,7 @@ func populate(mapOp *MapOperation, pbMapOp *rpbRiakDT.MapOp) {
 			Type: rpbRiakDT.MapField_COUNTER.Enum(),
 		}
 		counterOp := &rpbRiakDT.CounterOp{
-			Increment: &increment,
+			Increment: proto.Int64(increment), // NB: not &increment, which is reused by the loop
 		}
 		update := &rpbRiakDT.MapUpdate{
 			Field:     field,
@@ -1089,6 +1371,10 @@ func (cmd *FetchMapCommand) Name() string {
 	return "FetchMap"
 }
 
+func (cmd *FetchMapCommand) minimumVersion() Version {
+	return version2_0
+}
+
 func (cmd *FetchMapCommand) constructPbRequest() (proto.Message, error) {
 	return cmd.protobuf, nil
 }
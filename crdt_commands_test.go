@@ -8,6 +8,8 @@ import (
 	"time"
 
 	rpbRiakDT "github.com/basho/riak-go-client/rpb/riak_dt"
+	rpbRiakKV "github.com/basho/riak-go-client/rpb/riak_kv"
+	proto "github.com/golang/protobuf/proto"
 )
 
 // UpdateCounter
@@ -271,6 +273,125 @@ func TestValidationOfFetchCounterViaBuilder(t *testing.T) {
 	}
 }
 
+// UpdateLegacyCounter
+// RpbCounterUpdateReq
+// RpbCounterUpdateResp
+
+func TestBuildRpbCounterUpdateReqCorrectlyViaBuilder(t *testing.T) {
+	cmd, err := NewUpdateLegacyCounterCommandBuilder().
+		WithBucket("bucket_name").
+		WithKey("counter_1").
+		WithIncrement(-10).
+		WithW(3).
+		WithPw(1).
+		WithDw(2).
+		WithReturnValue(true).
+		Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	protobuf, err := cmd.constructPbRequest()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	req, ok := protobuf.(*rpbRiakKV.RpbCounterUpdateReq)
+	if !ok {
+		t.Fatalf("ok: %v - could not convert %v to *rpbRiakKV.RpbCounterUpdateReq", ok, reflect.TypeOf(protobuf))
+	}
+	if expected, actual := "bucket_name", string(req.GetBucket()); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := "counter_1", string(req.GetKey()); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := int64(-10), req.GetAmount(); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := uint32(3), req.GetW(); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := uint32(1), req.GetPw(); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := uint32(2), req.GetDw(); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := true, req.GetReturnvalue(); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	cmd.onSuccess(&rpbRiakKV.RpbCounterUpdateResp{Value: proto.Int64(42)})
+	if expected, actual := int64(42), cmd.(*UpdateLegacyCounterCommand).Response.CounterValue; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestValidationOfUpdateLegacyCounterViaBuilder(t *testing.T) {
+	_, err := NewUpdateLegacyCounterCommandBuilder().Build()
+	if err == nil {
+		t.Fatal("expected non-nil err")
+	}
+	if expected, actual := ErrBucketRequired.Error(), err.Error(); expected != actual {
+		t.Errorf("expected %v, actual %v", expected, actual)
+	}
+
+	// validate that Key is required, as Riak does not generate keys for counters
+	_, err = NewUpdateLegacyCounterCommandBuilder().WithBucket("bucket_name").Build()
+	if err == nil {
+		t.Fatal("expected non-nil err")
+	}
+	if expected, actual := ErrKeyRequired.Error(), err.Error(); expected != actual {
+		t.Errorf("expected %v, actual %v", expected, actual)
+	}
+}
+
+// FetchLegacyCounter
+// RpbCounterGetReq
+// RpbCounterGetResp
+
+func TestFetchLegacyCounterParsesRpbCounterGetRespCorrectly(t *testing.T) {
+	builder := NewFetchLegacyCounterCommandBuilder().
+		WithBucket("bucket_name").
+		WithKey("counter_1").
+		WithR(2).
+		WithPr(1).
+		WithNotFoundOk(true).
+		WithBasicQuorum(true)
+	cmd, err := builder.Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	protobuf, err := cmd.constructPbRequest()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	req := protobuf.(*rpbRiakKV.RpbCounterGetReq)
+	if expected, actual := uint32(2), req.GetR(); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := true, req.GetNotfoundOk(); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	cmd.onSuccess(&rpbRiakKV.RpbCounterGetResp{Value: proto.Int64(-5)})
+	rsp := cmd.(*FetchLegacyCounterCommand).Response
+	if expected, actual := false, rsp.IsNotFound; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := int64(-5), rsp.CounterValue; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	// Riak answers an empty response for a counter never updated
+	if cmd, err = builder.Build(); err != nil {
+		t.Fatal(err.Error())
+	}
+	cmd.onSuccess(nil)
+	if expected, actual := true, cmd.(*FetchLegacyCounterCommand).Response.IsNotFound; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
 // UpdateSet
 // DtUpdateReq
 // DtUpdateResp
@@ -0,0 +1,301 @@
+package riak
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// ContentTypeErlangBinary is the content type of MapReduce jobs and results in
+// the Erlang external term format
+const ContentTypeErlangBinary = "application/x-erlang-binary"
+
+// ErlangAtom is an Erlang atom decoded from the external term format
+type ErlangAtom string
+
+// ErlangTuple is an Erlang tuple decoded from the external term format
+type ErlangTuple []interface{}
+
+var (
+	errErlangTruncated    = newClientError("[Erlang] truncated term")
+	errErlangTrailingData = newClientError("[Erlang] trailing data after term")
+	errErlangTooDeep      = newClientError("[Erlang] term is nested too deeply")
+	errErlangVersion      = newClientError("[Erlang] unknown external term format version")
+)
+
+// external term format tags
+const (
+	erlangVersion         = 131
+	erlangNewFloatExt     = 70
+	erlangSmallIntegerExt = 97
+	erlangIntegerExt      = 98
+	erlangFloatExt        = 99
+	erlangAtomExt         = 100
+	erlangSmallTupleExt   = 104
+	erlangLargeTupleExt   = 105
+	erlangNilExt          = 106
+	erlangStringExt       = 107
+	erlangListExt         = 108
+	erlangBinaryExt       = 109
+	erlangSmallBigExt     = 110
+	erlangLargeBigExt     = 111
+	erlangSmallAtomExt    = 115
+	erlangMapExt          = 116
+	erlangAtomUtf8Ext     = 118
+	erlangSmallAtomUtf8   = 119
+)
+
+// erlangMaxDepth bounds the nesting of decoded lists, tuples and maps
+const erlangMaxDepth = 1000
+
+// decodeErlangTerm decodes a term in the Erlang external term format, as
+// returned by term_to_binary. Integers are decoded as int64, or *big.Int when
+// they do not fit, floats as float64, atoms as ErlangAtom, with true and false
+// decoded as bool, binaries as []byte, tuples as ErlangTuple, lists as
+// []interface{}, and maps as map[interface{}]interface{}, with binary keys
+// decoded as string. Lists of bytes, which term_to_binary encodes compactly,
+// are decoded as string. Pids, ports, references and funs are not supported.
+func decodeErlangTerm(data []byte) (interface{}, error) {
+	if len(data) == 0 {
+		return nil, errErlangTruncated
+	}
+	if data[0] != erlangVersion {
+		return nil, errErlangVersion
+	}
+	d := &erlangDecoder{data: data, pos: 1}
+	term, err := d.decode()
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(d.data) {
+		return nil, errErlangTrailingData
+	}
+	return term, nil
+}
+
+type erlangDecoder struct {
+	data  []byte
+	pos   int
+	depth int
+}
+
+func (d *erlangDecoder) next(n int) ([]byte, error) {
+	if n < 0 || n > len(d.data)-d.pos {
+		return nil, errErlangTruncated
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *erlangDecoder) readUint(size int) (uint64, error) {
+	b, err := d.next(size)
+	if err != nil {
+		return 0, err
+	}
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	return n, nil
+}
+
+// checkLength returns an error if there are not enough bytes left for n
+// terms, each taking at least one byte
+func (d *erlangDecoder) checkLength(n uint64) error {
+	if n > uint64(len(d.data)-d.pos) {
+		return errErlangTruncated
+	}
+	return nil
+}
+
+func (d *erlangDecoder) decode() (interface{}, error) {
+	if d.depth++; d.depth > erlangMaxDepth {
+		return nil, errErlangTooDeep
+	}
+	defer func() { d.depth-- }()
+	tag, err := d.readUint(1)
+	if err != nil {
+		return nil, err
+	}
+	switch tag {
+	case erlangSmallIntegerExt:
+		n, err := d.readUint(1)
+		return int64(n), err
+	case erlangIntegerExt:
+		n, err := d.readUint(4)
+		return int64(int32(n)), err
+	case erlangNewFloatExt:
+		n, err := d.readUint(8)
+		return math.Float64frombits(n), err
+	case erlangFloatExt:
+		b, err := d.next(31)
+		if err != nil {
+			return nil, err
+		}
+		end := 0
+		for end < len(b) && b[end] != 0 {
+			end++
+		}
+		f, err := strconv.ParseFloat(string(b[:end]), 64)
+		if err != nil {
+			return nil, newClientError(fmt.Sprintf("[Erlang] invalid float %q", b[:end]))
+		}
+		return f, nil
+	case erlangAtomExt, erlangAtomUtf8Ext:
+		return d.atom(2)
+	case erlangSmallAtomExt, erlangSmallAtomUtf8:
+		return d.atom(1)
+	case erlangSmallTupleExt:
+		return d.tuple(1)
+	case erlangLargeTupleExt:
+		return d.tuple(4)
+	case erlangNilExt:
+		return []interface{}{}, nil
+	case erlangStringExt:
+		n, err := d.readUint(2)
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.next(int(n))
+		return string(b), err
+	case erlangListExt:
+		return d.list()
+	case erlangBinaryExt:
+		n, err := d.readUint(4)
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.next(int(n))
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), b...), nil
+	case erlangSmallBigExt:
+		return d.big(1)
+	case erlangLargeBigExt:
+		return d.big(4)
+	case erlangMapExt:
+		return d.erlangMap()
+	}
+	return nil, newClientError(fmt.Sprintf("[Erlang] unsupported term tag %d", tag))
+}
+
+func (d *erlangDecoder) atom(size int) (interface{}, error) {
+	n, err := d.readUint(size)
+	if err != nil {
+		return nil, err
+	}
+	b, err := d.next(int(n))
+	if err != nil {
+		return nil, err
+	}
+	switch atom := string(b); atom {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	default:
+		return ErlangAtom(atom), nil
+	}
+}
+
+func (d *erlangDecoder) tuple(size int) (interface{}, error) {
+	n, err := d.readUint(size)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.checkLength(n); err != nil {
+		return nil, err
+	}
+	tuple := make(ErlangTuple, n)
+	for i := range tuple {
+		if tuple[i], err = d.decode(); err != nil {
+			return nil, err
+		}
+	}
+	return tuple, nil
+}
+
+func (d *erlangDecoder) list() (interface{}, error) {
+	n, err := d.readUint(4)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.checkLength(n); err != nil {
+		return nil, err
+	}
+	list := make([]interface{}, n)
+	for i := range list {
+		if list[i], err = d.decode(); err != nil {
+			return nil, err
+		}
+	}
+	tail, err := d.decode()
+	if err != nil {
+		return nil, err
+	}
+	if tail, ok := tail.([]interface{}); !ok || len(tail) != 0 {
+		return nil, newClientError("[Erlang] improper lists are not supported")
+	}
+	return list, nil
+}
+
+func (d *erlangDecoder) big(size int) (interface{}, error) {
+	n, err := d.readUint(size)
+	if err != nil {
+		return nil, err
+	}
+	sign, err := d.readUint(1)
+	if err != nil {
+		return nil, err
+	}
+	digits, err := d.next(int(n))
+	if err != nil {
+		return nil, err
+	}
+	// NB: digits are little endian
+	be := make([]byte, len(digits))
+	for i, b := range digits {
+		be[len(be)-1-i] = b
+	}
+	i := new(big.Int).SetBytes(be)
+	if sign != 0 {
+		i.Neg(i)
+	}
+	if i.IsInt64() {
+		return i.Int64(), nil
+	}
+	return i, nil
+}
+
+func (d *erlangDecoder) erlangMap() (interface{}, error) {
+	n, err := d.readUint(4)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.checkLength(n); err != nil {
+		return nil, err
+	}
+	m := make(map[interface{}]interface{}, n)
+	for i := uint64(0); i < n; i++ {
+		k, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		switch key := k.(type) {
+		case []byte:
+			// NB: binary keys are common, and slices are not comparable
+			k = string(key)
+		case []interface{}, ErlangTuple, map[interface{}]interface{}, *big.Int:
+			return nil, newClientError(fmt.Sprintf("[Erlang] unsupported map key %v", k))
+		}
+		m[k] = v
+	}
+	return m, nil
+}
@@ -0,0 +1,60 @@
+package riak
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeErlangTerm(t *testing.T) {
+	bigInt, _ := new(big.Int).SetString("-18446744073709551616", 10)
+	tests := []struct {
+		data     []byte
+		expected interface{}
+	}{
+		{[]byte{131, 97, 42}, int64(42)},
+		{[]byte{131, 98, 255, 255, 255, 254}, int64(-2)},
+		{[]byte{131, 70, 64, 9, 33, 251, 84, 68, 45, 24}, 3.141592653589793},
+		{[]byte{131, 100, 0, 2, 'o', 'k'}, ErlangAtom("ok")},
+		{[]byte{131, 119, 4, 't', 'r', 'u', 'e'}, true},
+		{[]byte{131, 106}, []interface{}{}},
+		{[]byte{131, 107, 0, 2, 'h', 'i'}, "hi"},
+		{[]byte{131, 109, 0, 0, 0, 2, 'h', 'i'}, []byte("hi")},
+		{[]byte{131, 110, 8, 1, 0, 0, 0, 0, 0, 0, 0, 128}, int64(-9223372036854775808)},
+		{[]byte{131, 110, 9, 1, 0, 0, 0, 0, 0, 0, 0, 0, 1}, bigInt},
+		{
+			[]byte{131, 104, 2, 100, 0, 2, 'o', 'k', 108, 0, 0, 0, 2, 97, 1, 97, 2, 106},
+			ErlangTuple{ErlangAtom("ok"), []interface{}{int64(1), int64(2)}},
+		},
+		{
+			[]byte{131, 116, 0, 0, 0, 1, 109, 0, 0, 0, 1, 'k', 97, 1},
+			map[interface{}]interface{}{"k": int64(1)},
+		},
+	}
+	for _, test := range tests {
+		actual, err := decodeErlangTerm(test.data)
+		if err != nil {
+			t.Errorf("%v: %v", test.data, err)
+			continue
+		}
+		if expected := test.expected; !reflect.DeepEqual(expected, actual) {
+			t.Errorf("expected %v, got %v", expected, actual)
+		}
+	}
+}
+
+func TestDecodeErlangTermRejectsInvalidData(t *testing.T) {
+	for _, data := range [][]byte{
+		nil,
+		{130, 97, 1},
+		{131, 97},
+		{131, 97, 1, 2},
+		{131, 108, 255, 255, 255, 255},
+		{131, 108, 0, 0, 0, 1, 97, 1, 97, 2},
+		{131, 103},
+	} {
+		if _, err := decodeErlangTerm(data); err == nil {
+			t.Errorf("%v: expected error", data)
+		}
+	}
+}
@@ -20,6 +20,9 @@ func newRiakError(rpb *rpb_riak.RpbErrorResp) (e error) {
 }
 
 func maybeRiakError(data []byte) (err error) {
+	if len(data) == 0 {
+		return ErrZeroLength
+	}
 	rpbMsgCode := data[0]
 	if rpbMsgCode == rpbCode_RpbErrorResp {
 		rpb := &rpb_riak.RpbErrorResp{}
@@ -42,6 +45,7 @@ var (
 	ErrAuthMissingConfig    = newClientError("[Connection] authentication is missing TLS config")
 	ErrAuthTLSUpgradeFailed = newClientError("[Connection] upgrading to TLS connection failed")
 	ErrBucketRequired       = newClientError("Bucket is required")
+	ErrBucketTypeRequired   = newClientError("Bucket type is required")
 	ErrKeyRequired          = newClientError("Key is required")
 	ErrNilOptions           = newClientError("[Command] options must be non-nil")
 	ErrOptionsRequired      = newClientError("Options are required")
@@ -0,0 +1,172 @@
+package riak
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	rpbRiakKV "github.com/basho/riak-go-client/rpb/riak_kv"
+	proto "github.com/golang/protobuf/proto"
+)
+
+// protobuf wire types
+const (
+	pbWireVarint  = 0
+	pbWireFixed64 = 1
+	pbWireBytes   = 2
+	pbWireFixed32 = 5
+)
+
+// pbStreamReader reads a protobuf message of a known size field by field
+type pbStreamReader struct {
+	r *bufio.Reader
+	n uint64
+}
+
+func (s *pbStreamReader) ReadByte() (b byte, err error) {
+	if s.n == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if b, err = s.r.ReadByte(); err == nil {
+		s.n--
+	}
+	return b, unexpectedEOF(err)
+}
+
+func (s *pbStreamReader) Read(p []byte) (n int, err error) {
+	if s.n == 0 {
+		return 0, io.EOF
+	}
+	if uint64(len(p)) > s.n {
+		p = p[:s.n]
+	}
+	n, err = s.r.Read(p)
+	s.n -= uint64(n)
+	return
+}
+
+// sub returns a reader for an embedded message of the given size
+func (s *pbStreamReader) sub(size uint64) (*pbStreamReader, error) {
+	if size > s.n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	s.n -= size
+	return &pbStreamReader{r: s.r, n: size}, nil
+}
+
+func (s *pbStreamReader) readVarint() (uint64, error) {
+	return binary.ReadUvarint(s)
+}
+
+// copyField reads the payload of a field and appends the whole field to dst, which may not
+// grow larger than max bytes
+func (s *pbStreamReader) copyField(dst *bytes.Buffer, key uint64, max uint32) (err error) {
+	var length uint64
+	switch key & 7 {
+	case pbWireVarint:
+		var v uint64
+		if v, err = s.readVarint(); err != nil {
+			return
+		}
+		writeUvarint(dst, key)
+		writeUvarint(dst, v)
+		return
+	case pbWireFixed64:
+		length = 8
+		writeUvarint(dst, key)
+	case pbWireFixed32:
+		length = 4
+		writeUvarint(dst, key)
+	case pbWireBytes:
+		if length, err = s.readVarint(); err != nil {
+			return
+		}
+		writeUvarint(dst, key)
+		writeUvarint(dst, length)
+	default:
+		return fmt.Errorf("[pbStreamReader] unsupported wire type: %d", key&7)
+	}
+	if uint64(dst.Len())+length > uint64(max) {
+		return FrameSizeError{Size: uint32(uint64(dst.Len()) + length), Max: max}
+	}
+	_, err = io.CopyN(dst, s, int64(length))
+	return unexpectedEOF(err)
+}
+
+func writeUvarint(dst *bytes.Buffer, v uint64) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	dst.Write(buf[:binary.PutUvarint(buf, v)])
+}
+
+// streamRpbGetResp decodes an RpbGetResp of the given size, copying the value of each
+// sibling to the writer returned by valueWriter. The remaining fields of the message, and of
+// each RpbContent, may not be larger than maxFieldSize.
+func streamRpbGetResp(r *bufio.Reader, size uint32, maxFieldSize uint32, valueWriter ValueWriterFunc) (*rpbRiakKV.RpbGetResp, error) {
+	s := &pbStreamReader{r: r, n: uint64(size)}
+	var contents []*rpbRiakKV.RpbContent
+	fields := new(bytes.Buffer)
+	for s.n > 0 {
+		key, err := s.readVarint()
+		if err != nil {
+			return nil, err
+		}
+		if key == 1<<3|pbWireBytes { // content
+			length, err := s.readVarint()
+			if err != nil {
+				return nil, err
+			}
+			cs, err := s.sub(length)
+			if err != nil {
+				return nil, err
+			}
+			content, err := streamRpbContent(cs, maxFieldSize, valueWriter(len(contents)))
+			if err != nil {
+				return nil, err
+			}
+			contents = append(contents, content)
+		} else if err = s.copyField(fields, key, maxFieldSize); err != nil {
+			return nil, err
+		}
+	}
+	rpbGetResp := &rpbRiakKV.RpbGetResp{}
+	if err := proto.Unmarshal(fields.Bytes(), rpbGetResp); err != nil {
+		return nil, err
+	}
+	rpbGetResp.Content = contents
+	return rpbGetResp, nil
+}
+
+func streamRpbContent(s *pbStreamReader, maxFieldSize uint32, w io.Writer) (*rpbRiakKV.RpbContent, error) {
+	fields := new(bytes.Buffer)
+	// NB: value is a required field, an empty one stands in for the streamed value
+	writeUvarint(fields, 1<<3|pbWireBytes)
+	writeUvarint(fields, 0)
+	for s.n > 0 {
+		key, err := s.readVarint()
+		if err != nil {
+			return nil, err
+		}
+		if key == 1<<3|pbWireBytes { // value
+			length, err := s.readVarint()
+			if err != nil {
+				return nil, err
+			}
+			if length > s.n {
+				return nil, io.ErrUnexpectedEOF
+			}
+			if _, err = io.CopyN(w, s, int64(length)); err != nil {
+				return nil, unexpectedEOF(err)
+			}
+		} else if err = s.copyField(fields, key, maxFieldSize); err != nil {
+			return nil, err
+		}
+	}
+	content := &rpbRiakKV.RpbContent{}
+	if err := proto.Unmarshal(fields.Bytes(), content); err != nil {
+		return nil, err
+	}
+	content.Value = nil
+	return content, nil
+}
@@ -0,0 +1,224 @@
+package riak
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	rpbRiak "github.com/basho/riak-go-client/rpb/riak"
+	rpbRiakKV "github.com/basho/riak-go-client/rpb/riak_kv"
+	proto "github.com/golang/protobuf/proto"
+)
+
+func largeRpbGetResp() *rpbRiakKV.RpbGetResp {
+	return &rpbRiakKV.RpbGetResp{
+		Content: []*rpbRiakKV.RpbContent{
+			{
+				Value:       bytes.Repeat([]byte("a"), 256*1024),
+				ContentType: []byte("application/octet-stream"),
+			},
+			{
+				Value:       bytes.Repeat([]byte("b"), 128*1024),
+				ContentType: []byte("text/plain"),
+				Usermeta: []*rpbRiak.RpbPair{
+					{Key: []byte("owner"), Value: []byte("blobs")},
+				},
+			},
+		},
+		Vclock: []byte("vclock"),
+	}
+}
+
+// respondingDialFunc returns a DialFunc whose server answers every request with the same message
+func respondingDialFunc(code byte, msg proto.Message) DialFunc {
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			sizeBuf := make([]byte, 4)
+			for {
+				if _, err := io.ReadFull(server, sizeBuf); err != nil {
+					return
+				}
+				if _, err := io.ReadFull(server, make([]byte, binary.BigEndian.Uint32(sizeBuf))); err != nil {
+					return
+				}
+				if _, err := server.Write(buildRiakMessage(code, data)); err != nil {
+					return
+				}
+			}
+		}()
+		return client, nil
+	}
+}
+
+func connectWithMaxFrameSize(t *testing.T, maxFrameSize uint32, dialFunc DialFunc) *connection {
+	addr, err := net.ResolveTCPAddr("tcp4", "127.0.0.1:8087")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	conn, err := newConnection(&connectionOptions{
+		remoteAddress: addr,
+		maxFrameSize:  maxFrameSize,
+		dialFunc:      dialFunc,
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := conn.connect(); err != nil {
+		t.Fatal(err.Error())
+	}
+	return conn
+}
+
+func TestFetchValueStreamsValuesLargerThanMaxFrameSize(t *testing.T) {
+	expected := largeRpbGetResp()
+	conn := connectWithMaxFrameSize(t, 64*1024, respondingDialFunc(rpbCode_RpbGetResp, expected))
+	defer conn.close()
+
+	buffers := make(map[int]*bytes.Buffer)
+	cmd, err := NewFetchValueCommandBuilder().
+		WithBucket("bucket").
+		WithKey("blob").
+		WithValueWriter(func(sibling int) io.Writer {
+			buffers[sibling] = new(bytes.Buffer)
+			return buffers[sibling]
+		}).
+		Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := conn.execute(cmd); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	response := cmd.(*FetchValueCommand).Response
+	if expected, actual := 2, len(response.Values); expected != actual {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := "vclock", string(response.VClock); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	for i, content := range expected.Content {
+		if !bytes.Equal(content.Value, buffers[i].Bytes()) {
+			t.Errorf("sibling %d: expected %d bytes, got %d bytes", i, len(content.Value), buffers[i].Len())
+		}
+		if response.Values[i].Value != nil {
+			t.Errorf("sibling %d: expected nil value, got %d bytes", i, len(response.Values[i].Value))
+		}
+		if expected, actual := string(content.ContentType), response.Values[i].ContentType; expected != actual {
+			t.Errorf("expected %v, got %v", expected, actual)
+		}
+	}
+	if expected, actual := "blobs", response.Values[1].UserMeta[0].Value; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	// the whole frame has been consumed, so the connection can be reused
+	if expected, actual := true, conn.available(); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	cmd, err = NewFetchValueCommandBuilder().
+		WithBucket("bucket").
+		WithKey("blob").
+		WithValueWriter(func(sibling int) io.Writer {
+			return ioutil.Discard
+		}).
+		Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := conn.execute(cmd); err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+func TestFetchValueWritesValuesWithinMaxFrameSize(t *testing.T) {
+	conn := connectWithMaxFrameSize(t, 0, respondingDialFunc(rpbCode_RpbGetResp, largeRpbGetResp()))
+	defer conn.close()
+
+	buf := new(bytes.Buffer)
+	cmd, err := NewFetchValueCommandBuilder().
+		WithBucket("bucket").
+		WithKey("blob").
+		WithValueWriter(func(sibling int) io.Writer {
+			return buf
+		}).
+		Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := conn.execute(cmd); err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := (256+128)*1024, buf.Len(); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if cmd.(*FetchValueCommand).Response.Values[0].Value != nil {
+		t.Error("expected nil value")
+	}
+}
+
+func TestFetchValueWithoutValueWriterRejectsLargeFrame(t *testing.T) {
+	conn := connectWithMaxFrameSize(t, 64*1024, respondingDialFunc(rpbCode_RpbGetResp, largeRpbGetResp()))
+	defer conn.close()
+
+	cmd, err := NewFetchValueCommandBuilder().
+		WithBucket("bucket").
+		WithKey("blob").
+		Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := conn.execute(cmd); err == nil {
+		t.Error("expected error")
+	} else if _, ok := err.(FrameSizeError); !ok {
+		t.Errorf("expected FrameSizeError, got %v", err)
+	}
+}
+
+func TestStreamRpbGetRespLimitsOtherFields(t *testing.T) {
+	resp := &rpbRiakKV.RpbGetResp{
+		Content: []*rpbRiakKV.RpbContent{
+			{
+				Value:       []byte("value"),
+				ContentType: bytes.Repeat([]byte("x"), 1024),
+			},
+		},
+	}
+	data, err := proto.Marshal(resp)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	r := bufio.NewReader(bytes.NewReader(data))
+	_, err = streamRpbGetResp(r, uint32(len(data)), 512, func(sibling int) io.Writer {
+		return ioutil.Discard
+	})
+	if _, ok := err.(FrameSizeError); !ok {
+		t.Errorf("expected FrameSizeError, got %v", err)
+	}
+}
+
+func TestStreamRpbGetRespTruncated(t *testing.T) {
+	data, err := proto.Marshal(largeRpbGetResp())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	data = data[:len(data)/2]
+	r := bufio.NewReader(bytes.NewReader(data))
+	_, err = streamRpbGetResp(r, uint32(len(data)), 1024, func(sibling int) io.Writer {
+		return ioutil.Discard
+	})
+	if err == nil {
+		t.Error("expected error")
+	}
+}
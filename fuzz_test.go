@@ -0,0 +1,168 @@
+//go:build go1.18
+// +build go1.18
+
+package riak
+
+import (
+	"testing"
+
+	rpbRiakDT "github.com/basho/riak-go-client/rpb/riak_dt"
+	rpbRiakKV "github.com/basho/riak-go-client/rpb/riak_kv"
+	proto "github.com/golang/protobuf/proto"
+)
+
+// fuzzCommands returns a new instance of every command, streaming commands
+// both with and without streaming
+func fuzzCommands(t testing.TB) []Command {
+	nop := func([]string) error { return nil }
+	builders := []CommandBuilder{
+		NewUpdateCounterCommandBuilder().WithBucket("b").WithKey("k"),
+		NewFetchCounterCommandBuilder().WithBucket("b").WithKey("k"),
+		NewUpdateSetCommandBuilder().WithBucket("b").WithKey("k"),
+		NewFetchSetCommandBuilder().WithBucket("b").WithKey("k"),
+		NewUpdateMapCommandBuilder().WithBucket("b").WithKey("k").WithMapOperation(&MapOperation{}),
+		NewFetchMapCommandBuilder().WithBucket("b").WithKey("k"),
+		NewFetchValueCommandBuilder().WithBucket("b").WithKey("k"),
+		NewStoreValueCommandBuilder().WithBucket("b").WithKey("k").WithContent(&Object{}),
+		NewDeleteValueCommandBuilder().WithBucket("b").WithKey("k"),
+		NewListBucketsCommandBuilder(),
+		NewListBucketsCommandBuilder().WithStreaming(true).WithCallback(nop),
+		NewListKeysCommandBuilder().WithBucket("b"),
+		NewListKeysCommandBuilder().WithBucket("b").WithStreaming(true).WithCallback(nop),
+		NewFetchPreflistCommandBuilder().WithBucket("b").WithKey("k"),
+		NewSecondaryIndexQueryCommandBuilder().WithBucket("b").WithIndexName("i_bin").WithIndexKey("k"),
+		NewSecondaryIndexQueryCommandBuilder().WithBucket("b").WithIndexName("i_bin").WithRange("a", "z").
+			WithStreaming(true).WithCallback(func([]*SecondaryIndexQueryResult) error { return nil }),
+		NewMapReduceCommandBuilder().WithQuery("{}"),
+		NewMapReduceCommandBuilder().WithQuery("{}").WithStreaming(true).WithCallback(func([]byte) error { return nil }),
+		NewFetchBucketPropsCommandBuilder().WithBucket("b"),
+		NewStoreBucketPropsCommandBuilder().WithBucket("b"),
+		NewStoreIndexCommandBuilder().WithIndexName("i"),
+		NewFetchIndexCommandBuilder().WithIndexName("i"),
+		NewDeleteIndexCommandBuilder().WithIndexName("i"),
+		NewStoreSchemaCommandBuilder().WithSchemaName("s").WithSchema("<schema/>"),
+		NewFetchSchemaCommandBuilder().WithSchemaName("s"),
+		NewSearchCommandBuilder().WithIndexName("i").WithQuery("*:*"),
+	}
+	cmds := []Command{&PingCommand{}, &StartTlsCommand{}, &AuthCommand{}}
+	for _, builder := range builders {
+		cmd, err := builder.Build()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		cmds = append(cmds, cmd)
+	}
+	return cmds
+}
+
+func addFuzzMessages(f *testing.F, code byte, msgs ...proto.Message) {
+	for _, msg := range msgs {
+		data, err := proto.Marshal(msg)
+		if err != nil {
+			f.Fatal(err.Error())
+		}
+		f.Add(append([]byte{code}, data...))
+	}
+}
+
+func addFuzzSeeds(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{rpbCode_RpbErrorResp})
+	f.Add([]byte{rpbCode_RpbPingResp})
+	addFuzzMessages(f, rpbCode_RpbGetResp, &rpbRiakKV.RpbGetResp{
+		Content: []*rpbRiakKV.RpbContent{{Value: []byte("value"), ContentType: []byte("text/plain")}},
+		Vclock:  []byte("vclock"),
+	})
+	addFuzzMessages(f, rpbCode_DtFetchResp, &rpbRiakDT.DtFetchResp{
+		Type: rpbRiakDT.DtFetchResp_MAP.Enum(),
+		Value: &rpbRiakDT.DtValue{
+			MapValue: []*rpbRiakDT.MapEntry{{
+				Field:        &rpbRiakDT.MapField{Name: []byte("n"), Type: rpbRiakDT.MapField_COUNTER.Enum()},
+				CounterValue: proto.Int64(1),
+			}},
+		},
+	})
+	addFuzzMessages(f, rpbCode_RpbListKeysResp, &rpbRiakKV.RpbListKeysResp{
+		Keys: [][]byte{[]byte("k")},
+		Done: proto.Bool(true),
+	})
+	addFuzzMessages(f, rpbCode_RpbIndexResp, &rpbRiakKV.RpbIndexResp{
+		Keys:         [][]byte{[]byte("k")},
+		Continuation: []byte("c"),
+	})
+}
+
+func FuzzMaybeRiakError(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		maybeRiakError(data)
+	})
+}
+
+func FuzzRpbValidateResp(f *testing.F) {
+	f.Add([]byte{}, rpbCode_RpbPingResp)
+	f.Add([]byte{rpbCode_RpbPingResp}, rpbCode_RpbPingResp)
+	f.Fuzz(func(t *testing.T, data []byte, code byte) {
+		err := rpbValidateResp(data, code)
+		if len(data) > 0 && data[0] == code && err != nil {
+			t.Errorf("expected nil, got %v", err)
+		}
+	})
+}
+
+func FuzzDecodeRiakMessage(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, cmd := range fuzzCommands(t) {
+			if msg, err := decodeRiakMessage(cmd, data); err == nil {
+				cmd.onSuccess(msg)
+			}
+		}
+	})
+}
+
+// FuzzCommandOnSuccess decodes data as the response message of every command,
+// whatever the message code
+func FuzzCommandOnSuccess(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, cmd := range fuzzCommands(t) {
+			msg := cmd.getResponseProtobufMessage()
+			if msg == nil {
+				cmd.onSuccess(nil)
+				continue
+			}
+			if err := proto.Unmarshal(data, msg); err == nil {
+				cmd.onSuccess(msg)
+			}
+		}
+	})
+}
+
+func FuzzFromRpbContent(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		content := &rpbRiakKV.RpbContent{}
+		if err := proto.Unmarshal(data, content); err == nil {
+			fromRpbContent(content)
+		}
+	})
+}
+
+func FuzzParsePbResponse(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		value := &rpbRiakDT.DtValue{}
+		if err := proto.Unmarshal(data, value); err == nil {
+			parsePbResponse(value.MapValue)
+		}
+	})
+}
+
+func FuzzMsgpackDecode(f *testing.F) {
+	f.Add([]byte{0x82, 0xa1, 'a', 0x01, 0xa1, 'b', 0x92, 0xc3, 0xc0})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var v interface{}
+		msgpackCodec{}.Decode(data, &v)
+	})
+}
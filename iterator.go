@@ -0,0 +1,261 @@
+package riak
+
+import (
+	"sync"
+)
+
+// ErrIteratorClosed is returned by the command of an iterator closed before
+// the end of its results
+var ErrIteratorClosed = newClientError("[Iterator] closed")
+
+// stream executes a streaming command in its own goroutine, handing the
+// batches of results given to the command's callback to the iterator. The
+// callback blocks until the iterator asks for the batch, so that Riak is only
+// read as fast as the results are consumed.
+type stream struct {
+	cmd       Command
+	batches   chan interface{}
+	closing   chan struct{}
+	closeOnce sync.Once
+	err       error // NB: set before batches is closed
+}
+
+func newStream() *stream {
+	return &stream{
+		batches: make(chan interface{}),
+		closing: make(chan struct{}),
+	}
+}
+
+// send is the callback of the command
+func (s *stream) send(batch interface{}) error {
+	// NB: a retry would stream again results already consumed
+	s.cmd.setRemainingTries(1)
+	select {
+	case s.batches <- batch:
+		return nil
+	case <-s.closing:
+		return ErrIteratorClosed
+	}
+}
+
+func (s *stream) start(executor Executor, cmd Command) {
+	s.cmd = cmd
+	go func() {
+		if err := executor.Execute(cmd); err != nil && err != ErrIteratorClosed {
+			s.err = err
+		}
+		close(s.batches)
+	}()
+}
+
+// next returns the next batch, or false at the end of the stream
+func (s *stream) next() (interface{}, bool) {
+	batch, ok := <-s.batches
+	return batch, ok
+}
+
+// close stops the stream and waits for the command to return. A command
+// stopped before the end of its stream fails with ErrIteratorClosed, and its
+// connection is closed rather than returned to the pool.
+func (s *stream) close() error {
+	s.closeOnce.Do(func() {
+		close(s.closing)
+	})
+	for range s.batches {
+	}
+	return nil
+}
+
+// KeyIterator iterates over the keys streamed by a ListKeysCommand or the
+// buckets streamed by a ListBucketsCommand
+//
+//	it, err := NewListKeysIterator(cluster, NewListKeysCommandBuilder().
+//	    WithBucketType("myBucketType").
+//	    WithBucket("myBucket"))
+//	if err != nil {
+//	    return err
+//	}
+//	defer it.Close()
+//	for it.Next() {
+//	    key := it.Value()
+//	}
+//	if err := it.Err(); err != nil {
+//	    return err
+//	}
+type KeyIterator struct {
+	stream *stream
+	batch  []string
+	value  string
+}
+
+// NewListKeysIterator executes the command built by builder, streaming, and
+// returns an iterator over the keys
+func NewListKeysIterator(executor Executor, builder *ListKeysCommandBuilder) (*KeyIterator, error) {
+	s := newStream()
+	cmd, err := builder.
+		WithStreaming(true).
+		WithCallback(func(keys []string) error { return s.send(keys) }).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+	s.start(executor, cmd)
+	return &KeyIterator{stream: s}, nil
+}
+
+// NewListBucketsIterator executes the command built by builder, streaming, and
+// returns an iterator over the buckets
+func NewListBucketsIterator(executor Executor, builder *ListBucketsCommandBuilder) (*KeyIterator, error) {
+	s := newStream()
+	cmd, err := builder.
+		WithStreaming(true).
+		WithCallback(func(buckets []string) error { return s.send(buckets) }).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+	s.start(executor, cmd)
+	return &KeyIterator{stream: s}, nil
+}
+
+// Next advances to the next key, returning false when there are no more keys
+// or an error occurred
+func (it *KeyIterator) Next() bool {
+	for len(it.batch) == 0 {
+		batch, ok := it.stream.next()
+		if !ok {
+			return false
+		}
+		it.batch = batch.([]string)
+	}
+	it.value, it.batch = it.batch[0], it.batch[1:]
+	return true
+}
+
+// Value returns the current key
+func (it *KeyIterator) Value() string {
+	return it.value
+}
+
+// Err returns the error that ended the iteration, if any, once Next returned
+// false
+func (it *KeyIterator) Err() error {
+	return it.stream.err
+}
+
+// Close stops the iteration. It must be called if the iteration is abandoned
+// before Next returns false, and may be called more than once.
+func (it *KeyIterator) Close() error {
+	it.batch = nil
+	return it.stream.close()
+}
+
+// IndexIterator iterates over the results streamed by a
+// SecondaryIndexQueryCommand
+type IndexIterator struct {
+	stream *stream
+	batch  []*SecondaryIndexQueryResult
+	value  *SecondaryIndexQueryResult
+}
+
+// NewSecondaryIndexQueryIterator executes the command built by builder,
+// streaming, and returns an iterator over the results
+func NewSecondaryIndexQueryIterator(executor Executor, builder *SecondaryIndexQueryCommandBuilder) (*IndexIterator, error) {
+	s := newStream()
+	cmd, err := builder.
+		WithStreaming(true).
+		WithCallback(func(results []*SecondaryIndexQueryResult) error { return s.send(results) }).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+	s.start(executor, cmd)
+	return &IndexIterator{stream: s}, nil
+}
+
+// Next advances to the next result, returning false when there are no more
+// results or an error occurred
+func (it *IndexIterator) Next() bool {
+	for len(it.batch) == 0 {
+		batch, ok := it.stream.next()
+		if !ok {
+			return false
+		}
+		it.batch = batch.([]*SecondaryIndexQueryResult)
+	}
+	it.value, it.batch = it.batch[0], it.batch[1:]
+	return true
+}
+
+// Value returns the current result
+func (it *IndexIterator) Value() *SecondaryIndexQueryResult {
+	return it.value
+}
+
+// Err returns the error that ended the iteration, if any, once Next returned
+// false
+func (it *IndexIterator) Err() error {
+	return it.stream.err
+}
+
+// Close stops the iteration. It must be called if the iteration is abandoned
+// before Next returns false, and may be called more than once.
+func (it *IndexIterator) Close() error {
+	it.batch = nil
+	return it.stream.close()
+}
+
+// MapReduceIterator iterates over the responses streamed by a MapReduceCommand,
+// each holding the JSON encoded results of a phase
+type MapReduceIterator struct {
+	stream *stream
+	value  []byte
+}
+
+// NewMapReduceIterator executes the command built by builder, streaming, and
+// returns an iterator over the responses
+func NewMapReduceIterator(executor Executor, builder *MapReduceCommandBuilder) (*MapReduceIterator, error) {
+	s := newStream()
+	cmd, err := builder.
+		WithStreaming(true).
+		WithCallback(func(response []byte) error { return s.send(response) }).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+	s.start(executor, cmd)
+	return &MapReduceIterator{stream: s}, nil
+}
+
+// Next advances to the next response, returning false when there are no more
+// responses or an error occurred
+func (it *MapReduceIterator) Next() bool {
+	for {
+		batch, ok := it.stream.next()
+		if !ok {
+			return false
+		}
+		// NB: the last message only marks the end of the stream
+		if it.value = batch.([]byte); it.value != nil {
+			return true
+		}
+	}
+}
+
+// Value returns the current response
+func (it *MapReduceIterator) Value() []byte {
+	return it.value
+}
+
+// Err returns the error that ended the iteration, if any, once Next returned
+// false
+func (it *MapReduceIterator) Err() error {
+	return it.stream.err
+}
+
+// Close stops the iteration. It must be called if the iteration is abandoned
+// before Next returns false, and may be called more than once.
+func (it *MapReduceIterator) Close() error {
+	return it.stream.close()
+}
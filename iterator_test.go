@@ -0,0 +1,192 @@
+package riak
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	rpbRiakKV "github.com/basho/riak-go-client/rpb/riak_kv"
+	proto "github.com/golang/protobuf/proto"
+)
+
+func storeIteratorTestKeys(t *testing.T, cluster *Cluster, bucket string, count int) []string {
+	keys := make([]string, count)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%04d", i)
+		o := &Object{ContentType: ContentTypeText, Value: []byte("value")}
+		if err := o.AddToInt64Index("id_int", int64(i)); err != nil {
+			t.Fatal(err.Error())
+		}
+		cmd, err := NewStoreValueCommandBuilder().
+			WithBucket(bucket).
+			WithKey(keys[i]).
+			WithContent(o).
+			Build()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if err := cluster.Execute(cmd); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	return keys
+}
+
+func TestListKeysIterator(t *testing.T) {
+	server, cluster := startTestServerCluster(t)
+	defer stopTestServerCluster(server, cluster)
+	expected := storeIteratorTestKeys(t, cluster, "bucket", 250)
+	it, err := NewListKeysIterator(cluster, NewListKeysCommandBuilder().WithBucket("bucket"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer it.Close()
+	var keys []string
+	for it.Next() {
+		keys = append(keys, it.Value())
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if actual := keys; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v keys, got %v", len(expected), len(actual))
+	}
+}
+
+func TestListBucketsIterator(t *testing.T) {
+	server, cluster := startTestServerCluster(t)
+	defer stopTestServerCluster(server, cluster)
+	storeIteratorTestKeys(t, cluster, "bucket1", 1)
+	storeIteratorTestKeys(t, cluster, "bucket2", 1)
+	it, err := NewListBucketsIterator(cluster, NewListBucketsCommandBuilder().WithBucketType(defaultBucketType))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer it.Close()
+	var buckets []string
+	for it.Next() {
+		buckets = append(buckets, it.Value())
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := []string{"bucket1", "bucket2"}, buckets; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestIteratorCloseDiscardsConnection(t *testing.T) {
+	server, cluster := startTestServerCluster(t)
+	defer stopTestServerCluster(server, cluster)
+	storeIteratorTestKeys(t, cluster, "bucket", 250)
+	for i := 0; i < 3; i++ {
+		it, err := NewListKeysIterator(cluster, NewListKeysCommandBuilder().WithBucket("bucket"))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if !it.Next() {
+			t.Fatal(it.Err())
+		}
+		if err := it.Close(); err != nil {
+			t.Fatal(err.Error())
+		}
+		if it.Next() {
+			t.Error("expected no more keys after Close")
+		}
+		if err := it.Err(); err != nil {
+			t.Errorf("expected no error after Close, got %v", err)
+		}
+		// NB: a connection returned to the pool mid-stream would answer the
+		// ping with the rest of the keys
+		if err := cluster.Execute(&PingCommand{}); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	node := cluster.nodes[0]
+	node.connMtx.RLock()
+	defer node.connMtx.RUnlock()
+	if expected, actual := uint16(1), node.currentNumConnections; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestSecondaryIndexQueryIterator(t *testing.T) {
+	server, cluster := startTestServerCluster(t)
+	defer stopTestServerCluster(server, cluster)
+	storeIteratorTestKeys(t, cluster, "bucket", 150)
+	it, err := NewSecondaryIndexQueryIterator(cluster, NewSecondaryIndexQueryCommandBuilder().
+		WithBucket("bucket").
+		WithIndexName("id_int").
+		WithReturnKeyAndIndex(true).
+		WithIntRange(10, 129))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer it.Close()
+	count := 0
+	for it.Next() {
+		result := it.Value()
+		if expected, actual := fmt.Sprintf("key%04d", result.IntIndexKey), string(result.ObjectKey); expected != actual {
+			t.Errorf("expected %v, got %v", expected, actual)
+		}
+		count++
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := 120, count; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+// streamingExecutor answers commands with the responses, as a connection
+// would
+type streamingExecutor struct {
+	responses []proto.Message
+	err       error
+}
+
+func (e *streamingExecutor) Execute(cmd Command) error {
+	for _, response := range e.responses {
+		if err := cmd.onSuccess(response); err != nil {
+			return err
+		}
+	}
+	return e.err
+}
+
+func TestMapReduceIterator(t *testing.T) {
+	executor := &streamingExecutor{
+		responses: []proto.Message{
+			&rpbRiakKV.RpbMapRedResp{Phase: proto.Uint32(0), Response: []byte("[1,2]")},
+			&rpbRiakKV.RpbMapRedResp{Phase: proto.Uint32(0), Response: []byte("[3]")},
+			&rpbRiakKV.RpbMapRedResp{Done: proto.Bool(true)},
+		},
+	}
+	it, err := NewMapReduceIterator(executor, NewMapReduceCommandBuilder().WithQuery("{}"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer it.Close()
+	var responses []string
+	for it.Next() {
+		responses = append(responses, string(it.Value()))
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := []string{"[1,2]", "[3]"}, responses; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	executor.err = errors.New("connection reset")
+	if it, err = NewMapReduceIterator(executor, NewMapReduceCommandBuilder().WithQuery("{}")); err != nil {
+		t.Fatal(err.Error())
+	}
+	for it.Next() {
+	}
+	if expected, actual := executor.err, it.Err(); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
@@ -1,7 +1,9 @@
 package riak
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"reflect"
 	"strconv"
 	"time"
@@ -22,9 +24,11 @@ type ConflictResolver interface {
 // FetchValueCommand is used to fetch / get a value from Riak
 type FetchValueCommand struct {
 	CommandImpl
-	Response *FetchValueResponse
-	protobuf *rpbRiakKV.RpbGetReq
-	resolver ConflictResolver
+	Response       *FetchValueResponse
+	protobuf       *rpbRiakKV.RpbGetReq
+	resolver       ConflictResolver
+	valueWriter    ValueWriterFunc
+	valuesStreamed bool
 }
 
 // Name identifies this command
@@ -61,6 +65,15 @@ func (cmd *FetchValueCommand) onSuccess(msg proto.Message) error {
 				}
 				response.Values = []*Object{object}
 			} else {
+				if cmd.valueWriter != nil && !cmd.valuesStreamed {
+					for i, content := range pbContent {
+						if _, err := cmd.valueWriter(i).Write(content.Value); err != nil {
+							return err
+						}
+						content.Value = nil
+					}
+				}
+				cmd.valuesStreamed = false
 				response.Values = make([]*Object, len(pbContent))
 				for i, content := range pbContent {
 					ro, err := fromRpbContent(content)
@@ -86,6 +99,19 @@ func (cmd *FetchValueCommand) onSuccess(msg proto.Message) error {
 	return nil
 }
 
+func (cmd *FetchValueCommand) streamsFrames() bool {
+	return cmd.valueWriter != nil
+}
+
+func (cmd *FetchValueCommand) decodeFrameStream(r *bufio.Reader, size uint32, maxFieldSize uint32) (proto.Message, error) {
+	rpbGetResp, err := streamRpbGetResp(r, size, maxFieldSize, cmd.valueWriter)
+	if err != nil {
+		return nil, err
+	}
+	cmd.valuesStreamed = true
+	return rpbGetResp, nil
+}
+
 func (cmd *FetchValueCommand) getRequestCode() byte {
 	return rpbCode_RpbGetReq
 }
@@ -106,6 +132,10 @@ type FetchValueResponse struct {
 	Values      []*Object
 }
 
+// ValueWriterFunc returns the io.Writer that the value of the sibling with the given index is
+// written to
+type ValueWriterFunc func(sibling int) io.Writer
+
 // FetchValueCommandBuilder type is required for creating new instances of FetchValueCommand
 //
 //    command := NewFetchValueCommandBuilder().
@@ -114,8 +144,9 @@ type FetchValueResponse struct {
 //        WithKey("myKey").
 //        Build()
 type FetchValueCommandBuilder struct {
-	protobuf *rpbRiakKV.RpbGetReq
-	resolver ConflictResolver
+	protobuf    *rpbRiakKV.RpbGetReq
+	resolver    ConflictResolver
+	valueWriter ValueWriterFunc
 }
 
 // NewFetchValueCommandBuilder is a factory function for generating the command builder struct
@@ -130,6 +161,17 @@ func (builder *FetchValueCommandBuilder) WithConflictResolver(resolver ConflictR
 	return builder
 }
 
+// WithValueWriter builds the command object with a function returning the io.Writer each sibling's
+// value is written to, rather than the Value of the returned objects. Values larger than the
+// node's MaxFrameSize are streamed to the writer as they are read from Riak, so they are never
+// held in memory. The ConflictResolver, if any, is given objects without values.
+//
+// NB: the function may be called again for the same sibling if the command is retried
+func (builder *FetchValueCommandBuilder) WithValueWriter(valueWriter ValueWriterFunc) *FetchValueCommandBuilder {
+	builder.valueWriter = valueWriter
+	return builder
+}
+
 // WithBucketType sets the bucket-type to be used by the command. If omitted, 'default' is used
 func (builder *FetchValueCommandBuilder) WithBucketType(bucketType string) *FetchValueCommandBuilder {
 	builder.protobuf.Type = []byte(bucketType)
@@ -220,7 +262,11 @@ func (builder *FetchValueCommandBuilder) Build() (Command, error) {
 	if err := validateLocatable(builder.protobuf); err != nil {
 		return nil, err
 	}
-	return &FetchValueCommand{protobuf: builder.protobuf}, nil
+	return &FetchValueCommand{
+		protobuf:    builder.protobuf,
+		resolver:    builder.resolver,
+		valueWriter: builder.valueWriter,
+	}, nil
 }
 
 // StoreValue
@@ -468,7 +514,7 @@ func (builder *StoreValueCommandBuilder) Build() (Command, error) {
 	if err := validateLocatable(builder.protobuf); err != nil {
 		return nil, err
 	}
-	return &StoreValueCommand{value: builder.value, protobuf: builder.protobuf}, nil
+	return &StoreValueCommand{value: builder.value, protobuf: builder.protobuf, resolver: builder.resolver}, nil
 }
 
 // DeleteValue
@@ -948,6 +994,10 @@ func (cmd *FetchPreflistCommand) Name() string {
 	return "FetchPreflist"
 }
 
+func (cmd *FetchPreflistCommand) minimumVersion() Version {
+	return version2_1
+}
+
 func (cmd *FetchPreflistCommand) constructPbRequest() (proto.Message, error) {
 	return cmd.protobuf, nil
 }
@@ -1057,6 +1107,7 @@ type SecondaryIndexQueryCommand struct {
 	protobuf *rpbRiakKV.RpbIndexReq
 	callback func([]*SecondaryIndexQueryResult) error
 	done     bool
+	intQuery bool
 }
 
 func (cmd *SecondaryIndexQueryCommand) Done() bool {
@@ -1126,6 +1177,18 @@ func (cmd *SecondaryIndexQueryCommand) onSuccess(msg proto.Message) error {
 				}
 			}
 
+			if cmd.intQuery {
+				for _, result := range results {
+					if result.IndexKey == nil {
+						continue
+					}
+					if intIndexKey, err := strconv.ParseInt(string(result.IndexKey), 10, 64); err == nil {
+						result.IntIndexKey = intIndexKey
+						result.IsIntIndexKey = true
+					}
+				}
+			}
+
 			if cmd.protobuf.GetStream() {
 				if cmd.callback == nil {
 					panic("SecondaryIndexQueryCommand requires a callback when streaming.")
@@ -1161,6 +1224,11 @@ func (cmd *SecondaryIndexQueryCommand) getResponseProtobufMessage() proto.Messag
 type SecondaryIndexQueryResult struct {
 	IndexKey  []byte
 	ObjectKey []byte
+	// IntIndexKey is the IndexKey parsed as an integer, when querying an
+	// integer index with WithIntRange or WithIntIndexKey. IsIntIndexKey is
+	// false if there is no IndexKey or it is not an integer.
+	IntIndexKey   int64
+	IsIntIndexKey bool
 }
 
 type SecondaryIndexQueryResponse struct {
@@ -1179,6 +1247,7 @@ type SecondaryIndexQueryResponse struct {
 type SecondaryIndexQueryCommandBuilder struct {
 	protobuf *rpbRiakKV.RpbIndexReq
 	callback func([]*SecondaryIndexQueryResult) error
+	intQuery bool
 }
 
 // NewSecondaryIndexQueryCommandBuilder is a factory function for generating the command builder struct
@@ -1214,6 +1283,7 @@ func (builder *SecondaryIndexQueryCommandBuilder) WithRange(min string, max stri
 func (builder *SecondaryIndexQueryCommandBuilder) WithIntRange(min int64, max int64) *SecondaryIndexQueryCommandBuilder {
 	builder.protobuf.RangeMin = []byte(strconv.FormatInt(min, 10))
 	builder.protobuf.RangeMax = []byte(strconv.FormatInt(max, 10))
+	builder.intQuery = true
 	return builder
 }
 
@@ -1222,6 +1292,13 @@ func (builder *SecondaryIndexQueryCommandBuilder) WithIndexKey(key string) *Seco
 	return builder
 }
 
+// WithIntIndexKey sets the term to query an integer index for
+func (builder *SecondaryIndexQueryCommandBuilder) WithIntIndexKey(key int64) *SecondaryIndexQueryCommandBuilder {
+	builder.protobuf.Key = []byte(strconv.FormatInt(key, 10))
+	builder.intQuery = true
+	return builder
+}
+
 func (builder *SecondaryIndexQueryCommandBuilder) WithReturnKeyAndIndex(val bool) *SecondaryIndexQueryCommandBuilder {
 	builder.protobuf.ReturnTerms = &val
 	return builder
@@ -1282,6 +1359,245 @@ func (builder *SecondaryIndexQueryCommandBuilder) Build() (Command, error) {
 	return &SecondaryIndexQueryCommand{
 		protobuf: builder.protobuf,
 		callback: builder.callback,
+		intQuery: builder.intQuery && isIntIndex(string(builder.protobuf.GetIndex())),
+	}, nil
+}
+
+// ListObjectsByKeyRange
+// RpbCSBucketReq
+// RpbCSBucketResp
+
+// ListObjectsByKeyRangeCommand is used to fetch the objects of a range of keys
+// from Riak KV in a single pass, rather than querying the keys and fetching
+// each object. Riak folds over the bucket's $key index, so the range is always
+// a range of keys; secondary indexes can not be used.
+type ListObjectsByKeyRangeCommand struct {
+	CommandImpl
+	Response  *ListObjectsByKeyRangeResponse
+	protobuf  *rpbRiakKV.RpbCSBucketReq
+	streaming bool
+	callback  func([]*Object) error
+	resolver  ConflictResolver
+	done      bool
+}
+
+// Done returns true once Riak sent the last response of the fold
+func (cmd *ListObjectsByKeyRangeCommand) Done() bool {
+	return cmd.done
+}
+
+// Name identifies this command
+func (cmd *ListObjectsByKeyRangeCommand) Name() string {
+	return "ListObjectsByKeyRange"
+}
+
+func (cmd *ListObjectsByKeyRangeCommand) minimumVersion() Version {
+	return version1_4
+}
+
+func (cmd *ListObjectsByKeyRangeCommand) constructPbRequest() (proto.Message, error) {
+	return cmd.protobuf, nil
+}
+
+func (cmd *ListObjectsByKeyRangeCommand) onSuccess(msg proto.Message) error {
+	cmd.Success = true
+	if msg == nil {
+		cmd.Response = &ListObjectsByKeyRangeResponse{}
+		cmd.done = true
+		return nil
+	}
+	rpbCSBucketResp, ok := msg.(*rpbRiakKV.RpbCSBucketResp)
+	if !ok {
+		cmd.done = true
+		return fmt.Errorf("[ListObjectsByKeyRangeCommand] could not convert %v to RpbCSBucketResp", reflect.TypeOf(msg))
+	}
+	cmd.done = rpbCSBucketResp.GetDone()
+	response := cmd.Response
+	if response == nil {
+		response = &ListObjectsByKeyRangeResponse{}
+		cmd.Response = response
+	}
+	if continuation := rpbCSBucketResp.GetContinuation(); continuation != nil {
+		response.Continuation = continuation
+	}
+
+	var objects []*Object
+	for _, rpbIndexObject := range rpbCSBucketResp.GetObjects() {
+		rpbGetResp := rpbIndexObject.GetObject()
+		vclock := rpbGetResp.GetVclock()
+		values := make([]*Object, 0, len(rpbGetResp.GetContent()))
+		for _, content := range rpbGetResp.GetContent() {
+			ro, err := fromRpbContent(content)
+			if err != nil {
+				return err
+			}
+			ro.VClock = vclock
+			ro.BucketType = string(cmd.protobuf.Type)
+			ro.Bucket = string(cmd.protobuf.Bucket)
+			ro.Key = string(rpbIndexObject.GetKey())
+			values = append(values, ro)
+		}
+		if cmd.resolver != nil && len(values) > 1 {
+			values = cmd.resolver.Resolve(values)
+		}
+		objects = append(objects, values...)
+	}
+
+	if cmd.streaming {
+		if cmd.callback == nil {
+			panic("ListObjectsByKeyRangeCommand requires a callback when streaming.")
+		}
+		if len(objects) > 0 {
+			if err := cmd.callback(objects); err != nil {
+				cmd.Response = nil
+				return err
+			}
+		}
+	} else {
+		response.Objects = append(response.Objects, objects...)
+	}
+	return nil
+}
+
+func (cmd *ListObjectsByKeyRangeCommand) getRequestCode() byte {
+	return rpbCode_RpbCSBucketReq
+}
+
+func (cmd *ListObjectsByKeyRangeCommand) getResponseCode() byte {
+	return rpbCode_RpbCSBucketResp
+}
+
+func (cmd *ListObjectsByKeyRangeCommand) getResponseProtobufMessage() proto.Message {
+	return &rpbRiakKV.RpbCSBucketResp{}
+}
+
+// ListObjectsByKeyRangeResponse contains the response data for a
+// ListObjectsByKeyRangeCommand. The siblings of a key, unless resolved, are
+// consecutive Objects with the same Key. Continuation is nil unless
+// WithMaxResults cut the range short.
+type ListObjectsByKeyRangeResponse struct {
+	Objects      []*Object
+	Continuation []byte
+}
+
+// ListObjectsByKeyRangeCommandBuilder type is required for creating new instances of ListObjectsByKeyRangeCommand
+//
+//    command := NewListObjectsByKeyRangeCommandBuilder().
+//        WithBucketType("myBucketType").
+//        WithBucket("myBucket").
+//        WithStartKey("a").
+//        WithEndKey("n").
+//        WithMaxResults(1000).
+//        Build()
+type ListObjectsByKeyRangeCommandBuilder struct {
+	protobuf  *rpbRiakKV.RpbCSBucketReq
+	streaming bool
+	callback  func([]*Object) error
+	resolver  ConflictResolver
+}
+
+// NewListObjectsByKeyRangeCommandBuilder is a factory function for generating the command builder struct
+func NewListObjectsByKeyRangeCommandBuilder() *ListObjectsByKeyRangeCommandBuilder {
+	return &ListObjectsByKeyRangeCommandBuilder{protobuf: &rpbRiakKV.RpbCSBucketReq{}}
+}
+
+// WithBucketType sets the bucket-type to be used by the command. If omitted, 'default' is used
+func (builder *ListObjectsByKeyRangeCommandBuilder) WithBucketType(bucketType string) *ListObjectsByKeyRangeCommandBuilder {
+	builder.protobuf.Type = []byte(bucketType)
+	return builder
+}
+
+// WithBucket sets the bucket to be used by the command
+func (builder *ListObjectsByKeyRangeCommandBuilder) WithBucket(bucket string) *ListObjectsByKeyRangeCommandBuilder {
+	builder.protobuf.Bucket = []byte(bucket)
+	return builder
+}
+
+// WithStartKey sets the first key of the range, included unless
+// WithStartInclusive(false) is given
+func (builder *ListObjectsByKeyRangeCommandBuilder) WithStartKey(key string) *ListObjectsByKeyRangeCommandBuilder {
+	builder.protobuf.StartKey = []byte(key)
+	return builder
+}
+
+// WithEndKey sets the last key of the range, excluded unless
+// WithEndInclusive(true) is given. If omitted, the range extends to the last
+// key of the bucket.
+func (builder *ListObjectsByKeyRangeCommandBuilder) WithEndKey(key string) *ListObjectsByKeyRangeCommandBuilder {
+	builder.protobuf.EndKey = []byte(key)
+	return builder
+}
+
+// WithStartInclusive sets whether the start key is part of the range, true by default
+func (builder *ListObjectsByKeyRangeCommandBuilder) WithStartInclusive(inclusive bool) *ListObjectsByKeyRangeCommandBuilder {
+	builder.protobuf.StartIncl = &inclusive
+	return builder
+}
+
+// WithEndInclusive sets whether the end key is part of the range, false by default
+func (builder *ListObjectsByKeyRangeCommandBuilder) WithEndInclusive(inclusive bool) *ListObjectsByKeyRangeCommandBuilder {
+	builder.protobuf.EndIncl = &inclusive
+	return builder
+}
+
+// WithMaxResults sets the maximum number of keys returned; the response then
+// has a continuation to fetch the rest of the range
+func (builder *ListObjectsByKeyRangeCommandBuilder) WithMaxResults(maxResults uint32) *ListObjectsByKeyRangeCommandBuilder {
+	builder.protobuf.MaxResults = &maxResults
+	return builder
+}
+
+// WithContinuation resumes the range after the last key of a previous response
+func (builder *ListObjectsByKeyRangeCommandBuilder) WithContinuation(cont []byte) *ListObjectsByKeyRangeCommandBuilder {
+	builder.protobuf.Continuation = cont
+	return builder
+}
+
+// WithStreaming sets whether the objects are given to the callback as Riak
+// sends them rather than collected in the response
+func (builder *ListObjectsByKeyRangeCommandBuilder) WithStreaming(streaming bool) *ListObjectsByKeyRangeCommandBuilder {
+	builder.streaming = streaming
+	return builder
+}
+
+// WithCallback sets the callback given the objects when streaming
+func (builder *ListObjectsByKeyRangeCommandBuilder) WithCallback(callback func([]*Object) error) *ListObjectsByKeyRangeCommandBuilder {
+	builder.callback = callback
+	return builder
+}
+
+// WithConflictResolver sets a ConflictResolver given the siblings of each key
+func (builder *ListObjectsByKeyRangeCommandBuilder) WithConflictResolver(resolver ConflictResolver) *ListObjectsByKeyRangeCommandBuilder {
+	builder.resolver = resolver
+	return builder
+}
+
+// WithTimeout sets a timeout in milliseconds to be used for this command operation
+func (builder *ListObjectsByKeyRangeCommandBuilder) WithTimeout(timeout time.Duration) *ListObjectsByKeyRangeCommandBuilder {
+	timeoutMilliseconds := uint32(timeout / time.Millisecond)
+	builder.protobuf.Timeout = &timeoutMilliseconds
+	return builder
+}
+
+// Build validates the configuration options provided then builds the command
+func (builder *ListObjectsByKeyRangeCommandBuilder) Build() (Command, error) {
+	if builder.protobuf == nil {
+		panic("builder.protobuf must not be nil")
+	}
+	if err := validateLocatable(builder.protobuf); err != nil {
+		return nil, err
+	}
+	if builder.protobuf.GetStartKey() == nil {
+		return nil, newClientError("ListObjectsByKeyRangeCommand requires a start key")
+	}
+	if builder.streaming && builder.callback == nil {
+		return nil, newClientError("ListObjectsByKeyRangeCommand requires a callback when streaming.")
+	}
+	return &ListObjectsByKeyRangeCommand{
+		protobuf:  builder.protobuf,
+		streaming: builder.streaming,
+		callback:  builder.callback,
+		resolver:  builder.resolver,
 	}, nil
 }
 
@@ -1292,11 +1608,14 @@ func (builder *SecondaryIndexQueryCommandBuilder) Build() (Command, error) {
 // Command used to fetch keys or data from Riak KV using the MapReduce technique
 type MapReduceCommand struct {
 	CommandImpl
-	Response  [][]byte
-	protobuf  *rpbRiakKV.RpbMapRedReq
-	streaming bool
-	callback  func(response []byte) error
-	done      bool
+	Response [][]byte
+	// Results holds the same responses as Response, grouped by phase
+	Results       *MapReduceResults
+	protobuf      *rpbRiakKV.RpbMapRedReq
+	streaming     bool
+	callback      func(response []byte) error
+	phaseCallback func(phase int, response []byte) error
+	done          bool
 }
 
 // Name identifies this command
@@ -1323,17 +1642,32 @@ func (cmd *MapReduceCommand) onSuccess(msg proto.Message) error {
 		if rpbMapRedResp, ok := msg.(*rpbRiakKV.RpbMapRedResp); ok {
 			cmd.done = rpbMapRedResp.GetDone()
 			rpbMapRedRespData := rpbMapRedResp.GetResponse()
+			phase := int(rpbMapRedResp.GetPhase())
 			if cmd.streaming {
-				if cmd.callback == nil {
+				if cmd.callback == nil && cmd.phaseCallback == nil {
 					panic("MapReduceCommand requires a callback when streaming.")
-				} else {
+				}
+				if cmd.callback != nil {
 					if err := cmd.callback(rpbMapRedRespData); err != nil {
 						cmd.Response = nil
 						return err
 					}
 				}
+				// NB: the last response only marks the end of the stream
+				if cmd.phaseCallback != nil && rpbMapRedRespData != nil {
+					if err := cmd.phaseCallback(phase, rpbMapRedRespData); err != nil {
+						cmd.Response = nil
+						return err
+					}
+				}
 			} else {
 				cmd.Response = append(cmd.Response, rpbMapRedRespData)
+				if cmd.Results == nil {
+					cmd.Results = &MapReduceResults{contentType: string(cmd.protobuf.GetContentType())}
+				}
+				if rpbMapRedRespData != nil {
+					cmd.Results.add(phase, rpbMapRedRespData)
+				}
 			}
 		} else {
 			cmd.done = true
@@ -1361,9 +1695,11 @@ func (cmd *MapReduceCommand) getResponseProtobufMessage() proto.Message {
 //        WithQuery("myMapReduceQuery").
 //        Build()
 type MapReduceCommandBuilder struct {
-	protobuf  *rpbRiakKV.RpbMapRedReq
-	streaming bool
-	callback  func(response []byte) error
+	protobuf      *rpbRiakKV.RpbMapRedReq
+	streaming     bool
+	callback      func(response []byte) error
+	phaseCallback func(phase int, response []byte) error
+	queryBuilder  *MapReduceQueryBuilder
 }
 
 // NewMapReduceCommandBuilder is a factory function for generating the command builder struct
@@ -1380,6 +1716,13 @@ func (builder *MapReduceCommandBuilder) WithQuery(query string) *MapReduceComman
 	return builder
 }
 
+// WithQueryBuilder sets the query to the job built by queryBuilder when the
+// command is built, failing the build if the job is invalid
+func (builder *MapReduceCommandBuilder) WithQueryBuilder(queryBuilder *MapReduceQueryBuilder) *MapReduceCommandBuilder {
+	builder.queryBuilder = queryBuilder
+	return builder
+}
+
 func (builder *MapReduceCommandBuilder) WithStreaming(streaming bool) *MapReduceCommandBuilder {
 	builder.streaming = streaming
 	return builder
@@ -1390,17 +1733,43 @@ func (builder *MapReduceCommandBuilder) WithCallback(callback func([]byte) error
 	return builder
 }
 
+// WithPhaseCallback sets a callback given each response when streaming, along
+// with the index of the phase the results belong to
+func (builder *MapReduceCommandBuilder) WithPhaseCallback(callback func(phase int, response []byte) error) *MapReduceCommandBuilder {
+	builder.phaseCallback = callback
+	return builder
+}
+
+// WithContentType sets the content type of the query and of the results,
+// ContentTypeJSON by default. With ContentTypeErlangBinary, the query is a
+// term in the Erlang external term format, and so are the results.
+func (builder *MapReduceCommandBuilder) WithContentType(contentType string) *MapReduceCommandBuilder {
+	builder.protobuf.ContentType = []byte(contentType)
+	return builder
+}
+
 // Build validates the configuration options provided then builds the command
 func (builder *MapReduceCommandBuilder) Build() (Command, error) {
 	if builder.protobuf == nil {
 		panic("builder.protobuf must not be nil")
 	}
-	if builder.streaming && builder.callback == nil {
+	if builder.queryBuilder != nil {
+		if mediaType(string(builder.protobuf.GetContentType())) != ContentTypeJSON {
+			return nil, newClientError("MapReduceCommand can only build JSON queries")
+		}
+		query, err := builder.queryBuilder.Build()
+		if err != nil {
+			return nil, err
+		}
+		builder.protobuf.Request = []byte(query)
+	}
+	if builder.streaming && builder.callback == nil && builder.phaseCallback == nil {
 		return nil, newClientError("MapReduceCommand requires a callback when streaming.")
 	}
 	return &MapReduceCommand{
-		protobuf:  builder.protobuf,
-		streaming: builder.streaming,
-		callback:  builder.callback,
+		protobuf:      builder.protobuf,
+		streaming:     builder.streaming,
+		callback:      builder.callback,
+		phaseCallback: builder.phaseCallback,
 	}, nil
 }
@@ -1583,3 +1583,204 @@ func TestParseRpbMapRedRespCorrectlyWithStreaming(t *testing.T) {
 func ExampleNewFetchValueCommandBuilder() {
 
 }
+
+func TestSecondaryIndexQueryReturnsIntTerms(t *testing.T) {
+	builder := NewSecondaryIndexQueryCommandBuilder().
+		WithBucket("bucket").
+		WithIndexName("age_int").
+		WithReturnKeyAndIndex(true).
+		WithIntRange(0, 50)
+	cmd, err := builder.Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	rpbIndexResp := &rpbRiakKV.RpbIndexResp{
+		Results: []*rpbRiak.RpbPair{
+			{Key: []byte("42"), Value: []byte("alice")},
+			{Key: []byte("-7"), Value: []byte("bob")},
+			{Key: []byte("0"), Value: []byte("carol")},
+			{Key: []byte("old"), Value: []byte("dave")},
+		},
+	}
+	if err := cmd.onSuccess(rpbIndexResp); err != nil {
+		t.Fatal(err.Error())
+	}
+	results := cmd.(*SecondaryIndexQueryCommand).Response.Results
+	if expected, actual := int64(42), results[0].IntIndexKey; expected != actual {
+		t.Errorf("expected %v, actual %v", expected, actual)
+	}
+	if expected, actual := int64(-7), results[1].IntIndexKey; expected != actual {
+		t.Errorf("expected %v, actual %v", expected, actual)
+	}
+	if !results[2].IsIntIndexKey || results[2].IntIndexKey != 0 {
+		t.Errorf("expected integer 0, got %v, %v", results[2].IsIntIndexKey, results[2].IntIndexKey)
+	}
+	if results[3].IsIntIndexKey {
+		t.Errorf("expected non-integer term, got %v", results[3].IntIndexKey)
+	}
+
+	builder = NewSecondaryIndexQueryCommandBuilder().
+		WithBucket("bucket").
+		WithIndexName("age_int").
+		WithIntIndexKey(42)
+	if cmd, err = builder.Build(); err != nil {
+		t.Fatal(err.Error())
+	}
+	protobuf, err := cmd.constructPbRequest()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := "42", string(protobuf.(*rpbRiakKV.RpbIndexReq).GetKey()); expected != actual {
+		t.Errorf("expected %v, actual %v", expected, actual)
+	}
+}
+
+func TestBuildRpbCSBucketReqCorrectlyViaBuilder(t *testing.T) {
+	_, err := NewListObjectsByKeyRangeCommandBuilder().
+		WithBucket("bucket_name").
+		Build()
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if expected, actual := "ClientError|ListObjectsByKeyRangeCommand requires a start key", err.Error(); expected != actual {
+		t.Errorf("expected %v, actual %v", expected, actual)
+	}
+
+	cmd, err := NewListObjectsByKeyRangeCommandBuilder().
+		WithBucketType("bucket_type").
+		WithBucket("bucket_name").
+		WithStartKey("a").
+		WithEndKey("n").
+		WithStartInclusive(false).
+		WithEndInclusive(true).
+		WithMaxResults(1024).
+		WithContinuation([]byte("continuation_1234")).
+		WithTimeout(time.Second * 20).
+		Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	protobuf, err := cmd.constructPbRequest()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	req := protobuf.(*rpbRiakKV.RpbCSBucketReq)
+	if expected, actual := "bucket_type", string(req.GetType()); expected != actual {
+		t.Errorf("expected %v, actual %v", expected, actual)
+	}
+	if expected, actual := "bucket_name", string(req.GetBucket()); expected != actual {
+		t.Errorf("expected %v, actual %v", expected, actual)
+	}
+	if expected, actual := "a", string(req.GetStartKey()); expected != actual {
+		t.Errorf("expected %v, actual %v", expected, actual)
+	}
+	if expected, actual := "n", string(req.GetEndKey()); expected != actual {
+		t.Errorf("expected %v, actual %v", expected, actual)
+	}
+	if expected, actual := false, req.GetStartIncl(); expected != actual {
+		t.Errorf("expected %v, actual %v", expected, actual)
+	}
+	if expected, actual := true, req.GetEndIncl(); expected != actual {
+		t.Errorf("expected %v, actual %v", expected, actual)
+	}
+	if expected, actual := uint32(1024), req.GetMaxResults(); expected != actual {
+		t.Errorf("expected %v, actual %v", expected, actual)
+	}
+	if expected, actual := "continuation_1234", string(req.GetContinuation()); expected != actual {
+		t.Errorf("expected %v, actual %v", expected, actual)
+	}
+	if expected, actual := uint32(20000), req.GetTimeout(); expected != actual {
+		t.Errorf("expected %v, actual %v", expected, actual)
+	}
+
+	if _, err = NewListObjectsByKeyRangeCommandBuilder().
+		WithBucket("bucket_name").
+		WithStartKey("a").
+		WithStreaming(true).
+		Build(); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestRpbCSBucketRespConvertsObjects(t *testing.T) {
+	rpbCSBucketResp := func(keys ...string) *rpbRiakKV.RpbCSBucketResp {
+		resp := &rpbRiakKV.RpbCSBucketResp{}
+		for _, key := range keys {
+			resp.Objects = append(resp.Objects, &rpbRiakKV.RpbIndexObject{
+				Key: []byte(key),
+				Object: &rpbRiakKV.RpbGetResp{
+					Vclock: []byte("vclock_" + key),
+					Content: []*rpbRiakKV.RpbContent{
+						{Value: []byte(key + "1"), Vtag: []byte("1")},
+						{Value: []byte(key + "2"), Vtag: []byte("2")},
+					},
+				},
+			})
+		}
+		return resp
+	}
+
+	cmd, err := NewListObjectsByKeyRangeCommandBuilder().
+		WithBucket("bucket").
+		WithStartKey("a").
+		Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := cmd.onSuccess(rpbCSBucketResp("a", "b")); err != nil {
+		t.Fatal(err.Error())
+	}
+	if cmd.(*ListObjectsByKeyRangeCommand).Done() {
+		t.Error("expected command not to be done")
+	}
+	if err := cmd.onSuccess(&rpbRiakKV.RpbCSBucketResp{Continuation: []byte("1234"), Done: proto.Bool(true)}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if !cmd.(*ListObjectsByKeyRangeCommand).Done() {
+		t.Error("expected command to be done")
+	}
+	rsp := cmd.(*ListObjectsByKeyRangeCommand).Response
+	if expected, actual := 4, len(rsp.Objects); expected != actual {
+		t.Fatalf("expected %v, actual %v", expected, actual)
+	}
+	o := rsp.Objects[3]
+	if expected, actual := "b", o.Key; expected != actual {
+		t.Errorf("expected %v, actual %v", expected, actual)
+	}
+	if expected, actual := "b2", string(o.Value); expected != actual {
+		t.Errorf("expected %v, actual %v", expected, actual)
+	}
+	if expected, actual := "vclock_b", string(o.VClock); expected != actual {
+		t.Errorf("expected %v, actual %v", expected, actual)
+	}
+	if expected, actual := "default", o.BucketType; expected != actual {
+		t.Errorf("expected %v, actual %v", expected, actual)
+	}
+	if expected, actual := "1234", string(rsp.Continuation); expected != actual {
+		t.Errorf("expected %v, actual %v", expected, actual)
+	}
+
+	// streaming gives the callback one object per key once resolved
+	var streamed []string
+	cmd, err = NewListObjectsByKeyRangeCommandBuilder().
+		WithBucket("bucket").
+		WithStartKey("a").
+		WithConflictResolver(NewVTagResolver()).
+		WithStreaming(true).
+		WithCallback(func(objects []*Object) error {
+			for _, o := range objects {
+				streamed = append(streamed, string(o.Value))
+			}
+			return nil
+		}).
+		Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := cmd.onSuccess(rpbCSBucketResp("a", "b")); err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := 2, len(streamed); expected != actual {
+		t.Errorf("expected %v, actual %v", expected, actual)
+	}
+}
@@ -0,0 +1,455 @@
+package riak
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Meta holds the Riak metadata of a struct stored by a Mapper. Embed it in the
+// struct, with a riak tag giving the bucket type and bucket:
+//
+//	type User struct {
+//		riak.Meta `riak:"bucket_type=users,bucket=users" json:"-"`
+//		Email     string      `riak:"key"`
+//		Name      string
+//		Country   string      `riak:"index=country_bin"`
+//		Age       int         `riak:"index=age_int"`
+//		Source    string      `riak:"usermeta=source" json:"-"`
+//		Friends   []riak.Link `riak:"links" json:"-"`
+//	}
+//
+// The bucket type defaults to "default". Fields tagged with riak are still
+// encoded in the value unless excluded with a tag of the codec, such as
+// `json:"-"`.
+type Meta struct {
+	// VClock is set when the struct is fetched or stored, and sent back to
+	// Riak on the next store or delete so that updates do not create siblings
+	VClock []byte
+}
+
+// Mapper errors
+var (
+	ErrMapperNotFound = newClientError("[Mapper] object not found")
+	ErrMapperSiblings = newClientError("[Mapper] object has siblings")
+)
+
+var metaType = reflect.TypeOf(Meta{})
+
+// mapperType describes the riak tags of a struct type
+type mapperType struct {
+	bucketType string
+	bucket     string
+	meta       []int // NB: field index of Meta, for reflect.Value.FieldByIndex
+	key        []int
+	indexes    []mapperIndex
+	userMeta   []mapperUserMeta
+	links      []int
+}
+
+type mapperIndex struct {
+	name  string
+	field []int
+}
+
+type mapperUserMeta struct {
+	key   string
+	field []int
+}
+
+var (
+	mapperTypesMtx sync.RWMutex
+	mapperTypes    = make(map[reflect.Type]*mapperType)
+)
+
+// parseTag parses a riak tag into its comma separated options
+func parseTag(tag string) map[string]string {
+	options := make(map[string]string)
+	for _, option := range strings.Split(tag, ",") {
+		option = strings.TrimSpace(option)
+		if option == "" {
+			continue
+		}
+		if i := strings.IndexByte(option, '='); i >= 0 {
+			options[option[:i]] = option[i+1:]
+		} else {
+			options[option] = ""
+		}
+	}
+	return options
+}
+
+func getMapperType(t reflect.Type) (*mapperType, error) {
+	mapperTypesMtx.RLock()
+	mt, ok := mapperTypes[t]
+	mapperTypesMtx.RUnlock()
+	if ok {
+		return mt, nil
+	}
+	mt, err := newMapperType(t)
+	if err != nil {
+		return nil, err
+	}
+	mapperTypesMtx.Lock()
+	mapperTypes[t] = mt
+	mapperTypesMtx.Unlock()
+	return mt, nil
+}
+
+func mapperTypeError(t reflect.Type, format string, args ...interface{}) error {
+	return newClientError(fmt.Sprintf("[Mapper] %v: %s", t, fmt.Sprintf(format, args...)))
+}
+
+func newMapperType(t reflect.Type) (*mapperType, error) {
+	mt := &mapperType{bucketType: defaultBucketType}
+	stringType := reflect.TypeOf("")
+	linksType := reflect.TypeOf([]Link{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Type == metaType {
+			mt.meta = f.Index
+			options := parseTag(f.Tag.Get("riak"))
+			if bucketType, ok := options["bucket_type"]; ok && bucketType != "" {
+				mt.bucketType = bucketType
+			}
+			mt.bucket = options["bucket"]
+			continue
+		}
+		tag, ok := f.Tag.Lookup("riak")
+		if !ok {
+			continue
+		}
+		options := parseTag(tag)
+		if _, ok := options["key"]; ok {
+			if f.Type != stringType {
+				return nil, mapperTypeError(t, "key field %s must be a string", f.Name)
+			}
+			mt.key = f.Index
+		}
+		if name, ok := options["index"]; ok {
+			if err := validateIndexField(f.Type, name); err != nil {
+				return nil, mapperTypeError(t, "field %s: %v", f.Name, err)
+			}
+			mt.indexes = append(mt.indexes, mapperIndex{name, f.Index})
+		}
+		if key, ok := options["usermeta"]; ok {
+			if f.Type != stringType {
+				return nil, mapperTypeError(t, "usermeta field %s must be a string", f.Name)
+			}
+			if key == "" {
+				key = f.Name
+			}
+			mt.userMeta = append(mt.userMeta, mapperUserMeta{key, f.Index})
+		}
+		if _, ok := options["links"]; ok {
+			if f.Type != linksType {
+				return nil, mapperTypeError(t, "links field %s must be a []riak.Link", f.Name)
+			}
+			mt.links = f.Index
+		}
+	}
+	if mt.meta == nil || mt.bucket == "" {
+		return nil, mapperTypeError(t, "a riak.Meta field with a bucket is required")
+	}
+	if mt.key == nil {
+		return nil, mapperTypeError(t, "a key field is required")
+	}
+	return mt, nil
+}
+
+// validateIndexField checks that a field of type t can hold the terms of the
+// index, strings or integers for binary indexes and integers for integer ones,
+// or slices of them
+func validateIndexField(t reflect.Type, indexName string) error {
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if isIntIndex(indexName) || strings.HasSuffix(indexName, BinIndexSuffix) {
+			return nil
+		}
+	case reflect.String:
+		if strings.HasSuffix(indexName, BinIndexSuffix) {
+			return nil
+		}
+		if isIntIndex(indexName) {
+			return fmt.Errorf("integer index '%s' requires an integer field", indexName)
+		}
+	default:
+		return fmt.Errorf("index field must be a string, an integer or a slice of them")
+	}
+	return fmt.Errorf("index name '%s' must end with %s or %s", indexName, IntIndexSuffix, BinIndexSuffix)
+}
+
+func indexTerm(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	default:
+		return strconv.FormatUint(v.Uint(), 10)
+	}
+}
+
+func setIndexTerm(v reflect.Value, term string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(term)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(term, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	default:
+		n, err := strconv.ParseUint(term, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	}
+	return nil
+}
+
+// MapperOptions are the options of a Mapper
+type MapperOptions struct {
+	// ContentType is the content type values are stored with, which must have
+	// a registered Codec. If empty, values are stored as JSON.
+	ContentType string
+
+	// ConflictResolver, if set, resolves siblings when fetching. Tombstones
+	// are given to the resolver too, see NewTombstoneResolver.
+	ConflictResolver ConflictResolver
+}
+
+// Mapper stores and fetches Go structs as Riak objects, using the riak tags of
+// their fields as described for Meta
+type Mapper struct {
+	executor    Executor
+	contentType string
+	resolver    ConflictResolver
+}
+
+// NewMapper returns a Mapper executing commands with the executor, usually a
+// Cluster. The options may be nil.
+func NewMapper(executor Executor, options *MapperOptions) *Mapper {
+	m := &Mapper{executor: executor, contentType: ContentTypeJSON}
+	if options != nil {
+		if options.ContentType != "" {
+			m.contentType = options.ContentType
+		}
+		m.resolver = options.ConflictResolver
+	}
+	return m
+}
+
+// mapped returns the struct pointed to by v and its type description
+func mapped(v interface{}) (reflect.Value, *mapperType, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}, nil, newClientError(fmt.Sprintf("[Mapper] expected a pointer to a struct, got %v", reflect.TypeOf(v)))
+	}
+	rv = rv.Elem()
+	mt, err := getMapperType(rv.Type())
+	return rv, mt, err
+}
+
+func (m *Mapper) execute(ctx context.Context, cmd Command) error {
+	return executeContext(ctx, m.executor, cmd)
+}
+
+// Get fetches the object with the key of the struct pointed to by v and decodes
+// it into v. It returns ErrMapperNotFound if there is no such object, and
+// ErrMapperSiblings if the object has siblings left by the ConflictResolver.
+func (m *Mapper) Get(ctx context.Context, v interface{}) error {
+	rv, mt, err := mapped(v)
+	if err != nil {
+		return err
+	}
+	key := rv.FieldByIndex(mt.key).String()
+	if key == "" {
+		return ErrKeyRequired
+	}
+	builder := NewFetchValueCommandBuilder().
+		WithBucketType(mt.bucketType).
+		WithBucket(mt.bucket).
+		WithKey(key)
+	if m.resolver != nil {
+		builder.WithConflictResolver(m.resolver)
+	}
+	if d, ok, err := timeout(ctx); err != nil {
+		return err
+	} else if ok {
+		builder.WithTimeout(d)
+	}
+	cmd, err := builder.Build()
+	if err != nil {
+		return err
+	}
+	if err = m.execute(ctx, cmd); err != nil {
+		return err
+	}
+	response := cmd.(*FetchValueCommand).Response
+	if response.IsNotFound || len(response.Values) == 0 {
+		return ErrMapperNotFound
+	}
+	if len(response.Values) > 1 {
+		return ErrMapperSiblings
+	}
+	if response.Values[0].IsTombstone {
+		rv.FieldByIndex(mt.meta).Set(reflect.ValueOf(Meta{VClock: response.VClock}))
+		return ErrMapperNotFound
+	}
+	return m.fromObject(rv, mt, response.Values[0], response.VClock)
+}
+
+// Put stores the struct pointed to by v. If its key is empty, Riak generates
+// one and the key field is set to it. The vclock of v is updated so that v can
+// be stored again without creating a sibling.
+func (m *Mapper) Put(ctx context.Context, v interface{}) error {
+	rv, mt, err := mapped(v)
+	if err != nil {
+		return err
+	}
+	o, err := m.toObject(rv, mt)
+	if err != nil {
+		return err
+	}
+	builder := NewStoreValueCommandBuilder().
+		WithBucketType(mt.bucketType).
+		WithBucket(mt.bucket).
+		WithContent(o).
+		WithReturnHead(true)
+	if o.Key != "" {
+		builder.WithKey(o.Key)
+	}
+	if d, ok, err := timeout(ctx); err != nil {
+		return err
+	} else if ok {
+		builder.WithTimeout(d)
+	}
+	cmd, err := builder.Build()
+	if err != nil {
+		return err
+	}
+	if err = m.execute(ctx, cmd); err != nil {
+		return err
+	}
+	response := cmd.(*StoreValueCommand).Response
+	if response.GeneratedKey != "" {
+		rv.FieldByIndex(mt.key).SetString(response.GeneratedKey)
+	}
+	rv.FieldByIndex(mt.meta).Set(reflect.ValueOf(Meta{VClock: response.VClock}))
+	return nil
+}
+
+// Delete deletes the object with the key of the struct pointed to by v, using
+// its vclock if it was fetched or stored
+func (m *Mapper) Delete(ctx context.Context, v interface{}) error {
+	rv, mt, err := mapped(v)
+	if err != nil {
+		return err
+	}
+	key := rv.FieldByIndex(mt.key).String()
+	if key == "" {
+		return ErrKeyRequired
+	}
+	meta := rv.FieldByIndex(mt.meta).Interface().(Meta)
+	builder := NewDeleteValueCommandBuilder().
+		WithBucketType(mt.bucketType).
+		WithBucket(mt.bucket).
+		WithKey(key)
+	if meta.VClock != nil {
+		builder.WithVClock(meta.VClock)
+	}
+	if d, ok, err := timeout(ctx); err != nil {
+		return err
+	} else if ok {
+		builder.WithTimeout(d)
+	}
+	cmd, err := builder.Build()
+	if err != nil {
+		return err
+	}
+	return m.execute(ctx, cmd)
+}
+
+func (m *Mapper) toObject(rv reflect.Value, mt *mapperType) (*Object, error) {
+	o := &Object{
+		BucketType:  mt.bucketType,
+		Bucket:      mt.bucket,
+		Key:         rv.FieldByIndex(mt.key).String(),
+		ContentType: m.contentType,
+		VClock:      rv.FieldByIndex(mt.meta).Interface().(Meta).VClock,
+	}
+	if err := o.Encode(rv.Addr().Interface()); err != nil {
+		return nil, err
+	}
+	for _, index := range mt.indexes {
+		f := rv.FieldByIndex(index.field)
+		if f.Kind() == reflect.Slice {
+			for i := 0; i < f.Len(); i++ {
+				o.AddToIndex(index.name, indexTerm(f.Index(i)))
+			}
+		} else {
+			o.AddToIndex(index.name, indexTerm(f))
+		}
+	}
+	for _, userMeta := range mt.userMeta {
+		if value := rv.FieldByIndex(userMeta.field).String(); value != "" {
+			o.UserMeta = append(o.UserMeta, &Pair{Key: userMeta.key, Value: value})
+		}
+	}
+	if mt.links != nil {
+		for _, link := range rv.FieldByIndex(mt.links).Interface().([]Link) {
+			l := link
+			o.Links = append(o.Links, &l)
+		}
+	}
+	return o, nil
+}
+
+func (m *Mapper) fromObject(rv reflect.Value, mt *mapperType, o *Object, vclock []byte) error {
+	if err := o.Decode(rv.Addr().Interface()); err != nil {
+		return err
+	}
+	rv.FieldByIndex(mt.meta).Set(reflect.ValueOf(Meta{VClock: vclock}))
+	for _, index := range mt.indexes {
+		f := rv.FieldByIndex(index.field)
+		terms := o.Indexes[index.name]
+		if f.Kind() == reflect.Slice {
+			s := reflect.MakeSlice(f.Type(), len(terms), len(terms))
+			for i, term := range terms {
+				if err := setIndexTerm(s.Index(i), term); err != nil {
+					return err
+				}
+			}
+			f.Set(s)
+		} else if len(terms) > 0 {
+			if err := setIndexTerm(f, terms[0]); err != nil {
+				return err
+			}
+		}
+	}
+	for _, userMeta := range mt.userMeta {
+		for _, pair := range o.UserMeta {
+			if strings.EqualFold(pair.Key, userMeta.key) {
+				rv.FieldByIndex(userMeta.field).SetString(pair.Value)
+			}
+		}
+	}
+	if mt.links != nil {
+		links := make([]Link, len(o.Links))
+		for i, link := range o.Links {
+			links[i] = *link
+		}
+		rv.FieldByIndex(mt.links).Set(reflect.ValueOf(links))
+	}
+	return nil
+}
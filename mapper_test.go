@@ -0,0 +1,234 @@
+package riak
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/basho/riak-go-client/riaktest"
+)
+
+type mapperTestUser struct {
+	Meta    `riak:"bucket_type=users,bucket=users" json:"-"`
+	Email   string   `riak:"key" json:"-"`
+	Name    string   `json:"name"`
+	Country string   `riak:"index=country_bin" json:"country"`
+	Age     int      `riak:"index=age_int" json:"age"`
+	Tags    []string `riak:"index=tag_bin" json:"-"`
+	Source  string   `riak:"usermeta=source" json:"-"`
+	Friends []Link   `riak:"links" json:"-"`
+}
+
+func startTestServerCluster(t *testing.T) (*riaktest.Server, *Cluster) {
+	server, err := riaktest.NewServer()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	node, err := NewNode(&NodeOptions{RemoteAddress: server.Addr()})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	cluster, err := NewCluster(&ClusterOptions{Nodes: []*Node{node}})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := cluster.Start(); err != nil {
+		t.Fatal(err.Error())
+	}
+	return server, cluster
+}
+
+func stopTestServerCluster(server *riaktest.Server, cluster *Cluster) {
+	cluster.Stop()
+	server.Close()
+}
+
+func startMapperCluster(t *testing.T) (*riaktest.Server, *Cluster, *Mapper) {
+	server, cluster := startTestServerCluster(t)
+	if err := server.CreateBucketType("users", ""); err != nil {
+		t.Fatal(err.Error())
+	}
+	return server, cluster, NewMapper(cluster, nil)
+}
+
+func TestMapperPutAndGet(t *testing.T) {
+	server, cluster, mapper := startMapperCluster(t)
+	defer stopTestServerCluster(server, cluster)
+	user := &mapperTestUser{
+		Email:   "alice@example.com",
+		Name:    "Alice",
+		Country: "nz",
+		Age:     42,
+		Tags:    []string{"admin", "staff"},
+		Source:  "signup",
+		Friends: []Link{{Bucket: "users", Key: "bob@example.com", Tag: "friend"}},
+	}
+	if err := mapper.Put(context.Background(), user); err != nil {
+		t.Fatal(err.Error())
+	}
+	if user.VClock == nil {
+		t.Error("expected vclock")
+	}
+	fetched := &mapperTestUser{Email: "alice@example.com"}
+	if err := mapper.Get(context.Background(), fetched); err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := user, fetched; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestMapperStoresIndexesAndUserMeta(t *testing.T) {
+	executor := NewMockExecutor()
+	executor.On(&StoreValueCommand{}).Respond(&StoreValueResponse{})
+	mapper := NewMapper(executor, nil)
+	user := &mapperTestUser{Email: "alice@example.com", Country: "nz", Age: 42, Source: "signup"}
+	if err := mapper.Put(context.Background(), user); err != nil {
+		t.Fatal(err.Error())
+	}
+	cmd := executor.Executed()[0].(*StoreValueCommand)
+	o := cmd.value
+	if expected, actual := "users", o.BucketType; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := ContentTypeJSON, o.ContentType; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	expectedIndexes := map[string][]string{"country_bin": {"nz"}, "age_int": {"42"}}
+	if expected, actual := expectedIndexes, o.Indexes; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := []*Pair{{Key: "source", Value: "signup"}}, o.UserMeta; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := `{"name":"","country":"nz","age":42}`, string(o.Value); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+// TestMapperVClockRoundTrips checks that updating a fetched or stored struct
+// does not create siblings in a bucket allowing them
+func TestMapperVClockRoundTrips(t *testing.T) {
+	server, cluster, mapper := startMapperCluster(t)
+	defer stopTestServerCluster(server, cluster)
+	user := &mapperTestUser{Email: "alice@example.com", Name: "Alice"}
+	if err := mapper.Put(context.Background(), user); err != nil {
+		t.Fatal(err.Error())
+	}
+	user.Name = "Alice Smith"
+	if err := mapper.Put(context.Background(), user); err != nil {
+		t.Fatal(err.Error())
+	}
+	fetched := &mapperTestUser{Email: "alice@example.com"}
+	if err := mapper.Get(context.Background(), fetched); err != nil {
+		t.Fatal(err.Error())
+	}
+	fetched.Name = "Alice Jones"
+	if err := mapper.Put(context.Background(), fetched); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := mapper.Get(context.Background(), fetched); err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := "Alice Jones", fetched.Name; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	// a write without the vclock does create a sibling
+	if err := mapper.Put(context.Background(), &mapperTestUser{Email: "alice@example.com"}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := ErrMapperSiblings, mapper.Get(context.Background(), fetched); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestMapperGeneratesKey(t *testing.T) {
+	server, cluster, mapper := startMapperCluster(t)
+	defer stopTestServerCluster(server, cluster)
+	user := &mapperTestUser{Name: "Alice"}
+	if err := mapper.Put(context.Background(), user); err != nil {
+		t.Fatal(err.Error())
+	}
+	if user.Email == "" {
+		t.Fatal("expected generated key")
+	}
+	fetched := &mapperTestUser{Email: user.Email}
+	if err := mapper.Get(context.Background(), fetched); err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := "Alice", fetched.Name; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestMapperDelete(t *testing.T) {
+	server, cluster, mapper := startMapperCluster(t)
+	defer stopTestServerCluster(server, cluster)
+	user := &mapperTestUser{Email: "alice@example.com"}
+	if expected, actual := ErrMapperNotFound, mapper.Get(context.Background(), user); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if err := mapper.Put(context.Background(), user); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := mapper.Delete(context.Background(), user); err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := ErrMapperNotFound, mapper.Get(context.Background(), user); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestMapperRejectsInvalidStructs(t *testing.T) {
+	mapper := NewMapper(NewMockExecutor(), nil)
+	var noMeta struct {
+		Key string `riak:"key"`
+	}
+	var noKey struct {
+		Meta `riak:"bucket=b"`
+	}
+	var badIndex struct {
+		Meta `riak:"bucket=b"`
+		Key  string `riak:"key"`
+		Name string `riak:"index=name"`
+	}
+	var stringIntIndex struct {
+		Meta `riak:"bucket=b"`
+		Key  string `riak:"key"`
+		Name string `riak:"index=name_int"`
+	}
+	user := mapperTestUser{}
+	for _, v := range []interface{}{nil, user, &noMeta, &noKey, &badIndex, &stringIntIndex, &user} {
+		if err := mapper.Put(context.Background(), v); err == nil {
+			t.Errorf("%T: expected error", v)
+		}
+	}
+	if expected, actual := ErrKeyRequired, mapper.Get(context.Background(), &user); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestMapperHonoursContext(t *testing.T) {
+	executor := NewMockExecutor()
+	mapper := NewMapper(executor, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	user := &mapperTestUser{Email: "alice@example.com"}
+	if expected, actual := context.Canceled, mapper.Get(ctx, user); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := 0, len(executor.Executed()); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	executor.On(&FetchValueCommand{}).Respond(&FetchValueResponse{IsNotFound: true})
+	ctx, cancel = context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	mapper.Get(ctx, user)
+	cmd := executor.Executed()[0].(*FetchValueCommand)
+	if timeout := cmd.protobuf.GetTimeout(); timeout == 0 || timeout > 60000 {
+		t.Errorf("expected timeout from the deadline, got %v", timeout)
+	}
+}
@@ -0,0 +1,468 @@
+package riak
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// MapReduceFunction is the function run by a map or reduce phase: JavaScript
+// source, a named or stored JavaScript function, or an Erlang function
+type MapReduceFunction struct {
+	language string
+	source   string
+	name     string
+	bucket   string
+	key      string
+	module   string
+	function string
+}
+
+// NewJavaScriptSourceFunction returns the JavaScript function with the given source
+func NewJavaScriptSourceFunction(source string) MapReduceFunction {
+	return MapReduceFunction{language: "javascript", source: source}
+}
+
+// NewJavaScriptNamedFunction returns the JavaScript function with the given
+// name, e.g. Riak.mapValuesJson
+func NewJavaScriptNamedFunction(name string) MapReduceFunction {
+	return MapReduceFunction{language: "javascript", name: name}
+}
+
+// NewJavaScriptStoredFunction returns the JavaScript function whose source is
+// the value of the given Riak object
+func NewJavaScriptStoredFunction(bucket, key string) MapReduceFunction {
+	return MapReduceFunction{language: "javascript", bucket: bucket, key: key}
+}
+
+// NewErlangFunction returns the Erlang function module:function, which must be
+// on the code path of every Riak node
+func NewErlangFunction(module, function string) MapReduceFunction {
+	return MapReduceFunction{language: "erlang", module: module, function: function}
+}
+
+func (f MapReduceFunction) valid() bool {
+	switch f.language {
+	case "javascript":
+		return f.source != "" || f.name != "" || (f.bucket != "" && f.key != "")
+	case "erlang":
+		return f.module != "" && f.function != ""
+	}
+	return false
+}
+
+// KeyFilter is a key filter of a MapReduce input, e.g.
+// KeyFilter{"tokenize", "-", 1} or KeyFilter{"eq", "2016"}
+type KeyFilter []interface{}
+
+// mrPhaseSpec is the JSON encoding of a map, reduce or link phase
+type mrPhaseSpec struct {
+	Language string      `json:"language,omitempty"`
+	Source   string      `json:"source,omitempty"`
+	Name     string      `json:"name,omitempty"`
+	Module   string      `json:"module,omitempty"`
+	Function string      `json:"function,omitempty"`
+	Bucket   string      `json:"bucket,omitempty"`
+	Key      string      `json:"key,omitempty"`
+	Tag      string      `json:"tag,omitempty"`
+	Keep     bool        `json:"keep"`
+	Arg      interface{} `json:"arg,omitempty"`
+}
+
+type mrPhase map[string]*mrPhaseSpec
+
+type mrQuery struct {
+	Inputs  interface{} `json:"inputs"`
+	Query   []mrPhase   `json:"query"`
+	Timeout uint32      `json:"timeout,omitempty"`
+}
+
+// MapReduce inputs kinds
+const (
+	mrInputBucket     = "bucket"
+	mrInputKeys       = "keys"
+	mrInputIndex      = "index"
+	mrInputSearch     = "search"
+	mrInputKeyFilters = "key filters"
+)
+
+type mrKeyInput struct {
+	bucket  string
+	key     string
+	keyData interface{}
+}
+
+// MapReduceQueryBuilder builds the JSON encoded MapReduce job given to
+// MapReduceCommandBuilder.WithQuery, or given as is to WithQueryBuilder. The
+// job has one kind of inputs, and one or more phases.
+//
+//	query, err := NewMapReduceQueryBuilder().
+//	    WithBucketType("myBucketType").
+//	    WithIndexRangeInput("myBucket", "age_int", "18", "30").
+//	    AddMapPhase(NewJavaScriptNamedFunction("Riak.mapValuesJson"), false, nil).
+//	    AddReducePhase(NewErlangFunction("riak_kv_mapreduce", "reduce_count_inputs"), true, nil).
+//	    Build()
+type MapReduceQueryBuilder struct {
+	bucketType string
+	inputKinds []string
+	bucket     string
+	keys       []mrKeyInput
+	index      map[string]interface{}
+	search     [2]string
+	keyFilters []KeyFilter
+	phases     []mrPhase
+	timeout    time.Duration
+	err        error
+}
+
+// NewMapReduceQueryBuilder is a factory function for generating the query builder struct
+func NewMapReduceQueryBuilder() *MapReduceQueryBuilder {
+	return &MapReduceQueryBuilder{}
+}
+
+func (builder *MapReduceQueryBuilder) addInputKind(kind string) {
+	for _, k := range builder.inputKinds {
+		if k == kind {
+			return
+		}
+	}
+	builder.inputKinds = append(builder.inputKinds, kind)
+}
+
+// WithBucketType sets the bucket-type of the buckets of the inputs. If omitted,
+// 'default' is used
+func (builder *MapReduceQueryBuilder) WithBucketType(bucketType string) *MapReduceQueryBuilder {
+	builder.bucketType = bucketType
+	return builder
+}
+
+// WithBucketInput sets every object of the bucket as the inputs. This lists
+// the keys of the bucket, which is expensive.
+func (builder *MapReduceQueryBuilder) WithBucketInput(bucket string) *MapReduceQueryBuilder {
+	builder.addInputKind(mrInputBucket)
+	builder.bucket = bucket
+	return builder
+}
+
+// AddKeyInput adds the object with the given key to the inputs. keyData, if
+// not nil, is given to the first map phase along with the object.
+func (builder *MapReduceQueryBuilder) AddKeyInput(bucket, key string, keyData interface{}) *MapReduceQueryBuilder {
+	builder.addInputKind(mrInputKeys)
+	builder.keys = append(builder.keys, mrKeyInput{bucket: bucket, key: key, keyData: keyData})
+	return builder
+}
+
+// WithIndexInput sets the objects with the given secondary index term as the inputs
+func (builder *MapReduceQueryBuilder) WithIndexInput(bucket, index, key string) *MapReduceQueryBuilder {
+	builder.addInputKind(mrInputIndex)
+	builder.bucket = bucket
+	builder.index = map[string]interface{}{"index": index, "key": key}
+	return builder
+}
+
+// WithIndexRangeInput sets the objects with a secondary index term in the
+// inclusive range [start, end] as the inputs
+func (builder *MapReduceQueryBuilder) WithIndexRangeInput(bucket, index, start, end string) *MapReduceQueryBuilder {
+	builder.addInputKind(mrInputIndex)
+	builder.bucket = bucket
+	builder.index = map[string]interface{}{"index": index, "start": start, "end": end}
+	return builder
+}
+
+// WithIntIndexRangeInput sets the objects with an integer index term in the
+// inclusive range [start, end] as the inputs
+func (builder *MapReduceQueryBuilder) WithIntIndexRangeInput(bucket, index string, start, end int64) *MapReduceQueryBuilder {
+	builder.addInputKind(mrInputIndex)
+	builder.bucket = bucket
+	builder.index = map[string]interface{}{"index": index, "start": start, "end": end}
+	return builder
+}
+
+// WithSearchInput sets the objects matching the Riak Search query on the
+// given search index as the inputs
+func (builder *MapReduceQueryBuilder) WithSearchInput(index, query string) *MapReduceQueryBuilder {
+	builder.addInputKind(mrInputSearch)
+	builder.search = [2]string{index, query}
+	return builder
+}
+
+// WithKeyFiltersInput sets the objects of the bucket whose key passes the
+// filters as the inputs. Like WithBucketInput, this lists the keys of the
+// bucket.
+func (builder *MapReduceQueryBuilder) WithKeyFiltersInput(bucket string, filters ...KeyFilter) *MapReduceQueryBuilder {
+	builder.addInputKind(mrInputKeyFilters)
+	builder.bucket = bucket
+	builder.keyFilters = filters
+	return builder
+}
+
+func (builder *MapReduceQueryBuilder) addPhase(kind string, f MapReduceFunction, keep bool, arg interface{}) *MapReduceQueryBuilder {
+	if !f.valid() && builder.err == nil {
+		builder.err = newClientError("MapReduce " + kind + " phase requires a function")
+	}
+	builder.phases = append(builder.phases, mrPhase{kind: &mrPhaseSpec{
+		Language: f.language,
+		Source:   f.source,
+		Name:     f.name,
+		Bucket:   f.bucket,
+		Key:      f.key,
+		Module:   f.module,
+		Function: f.function,
+		Keep:     keep,
+		Arg:      arg,
+	}})
+	return builder
+}
+
+// AddMapPhase adds a map phase running the function on each input, with the
+// static argument arg if not nil. The results of the phase are returned if
+// keep is true; the results of the last phase always are.
+func (builder *MapReduceQueryBuilder) AddMapPhase(f MapReduceFunction, keep bool, arg interface{}) *MapReduceQueryBuilder {
+	return builder.addPhase("map", f, keep, arg)
+}
+
+// AddReducePhase adds a reduce phase running the function on the results of
+// the previous phase, with the static argument arg if not nil
+func (builder *MapReduceQueryBuilder) AddReducePhase(f MapReduceFunction, keep bool, arg interface{}) *MapReduceQueryBuilder {
+	return builder.addPhase("reduce", f, keep, arg)
+}
+
+// AddLinkPhase adds a link phase following the links of the objects given by
+// the previous phase, or of the inputs. An empty bucket or tag matches any.
+func (builder *MapReduceQueryBuilder) AddLinkPhase(bucket, tag string, keep bool) *MapReduceQueryBuilder {
+	builder.phases = append(builder.phases, mrPhase{"link": &mrPhaseSpec{
+		Bucket: bucket,
+		Tag:    tag,
+		Keep:   keep,
+	}})
+	return builder
+}
+
+// WithTimeout sets a timeout to be used for the job
+func (builder *MapReduceQueryBuilder) WithTimeout(timeout time.Duration) *MapReduceQueryBuilder {
+	builder.timeout = timeout
+	return builder
+}
+
+// typedBucket returns the bucket as Riak expects it in inputs, a [type, bucket]
+// pair outside the default bucket type
+func (builder *MapReduceQueryBuilder) typedBucket(bucket string) interface{} {
+	if builder.bucketType == "" || builder.bucketType == defaultBucketType {
+		return bucket
+	}
+	return []string{builder.bucketType, bucket}
+}
+
+func (builder *MapReduceQueryBuilder) inputs() (interface{}, error) {
+	if len(builder.inputKinds) == 0 {
+		return nil, newClientError("MapReduce query requires inputs")
+	}
+	if len(builder.inputKinds) > 1 {
+		return nil, newClientError("MapReduce query can not mix " + builder.inputKinds[0] + " and " + builder.inputKinds[1] + " inputs")
+	}
+	switch builder.inputKinds[0] {
+	case mrInputBucket:
+		if builder.bucket == "" {
+			return nil, ErrBucketRequired
+		}
+		return builder.typedBucket(builder.bucket), nil
+	case mrInputKeys:
+		inputs := make([][]interface{}, len(builder.keys))
+		for i, k := range builder.keys {
+			if k.bucket == "" {
+				return nil, ErrBucketRequired
+			}
+			if k.key == "" {
+				return nil, ErrKeyRequired
+			}
+			inputs[i] = []interface{}{k.bucket, k.key}
+			if builder.bucketType != "" && builder.bucketType != defaultBucketType {
+				// NB: the bucket type follows the key data, which must then be given
+				inputs[i] = append(inputs[i], k.keyData, builder.bucketType)
+			} else if k.keyData != nil {
+				inputs[i] = append(inputs[i], k.keyData)
+			}
+		}
+		return inputs, nil
+	case mrInputIndex:
+		if builder.bucket == "" {
+			return nil, ErrBucketRequired
+		}
+		if builder.index["index"] == "" {
+			return nil, newClientError("MapReduce index input requires an index name")
+		}
+		inputs := map[string]interface{}{"bucket": builder.typedBucket(builder.bucket)}
+		for k, v := range builder.index {
+			inputs[k] = v
+		}
+		return inputs, nil
+	case mrInputSearch:
+		if builder.search[0] == "" || builder.search[1] == "" {
+			return nil, newClientError("MapReduce search input requires an index and a query")
+		}
+		return map[string]interface{}{
+			"module":   "yokozuna",
+			"function": "mapred_search",
+			"arg":      builder.search[:],
+		}, nil
+	default:
+		if builder.bucket == "" {
+			return nil, ErrBucketRequired
+		}
+		if len(builder.keyFilters) == 0 {
+			return nil, newClientError("MapReduce key filters input requires at least one filter")
+		}
+		for _, filter := range builder.keyFilters {
+			if len(filter) == 0 {
+				return nil, newClientError("MapReduce key filters can not be empty")
+			}
+		}
+		return map[string]interface{}{
+			"bucket":      builder.typedBucket(builder.bucket),
+			"key_filters": builder.keyFilters,
+		}, nil
+	}
+}
+
+// Build validates the structure of the job then returns its JSON encoding
+func (builder *MapReduceQueryBuilder) Build() (string, error) {
+	if builder.err != nil {
+		return "", builder.err
+	}
+	inputs, err := builder.inputs()
+	if err != nil {
+		return "", err
+	}
+	if len(builder.phases) == 0 {
+		return "", newClientError("MapReduce query requires at least one phase")
+	}
+	for i, phase := range builder.phases {
+		// NB: links are followed from bucket / key pairs, which a reduce phase
+		// does not return
+		if _, ok := phase["link"]; ok && i > 0 {
+			if _, ok := builder.phases[i-1]["reduce"]; ok {
+				return "", newClientError("MapReduce link phase can not follow a reduce phase")
+			}
+		}
+	}
+	query := &mrQuery{
+		Inputs:  inputs,
+		Query:   builder.phases,
+		Timeout: uint32(builder.timeout / time.Millisecond),
+	}
+	data, err := json.Marshal(query)
+	if err != nil {
+		return "", newClientError(err.Error())
+	}
+	return string(data), nil
+}
+
+// MapReduceResults holds the results of a MapReduce job grouped by phase. Riak
+// sends the results of a phase in chunks, each a JSON array, or an Erlang list
+// when the job is given in the Erlang external term format; the chunks of a
+// phase are merged when decoded.
+type MapReduceResults struct {
+	contentType string
+	chunks      map[int][][]byte
+}
+
+func (r *MapReduceResults) add(phase int, chunk []byte) {
+	if r.chunks == nil {
+		r.chunks = make(map[int][][]byte)
+	}
+	r.chunks[phase] = append(r.chunks[phase], chunk)
+}
+
+// Phases returns the indexes of the phases with results, in order
+func (r *MapReduceResults) Phases() []int {
+	phases := make([]int, 0, len(r.chunks))
+	for phase := range r.chunks {
+		phases = append(phases, phase)
+	}
+	sort.Ints(phases)
+	return phases
+}
+
+// Chunks returns the results of the phase as Riak sent them
+func (r *MapReduceResults) Chunks(phase int) [][]byte {
+	return r.chunks[phase]
+}
+
+func (r *MapReduceResults) isErlang() bool {
+	return mediaType(r.contentType) == ContentTypeErlangBinary
+}
+
+// JSON returns the results of the phase merged into a single JSON array
+func (r *MapReduceResults) JSON(phase int) ([]byte, error) {
+	if r.isErlang() {
+		return nil, newClientError("[MapReduceResults] results are Erlang terms, not JSON")
+	}
+	results := []json.RawMessage{}
+	for _, chunk := range r.chunks[phase] {
+		var values []json.RawMessage
+		if err := json.Unmarshal(chunk, &values); err != nil {
+			// NB: a chunk that is not an array is a single result
+			if !json.Valid(chunk) {
+				return nil, newClientError(fmt.Sprintf("[MapReduceResults] invalid JSON results for phase %d", phase))
+			}
+			values = []json.RawMessage{chunk}
+		}
+		results = append(results, values...)
+	}
+	return json.Marshal(results)
+}
+
+// Terms returns the results of the phase merged into a single list, decoded
+// from the Erlang external term format as described for ErlangTuple
+func (r *MapReduceResults) Terms(phase int) ([]interface{}, error) {
+	if !r.isErlang() {
+		return nil, newClientError("[MapReduceResults] results are JSON, not Erlang terms")
+	}
+	results := []interface{}{}
+	for _, chunk := range r.chunks[phase] {
+		term, err := decodeErlangTerm(chunk)
+		if err != nil {
+			return nil, err
+		}
+		switch t := term.(type) {
+		case []interface{}:
+			results = append(results, t...)
+		case string:
+			// NB: a list of bytes is encoded compactly, and decoded as a string
+			for _, c := range []byte(t) {
+				results = append(results, int64(c))
+			}
+		default:
+			results = append(results, t)
+		}
+	}
+	return results, nil
+}
+
+// Decode decodes the merged results of the phase into v. JSON results are
+// decoded like json.Unmarshal, e.g. into a pointer to a slice. Erlang results
+// can only be decoded into a *[]interface{} or an *interface{}.
+func (r *MapReduceResults) Decode(phase int, v interface{}) error {
+	if !r.isErlang() {
+		data, err := r.JSON(phase)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(data, v)
+	}
+	terms, err := r.Terms(phase)
+	if err != nil {
+		return err
+	}
+	switch p := v.(type) {
+	case *[]interface{}:
+		*p = terms
+	case *interface{}:
+		*p = terms
+	default:
+		return newClientError(fmt.Sprintf("[MapReduceResults] can not decode Erlang terms into %v", reflect.TypeOf(v)))
+	}
+	return nil
+}
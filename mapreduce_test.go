@@ -0,0 +1,278 @@
+package riak
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	rpbRiakKV "github.com/basho/riak-go-client/rpb/riak_kv"
+	proto "github.com/golang/protobuf/proto"
+)
+
+func TestMapReduceQueryBuilderInputs(t *testing.T) {
+	mapValues := NewJavaScriptNamedFunction("Riak.mapValuesJson")
+	tests := []struct {
+		builder  *MapReduceQueryBuilder
+		expected string
+	}{
+		{
+			NewMapReduceQueryBuilder().WithBucketInput("b"),
+			`{"inputs":"b","query":[{"map":{"language":"javascript","name":"Riak.mapValuesJson","keep":true}}]}`,
+		},
+		{
+			NewMapReduceQueryBuilder().WithBucketType("t").WithBucketInput("b"),
+			`{"inputs":["t","b"],"query":[{"map":{"language":"javascript","name":"Riak.mapValuesJson","keep":true}}]}`,
+		},
+		{
+			NewMapReduceQueryBuilder().AddKeyInput("b", "k1", nil).AddKeyInput("b", "k2", "data"),
+			`{"inputs":[["b","k1"],["b","k2","data"]],"query":[{"map":{"language":"javascript","name":"Riak.mapValuesJson","keep":true}}]}`,
+		},
+		{
+			NewMapReduceQueryBuilder().WithBucketType("t").AddKeyInput("b", "k1", nil),
+			`{"inputs":[["b","k1",null,"t"]],"query":[{"map":{"language":"javascript","name":"Riak.mapValuesJson","keep":true}}]}`,
+		},
+		{
+			NewMapReduceQueryBuilder().WithIndexInput("b", "email_bin", "a@b.c"),
+			`{"inputs":{"bucket":"b","index":"email_bin","key":"a@b.c"},"query":[{"map":{"language":"javascript","name":"Riak.mapValuesJson","keep":true}}]}`,
+		},
+		{
+			NewMapReduceQueryBuilder().WithBucketType("t").WithIntIndexRangeInput("b", "age_int", 18, 30),
+			`{"inputs":{"bucket":["t","b"],"end":30,"index":"age_int","start":18},"query":[{"map":{"language":"javascript","name":"Riak.mapValuesJson","keep":true}}]}`,
+		},
+		{
+			NewMapReduceQueryBuilder().WithSearchInput("users", "name:\"alice\""),
+			`{"inputs":{"arg":["users","name:\"alice\""],"function":"mapred_search","module":"yokozuna"},"query":[{"map":{"language":"javascript","name":"Riak.mapValuesJson","keep":true}}]}`,
+		},
+		{
+			NewMapReduceQueryBuilder().WithKeyFiltersInput("b", KeyFilter{"tokenize", "-", 1}, KeyFilter{"eq", "2016"}),
+			`{"inputs":{"bucket":"b","key_filters":[["tokenize","-",1],["eq","2016"]]},"query":[{"map":{"language":"javascript","name":"Riak.mapValuesJson","keep":true}}]}`,
+		},
+	}
+	for _, test := range tests {
+		query, err := test.builder.AddMapPhase(mapValues, true, nil).Build()
+		if err != nil {
+			t.Errorf("%v: %v", test.expected, err)
+			continue
+		}
+		if expected, actual := test.expected, query; expected != actual {
+			t.Errorf("expected %v, got %v", expected, actual)
+		}
+	}
+}
+
+func TestMapReduceQueryBuilderPhases(t *testing.T) {
+	query, err := NewMapReduceQueryBuilder().
+		WithBucketInput("b").
+		AddLinkPhase("friends", "", false).
+		AddMapPhase(NewJavaScriptSourceFunction(`function(v) { return [v.key]; }`), false, map[string]int{"limit": 10}).
+		AddMapPhase(NewJavaScriptStoredFunction("fns", "map"), false, nil).
+		AddReducePhase(NewErlangFunction("riak_kv_mapreduce", "reduce_count_inputs"), true, nil).
+		WithTimeout(time.Minute).
+		Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expected := `{"inputs":"b","query":[` +
+		`{"link":{"bucket":"friends","keep":false}},` +
+		`{"map":{"language":"javascript","source":"function(v) { return [v.key]; }","keep":false,"arg":{"limit":10}}},` +
+		`{"map":{"language":"javascript","bucket":"fns","key":"map","keep":false}},` +
+		`{"reduce":{"language":"erlang","module":"riak_kv_mapreduce","function":"reduce_count_inputs","keep":true}}` +
+		`],"timeout":60000}`
+	if actual := query; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestMapReduceQueryBuilderValidation(t *testing.T) {
+	mapValues := NewJavaScriptNamedFunction("Riak.mapValuesJson")
+	tests := []struct {
+		builder  *MapReduceQueryBuilder
+		expected string
+	}{
+		{
+			NewMapReduceQueryBuilder().AddMapPhase(mapValues, true, nil),
+			"ClientError|MapReduce query requires inputs",
+		},
+		{
+			NewMapReduceQueryBuilder().WithBucketInput("b"),
+			"ClientError|MapReduce query requires at least one phase",
+		},
+		{
+			NewMapReduceQueryBuilder().WithBucketInput("b").AddKeyInput("b", "k", nil).AddMapPhase(mapValues, true, nil),
+			"ClientError|MapReduce query can not mix bucket and keys inputs",
+		},
+		{
+			NewMapReduceQueryBuilder().AddKeyInput("b", "", nil).AddMapPhase(mapValues, true, nil),
+			ErrKeyRequired.Error(),
+		},
+		{
+			NewMapReduceQueryBuilder().WithKeyFiltersInput("b").AddMapPhase(mapValues, true, nil),
+			"ClientError|MapReduce key filters input requires at least one filter",
+		},
+		{
+			NewMapReduceQueryBuilder().WithBucketInput("b").AddMapPhase(NewErlangFunction("m", ""), true, nil),
+			"ClientError|MapReduce map phase requires a function",
+		},
+		{
+			NewMapReduceQueryBuilder().WithBucketInput("b").AddReducePhase(MapReduceFunction{}, true, nil),
+			"ClientError|MapReduce reduce phase requires a function",
+		},
+		{
+			NewMapReduceQueryBuilder().WithBucketInput("b").
+				AddReducePhase(mapValues, false, nil).
+				AddLinkPhase("", "", true),
+			"ClientError|MapReduce link phase can not follow a reduce phase",
+		},
+	}
+	for _, test := range tests {
+		_, err := test.builder.Build()
+		if err == nil {
+			t.Errorf("%v: expected error", test.expected)
+			continue
+		}
+		if expected, actual := test.expected, err.Error(); expected != actual {
+			t.Errorf("expected %v, got %v", expected, actual)
+		}
+	}
+}
+
+func TestMapReduceCommandBuilderWithQueryBuilder(t *testing.T) {
+	_, err := NewMapReduceCommandBuilder().
+		WithQueryBuilder(NewMapReduceQueryBuilder()).
+		Build()
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	cmd, err := NewMapReduceCommandBuilder().
+		WithQueryBuilder(NewMapReduceQueryBuilder().
+			WithBucketInput("b").
+			AddMapPhase(NewJavaScriptNamedFunction("Riak.mapValuesJson"), true, nil)).
+		Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expected := `{"inputs":"b","query":[{"map":{"language":"javascript","name":"Riak.mapValuesJson","keep":true}}]}`
+	if actual := string(cmd.(*MapReduceCommand).protobuf.GetRequest()); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestMapReduceResultsGroupedByPhase(t *testing.T) {
+	cmd, err := NewMapReduceCommandBuilder().WithQuery("{}").Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, rsp := range []*rpbRiakKV.RpbMapRedResp{
+		{Phase: proto.Uint32(0), Response: []byte(`[1,2]`)},
+		{Phase: proto.Uint32(1), Response: []byte(`[{"count":3}]`)},
+		{Phase: proto.Uint32(0), Response: []byte(`[3]`)},
+		{Done: proto.Bool(true)},
+	} {
+		if err := cmd.onSuccess(rsp); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	mr := cmd.(*MapReduceCommand)
+	if expected, actual := 4, len(mr.Response); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	results := mr.Results
+	if expected, actual := []int{0, 1}, results.Phases(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	var values []int
+	if err := results.Decode(0, &values); err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := []int{1, 2, 3}, values; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	var counts []struct{ Count int }
+	if err := results.Decode(1, &counts); err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := 3, counts[0].Count; len(counts) != 1 || expected != actual {
+		t.Errorf("expected %v, got %v", expected, counts)
+	}
+	data, err := results.JSON(2)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := "[]", string(data); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if _, err := results.Terms(0); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestMapReducePhaseCallback(t *testing.T) {
+	phases := map[int][]string{}
+	cmd, err := NewMapReduceCommandBuilder().
+		WithQuery("{}").
+		WithStreaming(true).
+		WithPhaseCallback(func(phase int, response []byte) error {
+			phases[phase] = append(phases[phase], string(response))
+			return nil
+		}).
+		Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, rsp := range []*rpbRiakKV.RpbMapRedResp{
+		{Phase: proto.Uint32(0), Response: []byte(`[1]`)},
+		{Phase: proto.Uint32(2), Response: []byte(`[2]`)},
+		{Done: proto.Bool(true)},
+	} {
+		if err := cmd.onSuccess(rsp); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	if expected, actual := map[int][]string{0: {"[1]"}, 2: {"[2]"}}, phases; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if cmd.(*MapReduceCommand).Results != nil {
+		t.Error("expected no results when streaming")
+	}
+}
+
+func TestMapReduceErlangResults(t *testing.T) {
+	cmd, err := NewMapReduceCommandBuilder().
+		WithContentType(ContentTypeErlangBinary).
+		WithQuery("query").
+		Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, rsp := range []*rpbRiakKV.RpbMapRedResp{
+		// [{<<"a">>, 1}]
+		{Phase: proto.Uint32(0), Response: []byte{131, 108, 0, 0, 0, 1, 104, 2, 109, 0, 0, 0, 1, 'a', 97, 1, 106}},
+		// [2, 3], encoded as a string
+		{Phase: proto.Uint32(0), Response: []byte{131, 107, 0, 2, 2, 3}},
+		{Done: proto.Bool(true)},
+	} {
+		if err := cmd.onSuccess(rsp); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	var terms []interface{}
+	if err := cmd.(*MapReduceCommand).Results.Decode(0, &terms); err != nil {
+		t.Fatal(err.Error())
+	}
+	expected := []interface{}{ErlangTuple{[]byte("a"), int64(1)}, int64(2), int64(3)}
+	if actual := terms; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	var values []int
+	if err := cmd.(*MapReduceCommand).Results.Decode(0, &values); err == nil {
+		t.Error("expected error")
+	}
+
+	if _, err := NewMapReduceCommandBuilder().
+		WithContentType(ContentTypeErlangBinary).
+		WithQueryBuilder(NewMapReduceQueryBuilder()).
+		Build(); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"reflect"
 
 	rpbRiak "github.com/basho/riak-go-client/rpb/riak"
+	rpbRiakKV "github.com/basho/riak-go-client/rpb/riak_kv"
 	proto "github.com/golang/protobuf/proto"
 )
 
@@ -117,6 +118,202 @@ func (cmd *AuthCommand) getResponseProtobufMessage() proto.Message {
 	return nil
 }
 
+// FetchServerInfo
+// RpbGetServerInfoReq
+// RpbGetServerInfoResp
+
+// FetchServerInfoCommand fetches the name and version of the Riak node it is executed on. The
+// version is recorded by the node, which then refuses commands that need a later version of Riak.
+type FetchServerInfoCommand struct {
+	CommandImpl
+	Response *FetchServerInfoResponse
+}
+
+// Name identifies this command
+func (cmd *FetchServerInfoCommand) Name() string {
+	return "FetchServerInfo"
+}
+
+func (cmd *FetchServerInfoCommand) constructPbRequest() (proto.Message, error) {
+	return nil, nil
+}
+
+func (cmd *FetchServerInfoCommand) onSuccess(msg proto.Message) error {
+	cmd.Success = true
+	cmd.Response = &FetchServerInfoResponse{}
+	if msg != nil {
+		if rpbResp, ok := msg.(*rpbRiak.RpbGetServerInfoResp); ok {
+			cmd.Response.Node = string(rpbResp.GetNode())
+			cmd.Response.ServerVersion = string(rpbResp.GetServerVersion())
+		} else {
+			return fmt.Errorf("[FetchServerInfoCommand] could not convert %v to RpbGetServerInfoResp", reflect.TypeOf(msg))
+		}
+	}
+	return nil
+}
+
+func (cmd *FetchServerInfoCommand) getRequestCode() byte {
+	return rpbCode_RpbGetServerInfoReq
+}
+
+func (cmd *FetchServerInfoCommand) getResponseCode() byte {
+	return rpbCode_RpbGetServerInfoResp
+}
+
+func (cmd *FetchServerInfoCommand) getResponseProtobufMessage() proto.Message {
+	return &rpbRiak.RpbGetServerInfoResp{}
+}
+
+// FetchServerInfoResponse contains the Erlang node name and Riak version of a node
+type FetchServerInfoResponse struct {
+	Node          string
+	ServerVersion string
+}
+
+// FetchServerInfoCommandBuilder type is required for creating new instances of FetchServerInfoCommand
+//
+//    command, err := NewFetchServerInfoCommandBuilder().Build()
+type FetchServerInfoCommandBuilder struct {
+}
+
+// NewFetchServerInfoCommandBuilder is a factory function for generating the command builder struct
+func NewFetchServerInfoCommandBuilder() *FetchServerInfoCommandBuilder {
+	return &FetchServerInfoCommandBuilder{}
+}
+
+// Build validates the configuration options provided then builds the command
+func (builder *FetchServerInfoCommandBuilder) Build() (Command, error) {
+	return &FetchServerInfoCommand{}, nil
+}
+
+// FetchClientId
+// RpbGetClientIdReq
+// RpbGetClientIdResp
+
+// FetchClientIdCommand fetches the client id of the connection it is executed on. Client ids are
+// only used by Riak when vclocks are generated client-side, i.e. vnode_vclocks is false.
+type FetchClientIdCommand struct {
+	CommandImpl
+	Response string
+}
+
+// Name identifies this command
+func (cmd *FetchClientIdCommand) Name() string {
+	return "FetchClientId"
+}
+
+func (cmd *FetchClientIdCommand) constructPbRequest() (proto.Message, error) {
+	return nil, nil
+}
+
+func (cmd *FetchClientIdCommand) onSuccess(msg proto.Message) error {
+	cmd.Success = true
+	if msg != nil {
+		if rpbResp, ok := msg.(*rpbRiakKV.RpbGetClientIdResp); ok {
+			cmd.Response = string(rpbResp.GetClientId())
+		} else {
+			return fmt.Errorf("[FetchClientIdCommand] could not convert %v to RpbGetClientIdResp", reflect.TypeOf(msg))
+		}
+	}
+	return nil
+}
+
+func (cmd *FetchClientIdCommand) getRequestCode() byte {
+	return rpbCode_RpbGetClientIdReq
+}
+
+func (cmd *FetchClientIdCommand) getResponseCode() byte {
+	return rpbCode_RpbGetClientIdResp
+}
+
+func (cmd *FetchClientIdCommand) getResponseProtobufMessage() proto.Message {
+	return &rpbRiakKV.RpbGetClientIdResp{}
+}
+
+// FetchClientIdCommandBuilder type is required for creating new instances of FetchClientIdCommand
+//
+//    command, err := NewFetchClientIdCommandBuilder().Build()
+type FetchClientIdCommandBuilder struct {
+}
+
+// NewFetchClientIdCommandBuilder is a factory function for generating the command builder struct
+func NewFetchClientIdCommandBuilder() *FetchClientIdCommandBuilder {
+	return &FetchClientIdCommandBuilder{}
+}
+
+// Build validates the configuration options provided then builds the command
+func (builder *FetchClientIdCommandBuilder) Build() (Command, error) {
+	return &FetchClientIdCommand{}, nil
+}
+
+// StoreClientId
+// RpbSetClientIdReq
+// RpbSetClientIdResp
+
+// StoreClientIdCommand sets the client id of the connection it is executed on. Since a Cluster may
+// execute it on any connection, use NodeOptions.ClientId to set the client id of every connection.
+type StoreClientIdCommand struct {
+	CommandImpl
+	protobuf *rpbRiakKV.RpbSetClientIdReq
+}
+
+// Name identifies this command
+func (cmd *StoreClientIdCommand) Name() string {
+	return "StoreClientId"
+}
+
+func (cmd *StoreClientIdCommand) constructPbRequest() (proto.Message, error) {
+	return cmd.protobuf, nil
+}
+
+func (cmd *StoreClientIdCommand) onSuccess(msg proto.Message) error {
+	cmd.Success = true
+	return nil
+}
+
+func (cmd *StoreClientIdCommand) getRequestCode() byte {
+	return rpbCode_RpbSetClientIdReq
+}
+
+func (cmd *StoreClientIdCommand) getResponseCode() byte {
+	return rpbCode_RpbSetClientIdResp
+}
+
+func (cmd *StoreClientIdCommand) getResponseProtobufMessage() proto.Message {
+	return nil
+}
+
+// StoreClientIdCommandBuilder type is required for creating new instances of StoreClientIdCommand
+//
+//    command, err := NewStoreClientIdCommandBuilder().
+//        WithClientId("myService-1").
+//        Build()
+type StoreClientIdCommandBuilder struct {
+	protobuf *rpbRiakKV.RpbSetClientIdReq
+}
+
+// NewStoreClientIdCommandBuilder is a factory function for generating the command builder struct
+func NewStoreClientIdCommandBuilder() *StoreClientIdCommandBuilder {
+	return &StoreClientIdCommandBuilder{protobuf: &rpbRiakKV.RpbSetClientIdReq{}}
+}
+
+// WithClientId sets the client id, which should be stable and unique for each client instance
+func (builder *StoreClientIdCommandBuilder) WithClientId(clientId string) *StoreClientIdCommandBuilder {
+	builder.protobuf.ClientId = []byte(clientId)
+	return builder
+}
+
+// Build validates the configuration options provided then builds the command
+func (builder *StoreClientIdCommandBuilder) Build() (Command, error) {
+	if builder.protobuf == nil {
+		panic("builder.protobuf must not be nil")
+	}
+	if len(builder.protobuf.ClientId) == 0 {
+		return nil, newClientError("StoreClientIdCommand requires a client id")
+	}
+	return &StoreClientIdCommand{protobuf: builder.protobuf}, nil
+}
+
 // FetchBucketProps
 
 type FetchBucketPropsCommand struct {
@@ -141,46 +338,10 @@ func (cmd *FetchBucketPropsCommand) onSuccess(msg proto.Message) error {
 	} else {
 		if rpbGetBucketResp, ok := msg.(*rpbRiak.RpbGetBucketResp); ok {
 			rpbBucketProps := rpbGetBucketResp.GetProps()
-			response := &FetchBucketPropsResponse{
-				NVal:          rpbBucketProps.GetNVal(),
-				AllowMult:     rpbBucketProps.GetAllowMult(),
-				LastWriteWins: rpbBucketProps.GetLastWriteWins(),
-				HasPrecommit:  rpbBucketProps.GetHasPrecommit(),
-				HasPostcommit: rpbBucketProps.GetHasPostcommit(),
-				OldVClock:     rpbBucketProps.GetOldVclock(),
-				YoungVClock:   rpbBucketProps.GetYoungVclock(),
-				BigVClock:     rpbBucketProps.GetBigVclock(),
-				SmallVClock:   rpbBucketProps.GetSmallVclock(),
-				R:             rpbBucketProps.GetR(),
-				Pr:            rpbBucketProps.GetPr(),
-				W:             rpbBucketProps.GetW(),
-				Pw:            rpbBucketProps.GetPw(),
-				Dw:            rpbBucketProps.GetDw(),
-				Rw:            rpbBucketProps.GetRw(),
-				BasicQuorum:   rpbBucketProps.GetBasicQuorum(),
-				NotFoundOk:    rpbBucketProps.GetNotfoundOk(),
-				Search:        rpbBucketProps.GetSearch(),
-				Consistent:    rpbBucketProps.GetConsistent(),
-				Repl:          ReplMode(rpbBucketProps.GetRepl()),
-				Backend:       string(rpbBucketProps.GetBackend()),
-				SearchIndex:   string(rpbBucketProps.GetSearchIndex()),
-				DataType:      string(rpbBucketProps.GetDatatype()),
-			}
-
-			if rpbBucketProps.GetHasPrecommit() {
-				response.PreCommit = getHooksFrom(rpbBucketProps.Precommit)
+			if rpbBucketProps == nil {
+				return fmt.Errorf("[FetchBucketPropsCommand] RpbGetBucketResp has no props")
 			}
-			if rpbBucketProps.GetHasPostcommit() {
-				response.PostCommit = getHooksFrom(rpbBucketProps.Postcommit)
-			}
-			if rpbBucketProps.ChashKeyfun != nil {
-				response.ChashKeyFun = getFunFrom(rpbBucketProps.ChashKeyfun)
-			}
-			if rpbBucketProps.Linkfun != nil {
-				response.LinkFun = getFunFrom(rpbBucketProps.Linkfun)
-			}
-
-			cmd.Response = response
+			cmd.Response = newFetchBucketPropsResponse(rpbBucketProps)
 		} else {
 			return fmt.Errorf("[FetchBucketPropsCommand] could not convert %v to RpbGetResp", reflect.TypeOf(msg))
 		}
@@ -219,34 +380,12 @@ type ModFun struct {
 	Function string
 }
 
+// FetchBucketPropsResponse contains the properties of a bucket or bucket type. HasPrecommit and
+// HasPostcommit report whether there are any commit hooks.
 type FetchBucketPropsResponse struct {
-	NVal          uint32
-	AllowMult     bool
-	LastWriteWins bool
+	BucketProperties
 	HasPrecommit  bool
 	HasPostcommit bool
-	OldVClock     uint32
-	YoungVClock   uint32
-	BigVClock     uint32
-	SmallVClock   uint32
-	R             uint32
-	Pr            uint32
-	W             uint32
-	Pw            uint32
-	Dw            uint32
-	Rw            uint32
-	BasicQuorum   bool
-	NotFoundOk    bool
-	Search        bool
-	Consistent    bool
-	Repl          ReplMode
-	Backend       string
-	SearchIndex   string
-	DataType      string
-	PreCommit     []*CommitHook
-	PostCommit    []*CommitHook
-	ChashKeyFun   *ModFun
-	LinkFun       *ModFun
 }
 
 type FetchBucketPropsCommandBuilder struct {
@@ -282,6 +421,15 @@ func (builder *FetchBucketPropsCommandBuilder) Build() (Command, error) {
 	return &FetchBucketPropsCommand{protobuf: builder.protobuf}, nil
 }
 
+// newFetchBucketPropsResponse maps the properties of a bucket or bucket type
+func newFetchBucketPropsResponse(rpbBucketProps *rpbRiak.RpbBucketProps) *FetchBucketPropsResponse {
+	return &FetchBucketPropsResponse{
+		BucketProperties: newBucketProperties(rpbBucketProps),
+		HasPrecommit:     rpbBucketProps.GetHasPrecommit(),
+		HasPostcommit:    rpbBucketProps.GetHasPostcommit(),
+	}
+}
+
 func getFunFrom(rpbModFun *rpbRiak.RpbModFun) *ModFun {
 	var modFun *ModFun
 	if rpbModFun == nil {
@@ -346,8 +494,8 @@ func (cmd *StoreBucketPropsCommand) getResponseProtobufMessage() proto.Message {
 }
 
 type StoreBucketPropsCommandBuilder struct {
+	bucketPropsBuilder
 	protobuf *rpbRiak.RpbSetBucketReq
-	props    *rpbRiak.RpbBucketProps
 }
 
 // NewStoreBucketPropsCommandBuilder is a factory function for generating the command builder struct
@@ -356,7 +504,7 @@ func NewStoreBucketPropsCommandBuilder() *StoreBucketPropsCommandBuilder {
 	protobuf := &rpbRiak.RpbSetBucketReq{
 		Props: props,
 	}
-	builder := &StoreBucketPropsCommandBuilder{protobuf: protobuf, props: props}
+	builder := &StoreBucketPropsCommandBuilder{bucketPropsBuilder: bucketPropsBuilder{props: props}, protobuf: protobuf}
 	return builder
 }
 
@@ -373,138 +521,176 @@ func (builder *StoreBucketPropsCommandBuilder) WithBucket(bucket string) *StoreB
 }
 
 // WithNVal sets the number of times this command operation is replicated in the Cluster. If
-// ommitted, the ring default is used.
+// omitted, the ring default is used.
 //
 // See http://basho.com/posts/technical/riaks-config-behaviors-part-2/
 func (builder *StoreBucketPropsCommandBuilder) WithNVal(nval uint32) *StoreBucketPropsCommandBuilder {
-	builder.props.NVal = &nval
+	builder.setNVal(nval)
 	return builder
 }
 
 func (builder *StoreBucketPropsCommandBuilder) WithAllowMult(allowMult bool) *StoreBucketPropsCommandBuilder {
-	builder.props.AllowMult = &allowMult
+	builder.setAllowMult(allowMult)
 	return builder
 }
 
 func (builder *StoreBucketPropsCommandBuilder) WithLastWriteWins(lww bool) *StoreBucketPropsCommandBuilder {
-	builder.props.LastWriteWins = &lww
+	builder.setLastWriteWins(lww)
 	return builder
 }
 
 func (builder *StoreBucketPropsCommandBuilder) WithOldVClock(oldVClock uint32) *StoreBucketPropsCommandBuilder {
-	builder.props.OldVclock = &oldVClock
+	builder.setOldVClock(oldVClock)
 	return builder
 }
 
 func (builder *StoreBucketPropsCommandBuilder) WithYoungVClock(youngVClock uint32) *StoreBucketPropsCommandBuilder {
-	builder.props.YoungVclock = &youngVClock
+	builder.setYoungVClock(youngVClock)
 	return builder
 }
 
 func (builder *StoreBucketPropsCommandBuilder) WithBigVClock(bigVClock uint32) *StoreBucketPropsCommandBuilder {
-	builder.props.BigVclock = &bigVClock
+	builder.setBigVClock(bigVClock)
 	return builder
 }
 
 func (builder *StoreBucketPropsCommandBuilder) WithSmallVClock(smallVClock uint32) *StoreBucketPropsCommandBuilder {
-	builder.props.SmallVclock = &smallVClock
+	builder.setSmallVClock(smallVClock)
 	return builder
 }
 
 // WithR sets the number of nodes that must report back a successful read in order for the
-// command operation to be considered a success by Riak. If ommitted, the bucket default is used.
+// command operation to be considered a success by Riak. If omitted, the bucket default is used.
 //
 // See http://basho.com/posts/technical/riaks-config-behaviors-part-2/
 func (builder *StoreBucketPropsCommandBuilder) WithR(r uint32) *StoreBucketPropsCommandBuilder {
-	builder.props.R = &r
+	builder.setR(r)
 	return builder
 }
 
 // WithPr sets the number of primary nodes (N) that must be read from in order for the command
-// operation to be considered a success by Riak. If ommitted, the bucket default is used.
+// operation to be considered a success by Riak. If omitted, the bucket default is used.
 //
 // See http://basho.com/posts/technical/riaks-config-behaviors-part-2/
 func (builder *StoreBucketPropsCommandBuilder) WithPr(pr uint32) *StoreBucketPropsCommandBuilder {
-	builder.props.Pr = &pr
+	builder.setPr(pr)
 	return builder
 }
 
 // WithW sets the number of nodes that must report back a successful write in order for the
-// command operation to be considered a success by Riak. If ommitted, the bucket default is used.
+// command operation to be considered a success by Riak. If omitted, the bucket default is used.
 //
 // See http://basho.com/posts/technical/riaks-config-behaviors-part-2/
 func (builder *StoreBucketPropsCommandBuilder) WithW(w uint32) *StoreBucketPropsCommandBuilder {
-	builder.props.W = &w
+	builder.setW(w)
 	return builder
 }
 
 // WithPw sets the number of primary nodes (N) that must report back a successful write in order for
-// the command operation to be considered a success by Riak. If ommitted, the bucket default is
+// the command operation to be considered a success by Riak. If omitted, the bucket default is
 // used.
 //
 // See http://basho.com/posts/technical/riaks-config-behaviors-part-2/
 func (builder *StoreBucketPropsCommandBuilder) WithPw(pw uint32) *StoreBucketPropsCommandBuilder {
-	builder.props.Pw = &pw
+	builder.setPw(pw)
 	return builder
 }
 
 // WithDw (durable writes) sets the number of nodes that must report back a successful write to
 // backend storage in order for the command operation to be considered a success by Riak. If
-// ommitted, the bucket default is used.
+// omitted, the bucket default is used.
 //
 // See http://basho.com/posts/technical/riaks-config-behaviors-part-2/
 func (builder *StoreBucketPropsCommandBuilder) WithDw(dw uint32) *StoreBucketPropsCommandBuilder {
-	builder.props.Dw = &dw
+	builder.setDw(dw)
 	return builder
 }
 
 func (builder *StoreBucketPropsCommandBuilder) WithRw(rw uint32) *StoreBucketPropsCommandBuilder {
-	builder.props.Rw = &rw
+	builder.setRw(rw)
 	return builder
 }
 
 func (builder *StoreBucketPropsCommandBuilder) WithBasicQuorum(basicQuorum bool) *StoreBucketPropsCommandBuilder {
-	builder.props.BasicQuorum = &basicQuorum
+	builder.setBasicQuorum(basicQuorum)
 	return builder
 }
 
 func (builder *StoreBucketPropsCommandBuilder) WithNotFoundOk(notFoundOk bool) *StoreBucketPropsCommandBuilder {
-	builder.props.NotfoundOk = &notFoundOk
+	builder.setNotFoundOk(notFoundOk)
 	return builder
 }
 
 func (builder *StoreBucketPropsCommandBuilder) WithSearch(search bool) *StoreBucketPropsCommandBuilder {
-	builder.props.Search = &search
+	builder.setSearch(search)
 	return builder
 }
 
 func (builder *StoreBucketPropsCommandBuilder) WithBackend(backend string) *StoreBucketPropsCommandBuilder {
-	builder.props.Backend = []byte(backend)
+	builder.setBackend(backend)
 	return builder
 }
 
 func (builder *StoreBucketPropsCommandBuilder) WithSearchIndex(searchIndex string) *StoreBucketPropsCommandBuilder {
-	builder.props.SearchIndex = []byte(searchIndex)
+	builder.setSearchIndex(searchIndex)
 	return builder
 }
 
 func (builder *StoreBucketPropsCommandBuilder) AddPreCommit(commitHook *CommitHook) *StoreBucketPropsCommandBuilder {
-	rpbCommitHook := toRpbCommitHook(commitHook)
-	builder.props.Precommit = addCommitHookTo(builder.props.Precommit, rpbCommitHook)
+	builder.addPreCommit(commitHook)
 	return builder
 }
 
 func (builder *StoreBucketPropsCommandBuilder) AddPostCommit(commitHook *CommitHook) *StoreBucketPropsCommandBuilder {
-	rpbCommitHook := toRpbCommitHook(commitHook)
-	builder.props.Postcommit = addCommitHookTo(builder.props.Postcommit, rpbCommitHook)
+	builder.addPostCommit(commitHook)
 	return builder
 }
 
 func (builder *StoreBucketPropsCommandBuilder) WithChashKeyFun(val *ModFun) *StoreBucketPropsCommandBuilder {
-	builder.props.ChashKeyfun = &rpbRiak.RpbModFun{
-		Module:   []byte(val.Module),
-		Function: []byte(val.Function),
-	}
+	builder.setChashKeyFun(val)
+	return builder
+}
+
+// WithConsistent sets whether the bucket type is strongly consistent. It can only be set when
+// the bucket type is created.
+func (builder *StoreBucketPropsCommandBuilder) WithConsistent(consistent bool) *StoreBucketPropsCommandBuilder {
+	builder.setConsistent(consistent)
+	return builder
+}
+
+// WithWriteOnce sets whether the bucket type is write once, i.e. values are never updated. It can
+// only be set when the bucket type is created.
+func (builder *StoreBucketPropsCommandBuilder) WithWriteOnce(writeOnce bool) *StoreBucketPropsCommandBuilder {
+	builder.setWriteOnce(writeOnce)
+	return builder
+}
+
+// WithDataType sets the Riak data type of the bucket type, one of "counter", "set" or "map". It
+// can only be set when the bucket type is created.
+func (builder *StoreBucketPropsCommandBuilder) WithDataType(dataType string) *StoreBucketPropsCommandBuilder {
+	builder.setDataType(dataType)
+	return builder
+}
+
+// WithRepl sets the Riak Enterprise replication mode
+func (builder *StoreBucketPropsCommandBuilder) WithRepl(repl ReplMode) *StoreBucketPropsCommandBuilder {
+	builder.setRepl(repl)
+	return builder
+}
+
+func (builder *StoreBucketPropsCommandBuilder) WithLinkFun(val *ModFun) *StoreBucketPropsCommandBuilder {
+	builder.setLinkFun(val)
+	return builder
+}
+
+// WithProperties sets every property to its value in props, e.g. as fetched and then modified.
+// Commit hooks are replaced. Setters called after WithProperties override its values.
+//
+// Zero values are sent too, so props should be fetched rather than partly filled in: the
+// properties of &BucketProperties{AllowMult: true} include an n_val of 0 and quorums of 0. Use the
+// other setters to change only some properties.
+func (builder *StoreBucketPropsCommandBuilder) WithProperties(props *BucketProperties) *StoreBucketPropsCommandBuilder {
+	builder.setProperties(props)
 	return builder
 }
 
@@ -524,14 +710,504 @@ func addCommitHookTo(rpbHooks []*rpbRiak.RpbCommitHook, rpbCommitHook *rpbRiak.R
 }
 
 func toRpbCommitHook(commitHook *CommitHook) *rpbRiak.RpbCommitHook {
-	rpbCommitHook := &rpbRiak.RpbCommitHook{
-		Name: []byte(commitHook.Name),
+	return &rpbRiak.RpbCommitHook{
+		Name:   []byte(commitHook.Name),
+		Modfun: toRpbModFun(commitHook.ModFun),
 	}
-	if commitHook.ModFun != nil {
-		rpbCommitHook.Modfun = &rpbRiak.RpbModFun{
-			Module:   []byte(commitHook.ModFun.Module),
-			Function: []byte(commitHook.ModFun.Function),
+}
+
+// bucketPropsBuilder sets the properties of the StoreBucketProps and StoreBucketTypeProps
+// command builders, whose setters delegate to it
+type bucketPropsBuilder struct {
+	props *rpbRiak.RpbBucketProps
+}
+
+func (b bucketPropsBuilder) setNVal(nval uint32) {
+	b.props.NVal = &nval
+}
+
+func (b bucketPropsBuilder) setAllowMult(allowMult bool) {
+	b.props.AllowMult = &allowMult
+}
+
+func (b bucketPropsBuilder) setLastWriteWins(lww bool) {
+	b.props.LastWriteWins = &lww
+}
+
+func (b bucketPropsBuilder) setOldVClock(oldVClock uint32) {
+	b.props.OldVclock = &oldVClock
+}
+
+func (b bucketPropsBuilder) setYoungVClock(youngVClock uint32) {
+	b.props.YoungVclock = &youngVClock
+}
+
+func (b bucketPropsBuilder) setBigVClock(bigVClock uint32) {
+	b.props.BigVclock = &bigVClock
+}
+
+func (b bucketPropsBuilder) setSmallVClock(smallVClock uint32) {
+	b.props.SmallVclock = &smallVClock
+}
+
+func (b bucketPropsBuilder) setR(r uint32) {
+	b.props.R = &r
+}
+
+func (b bucketPropsBuilder) setPr(pr uint32) {
+	b.props.Pr = &pr
+}
+
+func (b bucketPropsBuilder) setW(w uint32) {
+	b.props.W = &w
+}
+
+func (b bucketPropsBuilder) setPw(pw uint32) {
+	b.props.Pw = &pw
+}
+
+func (b bucketPropsBuilder) setDw(dw uint32) {
+	b.props.Dw = &dw
+}
+
+func (b bucketPropsBuilder) setRw(rw uint32) {
+	b.props.Rw = &rw
+}
+
+func (b bucketPropsBuilder) setBasicQuorum(basicQuorum bool) {
+	b.props.BasicQuorum = &basicQuorum
+}
+
+func (b bucketPropsBuilder) setNotFoundOk(notFoundOk bool) {
+	b.props.NotfoundOk = &notFoundOk
+}
+
+func (b bucketPropsBuilder) setSearch(search bool) {
+	b.props.Search = &search
+}
+
+func (b bucketPropsBuilder) setBackend(backend string) {
+	b.props.Backend = []byte(backend)
+}
+
+func (b bucketPropsBuilder) setSearchIndex(searchIndex string) {
+	b.props.SearchIndex = []byte(searchIndex)
+}
+
+func (b bucketPropsBuilder) addPreCommit(commitHook *CommitHook) {
+	b.props.Precommit = addCommitHookTo(b.props.Precommit, toRpbCommitHook(commitHook))
+}
+
+func (b bucketPropsBuilder) addPostCommit(commitHook *CommitHook) {
+	b.props.Postcommit = addCommitHookTo(b.props.Postcommit, toRpbCommitHook(commitHook))
+}
+
+func (b bucketPropsBuilder) setChashKeyFun(val *ModFun) {
+	b.props.ChashKeyfun = toRpbModFun(val)
+}
+
+func (b bucketPropsBuilder) setConsistent(consistent bool) {
+	b.props.Consistent = &consistent
+}
+
+func (b bucketPropsBuilder) setWriteOnce(writeOnce bool) {
+	b.props.WriteOnce = &writeOnce
+}
+
+func (b bucketPropsBuilder) setDataType(dataType string) {
+	b.props.Datatype = []byte(dataType)
+}
+
+func (b bucketPropsBuilder) setRepl(repl ReplMode) {
+	rpbRepl := rpbRiak.RpbBucketProps_RpbReplMode(repl)
+	b.props.Repl = &rpbRepl
+}
+
+func (b bucketPropsBuilder) setLinkFun(val *ModFun) {
+	b.props.Linkfun = toRpbModFun(val)
+}
+
+func (b bucketPropsBuilder) setProperties(props *BucketProperties) {
+	props.setRpbBucketProps(b.props)
+}
+
+// FetchBucketTypeProps
+// RpbGetBucketTypeReq
+// RpbGetBucketResp
+
+// FetchBucketTypePropsCommand fetches the properties of a bucket type, which apply to every bucket
+// of that type that does not override them
+type FetchBucketTypePropsCommand struct {
+	CommandImpl
+	Response *FetchBucketPropsResponse
+	protobuf *rpbRiak.RpbGetBucketTypeReq
+}
+
+// Name identifies this command
+func (cmd *FetchBucketTypePropsCommand) Name() string {
+	return "FetchBucketTypeProps"
+}
+
+func (cmd *FetchBucketTypePropsCommand) minimumVersion() Version {
+	return version2_0
+}
+
+func (cmd *FetchBucketTypePropsCommand) constructPbRequest() (proto.Message, error) {
+	return cmd.protobuf, nil
+}
+
+func (cmd *FetchBucketTypePropsCommand) onSuccess(msg proto.Message) error {
+	cmd.Success = true
+	if msg == nil {
+		cmd.Success = false
+	} else {
+		if rpbGetBucketResp, ok := msg.(*rpbRiak.RpbGetBucketResp); ok {
+			rpbBucketProps := rpbGetBucketResp.GetProps()
+			if rpbBucketProps == nil {
+				return fmt.Errorf("[FetchBucketTypePropsCommand] RpbGetBucketResp has no props")
+			}
+			cmd.Response = newFetchBucketPropsResponse(rpbBucketProps)
+		} else {
+			return fmt.Errorf("[FetchBucketTypePropsCommand] could not convert %v to RpbGetBucketResp", reflect.TypeOf(msg))
 		}
 	}
-	return rpbCommitHook
+	return nil
+}
+
+func (cmd *FetchBucketTypePropsCommand) getRequestCode() byte {
+	return rpbCode_RpbGetBucketTypeReq
+}
+
+func (cmd *FetchBucketTypePropsCommand) getResponseCode() byte {
+	return rpbCode_RpbGetBucketResp
+}
+
+func (cmd *FetchBucketTypePropsCommand) getResponseProtobufMessage() proto.Message {
+	return &rpbRiak.RpbGetBucketResp{}
+}
+
+// FetchBucketTypePropsCommandBuilder type is required for creating new instances of
+// FetchBucketTypePropsCommand
+//
+//    command, err := NewFetchBucketTypePropsCommandBuilder().
+//        WithBucketType("myBucketType").
+//        Build()
+type FetchBucketTypePropsCommandBuilder struct {
+	protobuf *rpbRiak.RpbGetBucketTypeReq
+}
+
+// NewFetchBucketTypePropsCommandBuilder is a factory function for generating the command builder struct
+func NewFetchBucketTypePropsCommandBuilder() *FetchBucketTypePropsCommandBuilder {
+	builder := &FetchBucketTypePropsCommandBuilder{protobuf: &rpbRiak.RpbGetBucketTypeReq{}}
+	return builder
+}
+
+// WithBucketType sets the bucket-type to be used by the command
+func (builder *FetchBucketTypePropsCommandBuilder) WithBucketType(bucketType string) *FetchBucketTypePropsCommandBuilder {
+	builder.protobuf.Type = []byte(bucketType)
+	return builder
+}
+
+// Build validates the configuration options provided then builds the command
+func (builder *FetchBucketTypePropsCommandBuilder) Build() (Command, error) {
+	if builder.protobuf == nil {
+		panic("builder.protobuf must not be nil")
+	}
+	if len(builder.protobuf.Type) == 0 {
+		return nil, ErrBucketTypeRequired
+	}
+	return &FetchBucketTypePropsCommand{protobuf: builder.protobuf}, nil
+}
+
+// StoreBucketTypeProps
+// RpbSetBucketTypeReq
+// RpbSetBucketResp
+
+// StoreBucketTypePropsCommand sets properties of an existing bucket type. Properties that are not
+// set keep their current value.
+type StoreBucketTypePropsCommand struct {
+	CommandImpl
+	protobuf *rpbRiak.RpbSetBucketTypeReq
+}
+
+// Name identifies this command
+func (cmd *StoreBucketTypePropsCommand) Name() string {
+	return "StoreBucketTypeProps"
+}
+
+func (cmd *StoreBucketTypePropsCommand) minimumVersion() Version {
+	return version2_0
+}
+
+func (cmd *StoreBucketTypePropsCommand) constructPbRequest() (proto.Message, error) {
+	return cmd.protobuf, nil
+}
+
+func (cmd *StoreBucketTypePropsCommand) onSuccess(msg proto.Message) error {
+	cmd.Success = true
+	return nil
+}
+
+func (cmd *StoreBucketTypePropsCommand) getRequestCode() byte {
+	return rpbCode_RpbSetBucketTypeReq
+}
+
+func (cmd *StoreBucketTypePropsCommand) getResponseCode() byte {
+	return rpbCode_RpbSetBucketResp
+}
+
+func (cmd *StoreBucketTypePropsCommand) getResponseProtobufMessage() proto.Message {
+	return nil
+}
+
+// StoreBucketTypePropsCommandBuilder type is required for creating new instances of
+// StoreBucketTypePropsCommand. It has the same property setters as StoreBucketPropsCommandBuilder.
+//
+//    command, err := NewStoreBucketTypePropsCommandBuilder().
+//        WithBucketType("myBucketType").
+//        WithNVal(5).
+//        Build()
+type StoreBucketTypePropsCommandBuilder struct {
+	bucketPropsBuilder
+	protobuf *rpbRiak.RpbSetBucketTypeReq
+}
+
+// NewStoreBucketTypePropsCommandBuilder is a factory function for generating the command builder struct
+func NewStoreBucketTypePropsCommandBuilder() *StoreBucketTypePropsCommandBuilder {
+	props := &rpbRiak.RpbBucketProps{}
+	protobuf := &rpbRiak.RpbSetBucketTypeReq{
+		Props: props,
+	}
+	builder := &StoreBucketTypePropsCommandBuilder{bucketPropsBuilder: bucketPropsBuilder{props: props}, protobuf: protobuf}
+	return builder
+}
+
+// WithBucketType sets the bucket-type to be used by the command
+func (builder *StoreBucketTypePropsCommandBuilder) WithBucketType(bucketType string) *StoreBucketTypePropsCommandBuilder {
+	builder.protobuf.Type = []byte(bucketType)
+	return builder
+}
+
+func (builder *StoreBucketTypePropsCommandBuilder) WithNVal(nval uint32) *StoreBucketTypePropsCommandBuilder {
+	builder.setNVal(nval)
+	return builder
+}
+
+func (builder *StoreBucketTypePropsCommandBuilder) WithAllowMult(allowMult bool) *StoreBucketTypePropsCommandBuilder {
+	builder.setAllowMult(allowMult)
+	return builder
+}
+
+func (builder *StoreBucketTypePropsCommandBuilder) WithLastWriteWins(lww bool) *StoreBucketTypePropsCommandBuilder {
+	builder.setLastWriteWins(lww)
+	return builder
+}
+
+func (builder *StoreBucketTypePropsCommandBuilder) WithOldVClock(oldVClock uint32) *StoreBucketTypePropsCommandBuilder {
+	builder.setOldVClock(oldVClock)
+	return builder
+}
+
+func (builder *StoreBucketTypePropsCommandBuilder) WithYoungVClock(youngVClock uint32) *StoreBucketTypePropsCommandBuilder {
+	builder.setYoungVClock(youngVClock)
+	return builder
+}
+
+func (builder *StoreBucketTypePropsCommandBuilder) WithBigVClock(bigVClock uint32) *StoreBucketTypePropsCommandBuilder {
+	builder.setBigVClock(bigVClock)
+	return builder
+}
+
+func (builder *StoreBucketTypePropsCommandBuilder) WithSmallVClock(smallVClock uint32) *StoreBucketTypePropsCommandBuilder {
+	builder.setSmallVClock(smallVClock)
+	return builder
+}
+
+func (builder *StoreBucketTypePropsCommandBuilder) WithR(r uint32) *StoreBucketTypePropsCommandBuilder {
+	builder.setR(r)
+	return builder
+}
+
+func (builder *StoreBucketTypePropsCommandBuilder) WithPr(pr uint32) *StoreBucketTypePropsCommandBuilder {
+	builder.setPr(pr)
+	return builder
+}
+
+func (builder *StoreBucketTypePropsCommandBuilder) WithW(w uint32) *StoreBucketTypePropsCommandBuilder {
+	builder.setW(w)
+	return builder
+}
+
+func (builder *StoreBucketTypePropsCommandBuilder) WithPw(pw uint32) *StoreBucketTypePropsCommandBuilder {
+	builder.setPw(pw)
+	return builder
+}
+
+func (builder *StoreBucketTypePropsCommandBuilder) WithDw(dw uint32) *StoreBucketTypePropsCommandBuilder {
+	builder.setDw(dw)
+	return builder
+}
+
+func (builder *StoreBucketTypePropsCommandBuilder) WithRw(rw uint32) *StoreBucketTypePropsCommandBuilder {
+	builder.setRw(rw)
+	return builder
+}
+
+func (builder *StoreBucketTypePropsCommandBuilder) WithBasicQuorum(basicQuorum bool) *StoreBucketTypePropsCommandBuilder {
+	builder.setBasicQuorum(basicQuorum)
+	return builder
+}
+
+func (builder *StoreBucketTypePropsCommandBuilder) WithNotFoundOk(notFoundOk bool) *StoreBucketTypePropsCommandBuilder {
+	builder.setNotFoundOk(notFoundOk)
+	return builder
+}
+
+func (builder *StoreBucketTypePropsCommandBuilder) WithSearch(search bool) *StoreBucketTypePropsCommandBuilder {
+	builder.setSearch(search)
+	return builder
+}
+
+func (builder *StoreBucketTypePropsCommandBuilder) WithBackend(backend string) *StoreBucketTypePropsCommandBuilder {
+	builder.setBackend(backend)
+	return builder
+}
+
+func (builder *StoreBucketTypePropsCommandBuilder) WithSearchIndex(searchIndex string) *StoreBucketTypePropsCommandBuilder {
+	builder.setSearchIndex(searchIndex)
+	return builder
+}
+
+func (builder *StoreBucketTypePropsCommandBuilder) AddPreCommit(commitHook *CommitHook) *StoreBucketTypePropsCommandBuilder {
+	builder.addPreCommit(commitHook)
+	return builder
+}
+
+func (builder *StoreBucketTypePropsCommandBuilder) AddPostCommit(commitHook *CommitHook) *StoreBucketTypePropsCommandBuilder {
+	builder.addPostCommit(commitHook)
+	return builder
+}
+
+func (builder *StoreBucketTypePropsCommandBuilder) WithChashKeyFun(val *ModFun) *StoreBucketTypePropsCommandBuilder {
+	builder.setChashKeyFun(val)
+	return builder
+}
+
+func (builder *StoreBucketTypePropsCommandBuilder) WithConsistent(consistent bool) *StoreBucketTypePropsCommandBuilder {
+	builder.setConsistent(consistent)
+	return builder
+}
+
+func (builder *StoreBucketTypePropsCommandBuilder) WithWriteOnce(writeOnce bool) *StoreBucketTypePropsCommandBuilder {
+	builder.setWriteOnce(writeOnce)
+	return builder
+}
+
+func (builder *StoreBucketTypePropsCommandBuilder) WithDataType(dataType string) *StoreBucketTypePropsCommandBuilder {
+	builder.setDataType(dataType)
+	return builder
+}
+
+func (builder *StoreBucketTypePropsCommandBuilder) WithRepl(repl ReplMode) *StoreBucketTypePropsCommandBuilder {
+	builder.setRepl(repl)
+	return builder
+}
+
+func (builder *StoreBucketTypePropsCommandBuilder) WithLinkFun(val *ModFun) *StoreBucketTypePropsCommandBuilder {
+	builder.setLinkFun(val)
+	return builder
+}
+
+// WithProperties sets every property to its value in props, see StoreBucketPropsCommandBuilder.WithProperties
+func (builder *StoreBucketTypePropsCommandBuilder) WithProperties(props *BucketProperties) *StoreBucketTypePropsCommandBuilder {
+	builder.setProperties(props)
+	return builder
+}
+
+// Build validates the configuration options provided then builds the command
+func (builder *StoreBucketTypePropsCommandBuilder) Build() (Command, error) {
+	if builder.protobuf == nil {
+		panic("builder.protobuf must not be nil")
+	}
+	if len(builder.protobuf.Type) == 0 {
+		return nil, ErrBucketTypeRequired
+	}
+	return &StoreBucketTypePropsCommand{protobuf: builder.protobuf}, nil
+}
+
+// ResetBucketProps
+// RpbResetBucketReq
+// RpbResetBucketResp
+
+// ResetBucketPropsCommand removes the properties set on a bucket, so that it reverts to the
+// properties of its bucket type
+type ResetBucketPropsCommand struct {
+	CommandImpl
+	protobuf *rpbRiak.RpbResetBucketReq
+}
+
+// Name identifies this command
+func (cmd *ResetBucketPropsCommand) Name() string {
+	return "ResetBucketProps"
+}
+
+func (cmd *ResetBucketPropsCommand) constructPbRequest() (proto.Message, error) {
+	return cmd.protobuf, nil
+}
+
+func (cmd *ResetBucketPropsCommand) onSuccess(msg proto.Message) error {
+	cmd.Success = true
+	return nil
+}
+
+func (cmd *ResetBucketPropsCommand) getRequestCode() byte {
+	return rpbCode_RpbResetBucketReq
+}
+
+func (cmd *ResetBucketPropsCommand) getResponseCode() byte {
+	return rpbCode_RpbResetBucketResp
+}
+
+func (cmd *ResetBucketPropsCommand) getResponseProtobufMessage() proto.Message {
+	return nil
+}
+
+// ResetBucketPropsCommandBuilder type is required for creating new instances of
+// ResetBucketPropsCommand
+//
+//    command, err := NewResetBucketPropsCommandBuilder().
+//        WithBucketType("myBucketType").
+//        WithBucket("myBucket").
+//        Build()
+type ResetBucketPropsCommandBuilder struct {
+	protobuf *rpbRiak.RpbResetBucketReq
+}
+
+// NewResetBucketPropsCommandBuilder is a factory function for generating the command builder struct
+func NewResetBucketPropsCommandBuilder() *ResetBucketPropsCommandBuilder {
+	builder := &ResetBucketPropsCommandBuilder{protobuf: &rpbRiak.RpbResetBucketReq{}}
+	return builder
+}
+
+// WithBucketType sets the bucket-type to be used by the command. If omitted, 'default' is used
+func (builder *ResetBucketPropsCommandBuilder) WithBucketType(bucketType string) *ResetBucketPropsCommandBuilder {
+	builder.protobuf.Type = []byte(bucketType)
+	return builder
+}
+
+// WithBucket sets the bucket to be used by the command
+func (builder *ResetBucketPropsCommandBuilder) WithBucket(bucket string) *ResetBucketPropsCommandBuilder {
+	builder.protobuf.Bucket = []byte(bucket)
+	return builder
+}
+
+// Build validates the configuration options provided then builds the command
+func (builder *ResetBucketPropsCommandBuilder) Build() (Command, error) {
+	if builder.protobuf == nil {
+		panic("builder.protobuf must not be nil")
+	}
+	if err := validateLocatable(builder.protobuf); err != nil {
+		return nil, err
+	}
+	return &ResetBucketPropsCommand{protobuf: builder.protobuf}, nil
 }
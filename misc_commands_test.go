@@ -5,8 +5,50 @@ import (
 	"testing"
 
 	rpbRiak "github.com/basho/riak-go-client/rpb/riak"
+	rpbRiakKV "github.com/basho/riak-go-client/rpb/riak_kv"
 )
 
+// FetchClientId
+
+func TestParseRpbGetClientIdRespCorrectly(t *testing.T) {
+	cmd, err := NewFetchClientIdCommandBuilder().Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := cmd.onSuccess(&rpbRiakKV.RpbGetClientIdResp{ClientId: []byte("client-1")}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := "client-1", cmd.(*FetchClientIdCommand).Response; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+// StoreClientId
+
+func TestBuildRpbSetClientIdReqCorrectlyViaBuilder(t *testing.T) {
+	cmd, err := NewStoreClientIdCommandBuilder().
+		WithClientId("client-1").
+		Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	protobuf, err := cmd.constructPbRequest()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if req, ok := protobuf.(*rpbRiakKV.RpbSetClientIdReq); ok {
+		if expected, actual := "client-1", string(req.GetClientId()); expected != actual {
+			t.Errorf("expected %v, got %v", expected, actual)
+		}
+	} else {
+		t.Errorf("ok: %v - could not convert %v to *rpbRiakKV.RpbSetClientIdReq", ok, reflect.TypeOf(protobuf))
+	}
+
+	if _, err := NewStoreClientIdCommandBuilder().Build(); err == nil {
+		t.Error("expected error")
+	}
+}
+
 // FetchBucketProps
 
 func TestBuildRpbGetBucketReqCorrectlyViaBuilder(t *testing.T) {
@@ -382,3 +424,101 @@ func TestParseRpbStoreBucketRespCorrectly(t *testing.T) {
 		t.Errorf("ok: %v - could not convert %v to *StoreBucketPropsCommand", ok, reflect.TypeOf(cmd))
 	}
 }
+
+// FetchBucketTypeProps
+
+func TestBuildRpbGetBucketTypeReqCorrectlyViaBuilder(t *testing.T) {
+	cmd, err := NewFetchBucketTypePropsCommandBuilder().
+		WithBucketType("bucket_type").
+		Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	protobuf, err := cmd.constructPbRequest()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if req, ok := protobuf.(*rpbRiak.RpbGetBucketTypeReq); ok {
+		if expected, actual := "bucket_type", string(req.GetType()); expected != actual {
+			t.Errorf("expected %v, got %v", expected, actual)
+		}
+	} else {
+		t.Errorf("ok: %v - could not convert %v to *rpbRiak.RpbGetBucketTypeReq", ok, reflect.TypeOf(protobuf))
+	}
+
+	if _, err := NewFetchBucketTypePropsCommandBuilder().Build(); err != ErrBucketTypeRequired {
+		t.Errorf("expected %v, got %v", ErrBucketTypeRequired, err)
+	}
+}
+
+// StoreBucketTypeProps
+
+func TestBuildRpbSetBucketTypeReqCorrectlyViaBuilder(t *testing.T) {
+	cmd, err := NewStoreBucketTypePropsCommandBuilder().
+		WithBucketType("bucket_type").
+		WithNVal(5).
+		WithAllowMult(true).
+		WithChashKeyFun(nil).
+		AddPreCommit(&CommitHook{Name: "hook"}).
+		Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	protobuf, err := cmd.constructPbRequest()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if req, ok := protobuf.(*rpbRiak.RpbSetBucketTypeReq); ok {
+		if expected, actual := "bucket_type", string(req.GetType()); expected != actual {
+			t.Errorf("expected %v, got %v", expected, actual)
+		}
+		props := req.GetProps()
+		if expected, actual := uint32(5), props.GetNVal(); expected != actual {
+			t.Errorf("expected %v, got %v", expected, actual)
+		}
+		if expected, actual := true, props.GetAllowMult(); expected != actual {
+			t.Errorf("expected %v, got %v", expected, actual)
+		}
+		if expected, actual := 1, len(props.GetPrecommit()); expected != actual {
+			t.Errorf("expected %v, got %v", expected, actual)
+		}
+		if props.LastWriteWins != nil || props.ChashKeyfun != nil {
+			t.Error("expected unset props to be nil")
+		}
+	} else {
+		t.Errorf("ok: %v - could not convert %v to *rpbRiak.RpbSetBucketTypeReq", ok, reflect.TypeOf(protobuf))
+	}
+
+	if _, err := NewStoreBucketTypePropsCommandBuilder().WithNVal(5).Build(); err != ErrBucketTypeRequired {
+		t.Errorf("expected %v, got %v", ErrBucketTypeRequired, err)
+	}
+}
+
+// ResetBucketProps
+
+func TestBuildRpbResetBucketReqCorrectlyViaBuilder(t *testing.T) {
+	cmd, err := NewResetBucketPropsCommandBuilder().
+		WithBucket("bucket_name").
+		Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	protobuf, err := cmd.constructPbRequest()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if req, ok := protobuf.(*rpbRiak.RpbResetBucketReq); ok {
+		if expected, actual := "default", string(req.GetType()); expected != actual {
+			t.Errorf("expected %v, got %v", expected, actual)
+		}
+		if expected, actual := "bucket_name", string(req.GetBucket()); expected != actual {
+			t.Errorf("expected %v, got %v", expected, actual)
+		}
+	} else {
+		t.Errorf("ok: %v - could not convert %v to *rpbRiak.RpbResetBucketReq", ok, reflect.TypeOf(protobuf))
+	}
+
+	if _, err := NewResetBucketPropsCommandBuilder().Build(); err != ErrBucketRequired {
+		t.Errorf("expected %v, got %v", ErrBucketRequired, err)
+	}
+}
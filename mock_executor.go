@@ -0,0 +1,179 @@
+package riak
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// MockExecutor is an Executor for unit tests of application code. It does not
+// talk to Riak: commands are answered according to expectations, and recorded
+// so that tests can assert which commands were executed.
+//
+//	mock := riak.NewMockExecutor()
+//	mock.On(&riak.FetchValueCommand{}).
+//		WithBucket("users").
+//		WithKey("alice").
+//		Respond(&riak.FetchValueResponse{IsNotFound: true})
+//
+// A MockExecutor is safe for concurrent use.
+type MockExecutor struct {
+	mtx          sync.Mutex
+	expectations []*MockExpectation
+	executed     []Command
+}
+
+// MockExpectation matches commands executed by a MockExecutor, by command type
+// and optionally bucket type, bucket and key, and defines their outcome
+type MockExpectation struct {
+	commandType reflect.Type
+	bucketType  string
+	bucket      string
+	key         string
+	response    interface{}
+	err         error
+	times       int
+	calls       int
+}
+
+// NewMockExecutor returns a MockExecutor without expectations
+func NewMockExecutor() *MockExecutor {
+	return &MockExecutor{}
+}
+
+// On adds an expectation matching commands of the same type as cmd, e.g.
+// &FetchMapCommand{}. Expectations are tried in the order they were added.
+func (m *MockExecutor) On(cmd Command) *MockExpectation {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	e := &MockExpectation{commandType: reflect.TypeOf(cmd)}
+	m.expectations = append(m.expectations, e)
+	return e
+}
+
+// WithBucketType restricts the expectation to commands on the bucket type
+func (e *MockExpectation) WithBucketType(bucketType string) *MockExpectation {
+	e.bucketType = bucketType
+	return e
+}
+
+// WithBucket restricts the expectation to commands on the bucket
+func (e *MockExpectation) WithBucket(bucket string) *MockExpectation {
+	e.bucket = bucket
+	return e
+}
+
+// WithKey restricts the expectation to commands on the key
+func (e *MockExpectation) WithKey(key string) *MockExpectation {
+	e.key = key
+	return e
+}
+
+// Respond sets the Response of matching commands, which must be of the type of
+// the command's Response field, e.g. a *FetchValueResponse for a
+// FetchValueCommand. Without a response, matching commands get an empty one.
+func (e *MockExpectation) Respond(response interface{}) *MockExpectation {
+	e.response = response
+	e.err = nil
+	return e
+}
+
+// ReturnError makes matching commands fail with err
+func (e *MockExpectation) ReturnError(err error) *MockExpectation {
+	e.err = err
+	e.response = nil
+	return e
+}
+
+// Times limits the number of commands the expectation matches. By default an
+// expectation matches any number of commands.
+func (e *MockExpectation) Times(times int) *MockExpectation {
+	e.times = times
+	return e
+}
+
+func (e *MockExpectation) matches(cmd Command) bool {
+	if reflect.TypeOf(cmd) != e.commandType {
+		return false
+	}
+	if e.times > 0 && e.calls >= e.times {
+		return false
+	}
+	if e.bucketType == "" && e.bucket == "" && e.key == "" {
+		return true
+	}
+	msg, err := cmd.constructPbRequest()
+	if err != nil {
+		return false
+	}
+	l, ok := msg.(rpbLocatable)
+	if !ok {
+		return false
+	}
+	return (e.bucketType == "" || e.bucketType == string(l.GetType())) &&
+		(e.bucket == "" || e.bucket == string(l.GetBucket())) &&
+		(e.key == "" || e.key == string(l.GetKey()))
+}
+
+// Execute records the command and applies the outcome of the first expectation
+// matching it. Commands matching no expectation fail.
+func (m *MockExecutor) Execute(cmd Command) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.executed = append(m.executed, cmd)
+
+	var e *MockExpectation
+	for _, candidate := range m.expectations {
+		if candidate.matches(cmd) {
+			e = candidate
+			break
+		}
+	}
+	if e == nil {
+		err := newClientError(fmt.Sprintf("[MockExecutor] no expectation matches %s", cmd.Name()))
+		cmd.onError(err)
+		return err
+	}
+	e.calls++
+
+	if e.err != nil {
+		cmd.onError(e.err)
+		return e.err
+	}
+	field := reflect.ValueOf(cmd).Elem().FieldByName("Response")
+	if e.response != nil {
+		value := reflect.ValueOf(e.response)
+		if !field.IsValid() || !value.Type().AssignableTo(field.Type()) {
+			err := newClientError(fmt.Sprintf("[MockExecutor] %s can not respond with %v", cmd.Name(), value.Type()))
+			cmd.onError(err)
+			return err
+		}
+		field.Set(value)
+	} else if field.IsValid() && field.Kind() == reflect.Ptr {
+		// NB: successful commands have a Response, which callers dereference
+		field.Set(reflect.New(field.Type().Elem()))
+	}
+	if c, ok := cmd.(interface {
+		impl() *CommandImpl
+	}); ok {
+		c.impl().Success = true
+	}
+	return nil
+}
+
+// Executed returns the commands executed so far, in order
+func (m *MockExecutor) Executed() []Command {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	executed := make([]Command, len(m.executed))
+	copy(executed, m.executed)
+	return executed
+}
+
+// Reset removes every expectation and recorded command
+func (m *MockExecutor) Reset() {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.expectations = nil
+	m.executed = nil
+}
@@ -0,0 +1,158 @@
+package riak
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+var _ Executor = &Cluster{}
+var _ Executor = &MockExecutor{}
+
+func buildFetchValue(t *testing.T, bucket, key string) *FetchValueCommand {
+	cmd, err := NewFetchValueCommandBuilder().
+		WithBucket(bucket).
+		WithKey(key).
+		Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return cmd.(*FetchValueCommand)
+}
+
+func TestMockExecutorRespondsToMatchingCommand(t *testing.T) {
+	mock := NewMockExecutor()
+	mock.On(&FetchValueCommand{}).
+		WithBucket("users").
+		WithKey("alice").
+		Respond(&FetchValueResponse{
+			Values: []*Object{{Value: []byte("alice")}},
+		})
+	mock.On(&FetchValueCommand{}).
+		Respond(&FetchValueResponse{IsNotFound: true})
+
+	cmd := buildFetchValue(t, "users", "alice")
+	if err := mock.Execute(cmd); err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := true, cmd.Successful(); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := "alice", string(cmd.Response.Values[0].Value); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	cmd = buildFetchValue(t, "users", "bob")
+	if err := mock.Execute(cmd); err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := true, cmd.Response.IsNotFound; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestMockExecutorRespondsWithDataTypes(t *testing.T) {
+	mock := NewMockExecutor()
+	mock.On(&FetchMapCommand{}).
+		WithBucketType("maps").
+		Respond(&FetchMapResponse{
+			Map: &Map{Counters: map[string]int64{"visits": 3}},
+		})
+	cmd, err := NewFetchMapCommandBuilder().
+		WithBucketType("maps").
+		WithBucket("users").
+		WithKey("alice").
+		Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := mock.Execute(cmd); err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := int64(3), cmd.(*FetchMapCommand).Response.Map.Counters["visits"]; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestMockExecutorReturnsError(t *testing.T) {
+	mock := NewMockExecutor()
+	overload := errors.New("overload")
+	mock.On(&FetchValueCommand{}).ReturnError(overload).Times(1)
+	mock.On(&FetchValueCommand{}).Respond(&FetchValueResponse{IsNotFound: true})
+
+	cmd := buildFetchValue(t, "users", "alice")
+	if expected, actual := overload, mock.Execute(cmd); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := false, cmd.Successful(); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := overload, cmd.Error; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	cmd = buildFetchValue(t, "users", "alice")
+	if err := mock.Execute(cmd); err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+func TestMockExecutorFailsUnmatchedCommand(t *testing.T) {
+	mock := NewMockExecutor()
+	mock.On(&StoreValueCommand{})
+	if err := mock.Execute(buildFetchValue(t, "users", "alice")); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestMockExecutorFailsOnWrongResponseType(t *testing.T) {
+	mock := NewMockExecutor()
+	mock.On(&FetchValueCommand{}).Respond(&StoreValueResponse{})
+	if err := mock.Execute(buildFetchValue(t, "users", "alice")); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestMockExecutorRespondsWithEmptyResponse(t *testing.T) {
+	mock := NewMockExecutor()
+	mock.On(&FetchValueCommand{})
+	cmd := buildFetchValue(t, "users", "alice")
+	if err := mock.Execute(cmd); err != nil {
+		t.Fatal(err.Error())
+	}
+	if cmd.Response == nil {
+		t.Error("expected an empty response")
+	}
+
+	mock.On(&ListKeysCommand{})
+	keys, err := NewBucket(mock, "default", "users", nil).ListKeys(context.Background())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := 0, len(keys); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestMockExecutorRecordsExecutedCommands(t *testing.T) {
+	mock := NewMockExecutor()
+	mock.On(&FetchValueCommand{})
+	mock.On(&PingCommand{})
+	first := buildFetchValue(t, "users", "alice")
+	mock.Execute(first)
+	mock.Execute(&PingCommand{})
+	executed := mock.Executed()
+	if expected, actual := 2, len(executed); expected != actual {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := Command(first), executed[0]; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := "Ping", executed[1].Name(); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	mock.Reset()
+	if expected, actual := 0, len(mock.Executed()); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
@@ -0,0 +1,613 @@
+package riak
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// msgpackCodec encodes values in the MessagePack format. It supports booleans,
+// numbers, strings, byte slices, slices, arrays, maps and structs, which are
+// encoded as maps keyed by field name or by the name given in a `msgpack`
+// field tag, e.g. `msgpack:"name,omitempty"`. Values decoded into an empty
+// interface are nil, bool, int64, uint64, float64, string, []byte,
+// []interface{} and map[string]interface{} or map[interface{}]interface{}.
+type msgpackCodec struct{}
+
+func (msgpackCodec) Encode(v interface{}) ([]byte, error) {
+	e := &msgpackEncoder{}
+	if err := e.encode(reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return e.buf.Bytes(), nil
+}
+
+func (msgpackCodec) Decode(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return newClientError(fmt.Sprintf("[Codec] can not decode %s into %v", ContentTypeMsgpack, reflect.TypeOf(v)))
+	}
+	d := &msgpackDecoder{data: data}
+	if err := d.decode(rv.Elem()); err != nil {
+		return err
+	}
+	if d.pos != len(d.data) {
+		return errMsgpackTrailingData
+	}
+	return nil
+}
+
+var (
+	errMsgpackTruncated    = newClientError("[Codec] truncated msgpack data")
+	errMsgpackTrailingData = newClientError("[Codec] trailing data after msgpack value")
+	errMsgpackTooDeep      = newClientError("[Codec] msgpack value is nested too deeply")
+)
+
+// msgpackField is an exported struct field and its msgpack name
+type msgpackField struct {
+	name      string
+	index     int
+	omitEmpty bool
+}
+
+func msgpackFields(t reflect.Type) []msgpackField {
+	var fields []msgpackField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue // NB: unexported
+		}
+		field := msgpackField{name: f.Name, index: i}
+		if tag := f.Tag.Get("msgpack"); tag != "" {
+			parts := strings.Split(tag, ",")
+			if parts[0] == "-" {
+				continue
+			}
+			if parts[0] != "" {
+				field.name = parts[0]
+			}
+			for _, opt := range parts[1:] {
+				if opt == "omitempty" {
+					field.omitEmpty = true
+				}
+			}
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+type msgpackEncoder struct {
+	buf bytes.Buffer
+}
+
+func (e *msgpackEncoder) writeByte(b byte) {
+	e.buf.WriteByte(b)
+}
+
+func (e *msgpackEncoder) writeUint(code byte, size int, n uint64) {
+	e.buf.WriteByte(code)
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], n)
+	e.buf.Write(b[8-size:])
+}
+
+// writeLength writes the header of a string, binary, array or map of length n,
+// using the fix code if n is less than fixMax
+func (e *msgpackEncoder) writeLength(n int, fixCode byte, fixMax int, code8, code16, code32 byte) {
+	switch {
+	case n < fixMax:
+		e.writeByte(fixCode | byte(n))
+	case code8 != 0 && n <= math.MaxUint8:
+		e.writeUint(code8, 1, uint64(n))
+	case n <= math.MaxUint16:
+		e.writeUint(code16, 2, uint64(n))
+	default:
+		e.writeUint(code32, 4, uint64(n))
+	}
+}
+
+func (e *msgpackEncoder) encodeInt(n int64) {
+	switch {
+	case n >= 0:
+		e.encodeUint(uint64(n))
+	case n >= -32:
+		e.writeByte(byte(n))
+	case n >= math.MinInt8:
+		e.writeUint(0xd0, 1, uint64(n))
+	case n >= math.MinInt16:
+		e.writeUint(0xd1, 2, uint64(n))
+	case n >= math.MinInt32:
+		e.writeUint(0xd2, 4, uint64(n))
+	default:
+		e.writeUint(0xd3, 8, uint64(n))
+	}
+}
+
+func (e *msgpackEncoder) encodeUint(n uint64) {
+	switch {
+	case n <= 0x7f:
+		e.writeByte(byte(n))
+	case n <= math.MaxUint8:
+		e.writeUint(0xcc, 1, n)
+	case n <= math.MaxUint16:
+		e.writeUint(0xcd, 2, n)
+	case n <= math.MaxUint32:
+		e.writeUint(0xce, 4, n)
+	default:
+		e.writeUint(0xcf, 8, n)
+	}
+}
+
+func (e *msgpackEncoder) encodeString(s string) {
+	e.writeLength(len(s), 0xa0, 32, 0xd9, 0xda, 0xdb)
+	e.buf.WriteString(s)
+}
+
+func (e *msgpackEncoder) encodeBytes(b []byte) {
+	e.writeLength(len(b), 0, 0, 0xc4, 0xc5, 0xc6)
+	e.buf.Write(b)
+}
+
+func (e *msgpackEncoder) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		e.writeByte(0xc0)
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			e.writeByte(0xc0)
+			return nil
+		}
+		return e.encode(v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			e.writeByte(0xc3)
+		} else {
+			e.writeByte(0xc2)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.encodeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.encodeUint(v.Uint())
+	case reflect.Float32:
+		e.writeUint(0xca, 4, uint64(math.Float32bits(float32(v.Float()))))
+	case reflect.Float64:
+		e.writeUint(0xcb, 8, math.Float64bits(v.Float()))
+	case reflect.String:
+		e.encodeString(v.String())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			e.writeByte(0xc0)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			e.encodeBytes(b)
+			return nil
+		}
+		e.writeLength(v.Len(), 0x90, 16, 0, 0xdc, 0xdd)
+		for i := 0; i < v.Len(); i++ {
+			if err := e.encode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			e.writeByte(0xc0)
+			return nil
+		}
+		// NB: keys are sorted by their encoding so that equal maps encode
+		// to the same bytes
+		type entry struct {
+			key   []byte
+			value reflect.Value
+		}
+		entries := make([]entry, 0, v.Len())
+		for _, k := range v.MapKeys() {
+			ke := &msgpackEncoder{}
+			if err := ke.encode(k); err != nil {
+				return err
+			}
+			entries = append(entries, entry{ke.buf.Bytes(), v.MapIndex(k)})
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return bytes.Compare(entries[i].key, entries[j].key) < 0
+		})
+		e.writeLength(len(entries), 0x80, 16, 0, 0xde, 0xdf)
+		for _, entry := range entries {
+			e.buf.Write(entry.key)
+			if err := e.encode(entry.value); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		var fields []msgpackField
+		for _, f := range msgpackFields(v.Type()) {
+			if !f.omitEmpty || !isEmptyValue(v.Field(f.index)) {
+				fields = append(fields, f)
+			}
+		}
+		e.writeLength(len(fields), 0x80, 16, 0, 0xde, 0xdf)
+		for _, f := range fields {
+			e.encodeString(f.name)
+			if err := e.encode(v.Field(f.index)); err != nil {
+				return err
+			}
+		}
+	default:
+		return newClientError(fmt.Sprintf("[Codec] can not encode %v as %s", v.Type(), ContentTypeMsgpack))
+	}
+	return nil
+}
+
+// msgpackMaxDepth bounds the nesting of decoded arrays and maps
+const msgpackMaxDepth = 1000
+
+type msgpackDecoder struct {
+	data  []byte
+	pos   int
+	depth int
+}
+
+func (d *msgpackDecoder) next(n int) ([]byte, error) {
+	if n < 0 || n > len(d.data)-d.pos {
+		return nil, errMsgpackTruncated
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *msgpackDecoder) readUint(size int) (uint64, error) {
+	b, err := d.next(size)
+	if err != nil {
+		return 0, err
+	}
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	return n, nil
+}
+
+// msgpackKind is the kind of a msgpack value, as given by its first byte
+type msgpackKind byte
+
+const (
+	msgpackNil msgpackKind = iota
+	msgpackBool
+	msgpackInt
+	msgpackUint
+	msgpackFloat
+	msgpackString
+	msgpackBinary
+	msgpackArray
+	msgpackMap
+)
+
+// header reads the header of the next value. For scalars, n is the value, or
+// the bits of a float; for strings, binaries, arrays and maps it is the length.
+func (d *msgpackDecoder) header() (kind msgpackKind, n uint64, err error) {
+	var b []byte
+	if b, err = d.next(1); err != nil {
+		return
+	}
+	c := b[0]
+	switch {
+	case c <= 0x7f:
+		return msgpackUint, uint64(c), nil
+	case c >= 0xe0:
+		return msgpackInt, uint64(int64(int8(c))), nil
+	case c&0xf0 == 0x80:
+		return msgpackMap, uint64(c & 0x0f), nil
+	case c&0xf0 == 0x90:
+		return msgpackArray, uint64(c & 0x0f), nil
+	case c&0xe0 == 0xa0:
+		return msgpackString, uint64(c & 0x1f), nil
+	}
+	switch c {
+	case 0xc0:
+		return msgpackNil, 0, nil
+	case 0xc2:
+		return msgpackBool, 0, nil
+	case 0xc3:
+		return msgpackBool, 1, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err = d.readUint(1 << (c - 0xc4))
+		return msgpackBinary, n, err
+	case 0xca:
+		var bits uint64
+		bits, err = d.readUint(4)
+		return msgpackFloat, math.Float64bits(float64(math.Float32frombits(uint32(bits)))), err
+	case 0xcb:
+		n, err = d.readUint(8)
+		return msgpackFloat, n, err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, err = d.readUint(1 << (c - 0xcc))
+		return msgpackUint, n, err
+	case 0xd0:
+		n, err = d.readUint(1)
+		return msgpackInt, uint64(int64(int8(n))), err
+	case 0xd1:
+		n, err = d.readUint(2)
+		return msgpackInt, uint64(int64(int16(n))), err
+	case 0xd2:
+		n, err = d.readUint(4)
+		return msgpackInt, uint64(int64(int32(n))), err
+	case 0xd3:
+		n, err = d.readUint(8)
+		return msgpackInt, n, err
+	case 0xd9, 0xda, 0xdb:
+		n, err = d.readUint(1 << (c - 0xd9))
+		return msgpackString, n, err
+	case 0xdc, 0xdd:
+		n, err = d.readUint(2 << (c - 0xdc))
+		return msgpackArray, n, err
+	case 0xde, 0xdf:
+		n, err = d.readUint(2 << (c - 0xde))
+		return msgpackMap, n, err
+	}
+	return 0, 0, newClientError(fmt.Sprintf("[Codec] unsupported msgpack type 0x%x", c))
+}
+
+// checkLength returns an error if there are not enough bytes left for n
+// values, each taking at least one byte
+func (d *msgpackDecoder) checkLength(n uint64) error {
+	if n > uint64(len(d.data)-d.pos) {
+		return errMsgpackTruncated
+	}
+	return nil
+}
+
+func (d *msgpackDecoder) decode(v reflect.Value) error {
+	if d.depth++; d.depth > msgpackMaxDepth {
+		return errMsgpackTooDeep
+	}
+	defer func() { d.depth-- }()
+	kind, n, err := d.header()
+	if err != nil {
+		return err
+	}
+	return d.decodeValue(kind, n, v)
+}
+
+func (d *msgpackDecoder) typeError(kind msgpackKind, v reflect.Value) error {
+	return newClientError(fmt.Sprintf("[Codec] can not decode msgpack value of kind %d into %v", kind, v.Type()))
+}
+
+func (d *msgpackDecoder) decodeValue(kind msgpackKind, n uint64, v reflect.Value) error {
+	if kind == msgpackNil {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return d.decodeValue(kind, n, v.Elem())
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return d.typeError(kind, v)
+		}
+		i, err := d.decodeInterface(kind, n)
+		if err != nil {
+			return err
+		}
+		if i == nil {
+			v.Set(reflect.Zero(v.Type()))
+		} else {
+			v.Set(reflect.ValueOf(i))
+		}
+		return nil
+	}
+
+	switch kind {
+	case msgpackBool:
+		if v.Kind() != reflect.Bool {
+			return d.typeError(kind, v)
+		}
+		v.SetBool(n == 1)
+	case msgpackInt, msgpackUint:
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			i := int64(n)
+			if (kind == msgpackUint && i < 0) || v.OverflowInt(i) {
+				return d.overflowError(v)
+			}
+			v.SetInt(i)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if (kind == msgpackInt && int64(n) < 0) || v.OverflowUint(n) {
+				return d.overflowError(v)
+			}
+			v.SetUint(n)
+		case reflect.Float32, reflect.Float64:
+			if kind == msgpackInt {
+				v.SetFloat(float64(int64(n)))
+			} else {
+				v.SetFloat(float64(n))
+			}
+		default:
+			return d.typeError(kind, v)
+		}
+	case msgpackFloat:
+		if v.Kind() != reflect.Float32 && v.Kind() != reflect.Float64 {
+			return d.typeError(kind, v)
+		}
+		v.SetFloat(math.Float64frombits(n))
+	case msgpackString, msgpackBinary:
+		if err := d.checkLength(n); err != nil {
+			return err
+		}
+		b, err := d.next(int(n))
+		if err != nil {
+			return err
+		}
+		switch {
+		case v.Kind() == reflect.String:
+			v.SetString(string(b))
+		case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+			v.SetBytes(append([]byte(nil), b...))
+		default:
+			return d.typeError(kind, v)
+		}
+	case msgpackArray:
+		if err := d.checkLength(n); err != nil {
+			return err
+		}
+		switch v.Kind() {
+		case reflect.Slice:
+			s := reflect.MakeSlice(v.Type(), int(n), int(n))
+			for i := 0; i < int(n); i++ {
+				if err := d.decode(s.Index(i)); err != nil {
+					return err
+				}
+			}
+			v.Set(s)
+		case reflect.Array:
+			if int(n) != v.Len() {
+				return newClientError(fmt.Sprintf("[Codec] can not decode msgpack array of length %d into %v", n, v.Type()))
+			}
+			for i := 0; i < int(n); i++ {
+				if err := d.decode(v.Index(i)); err != nil {
+					return err
+				}
+			}
+		default:
+			return d.typeError(kind, v)
+		}
+	case msgpackMap:
+		if err := d.checkLength(2 * n); err != nil {
+			return err
+		}
+		switch v.Kind() {
+		case reflect.Map:
+			if v.IsNil() {
+				v.Set(reflect.MakeMap(v.Type()))
+			}
+			for i := 0; i < int(n); i++ {
+				key := reflect.New(v.Type().Key()).Elem()
+				if err := d.decode(key); err != nil {
+					return err
+				}
+				value := reflect.New(v.Type().Elem()).Elem()
+				if err := d.decode(value); err != nil {
+					return err
+				}
+				v.SetMapIndex(key, value)
+			}
+		case reflect.Struct:
+			fields := make(map[string]int)
+			for _, f := range msgpackFields(v.Type()) {
+				fields[f.name] = f.index
+			}
+			for i := 0; i < int(n); i++ {
+				var name string
+				if err := d.decode(reflect.ValueOf(&name).Elem()); err != nil {
+					return err
+				}
+				if index, ok := fields[name]; ok {
+					if err := d.decode(v.Field(index)); err != nil {
+						return err
+					}
+				} else if err := d.skip(); err != nil {
+					return err
+				}
+			}
+		default:
+			return d.typeError(kind, v)
+		}
+	}
+	return nil
+}
+
+func (d *msgpackDecoder) overflowError(v reflect.Value) error {
+	return newClientError(fmt.Sprintf("[Codec] msgpack number overflows %v", v.Type()))
+}
+
+func (d *msgpackDecoder) decodeInterface(kind msgpackKind, n uint64) (interface{}, error) {
+	switch kind {
+	case msgpackBool:
+		return n == 1, nil
+	case msgpackInt:
+		return int64(n), nil
+	case msgpackUint:
+		return n, nil
+	case msgpackFloat:
+		return math.Float64frombits(n), nil
+	case msgpackString:
+		var s string
+		err := d.decodeValue(kind, n, reflect.ValueOf(&s).Elem())
+		return s, err
+	case msgpackBinary:
+		var b []byte
+		err := d.decodeValue(kind, n, reflect.ValueOf(&b).Elem())
+		return b, err
+	case msgpackArray:
+		var a []interface{}
+		err := d.decodeValue(kind, n, reflect.ValueOf(&a).Elem())
+		return a, err
+	case msgpackMap:
+		if err := d.checkLength(2 * n); err != nil {
+			return nil, err
+		}
+		m := make(map[interface{}]interface{}, n)
+		stringKeys := true
+		for i := 0; i < int(n); i++ {
+			var key, value interface{}
+			if err := d.decode(reflect.ValueOf(&key).Elem()); err != nil {
+				return nil, err
+			}
+			if err := d.decode(reflect.ValueOf(&value).Elem()); err != nil {
+				return nil, err
+			}
+			if key != nil && !reflect.TypeOf(key).Comparable() {
+				return nil, newClientError(fmt.Sprintf("[Codec] msgpack map key of type %T is not supported", key))
+			}
+			if _, ok := key.(string); !ok {
+				stringKeys = false
+			}
+			m[key] = value
+		}
+		if !stringKeys {
+			return m, nil
+		}
+		sm := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			sm[k.(string)] = v
+		}
+		return sm, nil
+	}
+	return nil, nil
+}
+
+// skip reads past the next value
+func (d *msgpackDecoder) skip() error {
+	var v interface{}
+	return d.decode(reflect.ValueOf(&v).Elem())
+}
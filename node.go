@@ -19,6 +19,27 @@ const (
 
 // NodeOptions defines the RemoteAddress and operational configuration for connections to a Riak KV
 // instance
+//
+// Dialer may be set to a net.Dialer template to tune keepalive, bind a local address or set socket
+// options via its Control function. If its Timeout is zero, ConnectTimeout is used. DialFunc takes
+// precedence over Dialer and can be used to tunnel through a proxy or to use an in-memory transport.
+// It is given a context with ConnectTimeout as its deadline.
+//
+// Capture may be set to record every PB frame exchanged with the node, see NewCapture.
+//
+// MaxFrameSize limits the size of response frames read from the node. A larger frame results in a
+// FrameSizeError and the connection being closed, unless the command streams oversized values (see
+// FetchValueCommandBuilder.WithValueWriter). Zero means no limit.
+//
+// The node fetches its Riak version when it starts and whenever it recovers from a failed health
+// check, unless DisableServerVersionDetection is set. A node with a known version refuses commands
+// that need a later version of Riak with a ClientError, rather than sending them to fail with an
+// RpbErrorResp. The version is also recorded whenever a FetchServerInfoCommand is executed on the
+// node, see Cluster.ServerVersions.
+//
+// ClientId is set on every new connection, after authentication. It is only used by Riak when
+// vclocks are generated client-side, i.e. vnode_vclocks is false, and should be stable and unique
+// for each client instance.
 type NodeOptions struct {
 	RemoteAddress       string
 	MinConnections      uint16
@@ -29,6 +50,13 @@ type NodeOptions struct {
 	HealthCheckInterval time.Duration
 	HealthCheckBuilder  CommandBuilder
 	AuthOptions         *AuthOptions
+	Dialer              *net.Dialer
+	DialFunc            DialFunc
+	Capture             *Capture
+	MaxFrameSize        uint32
+	ClientId            string
+
+	DisableServerVersionDetection bool
 }
 
 // Node is a struct that contains all of the information needed to connect and maintain connections
@@ -43,6 +71,15 @@ type Node struct {
 	healthCheckInterval time.Duration
 	healthCheckBuilder  CommandBuilder
 	authOptions         *AuthOptions
+	dialer              *net.Dialer
+	dialFunc            DialFunc
+	capture             *Capture
+	maxFrameSize        uint32
+	detectServerVersion bool
+	clientId            string
+	// Server version, when known
+	versionMtx    sync.RWMutex
+	serverVersion *Version
 	// Health Check stop channel / timer
 	stopChan     chan bool
 	expireTicker *time.Ticker
@@ -103,6 +140,12 @@ func NewNode(options *NodeOptions) (*Node, error) {
 			healthCheckInterval: options.HealthCheckInterval,
 			healthCheckBuilder:  options.HealthCheckBuilder,
 			authOptions:         options.AuthOptions,
+			dialer:              options.Dialer,
+			dialFunc:            options.DialFunc,
+			capture:             options.Capture,
+			maxFrameSize:        options.MaxFrameSize,
+			detectServerVersion: !options.DisableServerVersionDetection,
+			clientId:            options.ClientId,
 			available:           make([]*connection, 0, options.MinConnections),
 		}
 		n.setStateDesc("nodeError", "nodeCreated", "nodeRunning", "nodeHealthChecking", "nodeShuttingDown", "nodeShutdown")
@@ -151,9 +194,49 @@ func (n *Node) start() (err error) {
 
 	n.setState(nodeRunning)
 	logDebug("[Node]", "(%v) started", n)
+
+	if n.detectServerVersion {
+		n.fetchServerVersion()
+	}
 	return
 }
 
+// ServerVersion returns the Riak version of the node, if known
+func (n *Node) ServerVersion() (v Version, ok bool) {
+	n.versionMtx.RLock()
+	defer n.versionMtx.RUnlock()
+	if n.serverVersion == nil {
+		return Version{}, false
+	}
+	return *n.serverVersion, true
+}
+
+func (n *Node) setServerVersion(v *Version) {
+	n.versionMtx.Lock()
+	defer n.versionMtx.Unlock()
+	n.serverVersion = v
+}
+
+// recordServerVersion records the version in a FetchServerInfoCommand response
+func (n *Node) recordServerVersion(cmd *FetchServerInfoCommand) {
+	if cmd.Response == nil {
+		return
+	}
+	if v, err := ParseVersion(cmd.Response.ServerVersion); err == nil {
+		logDebug("[Node]", "(%v) runs Riak %v", n, v)
+		n.setServerVersion(&v)
+	} else {
+		logWarn("[Node]", "(%v) %v", n, err)
+		n.setServerVersion(nil)
+	}
+}
+
+func (n *Node) fetchServerVersion() {
+	if _, err := n.execute(&FetchServerInfoCommand{}); err != nil {
+		logErr("[Node]", err)
+	}
+}
+
 // Stop closes the connections with Riak at the configured remoteAddress and removes the connections
 // from the active pool
 func (n *Node) stop() (err error) {
@@ -178,12 +261,22 @@ func (n *Node) execute(cmd Command) (executed bool, err error) {
 		return
 	}
 
+	if v, ok := n.ServerVersion(); ok {
+		if err = checkServerVersion(cmd, v); err != nil {
+			// NB: not executed, so that another node may be tried
+			return
+		}
+	}
+
 	if n.isCurrentState(nodeRunning) {
 		var conn *connection
 		if conn = n.getAvailableConnection(); conn == nil {
+			// NB: createNewConnection takes the write lock, so the read lock
+			// must not be held while connecting
 			n.connMtx.RLock()
-			defer n.connMtx.RUnlock()
-			if n.currentNumConnections < n.maxConnections {
+			canCreate := n.currentNumConnections < n.maxConnections
+			n.connMtx.RUnlock()
+			if canCreate {
 				if conn, err = n.createNewConnection(nil, true); conn == nil || err != nil {
 					logErr("[Node]", err)
 					n.doHealthCheck()
@@ -195,7 +288,6 @@ func (n *Node) execute(cmd Command) (executed bool, err error) {
 				executed = false
 				return
 			}
-			n.connMtx.RUnlock()
 		}
 
 		if conn == nil {
@@ -209,25 +301,27 @@ func (n *Node) execute(cmd Command) (executed bool, err error) {
 		if err == nil {
 			// NB: basically the success path of _responseReceived in Node.js client
 			n.returnConnectionToPool(conn, true)
+			if si, ok := cmd.(*FetchServerInfoCommand); ok {
+				n.recordServerVersion(si)
+			}
+		} else if conn.abandoned() {
+			n.discardConnection(conn)
 		} else {
 			// NB: basically, this is _connectionClosed / _responseReceived in Node.js client
 			// must differentiate between Riak and non-Riak errors here and within execute() in connection
 			// TODO type switch?
 			switch err.(type) {
 			case RiakError, ClientError:
-				// Riak and Client errors will not close connection
-				n.returnConnectionToPool(conn, true)
+				// Riak and Client errors will not close connection, unless it
+				// can no longer be used, e.g. after an unexpected response code
+				if conn.available() {
+					n.returnConnectionToPool(conn, true)
+					break
+				}
+				n.closeConnection(conn, err)
 			default:
 				// NB: must be a non-Riak, non-Client error
-				n.connMtx.Lock()
-				defer n.connMtx.Unlock()
-				logDebug("[Node]", "(%v) - closing connection due to non-Riak error: '%v'", n, err)
-				if err := conn.close(); err != nil {
-					logErr("[Node]", err)
-				}
-				n.currentNumConnections--
-				n.doHealthCheck()
-				// TODO evaluate _connectionClosed code in riaknode.js
+				n.closeConnection(conn, err)
 			}
 		}
 	}
@@ -235,15 +329,44 @@ func (n *Node) execute(cmd Command) (executed bool, err error) {
 	return
 }
 
+// closeConnection closes a connection that failed with err and starts health checking
+func (n *Node) closeConnection(conn *connection, err error) {
+	n.connMtx.Lock()
+	defer n.connMtx.Unlock()
+	logDebug("[Node]", "(%v) - closing connection due to error: '%v'", n, err)
+	if err := conn.close(); err != nil {
+		logErr("[Node]", err)
+	}
+	n.currentNumConnections--
+	n.doHealthCheck()
+	// TODO evaluate _connectionClosed code in riaknode.js
+}
+
+// discardConnection closes a connection that can not be used again, without
+// health checking
+func (n *Node) discardConnection(conn *connection) {
+	n.connMtx.Lock()
+	defer n.connMtx.Unlock()
+	logDebug("[Node]", "(%v) - discarding abandoned connection", n)
+	if err := conn.close(); err != nil {
+		logErr("[Node]", err)
+	}
+	n.currentNumConnections--
+}
+
 func (n *Node) getAvailableConnection() *connection {
 	n.connMtx.Lock()
 	defer n.connMtx.Unlock()
-	if len(n.available) > 0 {
+	for len(n.available) > 0 {
 		c := n.available[0]
+		n.available = n.available[1:]
 		if c.available() {
-			n.available = n.available[1:]
 			return c
 		}
+		// NB: discard connections that went bad while in the pool
+		logDebug("[Node]", "(%v) - discarding unavailable connection", n)
+		c.close() // NB: discard error
+		n.currentNumConnections--
 	}
 	return nil
 }
@@ -315,6 +438,11 @@ func (n *Node) createNewConnection(healthCheck Command, shouldLock bool) (conn *
 		requestTimeout: n.requestTimeout,
 		healthCheck:    healthCheck,
 		authOptions:    n.authOptions,
+		dialer:         n.dialer,
+		dialFunc:       n.dialFunc,
+		capture:        n.capture,
+		maxFrameSize:   n.maxFrameSize,
+		clientId:       n.clientId,
 	}
 	if conn, err = newConnection(connectionOptions); err == nil {
 		if err = conn.connect(); err == nil {
@@ -387,8 +515,13 @@ func (n *Node) healthCheck() {
 						logDebug("[Node]", "(%v) failed healthcheck - conn: %v err: %v", n, conn == nil, err)
 					} else {
 						n.returnConnectionToPool(conn, true)
+						// NB: the node may have been upgraded while down
+						n.setServerVersion(nil)
 						n.setState(nodeRunning)
 						logDebug("[Node]", "(%v) healthcheck success", n)
+						if n.detectServerVersion {
+							n.fetchServerVersion()
+						}
 						return
 					}
 				}
@@ -2,7 +2,11 @@ package riak
 
 import (
 	"net"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/basho/riak-go-client/riaktest"
 )
 
 func TestCreateNodeWithOptions(t *testing.T) {
@@ -108,3 +112,255 @@ func TestEnsureDefaultNodeValues(t *testing.T) {
 		t.Errorf("expected %v, got: %v", expected, actual)
 	}
 }
+
+func TestNodeExecutesUsingDialFunc(t *testing.T) {
+	opts := &NodeOptions{
+		RemoteAddress: "127.0.0.1:8087",
+		DialFunc:      pipeDialFunc,
+	}
+	node, err := NewNode(opts)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if node.dialFunc == nil {
+		t.Fatal("expected node to have a dial func")
+	}
+	if err := node.start(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer node.stop()
+	cmd := &PingCommand{}
+	executed, err := node.execute(cmd)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := true, executed; expected != actual {
+		t.Errorf("expected %v, got: %v", expected, actual)
+	}
+	if expected, actual := true, cmd.Successful(); expected != actual {
+		t.Errorf("expected %v, got: %v", expected, actual)
+	}
+}
+
+func TestNodeClosesConnectionOnFrameSizeError(t *testing.T) {
+	opts := &NodeOptions{
+		RemoteAddress: "127.0.0.1:8087",
+		MaxFrameSize:  64 * 1024,
+		DialFunc:      respondingDialFunc(rpbCode_RpbGetResp, largeRpbGetResp()),
+		// NB: the dial func answers every request with the same response
+		DisableServerVersionDetection: true,
+	}
+	node, err := NewNode(opts)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := node.start(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer node.stop()
+	cmd, err := NewFetchValueCommandBuilder().
+		WithBucket("bucket").
+		WithKey("blob").
+		Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := node.execute(cmd); err == nil {
+		t.Fatal("expected error")
+	} else if _, ok := err.(FrameSizeError); !ok {
+		t.Errorf("expected FrameSizeError, got %v", err)
+	}
+	if expected, actual := uint16(0), node.currentNumConnections; expected != actual {
+		t.Errorf("expected %v, got: %v", expected, actual)
+	}
+	if expected, actual := nodeHealthChecking, node.getState(); expected != actual {
+		t.Errorf("expected %v, got: %v", expected, actual)
+	}
+}
+
+// newProxiedNode creates a node connected to a riaktest.Server through a
+// fault-injecting riaktest.Proxy
+func newProxiedNode(t *testing.T, opts *NodeOptions) (*riaktest.Server, *riaktest.Proxy, *Node) {
+	server, err := riaktest.NewServer()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	proxy, err := riaktest.NewProxy(server.Addr())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	opts.RemoteAddress = proxy.Addr()
+	if opts.HealthCheckInterval == 0 {
+		opts.HealthCheckInterval = 10 * time.Millisecond
+	}
+	node, err := NewNode(opts)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return server, proxy, node
+}
+
+func startProxiedNode(t *testing.T, opts *NodeOptions) (*riaktest.Server, *riaktest.Proxy, *Node) {
+	server, proxy, node := newProxiedNode(t, opts)
+	if err := node.start(); err != nil {
+		t.Fatal(err.Error())
+	}
+	return server, proxy, node
+}
+
+func stopProxiedNode(server *riaktest.Server, proxy *riaktest.Proxy, node *Node) {
+	node.stop()
+	proxy.Close()
+	server.Close()
+}
+
+func waitForNodeState(t *testing.T, node *Node, state state) {
+	for i := 0; i < 100; i++ {
+		if node.isCurrentState(state) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected node state %v, got: %v", state, node.getState())
+}
+
+func TestNodeRecoversFromBrokenResponses(t *testing.T) {
+	faults := map[string]riaktest.Fault{
+		"drop mid-frame":          riaktest.DropMidFrame(),
+		"truncated length prefix": riaktest.TruncatedLengthPrefix(),
+		"garbage response code":   riaktest.GarbageResponseCode(),
+		"blackhole":               riaktest.Blackhole(),
+	}
+	for name, fault := range faults {
+		server, proxy, node := startProxiedNode(t, &NodeOptions{
+			RequestTimeout: 100 * time.Millisecond,
+		})
+		proxy.Inject(fault, 1)
+		executed, err := node.execute(&PingCommand{})
+		if err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+		if expected, actual := true, executed; expected != actual {
+			t.Errorf("%s: expected %v, got: %v", name, expected, actual)
+		}
+		node.connMtx.RLock()
+		numConnections := node.currentNumConnections
+		node.connMtx.RUnlock()
+		if expected, actual := uint16(0), numConnections; expected != actual {
+			t.Errorf("%s: expected %v, got: %v", name, expected, actual)
+		}
+		waitForNodeState(t, node, nodeRunning)
+		cmd := &PingCommand{}
+		if _, err := node.execute(cmd); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if expected, actual := true, cmd.Successful(); expected != actual {
+			t.Errorf("%s: expected %v, got: %v", name, expected, actual)
+		}
+		stopProxiedNode(server, proxy, node)
+	}
+}
+
+func TestNodeKeepsConnectionOnRiakError(t *testing.T) {
+	server, proxy, node := startProxiedNode(t, &NodeOptions{})
+	defer stopProxiedNode(server, proxy, node)
+	proxy.Inject(riaktest.ErrorResponse("overload", 10*time.Millisecond), 1)
+	_, err := node.execute(&PingCommand{})
+	if riakErr, ok := err.(RiakError); !ok {
+		t.Fatalf("expected RiakError, got: %v", err)
+	} else if expected, actual := "overload", riakErr.Errmsg; expected != actual {
+		t.Errorf("expected %v, got: %v", expected, actual)
+	}
+	if expected, actual := nodeRunning, node.getState(); expected != actual {
+		t.Errorf("expected %v, got: %v", expected, actual)
+	}
+	if expected, actual := 1, len(node.available); expected != actual {
+		t.Errorf("expected %v, got: %v", expected, actual)
+	}
+}
+
+func TestNodeHealthCheckWaitsForRecovery(t *testing.T) {
+	server, proxy, node := startProxiedNode(t, &NodeOptions{})
+	defer stopProxiedNode(server, proxy, node)
+	proxy.Inject(riaktest.DropMidFrame(), 1)
+	proxy.Inject(riaktest.GarbageResponseCode(), 0)
+	if _, err := node.execute(&PingCommand{}); err == nil {
+		t.Fatal("expected error")
+	}
+	// NB: health checks fail while every response is garbage
+	time.Sleep(50 * time.Millisecond)
+	if expected, actual := nodeHealthChecking, node.getState(); expected != actual {
+		t.Errorf("expected %v, got: %v", expected, actual)
+	}
+	if executed, err := node.execute(&PingCommand{}); executed || err != nil {
+		t.Errorf("expected command not to execute, got: %v, %v", executed, err)
+	}
+	proxy.Clear()
+	waitForNodeState(t, node, nodeRunning)
+	if _, err := node.execute(&PingCommand{}); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestNodeDiscardsClosedConnectionsInPool(t *testing.T) {
+	server, proxy, node := startProxiedNode(t, &NodeOptions{
+		MinConnections: 2,
+	})
+	defer stopProxiedNode(server, proxy, node)
+	proxy.Inject(riaktest.GarbageResponseCode(), 1)
+	if _, err := node.execute(&PingCommand{}); err == nil {
+		t.Fatal("expected error")
+	}
+	waitForNodeState(t, node, nodeRunning)
+	// NB: a connection closed while pooled must be discarded rather than used
+	node.available[0].close()
+	if _, err := node.execute(&PingCommand{}); err != nil {
+		t.Error(err.Error())
+	}
+	if expected, actual := uint16(1), node.currentNumConnections; expected != actual {
+		t.Errorf("expected %v, got: %v", expected, actual)
+	}
+}
+
+func TestNodeCreatesConnectionWhenPoolIsEmpty(t *testing.T) {
+	server, proxy, node := startProxiedNode(t, &NodeOptions{
+		MinConnections: 1,
+	})
+	defer stopProxiedNode(server, proxy, node)
+	proxy.Inject(riaktest.Latency(50*time.Millisecond), 1)
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cmd := &PingCommand{}
+			if _, err := node.execute(cmd); err != nil {
+				t.Error(err.Error())
+			}
+			if !cmd.Successful() {
+				t.Error("expected ping to succeed")
+			}
+		}()
+	}
+	wg.Wait()
+	if expected, actual := uint16(2), node.currentNumConnections; expected != actual {
+		t.Errorf("expected %v, got: %v", expected, actual)
+	}
+}
+
+func TestNodeStoresClientIdOnEveryConnection(t *testing.T) {
+	server, proxy, node := startProxiedNode(t, &NodeOptions{
+		MinConnections: 2,
+		ClientId:       "client-1",
+	})
+	defer stopProxiedNode(server, proxy, node)
+	for _, conn := range node.available {
+		cmd := &FetchClientIdCommand{}
+		if err := conn.execute(cmd); err != nil {
+			t.Fatal(err.Error())
+		}
+		if expected, actual := "client-1", cmd.Response; expected != actual {
+			t.Errorf("expected %v, got: %v", expected, actual)
+		}
+	}
+}
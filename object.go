@@ -2,6 +2,8 @@ package riak
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	rpbRiak "github.com/basho/riak-go-client/rpb/riak"
@@ -31,7 +33,7 @@ type Object struct {
 	VTag            string
 	LastModified    time.Time
 	UserMeta        []*Pair
-	Indexes         map[string][]string // TODO int indexes vs string
+	Indexes         map[string][]string // NB: see IntIndexes and BinIndexes
 	Links           []*Link
 	VClock          []byte
 }
@@ -48,10 +50,87 @@ func (o *Object) HasLinks() bool {
 	return len(o.Links) > 0
 }
 
+// Secondary index name suffixes
+const (
+	IntIndexSuffix = "_int"
+	BinIndexSuffix = "_bin"
+)
+
+// isIntIndex returns whether the index has integer terms
+func isIntIndex(indexName string) bool {
+	return strings.HasSuffix(indexName, IntIndexSuffix)
+}
+
+// validateIndex checks that the index name has one of the suffixes Riak
+// requires, and that terms of integer indexes are integers
+func validateIndex(indexName string, indexValue string) error {
+	switch {
+	case isIntIndex(indexName):
+		if _, err := strconv.ParseInt(indexValue, 10, 64); err != nil {
+			return newClientError(fmt.Sprintf("[Object] index '%s' requires integer values, got '%s'", indexName, indexValue))
+		}
+	case strings.HasSuffix(indexName, BinIndexSuffix):
+	default:
+		return newClientError(fmt.Sprintf("[Object] index name '%s' must end with %s or %s", indexName, IntIndexSuffix, BinIndexSuffix))
+	}
+	return nil
+}
+
 func (o *Object) AddToIntIndex(indexName string, indexValue int) {
 	o.AddToIndex(indexName, fmt.Sprintf("%v", indexValue))
 }
 
+// AddToInt64Index adds the value to the integer index, whose name must end with
+// "_int"
+func (o *Object) AddToInt64Index(indexName string, indexValue int64) error {
+	if !isIntIndex(indexName) {
+		return newClientError(fmt.Sprintf("[Object] integer index name '%s' must end with %s", indexName, IntIndexSuffix))
+	}
+	o.AddToIndex(indexName, strconv.FormatInt(indexValue, 10))
+	return nil
+}
+
+// AddToBinIndex adds the value to the binary index, whose name must end with
+// "_bin"
+func (o *Object) AddToBinIndex(indexName string, indexValue string) error {
+	if !strings.HasSuffix(indexName, BinIndexSuffix) {
+		return newClientError(fmt.Sprintf("[Object] binary index name '%s' must end with %s", indexName, BinIndexSuffix))
+	}
+	o.AddToIndex(indexName, indexValue)
+	return nil
+}
+
+// IntIndexes returns the values of the integer indexes of the object, those
+// with a name ending with "_int". Values that are not integers are skipped.
+func (o *Object) IntIndexes() map[string][]int64 {
+	indexes := make(map[string][]int64)
+	for indexName, indexValues := range o.Indexes {
+		if !isIntIndex(indexName) {
+			continue
+		}
+		values := make([]int64, 0, len(indexValues))
+		for _, indexValue := range indexValues {
+			if value, err := strconv.ParseInt(indexValue, 10, 64); err == nil {
+				values = append(values, value)
+			}
+		}
+		indexes[indexName] = values
+	}
+	return indexes
+}
+
+// BinIndexes returns the values of the binary indexes of the object, those with
+// a name ending with "_bin"
+func (o *Object) BinIndexes() map[string][]string {
+	indexes := make(map[string][]string)
+	for indexName, indexValues := range o.Indexes {
+		if strings.HasSuffix(indexName, BinIndexSuffix) {
+			indexes[indexName] = indexValues
+		}
+	}
+	return indexes
+}
+
 func (o *Object) AddToIndex(indexName string, indexValue string) {
 	if o.Indexes == nil {
 		o.Indexes = make(map[string][]string)
@@ -64,6 +143,50 @@ func (o *Object) AddToIndex(indexName string, indexValue string) {
 	}
 }
 
+// NewObjectFrom returns an Object with the value v encoded by the codec
+// registered for the content type
+func NewObjectFrom(v interface{}, contentType string) (*Object, error) {
+	o := &Object{ContentType: contentType}
+	if err := o.Encode(v); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// Encode sets the value of the object to v, encoded by the codec registered for
+// the object's ContentType. The value is gzip compressed if the ContentEncoding
+// is "gzip".
+func (o *Object) Encode(v interface{}) error {
+	codec, err := codecFor(o.ContentType)
+	if err != nil {
+		return err
+	}
+	data, err := codec.Encode(v)
+	if err != nil {
+		return err
+	}
+	if data, err = compress(o.ContentEncoding, data); err != nil {
+		return err
+	}
+	o.Value = data
+	return nil
+}
+
+// Decode decodes the value of the object into v, using the codec registered for
+// the object's ContentType. Values with a "gzip" ContentEncoding are
+// decompressed first.
+func (o *Object) Decode(v interface{}) error {
+	codec, err := codecFor(o.ContentType)
+	if err != nil {
+		return err
+	}
+	data, err := decompress(o.ContentEncoding, o.Value)
+	if err != nil {
+		return err
+	}
+	return codec.Decode(data, v)
+}
+
 func fromRpbContent(rpbContent *rpbRiakKV.RpbContent) (ro *Object, err error) {
 	// NB: ro = "Riak Object"
 	ro = &Object{
@@ -73,7 +196,7 @@ func fromRpbContent(rpbContent *rpbRiakKV.RpbContent) (ro *Object, err error) {
 	if ro.IsTombstone {
 		ro.Value = nil
 	} else {
-		// TODO deserialization?
+		// NB: values are kept as stored, see Object.Decode
 		ro.Value = rpbContent.GetValue()
 	}
 
@@ -99,7 +222,8 @@ func fromRpbContent(rpbContent *rpbRiakKV.RpbContent) (ro *Object, err error) {
 		ro.Indexes = make(map[string][]string)
 		for _, index := range rpbIndexes {
 			indexName := string(index.Key)
-			// TODO int indexes
+			// NB: values are kept as stored, even an integer index value that
+			// is not an integer, which IntIndexes skips
 			indexValue := string(index.Value)
 			if ro.Indexes[indexName] == nil {
 				ro.Indexes[indexName] = make([]string, 1)
@@ -143,6 +267,9 @@ func toRpbContent(ro *Object) (*rpbRiakKV.RpbContent, error) {
 		for idxName, idxValues := range ro.Indexes {
 			idxNameBytes := []byte(idxName)
 			for _, idxVal := range idxValues {
+				if err := validateIndex(idxName, idxVal); err != nil {
+					return nil, err
+				}
 				pair := &rpbRiak.RpbPair{
 					Key:   idxNameBytes,
 					Value: []byte(idxVal),
@@ -0,0 +1,74 @@
+package riak
+
+import (
+	"reflect"
+	"testing"
+
+	rpbRiak "github.com/basho/riak-go-client/rpb/riak"
+	rpbRiakKV "github.com/basho/riak-go-client/rpb/riak_kv"
+)
+
+func TestObjectTypedIndexes(t *testing.T) {
+	o := &Object{}
+	if err := o.AddToBinIndex("email_bin", "alice@example.com"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := o.AddToInt64Index("age_int", -42); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := o.AddToInt64Index("age_int", 1<<40); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := o.AddToBinIndex("email_int", "alice@example.com"); err == nil {
+		t.Error("expected error")
+	}
+	if err := o.AddToInt64Index("age_bin", 42); err == nil {
+		t.Error("expected error")
+	}
+	if expected, actual := map[string][]int64{"age_int": {-42, 1 << 40}}, o.IntIndexes(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := map[string][]string{"email_bin": {"alice@example.com"}}, o.BinIndexes(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestToRpbContentValidatesIndexes(t *testing.T) {
+	for _, indexes := range []map[string][]string{
+		{"email": {"alice@example.com"}},
+		{"age_int": {"forty-two"}},
+		{"$key": {"alice"}},
+	} {
+		if _, err := toRpbContent(&Object{Indexes: indexes}); err == nil {
+			t.Errorf("%v: expected error", indexes)
+		} else if _, ok := err.(ClientError); !ok {
+			t.Errorf("expected ClientError, got %v", err)
+		}
+	}
+}
+
+func TestFromRpbContentParsesIntIndexes(t *testing.T) {
+	content := &rpbRiakKV.RpbContent{
+		Indexes: []*rpbRiak.RpbPair{
+			{Key: []byte("age_int"), Value: []byte("42")},
+			{Key: []byte("name_bin"), Value: []byte("alice")},
+		},
+	}
+	o, err := fromRpbContent(content)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := int64(42), o.IntIndexes()["age_int"][0]; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	content.Indexes = append(content.Indexes, &rpbRiak.RpbPair{Key: []byte("age_int"), Value: []byte("forty-two")})
+	if o, err = fromRpbContent(content); err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := []string{"42", "forty-two"}, o.Indexes["age_int"]; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := []int64{42}, o.IntIndexes()["age_int"]; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
@@ -0,0 +1,189 @@
+package riak
+
+import (
+	"sync"
+
+	rpbRiakKV "github.com/basho/riak-go-client/rpb/riak_kv"
+	proto "github.com/golang/protobuf/proto"
+)
+
+// ErrPaginatorStreaming is the error of a SecondaryIndexPaginator of a
+// streaming query, whose results go to its callback rather than to the pages
+var ErrPaginatorStreaming = newClientError("[SecondaryIndexPaginator] streaming queries can not be paginated")
+
+// SecondaryIndexPaginator walks the results of a secondary index query page by
+// page, querying Riak for each page only when it is asked for. The
+// continuation of the current page can be persisted to resume the walk later
+// with WithContinuation.
+//
+//	paginator := NewSecondaryIndexPaginator(cluster, NewSecondaryIndexQueryCommandBuilder().
+//	    WithBucket("myBucket").
+//	    WithIndexName("myIndex_int").
+//	    WithIntRange(0, 1000000), 1000)
+//	for paginator.Next() {
+//	    for _, result := range paginator.Page() {
+//	        ...
+//	    }
+//	    saveCursor(paginator.Continuation())
+//	}
+//	if err := paginator.Err(); err != nil {
+//	    return err
+//	}
+type SecondaryIndexPaginator struct {
+	executor     Executor
+	builder      *SecondaryIndexQueryCommandBuilder
+	pageSize     uint32
+	page         []*SecondaryIndexQueryResult
+	continuation []byte
+	done         bool
+	err          error
+}
+
+// NewSecondaryIndexPaginator returns a paginator over the results of the query
+// built by builder, in pages of at most pageSize results. The query is copied,
+// so the builder is left unchanged. If the builder has a continuation, the walk
+// starts from it. A streaming query fails with ErrPaginatorStreaming.
+func NewSecondaryIndexPaginator(executor Executor, builder *SecondaryIndexQueryCommandBuilder, pageSize uint32) *SecondaryIndexPaginator {
+	p := &SecondaryIndexPaginator{
+		executor: executor,
+		builder: &SecondaryIndexQueryCommandBuilder{
+			protobuf: proto.Clone(builder.protobuf).(*rpbRiakKV.RpbIndexReq),
+			intQuery: builder.intQuery,
+		},
+		pageSize:     pageSize,
+		continuation: builder.protobuf.GetContinuation(),
+	}
+	if builder.protobuf.GetStream() {
+		p.err = ErrPaginatorStreaming
+	}
+	return p
+}
+
+// Next queries the next page, returning false when there are no more pages or
+// an error occurred
+func (p *SecondaryIndexPaginator) Next() bool {
+	p.page = nil
+	if p.done || p.err != nil {
+		return false
+	}
+	cmd, err := p.builder.
+		WithMaxResults(p.pageSize).
+		WithContinuation(p.continuation).
+		Build()
+	if err != nil {
+		p.err = err
+		return false
+	}
+	if err = p.executor.Execute(cmd); err != nil {
+		p.err = err
+		return false
+	}
+	response := cmd.(*SecondaryIndexQueryCommand).Response
+	p.page, p.continuation = response.Results, response.Continuation
+	// NB: Riak only returns a continuation when there may be more results
+	p.done = p.continuation == nil
+	return len(p.page) > 0 || !p.done
+}
+
+// Page returns the results of the current page
+func (p *SecondaryIndexPaginator) Page() []*SecondaryIndexQueryResult {
+	return p.page
+}
+
+// Continuation returns the continuation to resume the walk after the current
+// page, or nil once the last page was reached
+func (p *SecondaryIndexPaginator) Continuation() []byte {
+	return p.continuation
+}
+
+// Err returns the error that ended the walk, if any, once Next returned false
+func (p *SecondaryIndexPaginator) Err() error {
+	return p.err
+}
+
+// IntRange is an inclusive range of integer index terms
+type IntRange struct {
+	Min int64
+	Max int64
+}
+
+// SplitIntRange splits the inclusive range [min, max] into at most n contiguous
+// ranges of about the same size, e.g. to query them in parallel with
+// ScanSecondaryIndex
+func SplitIntRange(min, max int64, n int) []IntRange {
+	if max < min || n < 1 {
+		return nil
+	}
+	// NB: computed as unsigned, the span of the whole int64 range overflows
+	span := uint64(max-min) + 1
+	if span != 0 && uint64(n) > span {
+		n = int(span)
+	}
+	size := span / uint64(n)
+	if span == 0 {
+		size = (1 << 63) / uint64(n) * 2
+	}
+	ranges := make([]IntRange, n)
+	lo := min
+	for i := range ranges {
+		hi := lo + int64(size-1)
+		if i == n-1 {
+			hi = max
+		}
+		ranges[i] = IntRange{Min: lo, Max: hi}
+		lo = hi + 1
+	}
+	return ranges
+}
+
+// ScanSecondaryIndex walks the results of the queries built by builders in
+// parallel, one goroutine per query, in pages of at most pageSize results. The
+// callback is given every page, from several goroutines at once. The first
+// error, of a query or returned by the callback, stops the scan and is
+// returned once every query stopped.
+//
+//	var builders []*SecondaryIndexQueryCommandBuilder
+//	for _, r := range SplitIntRange(0, 50000000, 8) {
+//	    builders = append(builders, NewSecondaryIndexQueryCommandBuilder().
+//	        WithBucket("myBucket").
+//	        WithIndexName("myIndex_int").
+//	        WithIntRange(r.Min, r.Max))
+//	}
+//	err := ScanSecondaryIndex(cluster, builders, 1000, func(page []*SecondaryIndexQueryResult) error {
+//	    ...
+//	})
+func ScanSecondaryIndex(executor Executor, builders []*SecondaryIndexQueryCommandBuilder, pageSize uint32, callback func([]*SecondaryIndexQueryResult) error) error {
+	var wg sync.WaitGroup
+	var errMtx sync.Mutex
+	var scanErr error
+	failed := func() bool {
+		errMtx.Lock()
+		defer errMtx.Unlock()
+		return scanErr != nil
+	}
+	fail := func(err error) {
+		errMtx.Lock()
+		defer errMtx.Unlock()
+		if scanErr == nil {
+			scanErr = err
+		}
+	}
+	for _, builder := range builders {
+		wg.Add(1)
+		go func(builder *SecondaryIndexQueryCommandBuilder) {
+			defer wg.Done()
+			p := NewSecondaryIndexPaginator(executor, builder, pageSize)
+			for !failed() && p.Next() {
+				if err := callback(p.Page()); err != nil {
+					fail(err)
+					return
+				}
+			}
+			if err := p.Err(); err != nil {
+				fail(err)
+			}
+		}(builder)
+	}
+	wg.Wait()
+	return scanErr
+}
@@ -0,0 +1,146 @@
+package riak
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func newPaginatorTestBuilder() *SecondaryIndexQueryCommandBuilder {
+	return NewSecondaryIndexQueryCommandBuilder().
+		WithBucket("bucket").
+		WithIndexName("id_int").
+		WithIntRange(0, 99)
+}
+
+func TestSecondaryIndexPaginator(t *testing.T) {
+	server, cluster := startTestServerCluster(t)
+	defer stopTestServerCluster(server, cluster)
+	expected := storeIteratorTestKeys(t, cluster, "bucket", 95)
+	p := NewSecondaryIndexPaginator(cluster, newPaginatorTestBuilder(), 10)
+	var keys []string
+	var continuations [][]byte
+	pages := 0
+	for p.Next() {
+		pages++
+		for _, result := range p.Page() {
+			keys = append(keys, string(result.ObjectKey))
+		}
+		continuations = append(continuations, p.Continuation())
+	}
+	if err := p.Err(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := 10, pages; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if actual := keys; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if continuations[9] != nil {
+		t.Errorf("expected no continuation after the last page, got %v", continuations[9])
+	}
+
+	// a persisted continuation resumes the walk
+	p = NewSecondaryIndexPaginator(cluster, newPaginatorTestBuilder().WithContinuation(continuations[6]), 10)
+	keys = nil
+	for p.Next() {
+		for _, result := range p.Page() {
+			keys = append(keys, string(result.ObjectKey))
+		}
+	}
+	if err := p.Err(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if actual := keys; !reflect.DeepEqual(expected[70:], actual) {
+		t.Errorf("expected %v, got %v", expected[70:], actual)
+	}
+}
+
+func TestSecondaryIndexPaginatorCopiesQuery(t *testing.T) {
+	executor := NewMockExecutor()
+	executor.On(&SecondaryIndexQueryCommand{}).Respond(&SecondaryIndexQueryResponse{})
+	builder := newPaginatorTestBuilder()
+	p := NewSecondaryIndexPaginator(executor, builder, 10)
+	if p.Next() {
+		t.Error("expected no page")
+	}
+	if err := p.Err(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if builder.protobuf.MaxResults != nil {
+		t.Errorf("expected the builder to be unchanged, got max results %v", builder.protobuf.GetMaxResults())
+	}
+
+	streaming := newPaginatorTestBuilder().
+		WithStreaming(true).
+		WithCallback(func([]*SecondaryIndexQueryResult) error { return nil })
+	p = NewSecondaryIndexPaginator(executor, streaming, 10)
+	if p.Next() {
+		t.Error("expected no page")
+	}
+	if expected, actual := ErrPaginatorStreaming, p.Err(); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestSplitIntRange(t *testing.T) {
+	tests := []struct {
+		min, max int64
+		n        int
+		expected []IntRange
+	}{
+		{0, 99, 4, []IntRange{{0, 24}, {25, 49}, {50, 74}, {75, 99}}},
+		{0, 9, 3, []IntRange{{0, 2}, {3, 5}, {6, 9}}},
+		{5, 6, 4, []IntRange{{5, 5}, {6, 6}}},
+		{-10, 10, 1, []IntRange{{-10, 10}}},
+		{math.MinInt64, math.MaxInt64, 2, []IntRange{{math.MinInt64, -1}, {0, math.MaxInt64}}},
+		{10, 0, 2, nil},
+	}
+	for _, test := range tests {
+		if actual := SplitIntRange(test.min, test.max, test.n); !reflect.DeepEqual(test.expected, actual) {
+			t.Errorf("%v, %v, %v: expected %v, got %v", test.min, test.max, test.n, test.expected, actual)
+		}
+	}
+}
+
+func TestScanSecondaryIndex(t *testing.T) {
+	server, cluster := startTestServerCluster(t)
+	defer stopTestServerCluster(server, cluster)
+	expected := storeIteratorTestKeys(t, cluster, "bucket", 200)
+	var builders []*SecondaryIndexQueryCommandBuilder
+	for _, r := range SplitIntRange(0, 199, 4) {
+		builders = append(builders, NewSecondaryIndexQueryCommandBuilder().
+			WithBucket("bucket").
+			WithIndexName("id_int").
+			WithIntRange(r.Min, r.Max))
+	}
+	var mtx sync.Mutex
+	var keys []string
+	err := ScanSecondaryIndex(cluster, builders, 15, func(page []*SecondaryIndexQueryResult) error {
+		mtx.Lock()
+		defer mtx.Unlock()
+		for _, result := range page {
+			keys = append(keys, string(result.ObjectKey))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	sort.Strings(keys)
+	if actual := keys; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v keys, got %v", len(expected), len(actual))
+	}
+
+	stop := errors.New("stop")
+	err = ScanSecondaryIndex(cluster, builders, 15, func(page []*SecondaryIndexQueryResult) error {
+		return stop
+	})
+	if expected, actual := stop, err; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
@@ -0,0 +1,218 @@
+package riak
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+	"testing/quick"
+
+	rpbRiakDT "github.com/basho/riak-go-client/rpb/riak_dt"
+)
+
+func quickString(r *rand.Rand) string {
+	v, _ := quick.Value(reflect.TypeOf(""), r)
+	return v.String()
+}
+
+func quickBytes(r *rand.Rand) []byte {
+	v, _ := quick.Value(reflect.TypeOf([]byte{}), r)
+	return v.Bytes()
+}
+
+// quickObject is an Object with the fields stored by toRpbContent
+type quickObject struct {
+	*Object
+}
+
+func (quickObject) Generate(r *rand.Rand, size int) reflect.Value {
+	o := &Object{
+		Value:           quickBytes(r),
+		ContentType:     quickString(r),
+		Charset:         quickString(r),
+		ContentEncoding: quickString(r),
+	}
+	for i := r.Intn(size + 1); i > 0; i-- {
+		o.UserMeta = append(o.UserMeta, &Pair{Key: quickString(r), Value: quickString(r)})
+	}
+	for i := r.Intn(size + 1); i > 0; i-- {
+		o.AddToIndex(quickString(r)+BinIndexSuffix, quickString(r))
+	}
+	for i := r.Intn(size + 1); i > 0; i-- {
+		o.AddToInt64Index(quickString(r)+IntIndexSuffix, r.Int63()-r.Int63())
+	}
+	for i := r.Intn(size + 1); i > 0; i-- {
+		o.Links = append(o.Links, &Link{Bucket: quickString(r), Key: quickString(r), Tag: quickString(r)})
+	}
+	return reflect.ValueOf(quickObject{o})
+}
+
+func TestObjectRoundTripsThroughRpbContent(t *testing.T) {
+	roundTrip := func(q quickObject) bool {
+		content, err := toRpbContent(q.Object)
+		if err != nil {
+			return false
+		}
+		o, err := fromRpbContent(content)
+		if err != nil {
+			return false
+		}
+		if len(o.Indexes) == 0 && len(q.Indexes) == 0 {
+			o.Indexes = q.Indexes
+		}
+		return bytes.Equal(o.Value, q.Value) &&
+			o.ContentType == q.ContentType &&
+			o.Charset == q.Charset &&
+			o.ContentEncoding == q.ContentEncoding &&
+			reflect.DeepEqual(o.UserMeta, q.UserMeta) &&
+			reflect.DeepEqual(o.Indexes, q.Indexes) &&
+			reflect.DeepEqual(o.Links, q.Links)
+	}
+	if err := quick.Check(roundTrip, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+// quickMapOperation is a MapOperation built with a random sequence of calls
+type quickMapOperation struct {
+	*MapOperation
+}
+
+func (quickMapOperation) Generate(r *rand.Rand, size int) reflect.Value {
+	return reflect.ValueOf(quickMapOperation{generateMapOperation(r, size, 2)})
+}
+
+func generateMapOperation(r *rand.Rand, size int, depth int) *MapOperation {
+	op := &MapOperation{}
+	// NB: a small set of names so that calls interact
+	names := []string{"a", "b", "c"}
+	for i := r.Intn(size + 1); i > 0; i-- {
+		name := names[r.Intn(len(names))]
+		switch r.Intn(11) {
+		case 0:
+			op.IncrementCounter(name, r.Int63n(100)-50)
+		case 1:
+			op.RemoveCounter(name)
+		case 2:
+			op.AddToSet(name, quickBytes(r))
+		case 3:
+			op.RemoveFromSet(name, quickBytes(r))
+		case 4:
+			op.RemoveSet(name)
+		case 5:
+			op.SetRegister(name, quickBytes(r))
+		case 6:
+			op.RemoveRegister(name)
+		case 7:
+			op.SetFlag(name, r.Intn(2) == 0)
+		case 8:
+			op.RemoveFlag(name)
+		case 9:
+			if depth > 0 {
+				nested := generateMapOperation(r, size/2, depth-1)
+				*op.Map(name) = *nested
+			}
+		case 10:
+			op.RemoveMap(name)
+		}
+	}
+	return op
+}
+
+// describeMapOperation returns the sorted list of changes made by a MapOperation
+func describeMapOperation(op *MapOperation, prefix string) []string {
+	var rv []string
+	for name, increment := range op.incrementCounters {
+		rv = append(rv, fmt.Sprintf("%s%s: increment counter by %d", prefix, name, increment))
+	}
+	for name := range op.removeCounters {
+		rv = append(rv, fmt.Sprintf("%s%s: remove counter", prefix, name))
+	}
+	for name, values := range op.addToSets {
+		rv = append(rv, fmt.Sprintf("%s%s: add %q to set", prefix, name, values))
+	}
+	for name, values := range op.removeFromSets {
+		rv = append(rv, fmt.Sprintf("%s%s: remove %q from set", prefix, name, values))
+	}
+	for name := range op.removeSets {
+		rv = append(rv, fmt.Sprintf("%s%s: remove set", prefix, name))
+	}
+	for name, value := range op.registersToSet {
+		rv = append(rv, fmt.Sprintf("%s%s: set register to %q", prefix, name, value))
+	}
+	for name := range op.removeRegisters {
+		rv = append(rv, fmt.Sprintf("%s%s: remove register", prefix, name))
+	}
+	for name, value := range op.flagsToSet {
+		rv = append(rv, fmt.Sprintf("%s%s: set flag to %v", prefix, name, value))
+	}
+	for name := range op.removeFlags {
+		rv = append(rv, fmt.Sprintf("%s%s: remove flag", prefix, name))
+	}
+	for name, nested := range op.maps {
+		rv = append(rv, fmt.Sprintf("%s%s: update map", prefix, name))
+		rv = append(rv, describeMapOperation(nested, prefix+name+".")...)
+	}
+	for name := range op.removeMaps {
+		rv = append(rv, fmt.Sprintf("%s%s: remove map", prefix, name))
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+// describePbMapOp returns the sorted list of changes made by a MapOp, in the
+// terms of describeMapOperation
+func describePbMapOp(pbMapOp *rpbRiakDT.MapOp, prefix string) []string {
+	var rv []string
+	kinds := map[rpbRiakDT.MapField_MapFieldType]string{
+		rpbRiakDT.MapField_COUNTER:  "counter",
+		rpbRiakDT.MapField_SET:      "set",
+		rpbRiakDT.MapField_REGISTER: "register",
+		rpbRiakDT.MapField_FLAG:     "flag",
+		rpbRiakDT.MapField_MAP:      "map",
+	}
+	for _, field := range pbMapOp.Removes {
+		rv = append(rv, fmt.Sprintf("%s%s: remove %s", prefix, field.Name, kinds[field.GetType()]))
+	}
+	for _, update := range pbMapOp.Updates {
+		name := string(update.Field.Name)
+		switch update.Field.GetType() {
+		case rpbRiakDT.MapField_COUNTER:
+			rv = append(rv, fmt.Sprintf("%s%s: increment counter by %d", prefix, name, update.CounterOp.GetIncrement()))
+		case rpbRiakDT.MapField_SET:
+			if len(update.SetOp.Adds) > 0 {
+				rv = append(rv, fmt.Sprintf("%s%s: add %q to set", prefix, name, update.SetOp.Adds))
+			}
+			if len(update.SetOp.Removes) > 0 {
+				rv = append(rv, fmt.Sprintf("%s%s: remove %q from set", prefix, name, update.SetOp.Removes))
+			}
+		case rpbRiakDT.MapField_REGISTER:
+			rv = append(rv, fmt.Sprintf("%s%s: set register to %q", prefix, name, update.RegisterOp))
+		case rpbRiakDT.MapField_FLAG:
+			rv = append(rv, fmt.Sprintf("%s%s: set flag to %v", prefix, name, update.GetFlagOp() == rpbRiakDT.MapUpdate_ENABLE))
+		case rpbRiakDT.MapField_MAP:
+			rv = append(rv, fmt.Sprintf("%s%s: update map", prefix, name))
+			rv = append(rv, describePbMapOp(update.MapOp, prefix+name+".")...)
+		}
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+func TestMapOperationPopulatesMapOp(t *testing.T) {
+	populates := func(q quickMapOperation) bool {
+		pbMapOp := &rpbRiakDT.MapOp{}
+		populate(q.MapOperation, pbMapOp)
+		expected := describeMapOperation(q.MapOperation, "")
+		actual := describePbMapOp(pbMapOp, "")
+		if len(expected) == 0 && len(actual) == 0 {
+			return true
+		}
+		return reflect.DeepEqual(expected, actual)
+	}
+	if err := quick.Check(populates, nil); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,815 @@
+package riak
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	rpbRiak "github.com/basho/riak-go-client/rpb/riak"
+)
+
+// Spec declares search schemas, search indexes and bucket properties, which a
+// Reconciler compares with a cluster and applies. A Spec is built in Go or
+// loaded from JSON with LoadSpec or LoadSpecFile:
+//
+//	{
+//	    "schemas": [{"name": "users", "file": "users_schema.xml"}],
+//	    "indexes": [{"name": "users", "schema": "users"}],
+//	    "buckets": [
+//	        {"bucket_type": "maps", "props": {"n_val": 5, "w": "quorum"}},
+//	        {"bucket_type": "maps", "bucket": "users", "search_index": "users"}
+//	    ]
+//	}
+//
+// Other formats, such as YAML, are loaded with LoadSpecWith or LoadSpecFileWith
+// and the unmarshal function of a library for the format, with the same field
+// names and values:
+//
+//	spec, err := riak.LoadSpecFileWith("spec.yaml", yaml.Unmarshal)
+type Spec struct {
+	Schemas []*SchemaSpec `json:"schemas"`
+	Indexes []*IndexSpec  `json:"indexes"`
+	Buckets []*BucketSpec `json:"buckets"`
+}
+
+// SchemaSpec declares a search schema. LoadSpecFile reads the Content from
+// File, relative to the spec file, if File is set.
+type SchemaSpec struct {
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	File    string `json:"file"`
+}
+
+// IndexSpec declares a search index. An empty Schema means the default schema
+// and an NVal of zero the default n_val. Since Riak can not change the schema
+// or n_val of an index, an existing index that differs is an error.
+type IndexSpec struct {
+	Name   string `json:"name"`
+	Schema string `json:"schema"`
+	NVal   uint32 `json:"n_val"`
+}
+
+// defaultSchemaName is the schema of indexes created without one
+const defaultSchemaName = "_yz_default"
+
+// BucketSpec declares the properties of a bucket type if Bucket is empty, or
+// of a bucket. Bucket types must already exist, see riak-admin bucket-type.
+// SearchIndex associates the bucket or bucket type with a search index, once
+// the index is visible.
+type BucketSpec struct {
+	BucketType  string                `json:"bucket_type"`
+	Bucket      string                `json:"bucket"`
+	Properties  *BucketPropertiesSpec `json:"props"`
+	SearchIndex string                `json:"search_index"`
+}
+
+func (b *BucketSpec) name() string {
+	bucketType := b.BucketType
+	if bucketType == "" {
+		bucketType = defaultBucketType
+	}
+	if b.Bucket == "" {
+		return bucketType
+	}
+	return bucketType + "/" + b.Bucket
+}
+
+// QuorumValue is a quorum bucket property in a BucketPropertiesSpec. In JSON
+// it is either a number of nodes or one of "one", "quorum", "all" and
+// "default".
+type QuorumValue uint32
+
+// UnmarshalJSON decodes a number of nodes or a symbolic quorum value
+func (q *QuorumValue) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		switch name {
+		case "one":
+			*q = QuorumValue(QuorumOne)
+		case "quorum":
+			*q = QuorumValue(QuorumQuorum)
+		case "all":
+			*q = QuorumValue(QuorumAll)
+		case "default":
+			*q = QuorumValue(QuorumDefault)
+		default:
+			return newClientError(fmt.Sprintf("[Reconciler] invalid quorum value '%s'", name))
+		}
+		return nil
+	}
+	var n uint32
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	*q = QuorumValue(n)
+	return nil
+}
+
+// ReplValue is the repl bucket property in a BucketPropertiesSpec. In JSON it
+// is one of "false", "realtime", "fullsync" and "true", or a boolean.
+type ReplValue ReplMode
+
+// UnmarshalJSON decodes a replication mode name or a boolean
+func (v *ReplValue) UnmarshalJSON(data []byte) error {
+	var enabled bool
+	if err := json.Unmarshal(data, &enabled); err == nil {
+		if enabled {
+			*v = ReplValue(TRUE)
+		} else {
+			*v = ReplValue(FALSE)
+		}
+		return nil
+	}
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return err
+	}
+	for _, mode := range []ReplMode{FALSE, REALTIME, FULLSYNC, TRUE} {
+		if mode.String() == name {
+			*v = ReplValue(mode)
+			return nil
+		}
+	}
+	return newClientError(fmt.Sprintf("[Reconciler] invalid repl value '%s'", name))
+}
+
+// BucketPropertiesSpec declares bucket properties. Nil properties are left
+// unchanged, and non-nil commit hooks replace the current ones, so an empty
+// list removes them.
+type BucketPropertiesSpec struct {
+	NVal          *uint32       `json:"n_val"`
+	AllowMult     *bool         `json:"allow_mult"`
+	LastWriteWins *bool         `json:"last_write_wins"`
+	OldVClock     *uint32       `json:"old_vclock"`
+	YoungVClock   *uint32       `json:"young_vclock"`
+	BigVClock     *uint32       `json:"big_vclock"`
+	SmallVClock   *uint32       `json:"small_vclock"`
+	R             *QuorumValue  `json:"r"`
+	Pr            *QuorumValue  `json:"pr"`
+	W             *QuorumValue  `json:"w"`
+	Pw            *QuorumValue  `json:"pw"`
+	Dw            *QuorumValue  `json:"dw"`
+	Rw            *QuorumValue  `json:"rw"`
+	BasicQuorum   *bool         `json:"basic_quorum"`
+	NotFoundOk    *bool         `json:"notfound_ok"`
+	Search        *bool         `json:"search"`
+	Consistent    *bool         `json:"consistent"`
+	WriteOnce     *bool         `json:"write_once"`
+	Repl          *ReplValue    `json:"repl"`
+	Backend       *string       `json:"backend"`
+	DataType      *string       `json:"datatype"`
+	PreCommit     []*CommitHook `json:"precommit"`
+	PostCommit    []*CommitHook `json:"postcommit"`
+	ChashKeyFun   *ModFun       `json:"chash_keyfun"`
+	LinkFun       *ModFun       `json:"linkfun"`
+}
+
+// apply sets the declared properties on props
+func (spec *BucketPropertiesSpec) apply(props *BucketProperties) {
+	setUint32 := func(dst *uint32, src *uint32) {
+		if src != nil {
+			*dst = *src
+		}
+	}
+	setQuorum := func(dst *uint32, src *QuorumValue) {
+		if src != nil {
+			*dst = uint32(*src)
+		}
+	}
+	setBool := func(dst *bool, src *bool) {
+		if src != nil {
+			*dst = *src
+		}
+	}
+	setUint32(&props.NVal, spec.NVal)
+	setBool(&props.AllowMult, spec.AllowMult)
+	setBool(&props.LastWriteWins, spec.LastWriteWins)
+	setUint32(&props.OldVClock, spec.OldVClock)
+	setUint32(&props.YoungVClock, spec.YoungVClock)
+	setUint32(&props.BigVClock, spec.BigVClock)
+	setUint32(&props.SmallVClock, spec.SmallVClock)
+	setQuorum(&props.R, spec.R)
+	setQuorum(&props.Pr, spec.Pr)
+	setQuorum(&props.W, spec.W)
+	setQuorum(&props.Pw, spec.Pw)
+	setQuorum(&props.Dw, spec.Dw)
+	setQuorum(&props.Rw, spec.Rw)
+	setBool(&props.BasicQuorum, spec.BasicQuorum)
+	setBool(&props.NotFoundOk, spec.NotFoundOk)
+	setBool(&props.Search, spec.Search)
+	setBool(&props.Consistent, spec.Consistent)
+	setBool(&props.WriteOnce, spec.WriteOnce)
+	if spec.Repl != nil {
+		props.Repl = ReplMode(*spec.Repl)
+	}
+	if spec.Backend != nil {
+		props.Backend = *spec.Backend
+	}
+	if spec.DataType != nil {
+		props.DataType = *spec.DataType
+	}
+	if spec.PreCommit != nil {
+		props.PreCommit = spec.PreCommit
+	}
+	if spec.PostCommit != nil {
+		props.PostCommit = spec.PostCommit
+	}
+	if spec.ChashKeyFun != nil {
+		props.ChashKeyFun = spec.ChashKeyFun
+	}
+	if spec.LinkFun != nil {
+		props.LinkFun = spec.LinkFun
+	}
+}
+
+// LoadSpec decodes a JSON Spec. Unknown fields are an error, so that typos in
+// property names are not silently ignored.
+func LoadSpec(r io.Reader) (*Spec, error) {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	spec := &Spec{}
+	if err := decoder.Decode(spec); err != nil {
+		return nil, newClientError(fmt.Sprintf("[Reconciler] invalid spec: %v", err))
+	}
+	return spec, nil
+}
+
+// LoadSpecWith decodes a Spec with unmarshal, such as yaml.Unmarshal of a YAML
+// library. The document is unmarshalled into generic values, which are then
+// decoded like JSON by LoadSpec. If unmarshal is nil, the Spec is JSON.
+func LoadSpecWith(r io.Reader, unmarshal func([]byte, interface{}) error) (*Spec, error) {
+	if unmarshal == nil {
+		return LoadSpec(r)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	if err = unmarshal(data, &doc); err != nil {
+		return nil, newClientError(fmt.Sprintf("[Reconciler] invalid spec: %v", err))
+	}
+	if doc, err = jsonValue(doc); err != nil {
+		return nil, newClientError(fmt.Sprintf("[Reconciler] invalid spec: %v", err))
+	}
+	if data, err = json.Marshal(doc); err != nil {
+		return nil, newClientError(fmt.Sprintf("[Reconciler] invalid spec: %v", err))
+	}
+	return LoadSpec(bytes.NewReader(data))
+}
+
+// jsonValue converts an unmarshalled value to one that encoding/json can
+// marshal. YAML libraries unmarshal mappings into maps with interface{} keys.
+func jsonValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			name, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("key %v is not a string", key)
+			}
+			converted, err := jsonValue(value)
+			if err != nil {
+				return nil, err
+			}
+			m[name] = converted
+		}
+		return m, nil
+	case map[string]interface{}:
+		for key, value := range v {
+			converted, err := jsonValue(value)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = converted
+		}
+		return v, nil
+	case []interface{}:
+		for i, value := range v {
+			converted, err := jsonValue(value)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = converted
+		}
+		return v, nil
+	}
+	return v, nil
+}
+
+// LoadSpecFile reads a JSON Spec from a file, and the content of schemas from
+// their File
+func LoadSpecFile(path string) (*Spec, error) {
+	return LoadSpecFileWith(path, nil)
+}
+
+// LoadSpecFileWith reads a Spec from a file with unmarshal, see LoadSpecWith,
+// and the content of schemas from their File
+func LoadSpecFileWith(path string, unmarshal func([]byte, interface{}) error) (*Spec, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	spec, err := LoadSpecWith(f, unmarshal)
+	if err != nil {
+		return nil, err
+	}
+	for _, schema := range spec.Schemas {
+		if schema.File == "" {
+			continue
+		}
+		if schema.Content != "" {
+			return nil, newClientError(fmt.Sprintf("[Reconciler] schema %s has both content and file", schema.Name))
+		}
+		file := schema.File
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(filepath.Dir(path), file)
+		}
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		schema.Content = string(content)
+	}
+	return spec, nil
+}
+
+// ReconcileAction is the kind of change made by a ReconcileStep
+type ReconcileAction int
+
+// Reconcile actions
+const (
+	CreateSchema ReconcileAction = iota
+	UpdateSchema
+	CreateIndex
+	UpdateBucketTypeProps
+	UpdateBucketProps
+)
+
+func (a ReconcileAction) String() string {
+	switch a {
+	case CreateSchema:
+		return "create schema"
+	case UpdateSchema:
+		return "update schema"
+	case CreateIndex:
+		return "create index"
+	case UpdateBucketTypeProps:
+		return "update bucket type"
+	case UpdateBucketProps:
+		return "update bucket"
+	default:
+		return fmt.Sprintf("ReconcileAction(%d)", int(a))
+	}
+}
+
+// ReconcileStep is a change needed to bring a cluster in line with a Spec.
+// Name is the schema or index name, the bucket type, or the bucket type and
+// bucket separated by a slash. Changes lists the bucket properties that change.
+type ReconcileStep struct {
+	Action  ReconcileAction
+	Name    string
+	Changes []BucketPropertyChange
+
+	schema *SchemaSpec
+	index  *IndexSpec
+	bucket *BucketSpec
+}
+
+func (s *ReconcileStep) String() string {
+	if len(s.Changes) == 0 {
+		return fmt.Sprintf("%v %s", s.Action, s.Name)
+	}
+	changes := make([]string, len(s.Changes))
+	for i, change := range s.Changes {
+		changes[i] = change.String()
+	}
+	return fmt.Sprintf("%v %s (%s)", s.Action, s.Name, strings.Join(changes, ", "))
+}
+
+// ReconcilePlan is the list of steps a Reconciler applies, in order: schemas,
+// then indexes, then bucket types and buckets
+type ReconcilePlan struct {
+	Steps []*ReconcileStep
+}
+
+// Empty reports whether the cluster already matches the Spec
+func (p *ReconcilePlan) Empty() bool {
+	return len(p.Steps) == 0
+}
+
+func (p *ReconcilePlan) String() string {
+	steps := make([]string, len(p.Steps))
+	for i, step := range p.Steps {
+		steps[i] = step.String()
+	}
+	return strings.Join(steps, "\n")
+}
+
+// ReconcilerOptions configures a Reconciler
+type ReconcilerOptions struct {
+	// IndexTimeout bounds the wait for a new search index to become visible.
+	// If zero, 45 seconds is used. With a Cluster, the index must be visible
+	// on every available node, see Cluster.ExecuteOnEachNode; other executors
+	// are only asked once.
+	IndexTimeout time.Duration
+	// PollInterval is the time between checks for a new search index. If zero,
+	// 1 second is used.
+	PollInterval time.Duration
+}
+
+const (
+	defaultReconcilerIndexTimeout = 45 * time.Second
+	defaultReconcilerPollInterval = time.Second
+)
+
+// Reconciler compares a Spec with the state of a cluster, plans the changes
+// needed and applies them. Applying a plan is idempotent: planning again
+// afterwards returns an empty plan.
+//
+//	reconciler := riak.NewReconciler(cluster, spec, nil)
+//	plan, err := reconciler.Plan()
+//	if err != nil {
+//	    return err
+//	}
+//	fmt.Println(plan)
+//	err = reconciler.Apply(plan)
+//
+// New indexes are only associated with buckets once they are visible. When
+// the executor is a Cluster, an index is visible once every available node
+// returns it.
+type Reconciler struct {
+	executor     Executor
+	spec         *Spec
+	indexTimeout time.Duration
+	pollInterval time.Duration
+}
+
+// NewReconciler returns a Reconciler applying spec with the executor, usually
+// a Cluster. The options may be nil.
+func NewReconciler(executor Executor, spec *Spec, options *ReconcilerOptions) *Reconciler {
+	r := &Reconciler{
+		executor:     executor,
+		spec:         spec,
+		indexTimeout: defaultReconcilerIndexTimeout,
+		pollInterval: defaultReconcilerPollInterval,
+	}
+	if options != nil {
+		if options.IndexTimeout > 0 {
+			r.indexTimeout = options.IndexTimeout
+		}
+		if options.PollInterval > 0 {
+			r.pollInterval = options.PollInterval
+		}
+	}
+	return r
+}
+
+// isNotFound reports whether err is the error Riak returns for a missing
+// search index or schema
+func isNotFound(err error) bool {
+	riakErr, ok := err.(RiakError)
+	return ok && strings.Contains(riakErr.Errmsg, "notfound")
+}
+
+// Reconcile plans and applies the changes, returning the applied plan
+func (r *Reconciler) Reconcile() (*ReconcilePlan, error) {
+	plan, err := r.Plan()
+	if err != nil {
+		return nil, err
+	}
+	return plan, r.Apply(plan)
+}
+
+// Plan compares the Spec with the cluster and returns the changes needed
+func (r *Reconciler) Plan() (*ReconcilePlan, error) {
+	plan := &ReconcilePlan{}
+	for _, schema := range r.spec.Schemas {
+		step, err := r.planSchema(schema)
+		if err != nil {
+			return nil, err
+		}
+		if step != nil {
+			plan.Steps = append(plan.Steps, step)
+		}
+	}
+	for _, index := range r.spec.Indexes {
+		step, err := r.planIndex(index)
+		if err != nil {
+			return nil, err
+		}
+		if step != nil {
+			plan.Steps = append(plan.Steps, step)
+		}
+	}
+	for _, bucket := range r.spec.Buckets {
+		step, err := r.planBucket(bucket)
+		if err != nil {
+			return nil, err
+		}
+		if step != nil {
+			plan.Steps = append(plan.Steps, step)
+		}
+	}
+	return plan, nil
+}
+
+func (r *Reconciler) planSchema(schema *SchemaSpec) (*ReconcileStep, error) {
+	if schema.Name == "" || schema.Content == "" {
+		return nil, newClientError("[Reconciler] schema requires a name and content")
+	}
+	cmd, err := NewFetchSchemaCommandBuilder().WithSchemaName(schema.Name).Build()
+	if err != nil {
+		return nil, err
+	}
+	err = r.executor.Execute(cmd)
+	current := cmd.(*FetchSchemaCommand).Response
+	switch {
+	case isNotFound(err) || (err == nil && current == nil):
+		return &ReconcileStep{Action: CreateSchema, Name: schema.Name, schema: schema}, nil
+	case err != nil:
+		return nil, err
+	case current.Content != schema.Content:
+		return &ReconcileStep{Action: UpdateSchema, Name: schema.Name, schema: schema}, nil
+	}
+	return nil, nil
+}
+
+func (r *Reconciler) planIndex(index *IndexSpec) (*ReconcileStep, error) {
+	if index.Name == "" {
+		return nil, newClientError("[Reconciler] index requires a name")
+	}
+	current, err := r.fetchIndex(index.Name)
+	if err != nil {
+		return nil, err
+	}
+	if current == nil {
+		return &ReconcileStep{Action: CreateIndex, Name: index.Name, index: index}, nil
+	}
+	schema := index.Schema
+	if schema == "" {
+		schema = defaultSchemaName
+	}
+	if current.Schema != schema {
+		return nil, newClientError(fmt.Sprintf("[Reconciler] index %s uses schema %s, not %s, and can not be changed", index.Name, current.Schema, schema))
+	}
+	if index.NVal != 0 && current.NVal != index.NVal {
+		return nil, newClientError(fmt.Sprintf("[Reconciler] index %s has n_val %d, not %d, and can not be changed", index.Name, current.NVal, index.NVal))
+	}
+	return nil, nil
+}
+
+// fetchIndex returns the index, or nil if it does not exist
+func (r *Reconciler) fetchIndex(name string) (*SearchIndex, error) {
+	cmd, err := NewFetchIndexCommandBuilder().WithIndexName(name).Build()
+	if err != nil {
+		return nil, err
+	}
+	err = r.executor.Execute(cmd)
+	return fetchedIndex(cmd.(*FetchIndexCommand), err)
+}
+
+func fetchedIndex(cmd *FetchIndexCommand, err error) (*SearchIndex, error) {
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(cmd.Response) == 0 {
+		return nil, nil
+	}
+	return cmd.Response[0], nil
+}
+
+func (r *Reconciler) planBucket(bucket *BucketSpec) (*ReconcileStep, error) {
+	if bucket.BucketType == "" && bucket.Bucket == "" {
+		return nil, newClientError("[Reconciler] bucket spec requires a bucket type or a bucket")
+	}
+	current, err := r.fetchBucketProperties(bucket)
+	if err != nil {
+		return nil, err
+	}
+	desired := *current
+	if bucket.Properties != nil {
+		bucket.Properties.apply(&desired)
+	}
+	if bucket.SearchIndex != "" {
+		desired.SearchIndex = bucket.SearchIndex
+	}
+	changes := Diff(current, &desired)
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	action := UpdateBucketProps
+	if bucket.Bucket == "" {
+		action = UpdateBucketTypeProps
+	}
+	return &ReconcileStep{Action: action, Name: bucket.name(), Changes: changes, bucket: bucket}, nil
+}
+
+func (r *Reconciler) fetchBucketProperties(bucket *BucketSpec) (*BucketProperties, error) {
+	if bucket.Bucket == "" {
+		cmd, err := NewFetchBucketTypePropsCommandBuilder().WithBucketType(bucket.BucketType).Build()
+		if err != nil {
+			return nil, err
+		}
+		if err = r.executor.Execute(cmd); err != nil {
+			return nil, err
+		}
+		if response := cmd.(*FetchBucketTypePropsCommand).Response; response != nil {
+			return &response.BucketProperties, nil
+		}
+	} else {
+		cmd, err := NewFetchBucketPropsCommandBuilder().
+			WithBucketType(bucket.BucketType).
+			WithBucket(bucket.Bucket).
+			Build()
+		if err != nil {
+			return nil, err
+		}
+		if err = r.executor.Execute(cmd); err != nil {
+			return nil, err
+		}
+		if response := cmd.(*FetchBucketPropsCommand).Response; response != nil {
+			return &response.BucketProperties, nil
+		}
+	}
+	return nil, newClientError(fmt.Sprintf("[Reconciler] no properties returned for %s", bucket.name()))
+}
+
+// Apply applies the steps of the plan in order. Buckets are only updated once
+// every index created by the plan is visible.
+func (r *Reconciler) Apply(plan *ReconcilePlan) error {
+	var created []string
+	waited := false
+	for _, step := range plan.Steps {
+		var cmd Command
+		var err error
+		switch step.Action {
+		case CreateSchema, UpdateSchema:
+			cmd, err = NewStoreSchemaCommandBuilder().
+				WithSchemaName(step.schema.Name).
+				WithSchema(step.schema.Content).
+				Build()
+		case CreateIndex:
+			builder := NewStoreIndexCommandBuilder().WithIndexName(step.index.Name)
+			if step.index.Schema != "" {
+				builder.WithSchemaName(step.index.Schema)
+			}
+			if step.index.NVal != 0 {
+				builder.WithNVal(step.index.NVal)
+			}
+			cmd, err = builder.Build()
+			created = append(created, step.index.Name)
+		case UpdateBucketTypeProps, UpdateBucketProps:
+			if !waited {
+				if err = r.waitForIndexes(created); err != nil {
+					return err
+				}
+				waited = true
+			}
+			cmd, err = step.bucket.buildStoreCommand()
+		default:
+			err = newClientError(fmt.Sprintf("[Reconciler] unknown action %v", step.Action))
+		}
+		if err != nil {
+			return err
+		}
+		logDebug("[Reconciler]", "%v", step)
+		if err = r.executor.Execute(cmd); err != nil {
+			return err
+		}
+	}
+	if !waited {
+		return r.waitForIndexes(created)
+	}
+	return nil
+}
+
+func (b *BucketSpec) buildStoreCommand() (Command, error) {
+	if b.Bucket == "" {
+		builder := NewStoreBucketTypePropsCommandBuilder().WithBucketType(b.BucketType)
+		b.setRpbBucketProps(builder.props)
+		return builder.Build()
+	}
+	builder := NewStoreBucketPropsCommandBuilder().
+		WithBucketType(b.BucketType).
+		WithBucket(b.Bucket)
+	b.setRpbBucketProps(builder.props)
+	return builder.Build()
+}
+
+// setRpbBucketProps sets the declared properties, and only those, so that
+// buckets keep inheriting the other properties from their bucket type
+func (b *BucketSpec) setRpbBucketProps(rpbBucketProps *rpbRiak.RpbBucketProps) {
+	if b.SearchIndex != "" {
+		rpbBucketProps.SearchIndex = []byte(b.SearchIndex)
+	}
+	spec := b.Properties
+	if spec == nil {
+		return
+	}
+	rpbBucketProps.NVal = spec.NVal
+	rpbBucketProps.AllowMult = spec.AllowMult
+	rpbBucketProps.LastWriteWins = spec.LastWriteWins
+	rpbBucketProps.OldVclock = spec.OldVClock
+	rpbBucketProps.YoungVclock = spec.YoungVClock
+	rpbBucketProps.BigVclock = spec.BigVClock
+	rpbBucketProps.SmallVclock = spec.SmallVClock
+	rpbBucketProps.R = (*uint32)(spec.R)
+	rpbBucketProps.Pr = (*uint32)(spec.Pr)
+	rpbBucketProps.W = (*uint32)(spec.W)
+	rpbBucketProps.Pw = (*uint32)(spec.Pw)
+	rpbBucketProps.Dw = (*uint32)(spec.Dw)
+	rpbBucketProps.Rw = (*uint32)(spec.Rw)
+	rpbBucketProps.BasicQuorum = spec.BasicQuorum
+	rpbBucketProps.NotfoundOk = spec.NotFoundOk
+	rpbBucketProps.Search = spec.Search
+	rpbBucketProps.Consistent = spec.Consistent
+	rpbBucketProps.WriteOnce = spec.WriteOnce
+	if spec.Repl != nil {
+		repl := rpbRiak.RpbBucketProps_RpbReplMode(*spec.Repl)
+		rpbBucketProps.Repl = &repl
+	}
+	if spec.Backend != nil {
+		rpbBucketProps.Backend = []byte(*spec.Backend)
+	}
+	if spec.DataType != nil {
+		rpbBucketProps.Datatype = []byte(*spec.DataType)
+	}
+	if spec.PreCommit != nil {
+		hasPrecommit := true
+		rpbBucketProps.HasPrecommit = &hasPrecommit
+		rpbBucketProps.Precommit = toRpbCommitHooks(spec.PreCommit)
+	}
+	if spec.PostCommit != nil {
+		hasPostcommit := true
+		rpbBucketProps.HasPostcommit = &hasPostcommit
+		rpbBucketProps.Postcommit = toRpbCommitHooks(spec.PostCommit)
+	}
+	if spec.ChashKeyFun != nil {
+		rpbBucketProps.ChashKeyfun = toRpbModFun(spec.ChashKeyFun)
+	}
+	if spec.LinkFun != nil {
+		rpbBucketProps.Linkfun = toRpbModFun(spec.LinkFun)
+	}
+}
+
+func (r *Reconciler) waitForIndexes(names []string) error {
+	for _, name := range names {
+		deadline := time.Now().Add(r.indexTimeout)
+		for {
+			visible, err := r.indexVisible(name)
+			if err != nil {
+				return err
+			}
+			if visible {
+				break
+			}
+			if time.Now().After(deadline) {
+				return newClientError(fmt.Sprintf("[Reconciler] index %s is not visible after %v", name, r.indexTimeout))
+			}
+			time.Sleep(r.pollInterval)
+		}
+	}
+	return nil
+}
+
+// eachNodeExecutor is implemented by executors of several nodes, such as
+// Cluster, that can execute a command on each of them
+type eachNodeExecutor interface {
+	ExecuteOnEachNode(newCommand func() (Command, error), done func(cmd Command, err error) error) error
+}
+
+// indexVisible reports whether the index exists. If the executor can execute
+// commands on each node, every available node must return it, since indexes
+// are created asynchronously, and it is not visible while no node is
+// available.
+func (r *Reconciler) indexVisible(name string) (bool, error) {
+	executor, ok := r.executor.(eachNodeExecutor)
+	if !ok {
+		index, err := r.fetchIndex(name)
+		return index != nil, err
+	}
+	answered, visible := 0, 0
+	err := executor.ExecuteOnEachNode(func() (Command, error) {
+		return NewFetchIndexCommandBuilder().WithIndexName(name).Build()
+	}, func(cmd Command, err error) error {
+		answered++
+		index, err := fetchedIndex(cmd.(*FetchIndexCommand), err)
+		if index != nil {
+			visible++
+		}
+		return err
+	})
+	return err == nil && answered > 0 && visible == answered, err
+}
@@ -0,0 +1,331 @@
+package riak
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var errIndexNotFound = RiakError{Errmsg: "notfound"}
+
+func TestLoadSpecFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "riak-spec")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "schema.xml"), []byte("<schema/>"), 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+	specFile := filepath.Join(dir, "spec.json")
+	if err := ioutil.WriteFile(specFile, []byte(`{
+		"schemas": [{"name": "users", "file": "schema.xml"}],
+		"indexes": [{"name": "users", "schema": "users", "n_val": 3}],
+		"buckets": [{"bucket_type": "maps", "props": {"n_val": 5, "w": "quorum", "dw": 2, "repl": "realtime", "precommit": []}}]
+	}`), 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+	spec, err := LoadSpecFile(specFile)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := "<schema/>", spec.Schemas[0].Content; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := (IndexSpec{Name: "users", Schema: "users", NVal: 3}), *spec.Indexes[0]; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	props := spec.Buckets[0].Properties
+	if expected, actual := QuorumValue(QuorumQuorum), *props.W; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := QuorumValue(2), *props.Dw; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := ReplValue(REALTIME), *props.Repl; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if props.PreCommit == nil || len(props.PreCommit) != 0 {
+		t.Errorf("expected empty precommit, got %v", props.PreCommit)
+	}
+	if props.R != nil {
+		t.Error("expected r to be unset")
+	}
+
+	for _, invalid := range []string{
+		`{"buckets": [{"bucket_type": "maps", "props": {"nval": 5}}]}`,
+		`{"buckets": [{"bucket_type": "maps", "props": {"w": "most"}}]}`,
+		`{"buckets": [{"bucket_type": "maps", "props": {"repl": "sometimes"}}]}`,
+	} {
+		if _, err := LoadSpec(strings.NewReader(invalid)); err == nil {
+			t.Errorf("%v: expected error", invalid)
+		}
+	}
+}
+
+// yamlUnmarshal stands in for the Unmarshal function of a YAML library, which
+// unmarshals mappings into maps with interface{} keys and integers into ints
+func yamlUnmarshal(data []byte, v interface{}) error {
+	var doc interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return err
+	}
+	var convert func(interface{}) interface{}
+	convert = func(v interface{}) interface{} {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			m := make(map[interface{}]interface{}, len(v))
+			for key, value := range v {
+				m[key] = convert(value)
+			}
+			return m
+		case []interface{}:
+			for i, value := range v {
+				v[i] = convert(value)
+			}
+		case json.Number:
+			n, _ := v.Int64()
+			return int(n)
+		}
+		return v
+	}
+	*(v.(*interface{})) = convert(doc)
+	return nil
+}
+
+func TestLoadSpecWith(t *testing.T) {
+	spec, err := LoadSpecWith(strings.NewReader(`{
+		"indexes": [{"name": "users", "n_val": 3}],
+		"buckets": [{"bucket_type": "maps", "props": {"n_val": 5, "w": "quorum", "repl": true}}]
+	}`), yamlUnmarshal)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := (IndexSpec{Name: "users", NVal: 3}), *spec.Indexes[0]; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	props := spec.Buckets[0].Properties
+	if expected, actual := uint32(5), *props.NVal; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := QuorumValue(QuorumQuorum), *props.W; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := ReplValue(TRUE), *props.Repl; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	if _, err := LoadSpecWith(strings.NewReader(`{"buckets": [{"bucket_type": "maps", "props": {"nval": 5}}]}`), yamlUnmarshal); err == nil {
+		t.Error("expected error")
+	}
+	if _, err := LoadSpecWith(strings.NewReader(`[1, 2]`), func(data []byte, v interface{}) error {
+		*(v.(*interface{})) = map[interface{}]interface{}{1: "one"}
+		return nil
+	}); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestReconcilerPlansAndAppliesChanges(t *testing.T) {
+	nVal := uint32(5)
+	w := QuorumValue(QuorumAll)
+	spec := &Spec{
+		Schemas: []*SchemaSpec{{Name: "users", Content: "<schema/>"}},
+		Indexes: []*IndexSpec{{Name: "users", Schema: "users"}},
+		Buckets: []*BucketSpec{
+			{BucketType: "maps", Properties: &BucketPropertiesSpec{NVal: &nVal, W: &w}},
+			{BucketType: "maps", Bucket: "users", SearchIndex: "users"},
+		},
+	}
+	mock := NewMockExecutor()
+	mock.On(&FetchSchemaCommand{}).ReturnError(errIndexNotFound)
+	mock.On(&FetchIndexCommand{}).Times(2).ReturnError(errIndexNotFound)
+	mock.On(&FetchIndexCommand{}).Respond([]*SearchIndex{{Name: "users", Schema: "users", NVal: 3}})
+	mock.On(&FetchBucketTypePropsCommand{}).Respond(&FetchBucketPropsResponse{
+		BucketProperties: BucketProperties{NVal: 3, W: QuorumQuorum},
+	})
+	mock.On(&FetchBucketPropsCommand{}).Respond(&FetchBucketPropsResponse{
+		BucketProperties: BucketProperties{NVal: 3, W: QuorumQuorum},
+	})
+	mock.On(&StoreSchemaCommand{})
+	mock.On(&StoreIndexCommand{})
+	mock.On(&StoreBucketTypePropsCommand{})
+	mock.On(&StoreBucketPropsCommand{})
+
+	reconciler := NewReconciler(mock, spec, &ReconcilerOptions{PollInterval: time.Millisecond})
+	plan, err := reconciler.Plan()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expected := "create schema users\n" +
+		"create index users\n" +
+		"update bucket type maps (n_val: 3 -> 5, w: quorum -> all)\n" +
+		"update bucket maps/users (search_index: none -> users)"
+	if actual := plan.String(); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	mock.Reset()
+	mock.On(&FetchIndexCommand{}).Times(1).ReturnError(errIndexNotFound)
+	mock.On(&FetchIndexCommand{}).Respond([]*SearchIndex{{Name: "users", Schema: "users"}})
+	mock.On(&StoreSchemaCommand{})
+	mock.On(&StoreIndexCommand{})
+	mock.On(&StoreBucketTypePropsCommand{})
+	mock.On(&StoreBucketPropsCommand{})
+	if err := reconciler.Apply(plan); err != nil {
+		t.Fatal(err.Error())
+	}
+	var names []string
+	for _, cmd := range mock.Executed() {
+		names = append(names, cmd.Name())
+	}
+	// NB: the bucket is only associated once the index is visible
+	if expected, actual := []string{"StoreSchema", "StoreIndex", "FetchIndex", "FetchIndex", "StoreBucketTypeProps", "StoreBucketProps"}, names; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	executed := mock.Executed()
+	typeProps := executed[4].(*StoreBucketTypePropsCommand).protobuf.GetProps()
+	if expected, actual := uint32(5), typeProps.GetNVal(); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := QuorumAll, typeProps.GetW(); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	bucketProps := executed[5].(*StoreBucketPropsCommand).protobuf.GetProps()
+	if expected, actual := "users", string(bucketProps.GetSearchIndex()); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if bucketProps.NVal != nil {
+		t.Error("expected only declared properties to be stored")
+	}
+}
+
+func TestReconcilerTimesOutWaitingForIndex(t *testing.T) {
+	spec := &Spec{Indexes: []*IndexSpec{{Name: "users"}}}
+	mock := NewMockExecutor()
+	mock.On(&FetchIndexCommand{}).ReturnError(errIndexNotFound)
+	mock.On(&StoreIndexCommand{})
+	reconciler := NewReconciler(mock, spec, &ReconcilerOptions{
+		IndexTimeout: 10 * time.Millisecond,
+		PollInterval: time.Millisecond,
+	})
+	if _, err := reconciler.Reconcile(); err == nil || !strings.Contains(err.Error(), "index users is not visible") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+// unavailableNodesExecutor executes commands with a MockExecutor, but has no
+// available node to execute commands on each node
+type unavailableNodesExecutor struct {
+	*MockExecutor
+}
+
+func (e unavailableNodesExecutor) ExecuteOnEachNode(newCommand func() (Command, error), done func(cmd Command, err error) error) error {
+	return nil
+}
+
+func TestReconcilerWaitsForAvailableNodes(t *testing.T) {
+	spec := &Spec{Indexes: []*IndexSpec{{Name: "users"}}}
+	mock := NewMockExecutor()
+	mock.On(&FetchIndexCommand{}).ReturnError(errIndexNotFound)
+	mock.On(&StoreIndexCommand{})
+	reconciler := NewReconciler(unavailableNodesExecutor{mock}, spec, &ReconcilerOptions{
+		IndexTimeout: 10 * time.Millisecond,
+		PollInterval: time.Millisecond,
+	})
+	if _, err := reconciler.Reconcile(); err == nil || !strings.Contains(err.Error(), "index users is not visible") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestReconcilerRefusesToChangeIndexes(t *testing.T) {
+	spec := &Spec{Indexes: []*IndexSpec{{Name: "users", Schema: "users"}}}
+	mock := NewMockExecutor()
+	mock.On(&FetchIndexCommand{}).Respond([]*SearchIndex{{Name: "users", Schema: "_yz_default", NVal: 3}})
+	if _, err := NewReconciler(mock, spec, nil).Plan(); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestReconcilerIsIdempotent(t *testing.T) {
+	server, cluster := startTestServerCluster(t)
+	defer stopTestServerCluster(server, cluster)
+	if err := server.CreateBucketType("maps", "map"); err != nil {
+		t.Fatal(err.Error())
+	}
+	spec, err := LoadSpec(strings.NewReader(`{"buckets": [
+		{"bucket_type": "maps", "props": {"n_val": 5, "r": "one"}},
+		{"bucket_type": "maps", "bucket": "users", "props": {"allow_mult": false, "precommit": [{"name": "validate"}]}}
+	]}`))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	reconciler := NewReconciler(cluster, spec, nil)
+	plan, err := reconciler.Reconcile()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := 2, len(plan.Steps); expected != actual {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+	if plan, err = reconciler.Plan(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if !plan.Empty() {
+		t.Errorf("expected empty plan, got %v", plan)
+	}
+
+	cmd, err := NewFetchBucketPropsCommandBuilder().WithBucketType("maps").WithBucket("users").Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := cluster.Execute(cmd); err != nil {
+		t.Fatal(err.Error())
+	}
+	props := cmd.(*FetchBucketPropsCommand).Response
+	if expected, actual := uint32(5), props.NVal; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := QuorumOne, props.R; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := false, props.AllowMult; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestReconcilerManagesRepl(t *testing.T) {
+	spec, err := LoadSpec(strings.NewReader(`{"buckets": [{"bucket_type": "maps", "bucket": "users", "props": {"repl": true}}]}`))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	mock := NewMockExecutor()
+	mock.On(&FetchBucketPropsCommand{}).Respond(&FetchBucketPropsResponse{
+		BucketProperties: BucketProperties{Repl: REALTIME},
+	})
+	mock.On(&StoreBucketPropsCommand{})
+	reconciler := NewReconciler(mock, spec, nil)
+	plan, err := reconciler.Plan()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := "update bucket maps/users (repl: realtime -> true)", plan.String(); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if err := reconciler.Apply(plan); err != nil {
+		t.Fatal(err.Error())
+	}
+	executed := mock.Executed()
+	props := executed[len(executed)-1].(*StoreBucketPropsCommand).protobuf.GetProps()
+	if expected, actual := int32(TRUE), int32(props.GetRepl()); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
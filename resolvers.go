@@ -0,0 +1,258 @@
+package riak
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"sync/atomic"
+)
+
+// ConflictResolverFunc is an adapter to use an ordinary function as a
+// ConflictResolver
+type ConflictResolverFunc func([]*Object) []*Object
+
+// Resolve calls f(objs)
+func (f ConflictResolverFunc) Resolve(objs []*Object) []*Object {
+	return f(objs)
+}
+
+// newer returns whether a was modified after b. Objects modified at the same
+// time are ordered by vtag, so that every client picks the same object.
+func newer(a, b *Object) bool {
+	if !a.LastModified.Equal(b.LastModified) {
+		return a.LastModified.After(b.LastModified)
+	}
+	return a.VTag > b.VTag
+}
+
+// newest returns the most recently modified of the objects
+func newest(objs []*Object) *Object {
+	var o *Object
+	for _, obj := range objs {
+		if o == nil || newer(obj, o) {
+			o = obj
+		}
+	}
+	return o
+}
+
+// NewLastModifiedResolver returns a ConflictResolver keeping the most recently
+// modified sibling, tombstones included. Siblings modified at the same time
+// are ordered by vtag.
+func NewLastModifiedResolver() ConflictResolver {
+	return ConflictResolverFunc(func(objs []*Object) []*Object {
+		if len(objs) < 2 {
+			return objs
+		}
+		return []*Object{newest(objs)}
+	})
+}
+
+// NewVTagResolver returns a ConflictResolver keeping the sibling with the
+// greatest vtag. Vtags are random, so the choice is arbitrary but the same for
+// every client.
+func NewVTagResolver() ConflictResolver {
+	return ConflictResolverFunc(func(objs []*Object) []*Object {
+		if len(objs) < 2 {
+			return objs
+		}
+		o := objs[0]
+		for _, obj := range objs[1:] {
+			if obj.VTag > o.VTag {
+				o = obj
+			}
+		}
+		return []*Object{o}
+	})
+}
+
+// NewTombstoneResolver returns a ConflictResolver handling siblings created by
+// deletes before the resolver, which is only given live siblings. If
+// deleteWins is true, any tombstone wins over live siblings, so that concurrent
+// deletes and updates result in a delete. Otherwise tombstones are dropped
+// when there are live siblings. If all siblings are tombstones the newest one
+// is kept. The resolver may be nil to keep every live sibling.
+func NewTombstoneResolver(resolver ConflictResolver, deleteWins bool) ConflictResolver {
+	return ConflictResolverFunc(func(objs []*Object) []*Object {
+		live := make([]*Object, 0, len(objs))
+		tombstones := make([]*Object, 0, len(objs))
+		for _, obj := range objs {
+			if obj.IsTombstone {
+				tombstones = append(tombstones, obj)
+			} else {
+				live = append(live, obj)
+			}
+		}
+		if len(tombstones) > 0 && (deleteWins || len(live) == 0) {
+			return []*Object{newest(tombstones)}
+		}
+		if resolver == nil {
+			return live
+		}
+		return resolver.Resolve(live)
+	})
+}
+
+// MergeFunc merges two values decoded from siblings. It may modify and return
+// either value.
+type MergeFunc func(a, b interface{}) (interface{}, error)
+
+// NewMergeResolver returns a ConflictResolver decoding the live siblings with
+// the codecs of their content types into values returned by newValue, merging
+// them with merge and storing the result in a single object. The object has
+// the content type and metadata of the newest sibling. Tombstones are handled
+// as by NewTombstoneResolver without deleteWins, so they are dropped when there
+// are live siblings. If a sibling can not be decoded or merged, the live
+// siblings are returned unchanged.
+func NewMergeResolver(newValue func() interface{}, merge MergeFunc) ConflictResolver {
+	return NewTombstoneResolver(ConflictResolverFunc(func(objs []*Object) []*Object {
+		if len(objs) < 2 {
+			return objs
+		}
+		var merged interface{}
+		for i, obj := range objs {
+			v := newValue()
+			if err := obj.Decode(v); err != nil {
+				return objs
+			}
+			if i == 0 {
+				merged = v
+				continue
+			}
+			var err error
+			if merged, err = merge(merged, v); err != nil {
+				return objs
+			}
+		}
+		o := *newest(objs)
+		if err := o.Encode(merged); err != nil {
+			return objs
+		}
+		return []*Object{&o}
+	}), false)
+}
+
+// NewJSONSetUnionResolver returns a ConflictResolver for siblings whose values
+// are JSON arrays used as sets. The resolved value holds every element of the
+// siblings once, in order of first appearance with the oldest sibling first.
+func NewJSONSetUnionResolver() ConflictResolver {
+	merge := func(a, b interface{}) (interface{}, error) {
+		union := *(a.(*[]json.RawMessage))
+		seen := make(map[string]bool, len(union))
+		for _, element := range union {
+			seen[compactJSON(element)] = true
+		}
+		for _, element := range *(b.(*[]json.RawMessage)) {
+			if key := compactJSON(element); !seen[key] {
+				seen[key] = true
+				union = append(union, element)
+			}
+		}
+		return &union, nil
+	}
+	return oldestFirst(NewMergeResolver(func() interface{} { return &[]json.RawMessage{} }, merge))
+}
+
+// NewJSONFieldMergeResolver returns a ConflictResolver for siblings whose
+// values are JSON objects. The resolved object has every field of the
+// siblings, taken from the newest sibling having it. Fields that are objects
+// in every sibling having them are merged the same way.
+func NewJSONFieldMergeResolver() ConflictResolver {
+	merge := func(a, b interface{}) (interface{}, error) {
+		return mergeJSONObjects(*(a.(*map[string]json.RawMessage)), *(b.(*map[string]json.RawMessage)))
+	}
+	return oldestFirst(NewMergeResolver(func() interface{} { return &map[string]json.RawMessage{} }, merge))
+}
+
+// mergeJSONObjects merges the fields of b into a, b taking precedence
+func mergeJSONObjects(a, b map[string]json.RawMessage) (*map[string]json.RawMessage, error) {
+	if a == nil {
+		a = make(map[string]json.RawMessage, len(b))
+	}
+	for name, value := range b {
+		old, ok := a[name]
+		if ok && isJSONObject(old) && isJSONObject(value) {
+			var oldFields, fields map[string]json.RawMessage
+			if err := json.Unmarshal(old, &oldFields); err != nil {
+				return nil, err
+			}
+			if err := json.Unmarshal(value, &fields); err != nil {
+				return nil, err
+			}
+			merged, err := mergeJSONObjects(oldFields, fields)
+			if err != nil {
+				return nil, err
+			}
+			if value, err = json.Marshal(merged); err != nil {
+				return nil, err
+			}
+		}
+		a[name] = value
+	}
+	return &a, nil
+}
+
+func isJSONObject(data json.RawMessage) bool {
+	data = bytes.TrimSpace(data)
+	return len(data) > 0 && data[0] == '{'
+}
+
+// compactJSON returns data without insignificant space, to compare JSON values
+func compactJSON(data json.RawMessage) string {
+	buf := new(bytes.Buffer)
+	if err := json.Compact(buf, data); err != nil {
+		return string(data)
+	}
+	return buf.String()
+}
+
+// oldestFirst sorts the siblings from the oldest to the newest before the
+// resolver, so that merges give precedence to recent values
+func oldestFirst(resolver ConflictResolver) ConflictResolver {
+	return ConflictResolverFunc(func(objs []*Object) []*Object {
+		sorted := make([]*Object, len(objs))
+		copy(sorted, objs)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return newer(sorted[j], sorted[i])
+		})
+		return resolver.Resolve(sorted)
+	})
+}
+
+// CompositeResolver tries its resolvers in turn until there is at most one
+// sibling left, and counts the siblings it resolved
+type CompositeResolver struct {
+	resolvers []ConflictResolver
+	resolved  uint64
+	resolves  uint64
+}
+
+// NewCompositeResolver returns a CompositeResolver trying the resolvers in turn
+func NewCompositeResolver(resolvers ...ConflictResolver) *CompositeResolver {
+	return &CompositeResolver{resolvers: resolvers}
+}
+
+// Resolve implements ConflictResolver
+func (r *CompositeResolver) Resolve(objs []*Object) []*Object {
+	if len(objs) < 2 {
+		return objs
+	}
+	resolved := objs
+	for _, resolver := range r.resolvers {
+		if len(resolved) < 2 {
+			break
+		}
+		resolved = resolver.Resolve(resolved)
+	}
+	if len(resolved) < len(objs) {
+		atomic.AddUint64(&r.resolved, uint64(len(objs)-len(resolved)))
+		atomic.AddUint64(&r.resolves, 1)
+	}
+	return resolved
+}
+
+// Resolved returns the number of siblings removed by the resolver, and the
+// number of calls to Resolve that removed siblings
+func (r *CompositeResolver) Resolved() (siblings uint64, resolves uint64) {
+	return atomic.LoadUint64(&r.resolved), atomic.LoadUint64(&r.resolves)
+}
@@ -0,0 +1,227 @@
+package riak
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func newResolverTestObject(value string, lastModified int64, vtag string) *Object {
+	return &Object{
+		ContentType:  ContentTypeJSON,
+		Value:        []byte(value),
+		VTag:         vtag,
+		LastModified: time.Unix(lastModified, 0),
+	}
+}
+
+func newResolverTestTombstone(lastModified int64, vtag string) *Object {
+	return &Object{
+		IsTombstone:  true,
+		VTag:         vtag,
+		LastModified: time.Unix(lastModified, 0),
+	}
+}
+
+func TestLastModifiedResolver(t *testing.T) {
+	a := newResolverTestObject(`"a"`, 2, "x")
+	b := newResolverTestObject(`"b"`, 3, "a")
+	c := newResolverTestObject(`"c"`, 3, "b")
+	resolver := NewLastModifiedResolver()
+	for _, objs := range [][]*Object{{a, b, c}, {c, b, a}, {b, c, a}} {
+		resolved := resolver.Resolve(objs)
+		if expected, actual := 1, len(resolved); expected != actual {
+			t.Fatalf("expected %v, got %v", expected, actual)
+		}
+		if expected, actual := c, resolved[0]; expected != actual {
+			t.Errorf("expected %v, got %v", expected, actual)
+		}
+	}
+}
+
+func TestVTagResolver(t *testing.T) {
+	a := newResolverTestObject(`"a"`, 3, "a")
+	b := newResolverTestObject(`"b"`, 1, "b")
+	resolved := NewVTagResolver().Resolve([]*Object{a, b})
+	if expected, actual := []*Object{b}, resolved; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestTombstoneResolver(t *testing.T) {
+	a := newResolverTestObject(`"a"`, 1, "a")
+	b := newResolverTestObject(`"b"`, 2, "b")
+	deleted := newResolverTestTombstone(3, "c")
+	olderDeleted := newResolverTestTombstone(2, "d")
+
+	resolved := NewTombstoneResolver(NewLastModifiedResolver(), false).Resolve([]*Object{a, deleted, b})
+	if expected, actual := []*Object{b}, resolved; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	resolved = NewTombstoneResolver(NewLastModifiedResolver(), true).Resolve([]*Object{a, deleted, b})
+	if expected, actual := []*Object{deleted}, resolved; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	resolved = NewTombstoneResolver(nil, false).Resolve([]*Object{olderDeleted, deleted})
+	if expected, actual := []*Object{deleted}, resolved; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	resolved = NewTombstoneResolver(nil, false).Resolve([]*Object{a, deleted, b})
+	if expected, actual := []*Object{a, b}, resolved; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestMergeResolver(t *testing.T) {
+	sum := func(a, b interface{}) (interface{}, error) {
+		*(a.(*int)) += *(b.(*int))
+		return a, nil
+	}
+	resolver := NewMergeResolver(func() interface{} { return new(int) }, sum)
+	a := newResolverTestObject("1", 1, "a")
+	b := newResolverTestObject("2", 3, "b")
+	b.AddToIndex("total_int", "2")
+	c := newResolverTestObject("3", 2, "c")
+	deleted := newResolverTestTombstone(4, "d")
+	resolved := resolver.Resolve([]*Object{a, b, deleted, c})
+	if expected, actual := 1, len(resolved); expected != actual {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := "6", string(resolved[0].Value); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := "b", resolved[0].VTag; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := "2", string(b.Value); expected != actual {
+		t.Errorf("expected siblings not to be modified, got %v", actual)
+	}
+
+	malformed := newResolverTestObject("not json", 1, "e")
+	objs := []*Object{a, malformed}
+	if expected, actual := objs, resolver.Resolve(objs); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestJSONSetUnionResolver(t *testing.T) {
+	a := newResolverTestObject(`["x", "y"]`, 1, "a")
+	b := newResolverTestObject(`["z","y",{"k": 1}]`, 2, "b")
+	c := newResolverTestObject(`[{"k":1},"x"]`, 3, "c")
+	resolved := NewJSONSetUnionResolver().Resolve([]*Object{c, b, a})
+	if expected, actual := 1, len(resolved); expected != actual {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := `["x","y","z",{"k":1}]`, string(resolved[0].Value); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestJSONFieldMergeResolver(t *testing.T) {
+	a := newResolverTestObject(`{"name":"alice","address":{"city":"Auckland","zip":"1010"},"age":41}`, 1, "a")
+	b := newResolverTestObject(`{"age":42,"address":{"city":"Wellington"}}`, 2, "b")
+	c := newResolverTestObject(`{"email":"alice@example.com"}`, 3, "c")
+	resolved := NewJSONFieldMergeResolver().Resolve([]*Object{b, c, a})
+	if expected, actual := 1, len(resolved); expected != actual {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+	var v map[string]interface{}
+	if err := json.Unmarshal(resolved[0].Value, &v); err != nil {
+		t.Fatal(err.Error())
+	}
+	expected := map[string]interface{}{
+		"name":    "alice",
+		"age":     42.0,
+		"email":   "alice@example.com",
+		"address": map[string]interface{}{"city": "Wellington", "zip": "1010"},
+	}
+	if actual := v; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestCompositeResolver(t *testing.T) {
+	keepAll := ConflictResolverFunc(func(objs []*Object) []*Object { return objs })
+	resolver := NewCompositeResolver(keepAll, NewTombstoneResolver(nil, false), NewLastModifiedResolver())
+	a := newResolverTestObject(`"a"`, 1, "a")
+	b := newResolverTestObject(`"b"`, 2, "b")
+	deleted := newResolverTestTombstone(3, "c")
+	if expected, actual := []*Object{b}, resolver.Resolve([]*Object{a, deleted, b}); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	resolver.Resolve([]*Object{a})
+	resolver.Resolve([]*Object{a, b})
+	siblings, resolves := resolver.Resolved()
+	if expected, actual := uint64(3), siblings; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := uint64(2), resolves; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestFetchValueResolvesSiblings(t *testing.T) {
+	server, cluster, mapper := startMapperCluster(t)
+	defer stopTestServerCluster(server, cluster)
+	for _, tags := range [][]string{{"admin"}, {"staff"}} {
+		o, err := NewObjectFrom(tags, ContentTypeJSON)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		cmd, err := NewStoreValueCommandBuilder().
+			WithBucketType("users").
+			WithBucket("users").
+			WithKey("alice").
+			WithContent(o).
+			Build()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if err := cluster.Execute(cmd); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	cmd, err := NewFetchValueCommandBuilder().
+		WithBucketType("users").
+		WithBucket("users").
+		WithKey("alice").
+		WithConflictResolver(NewJSONSetUnionResolver()).
+		Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := cluster.Execute(cmd); err != nil {
+		t.Fatal(err.Error())
+	}
+	values := cmd.(*FetchValueCommand).Response.Values
+	if expected, actual := 1, len(values); expected != actual {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+	var tags []string
+	if err := values[0].Decode(&tags); err != nil {
+		t.Fatal(err.Error())
+	}
+	sort.Strings(tags)
+	if expected, actual := []string{"admin", "staff"}, tags; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	// the mapper resolves siblings too
+	if err := mapper.Put(context.Background(), &mapperTestUser{Email: "bob", Name: "Bob"}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := mapper.Put(context.Background(), &mapperTestUser{Email: "bob", Age: 42}); err != nil {
+		t.Fatal(err.Error())
+	}
+	mapper = NewMapper(cluster, &MapperOptions{ConflictResolver: NewJSONFieldMergeResolver()})
+	user := &mapperTestUser{Email: "bob"}
+	if err := mapper.Get(context.Background(), user); err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := 42, user.Age; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
@@ -0,0 +1,372 @@
+package riaktest
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	rpbRiakDT "github.com/basho/riak-go-client/rpb/riak_dt"
+	rpbRiakKV "github.com/basho/riak-go-client/rpb/riak_kv"
+	proto "github.com/golang/protobuf/proto"
+)
+
+// datatype is a Riak data type: a counter, set or map. Concurrent updates are
+// serialized by the server, so values are kept as plain values rather than
+// as CRDTs.
+type datatype struct {
+	counter int64
+	set     map[string]bool
+	m       *mapValue
+	version uint64
+}
+
+type mapFieldId struct {
+	name      string
+	fieldType rpbRiakDT.MapField_MapFieldType
+}
+
+type mapValue struct {
+	entries map[mapFieldId]*mapEntry
+}
+
+type mapEntry struct {
+	counter  int64
+	set      map[string]bool
+	register []byte
+	flag     bool
+	m        *mapValue
+}
+
+func newMapValue() *mapValue {
+	return &mapValue{entries: make(map[mapFieldId]*mapEntry)}
+}
+
+func (dt *datatype) context() []byte {
+	return []byte(fmt.Sprintf("riaktest-context-%d", dt.version))
+}
+
+// datatypeProps returns the datatype of the bucket, which must be a data type
+// bucket. The server mutex must be held.
+func (s *Server) datatypeProps(t []byte, bucket []byte) (string, error) {
+	props, err := s.bucketProps(t, bucket)
+	if err != nil {
+		return "", err
+	}
+	if props.Datatype == nil {
+		return "", fmt.Errorf("Bucket datatype 'undefined' is not a supported type")
+	}
+	return string(props.Datatype), nil
+}
+
+func dtFetchRespType(datatype string) rpbRiakDT.DtFetchResp_DataType {
+	switch datatype {
+	case datatypeSet:
+		return rpbRiakDT.DtFetchResp_SET
+	case datatypeMap:
+		return rpbRiakDT.DtFetchResp_MAP
+	default:
+		return rpbRiakDT.DtFetchResp_COUNTER
+	}
+}
+
+func handleDtFetch(s *Server, data []byte) ([]frame, error) {
+	req := &rpbRiakDT.DtFetchReq{}
+	if err := proto.Unmarshal(data, req); err != nil {
+		return nil, err
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	kind, err := s.datatypeProps(req.Type, req.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	b, _ := s.getBucket(req.Type, req.Bucket)
+	resp := &rpbRiakDT.DtFetchResp{Type: dtFetchRespType(kind).Enum()}
+	if dt, ok := b.dts[string(req.Key)]; ok {
+		value := &rpbRiakDT.DtValue{}
+		switch kind {
+		case datatypeCounter:
+			value.CounterValue = proto.Int64(dt.counter)
+		case datatypeSet:
+			value.SetValue = setValue(dt.set)
+		case datatypeMap:
+			value.MapValue = dt.m.value()
+		}
+		resp.Value = value
+		if req.IncludeContext == nil || req.GetIncludeContext() {
+			resp.Context = dt.context()
+		}
+	}
+	return []frame{{code: rpbCode_DtFetchResp, msg: resp}}, nil
+}
+
+func handleDtUpdate(s *Server, data []byte) ([]frame, error) {
+	req := &rpbRiakDT.DtUpdateReq{}
+	if err := proto.Unmarshal(data, req); err != nil {
+		return nil, err
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	kind, err := s.datatypeProps(req.Type, req.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	b, _ := s.getBucket(req.Type, req.Bucket)
+
+	resp := &rpbRiakDT.DtUpdateResp{}
+	key := string(req.Key)
+	if key == "" {
+		key = fmt.Sprintf("riaktest%08d", s.nextCounter())
+		resp.Key = []byte(key)
+	}
+	// NB: updates are applied to a copy so that a failed operation changes nothing
+	current, ok := b.dts[key]
+	dt := &datatype{set: make(map[string]bool), m: newMapValue()}
+	if ok {
+		dt.counter = current.counter
+		dt.set = copySet(current.set)
+		dt.m = current.m.copy()
+		dt.version = current.version
+	}
+
+	op := req.Op
+	switch kind {
+	case datatypeCounter:
+		if op.CounterOp == nil {
+			return nil, errors.New("Operation type is `counter`, but bucket datatype is not a counter")
+		}
+		dt.counter += op.CounterOp.GetIncrement()
+	case datatypeSet:
+		if op.SetOp == nil {
+			return nil, errors.New("Operation type is `set`, but bucket datatype is not a set")
+		}
+		if err = applySetOp(dt.set, op.SetOp); err != nil {
+			return nil, err
+		}
+	case datatypeMap:
+		if op.MapOp == nil {
+			return nil, errors.New("Operation type is `map`, but bucket datatype is not a map")
+		}
+		if err = dt.m.apply(op.MapOp); err != nil {
+			return nil, err
+		}
+	}
+	dt.version = s.nextCounter()
+	b.dts[key] = dt
+
+	if req.GetReturnBody() {
+		switch kind {
+		case datatypeCounter:
+			resp.CounterValue = proto.Int64(dt.counter)
+		case datatypeSet:
+			resp.SetValue = setValue(dt.set)
+		case datatypeMap:
+			resp.MapValue = dt.m.value()
+		}
+		if req.IncludeContext == nil || req.GetIncludeContext() {
+			resp.Context = dt.context()
+		}
+	}
+	return []frame{{code: rpbCode_DtUpdateResp, msg: resp}}, nil
+}
+
+func copySet(set map[string]bool) map[string]bool {
+	rv := make(map[string]bool, len(set))
+	for v := range set {
+		rv[v] = true
+	}
+	return rv
+}
+
+func setValue(set map[string]bool) [][]byte {
+	values := make([]string, 0, len(set))
+	for v := range set {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	return toBytes(values)
+}
+
+func applySetOp(set map[string]bool, op *rpbRiakDT.SetOp) error {
+	for _, v := range op.Removes {
+		if !set[string(v)] {
+			return fmt.Errorf("{precondition,{not_present,<<\"%s\">>}}", v)
+		}
+		delete(set, string(v))
+	}
+	for _, v := range op.Adds {
+		set[string(v)] = true
+	}
+	return nil
+}
+
+func (m *mapValue) copy() *mapValue {
+	rv := newMapValue()
+	for id, e := range m.entries {
+		c := &mapEntry{
+			counter:  e.counter,
+			register: e.register,
+			flag:     e.flag,
+		}
+		if e.set != nil {
+			c.set = copySet(e.set)
+		}
+		if e.m != nil {
+			c.m = e.m.copy()
+		}
+		rv.entries[id] = c
+	}
+	return rv
+}
+
+func (m *mapValue) apply(op *rpbRiakDT.MapOp) error {
+	for _, field := range op.Removes {
+		id := mapFieldId{string(field.Name), field.GetType()}
+		if _, ok := m.entries[id]; !ok {
+			return fmt.Errorf("{precondition,{not_present,{<<\"%s\">>,%s}}}", field.Name, field.GetType())
+		}
+		delete(m.entries, id)
+	}
+	for _, update := range op.Updates {
+		id := mapFieldId{string(update.Field.Name), update.Field.GetType()}
+		e, ok := m.entries[id]
+		if !ok {
+			e = &mapEntry{}
+		}
+		switch id.fieldType {
+		case rpbRiakDT.MapField_COUNTER:
+			if update.CounterOp != nil {
+				e.counter += update.CounterOp.GetIncrement()
+			}
+		case rpbRiakDT.MapField_SET:
+			if e.set == nil {
+				e.set = make(map[string]bool)
+			}
+			if update.SetOp != nil {
+				if err := applySetOp(e.set, update.SetOp); err != nil {
+					return err
+				}
+			}
+		case rpbRiakDT.MapField_REGISTER:
+			if update.RegisterOp != nil {
+				e.register = update.RegisterOp
+			}
+		case rpbRiakDT.MapField_FLAG:
+			if update.FlagOp != nil {
+				e.flag = update.GetFlagOp() == rpbRiakDT.MapUpdate_ENABLE
+			}
+		case rpbRiakDT.MapField_MAP:
+			if e.m == nil {
+				e.m = newMapValue()
+			}
+			if update.MapOp != nil {
+				if err := e.m.apply(update.MapOp); err != nil {
+					return err
+				}
+			}
+		}
+		m.entries[id] = e
+	}
+	return nil
+}
+
+// value returns the map entries sorted by field name and type
+func (m *mapValue) value() []*rpbRiakDT.MapEntry {
+	ids := make([]mapFieldId, 0, len(m.entries))
+	for id := range m.entries {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if ids[i].name != ids[j].name {
+			return ids[i].name < ids[j].name
+		}
+		return ids[i].fieldType < ids[j].fieldType
+	})
+	rv := make([]*rpbRiakDT.MapEntry, len(ids))
+	for i, id := range ids {
+		e := m.entries[id]
+		entry := &rpbRiakDT.MapEntry{
+			Field: &rpbRiakDT.MapField{
+				Name: []byte(id.name),
+				Type: id.fieldType.Enum(),
+			},
+		}
+		switch id.fieldType {
+		case rpbRiakDT.MapField_COUNTER:
+			entry.CounterValue = proto.Int64(e.counter)
+		case rpbRiakDT.MapField_SET:
+			entry.SetValue = setValue(e.set)
+		case rpbRiakDT.MapField_REGISTER:
+			entry.RegisterValue = e.register
+		case rpbRiakDT.MapField_FLAG:
+			entry.FlagValue = proto.Bool(e.flag)
+		case rpbRiakDT.MapField_MAP:
+			entry.MapValue = e.m.value()
+		}
+		rv[i] = entry
+	}
+	return rv
+}
+
+// legacyCounterBucket returns the bucket of a Riak 1.4 counter, which must
+// allow siblings. The server mutex must be held.
+func (s *Server) legacyCounterBucket(name []byte) (*bucket, error) {
+	props, err := s.bucketProps(nil, name)
+	if err != nil {
+		return nil, err
+	}
+	if !props.GetAllowMult() {
+		return nil, errors.New("Counters require bucket property 'allow_mult=true'")
+	}
+	return s.getBucket(nil, name)
+}
+
+// handleCounterUpdate updates a Riak 1.4 counter. Like data types, the counter
+// is kept as a plain value, so it can not be fetched as an object.
+func handleCounterUpdate(s *Server, data []byte) ([]frame, error) {
+	req := &rpbRiakKV.RpbCounterUpdateReq{}
+	if err := proto.Unmarshal(data, req); err != nil {
+		return nil, err
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	b, err := s.legacyCounterBucket(req.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	dt, ok := b.dts[string(req.Key)]
+	if !ok {
+		dt = &datatype{}
+		b.dts[string(req.Key)] = dt
+	}
+	dt.counter += req.GetAmount()
+	dt.version++
+	if !req.GetReturnvalue() {
+		return []frame{{code: rpbCode_RpbCounterUpdateResp}}, nil
+	}
+	return []frame{{
+		code: rpbCode_RpbCounterUpdateResp,
+		msg:  &rpbRiakKV.RpbCounterUpdateResp{Value: proto.Int64(dt.counter)},
+	}}, nil
+}
+
+func handleCounterGet(s *Server, data []byte) ([]frame, error) {
+	req := &rpbRiakKV.RpbCounterGetReq{}
+	if err := proto.Unmarshal(data, req); err != nil {
+		return nil, err
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	b, err := s.legacyCounterBucket(req.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	dt, ok := b.dts[string(req.Key)]
+	if !ok {
+		return []frame{{code: rpbCode_RpbCounterGetResp}}, nil
+	}
+	return []frame{{
+		code: rpbCode_RpbCounterGetResp,
+		msg:  &rpbRiakKV.RpbCounterGetResp{Value: proto.Int64(dt.counter)},
+	}}, nil
+}
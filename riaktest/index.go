@@ -0,0 +1,311 @@
+package riaktest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	rpbRiakKV "github.com/basho/riak-go-client/rpb/riak_kv"
+	proto "github.com/golang/protobuf/proto"
+)
+
+// special indexes
+const (
+	bucketIndex = "$bucket"
+	keyIndex    = "$key"
+)
+
+var errInvalidContinuation = errors.New("Invalid continuation")
+
+// indexEntry is an index term and the key of the object it belongs to
+type indexEntry struct {
+	Term string `json:"t"`
+	Key  string `json:"k"`
+}
+
+type indexQuery struct {
+	isInt    bool
+	eq       bool
+	key      string
+	min, max string
+	intKey   int64
+	intMin   int64
+	intMax   int64
+	regex    *regexp.Regexp
+}
+
+func newIndexQuery(req *rpbRiakKV.RpbIndexReq) (q *indexQuery, err error) {
+	index := string(req.Index)
+	q = &indexQuery{
+		isInt: strings.HasSuffix(index, "_int"),
+		eq:    req.GetQtype() == rpbRiakKV.RpbIndexReq_eq,
+		key:   string(req.Key),
+		min:   string(req.RangeMin),
+		max:   string(req.RangeMax),
+	}
+	if q.isInt {
+		if q.eq {
+			if q.intKey, err = strconv.ParseInt(q.key, 10, 64); err != nil {
+				return nil, fmt.Errorf("Invalid integer index key: %s", q.key)
+			}
+		} else {
+			if q.intMin, err = strconv.ParseInt(q.min, 10, 64); err != nil {
+				return nil, fmt.Errorf("Invalid integer index range: %s", q.min)
+			}
+			if q.intMax, err = strconv.ParseInt(q.max, 10, 64); err != nil {
+				return nil, fmt.Errorf("Invalid integer index range: %s", q.max)
+			}
+		}
+	}
+	if req.TermRegex != nil {
+		if q.eq || q.isInt {
+			return nil, errors.New("Can not use term regular expressions on integer queries or equality queries")
+		}
+		if q.regex, err = regexp.Compile(string(req.TermRegex)); err != nil {
+			return nil, err
+		}
+	}
+	return q, nil
+}
+
+func (q *indexQuery) matches(term string) bool {
+	if q.isInt {
+		v, err := strconv.ParseInt(term, 10, 64)
+		if err != nil {
+			return false
+		}
+		if q.eq {
+			return v == q.intKey
+		}
+		return v >= q.intMin && v <= q.intMax
+	}
+	if q.eq {
+		return term == q.key
+	}
+	if term < q.min || term > q.max {
+		return false
+	}
+	return q.regex == nil || q.regex.MatchString(term)
+}
+
+// less orders entries by term, numerically for integer indexes, then by key
+func (q *indexQuery) less(a, b indexEntry) bool {
+	if a.Term != b.Term {
+		if q.isInt {
+			x, _ := strconv.ParseInt(a.Term, 10, 64)
+			y, _ := strconv.ParseInt(b.Term, 10, 64)
+			return x < y
+		}
+		return a.Term < b.Term
+	}
+	return a.Key < b.Key
+}
+
+// indexEntries returns the sorted, distinct index entries of the bucket matching the query
+func (b *bucket) indexEntries(index string, bucketName string, q *indexQuery) []indexEntry {
+	seen := make(map[indexEntry]bool)
+	var entries []indexEntry
+	add := func(e indexEntry) {
+		if !seen[e] && q.matches(e.Term) {
+			seen[e] = true
+			entries = append(entries, e)
+		}
+	}
+	for key, o := range b.objects {
+		if !o.live() {
+			continue
+		}
+		switch index {
+		case bucketIndex:
+			add(indexEntry{bucketName, key})
+		case keyIndex:
+			add(indexEntry{key, key})
+		default:
+			for _, s := range o.siblings {
+				if s.content.GetDeleted() {
+					continue
+				}
+				for _, idx := range s.content.Indexes {
+					if string(idx.Key) == index {
+						add(indexEntry{string(idx.Value), key})
+					}
+				}
+			}
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return q.less(entries[i], entries[j])
+	})
+	return entries
+}
+
+func encodeContinuation(e indexEntry) []byte {
+	data, _ := json.Marshal(e)
+	return []byte(base64.StdEncoding.EncodeToString(data))
+}
+
+func decodeContinuation(continuation []byte) (e indexEntry, err error) {
+	var data []byte
+	if data, err = base64.StdEncoding.DecodeString(string(continuation)); err != nil {
+		return e, errInvalidContinuation
+	}
+	if err = json.Unmarshal(data, &e); err != nil {
+		return e, errInvalidContinuation
+	}
+	return
+}
+
+func handleIndex(s *Server, data []byte) ([]frame, error) {
+	req := &rpbRiakKV.RpbIndexReq{}
+	if err := proto.Unmarshal(data, req); err != nil {
+		return nil, err
+	}
+	q, err := newIndexQuery(req)
+	if err != nil {
+		return nil, err
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	b, err := s.getBucket(req.Type, req.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	entries := b.indexEntries(string(req.Index), string(req.Bucket), q)
+
+	if req.Continuation != nil {
+		after, err := decodeContinuation(req.Continuation)
+		if err != nil {
+			return nil, err
+		}
+		i := sort.Search(len(entries), func(i int) bool {
+			return q.less(after, entries[i])
+		})
+		entries = entries[i:]
+	}
+	var continuation []byte
+	if max := int(req.GetMaxResults()); max > 0 && len(entries) > max {
+		entries = entries[:max]
+		continuation = encodeContinuation(entries[max-1])
+	}
+
+	// NB: like Riak, terms are only returned for range queries
+	returnTerms := req.GetReturnTerms() && !q.eq
+	newResp := func(entries []indexEntry) *rpbRiakKV.RpbIndexResp {
+		resp := &rpbRiakKV.RpbIndexResp{}
+		seen := make(map[string]bool)
+		for _, e := range entries {
+			if returnTerms {
+				resp.Results = append(resp.Results, pair([]byte(e.Term), []byte(e.Key)))
+			} else if !seen[e.Key] {
+				seen[e.Key] = true
+				resp.Keys = append(resp.Keys, []byte(e.Key))
+			}
+		}
+		return resp
+	}
+
+	if !req.GetStream() {
+		resp := newResp(entries)
+		resp.Continuation = continuation
+		return []frame{{code: rpbCode_RpbIndexResp, msg: resp}}, nil
+	}
+	var frames []frame
+	for len(entries) > 0 {
+		n := listChunkSize
+		if n > len(entries) {
+			n = len(entries)
+		}
+		frames = append(frames, frame{code: rpbCode_RpbIndexResp, msg: newResp(entries[:n])})
+		entries = entries[n:]
+	}
+	frames = append(frames, frame{
+		code: rpbCode_RpbIndexResp,
+		msg: &rpbRiakKV.RpbIndexResp{
+			Continuation: continuation,
+			Done:         proto.Bool(true),
+		},
+	})
+	return frames, nil
+}
+
+// inKeyRange returns true if the key is within the range of the fold
+func inKeyRange(req *rpbRiakKV.RpbCSBucketReq, key string) bool {
+	start := string(req.StartKey)
+	if key < start || (key == start && !req.GetStartIncl()) {
+		return false
+	}
+	if req.EndKey == nil {
+		return true
+	}
+	end := string(req.EndKey)
+	return key < end || (key == end && req.GetEndIncl())
+}
+
+// handleCSBucket folds over the objects of a range of keys, like the $key
+// index query Riak uses for it
+func handleCSBucket(s *Server, data []byte) ([]frame, error) {
+	req := &rpbRiakKV.RpbCSBucketReq{}
+	if err := proto.Unmarshal(data, req); err != nil {
+		return nil, err
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	b, err := s.getBucket(req.Type, req.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	var after *indexEntry
+	if req.Continuation != nil {
+		e, err := decodeContinuation(req.Continuation)
+		if err != nil {
+			return nil, err
+		}
+		after = &e
+	}
+	var keys []string
+	for key, o := range b.objects {
+		if o.live() && inKeyRange(req, key) && (after == nil || key > after.Key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	var continuation []byte
+	if max := int(req.GetMaxResults()); max > 0 && len(keys) > max {
+		keys = keys[:max]
+		continuation = encodeContinuation(indexEntry{keys[max-1], keys[max-1]})
+	}
+
+	var frames []frame
+	for len(keys) > 0 {
+		n := listChunkSize
+		if n > len(keys) {
+			n = len(keys)
+		}
+		resp := &rpbRiakKV.RpbCSBucketResp{}
+		for _, key := range keys[:n] {
+			o := b.objects[key]
+			resp.Objects = append(resp.Objects, &rpbRiakKV.RpbIndexObject{
+				Key: []byte(key),
+				Object: &rpbRiakKV.RpbGetResp{
+					Content: o.contents(false),
+					Vclock:  o.clock.encode(),
+				},
+			})
+		}
+		frames = append(frames, frame{code: rpbCode_RpbCSBucketResp, msg: resp})
+		keys = keys[n:]
+	}
+	frames = append(frames, frame{
+		code: rpbCode_RpbCSBucketResp,
+		msg: &rpbRiakKV.RpbCSBucketResp{
+			Continuation: continuation,
+			Done:         proto.Bool(true),
+		},
+	})
+	return frames, nil
+}
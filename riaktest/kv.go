@@ -0,0 +1,361 @@
+package riaktest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	rpbRiak "github.com/basho/riak-go-client/rpb/riak"
+	rpbRiakKV "github.com/basho/riak-go-client/rpb/riak_kv"
+	proto "github.com/golang/protobuf/proto"
+)
+
+// actor is the vclock actor of every update made by the server
+const actor = "riaktest"
+
+// listChunkSize is the number of keys or buckets sent in each streamed response
+const listChunkSize = 100
+
+var errInvalidVClock = errors.New("Invalid vclock")
+
+// vclock is a version vector, encoded opaquely for clients
+type vclock map[string]uint64
+
+func (vc vclock) encode() []byte {
+	actors := make([]string, 0, len(vc))
+	for a := range vc {
+		actors = append(actors, a)
+	}
+	sort.Strings(actors)
+	buf := new(bytes.Buffer)
+	varint := make([]byte, binary.MaxVarintLen64)
+	for _, a := range actors {
+		buf.Write(varint[:binary.PutUvarint(varint, uint64(len(a)))])
+		buf.WriteString(a)
+		buf.Write(varint[:binary.PutUvarint(varint, vc[a])])
+	}
+	return buf.Bytes()
+}
+
+func decodeVClock(data []byte) (vclock, error) {
+	vc := make(vclock)
+	r := bytes.NewReader(data)
+	for r.Len() > 0 {
+		n, err := binary.ReadUvarint(r)
+		if err != nil || n > uint64(r.Len()) {
+			return nil, errInvalidVClock
+		}
+		a := make([]byte, n)
+		r.Read(a)
+		if vc[string(a)], err = binary.ReadUvarint(r); err != nil {
+			return nil, errInvalidVClock
+		}
+	}
+	return vc, nil
+}
+
+// dot identifies the update that created a sibling
+type dot struct {
+	actor   string
+	counter uint64
+}
+
+type sibling struct {
+	dot     dot
+	content *rpbRiakKV.RpbContent
+}
+
+// object is a Riak object, stored as a dotted version vector so that
+// concurrent updates result in siblings
+type object struct {
+	clock    vclock
+	siblings []*sibling
+}
+
+// update stores content as a new sibling, discarding the siblings the client
+// has seen according to its vclock. Every sibling is discarded if allowMult is false.
+func (o *object) update(clientClock vclock, content *rpbRiakKV.RpbContent, allowMult bool) {
+	counter := o.clock[actor]
+	if clientClock[actor] > counter {
+		counter = clientClock[actor]
+	}
+	counter++
+	var siblings []*sibling
+	if allowMult {
+		for _, s := range o.siblings {
+			if s.dot.counter > clientClock[s.dot.actor] {
+				siblings = append(siblings, s)
+			}
+		}
+	}
+	o.siblings = append(siblings, &sibling{
+		dot:     dot{actor, counter},
+		content: content,
+	})
+	for a, c := range clientClock {
+		if c > o.clock[a] {
+			o.clock[a] = c
+		}
+	}
+	o.clock[actor] = counter
+}
+
+// live returns true if any sibling is not a tombstone
+func (o *object) live() bool {
+	for _, s := range o.siblings {
+		if !s.content.GetDeleted() {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *object) contents(head bool) []*rpbRiakKV.RpbContent {
+	contents := make([]*rpbRiakKV.RpbContent, len(o.siblings))
+	for i, s := range o.siblings {
+		contents[i] = proto.Clone(s.content).(*rpbRiakKV.RpbContent)
+		if head {
+			contents[i].Value = []byte{}
+		}
+	}
+	return contents
+}
+
+// newContent returns a copy of the content with the metadata Riak sets on write
+func (s *Server) newContent(content *rpbRiakKV.RpbContent) *rpbRiakKV.RpbContent {
+	c := proto.Clone(content).(*rpbRiakKV.RpbContent)
+	now := time.Now()
+	c.Vtag = []byte(fmt.Sprintf("vtag%d", s.nextCounter()))
+	c.LastMod = proto.Uint32(uint32(now.Unix()))
+	c.LastModUsecs = proto.Uint32(uint32(now.Nanosecond() / 1000))
+	c.Deleted = nil
+	return c
+}
+
+func handleGet(s *Server, data []byte) ([]frame, error) {
+	req := &rpbRiakKV.RpbGetReq{}
+	if err := proto.Unmarshal(data, req); err != nil {
+		return nil, err
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	b, err := s.getBucket(req.Type, req.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	o, ok := b.objects[string(req.Key)]
+	if !ok {
+		return []frame{{code: rpbCode_RpbGetResp}}, nil
+	}
+	encodedClock := o.clock.encode()
+	if !o.live() {
+		if req.GetDeletedvclock() {
+			return []frame{{code: rpbCode_RpbGetResp, msg: &rpbRiakKV.RpbGetResp{Vclock: encodedClock}}}, nil
+		}
+		return []frame{{code: rpbCode_RpbGetResp}}, nil
+	}
+	if req.IfModified != nil && bytes.Equal(req.IfModified, encodedClock) {
+		return []frame{{code: rpbCode_RpbGetResp, msg: &rpbRiakKV.RpbGetResp{Unchanged: proto.Bool(true)}}}, nil
+	}
+	return []frame{{
+		code: rpbCode_RpbGetResp,
+		msg: &rpbRiakKV.RpbGetResp{
+			Content: o.contents(req.GetHead()),
+			Vclock:  encodedClock,
+		},
+	}}, nil
+}
+
+func handlePut(s *Server, data []byte) ([]frame, error) {
+	req := &rpbRiakKV.RpbPutReq{}
+	if err := proto.Unmarshal(data, req); err != nil {
+		return nil, err
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	props, err := s.bucketProps(req.Type, req.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	if props.Datatype != nil {
+		return nil, fmt.Errorf("Bucket datatype '%s' does not allow plain values", props.Datatype)
+	}
+	b, _ := s.getBucket(req.Type, req.Bucket)
+
+	resp := &rpbRiakKV.RpbPutResp{}
+	key := string(req.Key)
+	if key == "" {
+		key = fmt.Sprintf("riaktest%08d", s.nextCounter())
+		resp.Key = []byte(key)
+	}
+	o, ok := b.objects[key]
+	if req.GetIfNoneMatch() && ok && o.live() {
+		return nil, errors.New("match_found")
+	}
+	if req.GetIfNotModified() {
+		if !ok || !o.live() {
+			return nil, errors.New("notfound")
+		}
+		if !bytes.Equal(req.Vclock, o.clock.encode()) {
+			return nil, errors.New("modified")
+		}
+	}
+	clientClock, err := decodeVClock(req.Vclock)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		o = &object{clock: make(vclock)}
+		b.objects[key] = o
+	}
+	allowMult := props.GetAllowMult() && !props.GetLastWriteWins()
+	o.update(clientClock, s.newContent(req.Content), allowMult)
+
+	if req.GetReturnBody() || req.GetReturnHead() {
+		resp.Content = o.contents(req.GetReturnHead())
+		resp.Vclock = o.clock.encode()
+	}
+	return []frame{{code: rpbCode_RpbPutResp, msg: resp}}, nil
+}
+
+func handleDel(s *Server, data []byte) ([]frame, error) {
+	req := &rpbRiakKV.RpbDelReq{}
+	if err := proto.Unmarshal(data, req); err != nil {
+		return nil, err
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	props, err := s.bucketProps(req.Type, req.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	b, _ := s.getBucket(req.Type, req.Bucket)
+	key := string(req.Key)
+	if _, ok := b.dts[key]; ok {
+		delete(b.dts, key)
+		return []frame{{code: rpbCode_RpbDelResp}}, nil
+	}
+	o, ok := b.objects[key]
+	if !ok {
+		return []frame{{code: rpbCode_RpbDelResp}}, nil
+	}
+	// NB: like Riak, a delete without a vclock deletes every sibling
+	clientClock := o.clock
+	if req.Vclock != nil {
+		if clientClock, err = decodeVClock(req.Vclock); err != nil {
+			return nil, err
+		}
+	}
+	tombstone := s.newContent(&rpbRiakKV.RpbContent{Value: []byte{}})
+	tombstone.Deleted = proto.Bool(true)
+	allowMult := props.GetAllowMult() && !props.GetLastWriteWins()
+	o.update(clientClock, tombstone, allowMult)
+	return []frame{{code: rpbCode_RpbDelResp}}, nil
+}
+
+// keys returns the sorted keys of the bucket's live objects and data types
+func (b *bucket) keys() []string {
+	keys := make([]string, 0, len(b.objects)+len(b.dts))
+	for key, o := range b.objects {
+		if o.live() {
+			keys = append(keys, key)
+		}
+	}
+	for key := range b.dts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func handleListKeys(s *Server, data []byte) ([]frame, error) {
+	req := &rpbRiakKV.RpbListKeysReq{}
+	if err := proto.Unmarshal(data, req); err != nil {
+		return nil, err
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	b, err := s.getBucket(req.Type, req.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	var frames []frame
+	for _, chunk := range chunks(b.keys()) {
+		frames = append(frames, frame{
+			code: rpbCode_RpbListKeysResp,
+			msg:  &rpbRiakKV.RpbListKeysResp{Keys: chunk},
+		})
+	}
+	frames = append(frames, frame{
+		code: rpbCode_RpbListKeysResp,
+		msg:  &rpbRiakKV.RpbListKeysResp{Done: proto.Bool(true)},
+	})
+	return frames, nil
+}
+
+func handleListBuckets(s *Server, data []byte) ([]frame, error) {
+	req := &rpbRiakKV.RpbListBucketsReq{}
+	if err := proto.Unmarshal(data, req); err != nil {
+		return nil, err
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	t := bucketType(req.Type)
+	if _, ok := s.bucketTypes[t]; !ok {
+		return nil, fmt.Errorf("Invalid bucket type: %s", t)
+	}
+	var buckets []string
+	for id, b := range s.buckets {
+		if id.bucketType == t && len(b.keys()) > 0 {
+			buckets = append(buckets, id.bucket)
+		}
+	}
+	sort.Strings(buckets)
+	if !req.GetStream() {
+		return []frame{{
+			code: rpbCode_RpbListBucketsResp,
+			msg:  &rpbRiakKV.RpbListBucketsResp{Buckets: toBytes(buckets)},
+		}}, nil
+	}
+	var frames []frame
+	for _, chunk := range chunks(buckets) {
+		frames = append(frames, frame{
+			code: rpbCode_RpbListBucketsResp,
+			msg:  &rpbRiakKV.RpbListBucketsResp{Buckets: chunk},
+		})
+	}
+	frames = append(frames, frame{
+		code: rpbCode_RpbListBucketsResp,
+		msg:  &rpbRiakKV.RpbListBucketsResp{Done: proto.Bool(true)},
+	})
+	return frames, nil
+}
+
+func toBytes(values []string) [][]byte {
+	rv := make([][]byte, len(values))
+	for i, v := range values {
+		rv[i] = []byte(v)
+	}
+	return rv
+}
+
+func chunks(values []string) [][][]byte {
+	var rv [][][]byte
+	for len(values) > 0 {
+		n := listChunkSize
+		if n > len(values) {
+			n = len(values)
+		}
+		rv = append(rv, toBytes(values[:n]))
+		values = values[n:]
+	}
+	return rv
+}
+
+// pair returns an RpbPair, used for index entries
+func pair(key, value []byte) *rpbRiak.RpbPair {
+	return &rpbRiak.RpbPair{Key: key, Value: value}
+}
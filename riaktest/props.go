@@ -0,0 +1,223 @@
+package riaktest
+
+import (
+	"fmt"
+
+	rpbRiak "github.com/basho/riak-go-client/rpb/riak"
+	proto "github.com/golang/protobuf/proto"
+)
+
+const defaultBucketType = "default"
+
+// bucket data types
+const (
+	datatypeCounter = "counter"
+	datatypeSet     = "set"
+	datatypeMap     = "map"
+)
+
+type bucketId struct {
+	bucketType string
+	bucket     string
+}
+
+type bucket struct {
+	props   *rpbRiak.RpbBucketProps // NB: only the properties set on the bucket itself
+	objects map[string]*object
+	dts     map[string]*datatype
+}
+
+func defaultBucketTypeProps() *rpbRiak.RpbBucketProps {
+	return &rpbRiak.RpbBucketProps{
+		NVal:          proto.Uint32(3),
+		AllowMult:     proto.Bool(false),
+		LastWriteWins: proto.Bool(false),
+		HasPrecommit:  proto.Bool(false),
+		HasPostcommit: proto.Bool(false),
+		OldVclock:     proto.Uint32(86400),
+		YoungVclock:   proto.Uint32(20),
+		BigVclock:     proto.Uint32(50),
+		SmallVclock:   proto.Uint32(50),
+		Pr:            proto.Uint32(0),
+		R:             proto.Uint32(quorum),
+		W:             proto.Uint32(quorum),
+		Pw:            proto.Uint32(0),
+		Dw:            proto.Uint32(quorum),
+		Rw:            proto.Uint32(quorum),
+		BasicQuorum:   proto.Bool(false),
+		NotfoundOk:    proto.Bool(true),
+		Search:        proto.Bool(false),
+		Consistent:    proto.Bool(false),
+		WriteOnce:     proto.Bool(false),
+	}
+}
+
+func newBucketTypeProps() *rpbRiak.RpbBucketProps {
+	props := defaultBucketTypeProps()
+	props.AllowMult = proto.Bool(true)
+	return props
+}
+
+// symbolic quorum value for "quorum", as sent by Riak
+const quorum uint32 = 0xfffffffd
+
+// bucketType returns the name of the bucket type, which is "default" if empty
+func bucketType(t []byte) string {
+	if len(t) == 0 {
+		return defaultBucketType
+	}
+	return string(t)
+}
+
+// getBucket returns the bucket, creating it if needed. The server mutex must
+// be held.
+func (s *Server) getBucket(t []byte, name []byte) (*bucket, error) {
+	id := bucketId{bucketType(t), string(name)}
+	if _, ok := s.bucketTypes[id.bucketType]; !ok {
+		return nil, fmt.Errorf("Invalid bucket type: %s", id.bucketType)
+	}
+	b, ok := s.buckets[id]
+	if !ok {
+		b = &bucket{
+			props:   &rpbRiak.RpbBucketProps{},
+			objects: make(map[string]*object),
+			dts:     make(map[string]*datatype),
+		}
+		s.buckets[id] = b
+	}
+	return b, nil
+}
+
+// bucketProps returns the properties of a bucket: those of its bucket type
+// overridden by the ones set on the bucket. The server mutex must be held.
+func (s *Server) bucketProps(t []byte, name []byte) (*rpbRiak.RpbBucketProps, error) {
+	b, err := s.getBucket(t, name)
+	if err != nil {
+		return nil, err
+	}
+	props := proto.Clone(s.bucketTypes[bucketType(t)]).(*rpbRiak.RpbBucketProps)
+	mergeProps(props, b.props)
+	return props, nil
+}
+
+// mergeProps sets the properties in src on dst. Like Riak, commit hooks in src
+// replace those in dst, and has_precommit / has_postcommit with no hooks
+// clears them.
+func mergeProps(dst *rpbRiak.RpbBucketProps, src *rpbRiak.RpbBucketProps) {
+	precommit, postcommit := dst.Precommit, dst.Postcommit
+	if len(src.Precommit) > 0 || src.GetHasPrecommit() {
+		precommit = src.Precommit
+	}
+	if len(src.Postcommit) > 0 || src.GetHasPostcommit() {
+		postcommit = src.Postcommit
+	}
+	dst.Precommit, dst.Postcommit = nil, nil
+	proto.Merge(dst, src)
+	dst.Precommit, dst.Postcommit = precommit, postcommit
+	dst.HasPrecommit = proto.Bool(len(precommit) > 0)
+	dst.HasPostcommit = proto.Bool(len(postcommit) > 0)
+}
+
+// checkImmutableProps returns an error if props changes a property that can
+// only be set when a bucket type is created
+func checkImmutableProps(current *rpbRiak.RpbBucketProps, props *rpbRiak.RpbBucketProps) error {
+	if props.Datatype != nil && string(props.Datatype) != string(current.Datatype) {
+		return fmt.Errorf("Error setting bucket properties: datatype can not be changed")
+	}
+	if props.Consistent != nil && props.GetConsistent() != current.GetConsistent() {
+		return fmt.Errorf("Error setting bucket properties: consistent can not be changed")
+	}
+	if props.WriteOnce != nil && props.GetWriteOnce() != current.GetWriteOnce() {
+		return fmt.Errorf("Error setting bucket properties: write_once can not be changed")
+	}
+	return nil
+}
+
+func handleGetBucket(s *Server, data []byte) ([]frame, error) {
+	req := &rpbRiak.RpbGetBucketReq{}
+	if err := proto.Unmarshal(data, req); err != nil {
+		return nil, err
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	props, err := s.bucketProps(req.Type, req.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	return []frame{{
+		code: rpbCode_RpbGetBucketResp,
+		msg:  &rpbRiak.RpbGetBucketResp{Props: props},
+	}}, nil
+}
+
+func handleSetBucket(s *Server, data []byte) ([]frame, error) {
+	req := &rpbRiak.RpbSetBucketReq{}
+	if err := proto.Unmarshal(data, req); err != nil {
+		return nil, err
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	current, err := s.bucketProps(req.Type, req.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkImmutableProps(current, req.Props); err != nil {
+		return nil, err
+	}
+	b, err := s.getBucket(req.Type, req.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	mergeProps(b.props, req.Props)
+	return []frame{{code: rpbCode_RpbSetBucketResp}}, nil
+}
+
+func handleResetBucket(s *Server, data []byte) ([]frame, error) {
+	req := &rpbRiak.RpbResetBucketReq{}
+	if err := proto.Unmarshal(data, req); err != nil {
+		return nil, err
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	b, err := s.getBucket(req.Type, req.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	b.props = &rpbRiak.RpbBucketProps{}
+	return []frame{{code: rpbCode_RpbResetBucketResp}}, nil
+}
+
+func handleGetBucketType(s *Server, data []byte) ([]frame, error) {
+	req := &rpbRiak.RpbGetBucketTypeReq{}
+	if err := proto.Unmarshal(data, req); err != nil {
+		return nil, err
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	props, ok := s.bucketTypes[bucketType(req.Type)]
+	if !ok {
+		return nil, fmt.Errorf("Invalid bucket type: %s", bucketType(req.Type))
+	}
+	return []frame{{
+		code: rpbCode_RpbGetBucketResp,
+		msg:  &rpbRiak.RpbGetBucketResp{Props: proto.Clone(props).(*rpbRiak.RpbBucketProps)},
+	}}, nil
+}
+
+func handleSetBucketType(s *Server, data []byte) ([]frame, error) {
+	req := &rpbRiak.RpbSetBucketTypeReq{}
+	if err := proto.Unmarshal(data, req); err != nil {
+		return nil, err
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	props, ok := s.bucketTypes[bucketType(req.Type)]
+	if !ok {
+		return nil, fmt.Errorf("Invalid bucket type: %s", bucketType(req.Type))
+	}
+	if err := checkImmutableProps(props, req.Props); err != nil {
+		return nil, err
+	}
+	mergeProps(props, req.Props)
+	return []frame{{code: rpbCode_RpbSetBucketResp}}, nil
+}
@@ -0,0 +1,315 @@
+package riaktest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+var errEmptyFrame = errors.New("[riaktest] frame without a message code")
+
+type faultKind byte
+
+const (
+	faultLatency faultKind = iota
+	faultDropMidFrame
+	faultTruncatedLengthPrefix
+	faultGarbageResponseCode
+	faultErrorResponse
+	faultBlackhole
+)
+
+// garbageCode is the message code sent in place of the real one by
+// GarbageResponseCode; it is not used by any Riak message
+const garbageCode byte = 255
+
+// Fault is a failure injected by a Proxy. Every fault but ErrorResponse acts on
+// a response frame sent by the target: the request has been processed by then.
+type Fault struct {
+	kind   faultKind
+	delay  time.Duration
+	errmsg string
+}
+
+// Latency delays a response frame
+func Latency(delay time.Duration) Fault {
+	return Fault{kind: faultLatency, delay: delay}
+}
+
+// DropMidFrame sends the length prefix and half of a response frame, then
+// closes the connection
+func DropMidFrame() Fault {
+	return Fault{kind: faultDropMidFrame}
+}
+
+// TruncatedLengthPrefix sends two bytes of the length prefix of a response
+// frame, then closes the connection
+func TruncatedLengthPrefix() Fault {
+	return Fault{kind: faultTruncatedLengthPrefix}
+}
+
+// GarbageResponseCode replaces the message code of a response frame with one
+// no Riak message uses
+func GarbageResponseCode() Fault {
+	return Fault{kind: faultGarbageResponseCode}
+}
+
+// ErrorResponse answers a request with an RpbErrorResp carrying errmsg, such as
+// "overload", after the delay. The request is not sent to the target.
+func ErrorResponse(errmsg string, delay time.Duration) Fault {
+	return Fault{kind: faultErrorResponse, delay: delay, errmsg: errmsg}
+}
+
+// Blackhole discards a response frame, leaving the client to time out
+func Blackhole() Fault {
+	return Fault{kind: faultBlackhole}
+}
+
+func (f Fault) onRequest() bool {
+	return f.kind == faultErrorResponse
+}
+
+type injection struct {
+	fault Fault
+	times int // NB: zero means until cleared
+}
+
+// Proxy is a TCP proxy between a client and a Riak node, real or a Server,
+// injecting faults on command to test how an application copes with partial
+// failure:
+//
+//	proxy, err := riaktest.NewProxy(server.Addr())
+//	...
+//	proxy.Inject(riaktest.ErrorResponse("overload", 0), 2)
+//
+// Faults are applied, in the order they were injected, to the next frames
+// passing through the proxy on any connection.
+type Proxy struct {
+	target   string
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mtx       sync.Mutex
+	closing   bool
+	conns     map[net.Conn]bool
+	requests  []*injection
+	responses []*injection
+}
+
+// NewProxy starts a Proxy to the target address listening on a random port of
+// the loopback interface
+func NewProxy(target string) (*Proxy, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	p := &Proxy{
+		target:   target,
+		listener: listener,
+		conns:    make(map[net.Conn]bool),
+	}
+	p.wg.Add(1)
+	go p.accept()
+	return p, nil
+}
+
+// Addr returns the address the proxy is listening on, for use as NodeOptions.RemoteAddress
+func (p *Proxy) Addr() string {
+	return p.listener.Addr().String()
+}
+
+// Close stops the proxy, closing every connection
+func (p *Proxy) Close() error {
+	err := p.listener.Close()
+	p.mtx.Lock()
+	p.closing = true
+	p.mtx.Unlock()
+	p.CloseConnections()
+	p.wg.Wait()
+	return err
+}
+
+// CloseConnections closes every connection currently going through the proxy,
+// as a restarting node would. New connections are accepted.
+func (p *Proxy) CloseConnections() {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	for conn := range p.conns {
+		conn.Close()
+	}
+}
+
+// Inject applies the fault to the given number of frames, or to every frame
+// until Clear is called if times is zero
+func (p *Proxy) Inject(fault Fault, times int) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	i := &injection{fault: fault, times: times}
+	if fault.onRequest() {
+		p.requests = append(p.requests, i)
+	} else {
+		p.responses = append(p.responses, i)
+	}
+}
+
+// Clear removes every pending fault
+func (p *Proxy) Clear() {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.requests = nil
+	p.responses = nil
+}
+
+// nextFault returns the fault to apply to the next frame of the queue, if any
+func (p *Proxy) nextFault(onRequest bool) (Fault, bool) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	queue := &p.responses
+	if onRequest {
+		queue = &p.requests
+	}
+	if len(*queue) == 0 {
+		return Fault{}, false
+	}
+	i := (*queue)[0]
+	if i.times > 0 {
+		i.times--
+		if i.times == 0 {
+			*queue = (*queue)[1:]
+		}
+	}
+	return i.fault, true
+}
+
+func (p *Proxy) untrack(conns ...net.Conn) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	for _, conn := range conns {
+		conn.Close()
+		delete(p.conns, conn)
+	}
+}
+
+func (p *Proxy) accept() {
+	defer p.wg.Done()
+	for {
+		client, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		server, err := net.Dial("tcp", p.target)
+		if err != nil {
+			client.Close()
+			continue
+		}
+		p.mtx.Lock()
+		if p.closing {
+			p.mtx.Unlock()
+			client.Close()
+			server.Close()
+			return
+		}
+		p.conns[client] = true
+		p.conns[server] = true
+		p.mtx.Unlock()
+		pc := &proxyConn{proxy: p, client: client, server: server}
+		p.wg.Add(2)
+		go pc.relayRequests()
+		go pc.relayResponses()
+	}
+}
+
+// proxyConn is a client connection and its connection to the target
+type proxyConn struct {
+	proxy    *Proxy
+	client   net.Conn
+	server   net.Conn
+	writeMtx sync.Mutex // NB: both relays write to the client
+}
+
+func (pc *proxyConn) close() {
+	pc.proxy.untrack(pc.client, pc.server)
+}
+
+func (pc *proxyConn) writeClient(data []byte) error {
+	pc.writeMtx.Lock()
+	defer pc.writeMtx.Unlock()
+	_, err := pc.client.Write(data)
+	return err
+}
+
+func (pc *proxyConn) relayRequests() {
+	defer pc.proxy.wg.Done()
+	defer pc.close()
+	for {
+		data, err := readFrame(pc.client)
+		if err != nil {
+			return
+		}
+		if fault, ok := pc.proxy.nextFault(true); ok {
+			// NB: ErrorResponse is the only request fault
+			time.Sleep(fault.delay)
+			buf := new(bytes.Buffer)
+			writeFrame(buf, errorFrames(errors.New(fault.errmsg))[0])
+			if err = pc.writeClient(buf.Bytes()); err != nil {
+				return
+			}
+			continue
+		}
+		if _, err = pc.server.Write(data); err != nil {
+			return
+		}
+	}
+}
+
+func (pc *proxyConn) relayResponses() {
+	defer pc.proxy.wg.Done()
+	defer pc.close()
+	for {
+		data, err := readFrame(pc.server)
+		if err != nil {
+			return
+		}
+		if fault, ok := pc.proxy.nextFault(false); ok {
+			switch fault.kind {
+			case faultLatency:
+				time.Sleep(fault.delay)
+			case faultDropMidFrame:
+				pc.writeClient(data[:4+(len(data)-4)/2])
+				return
+			case faultTruncatedLengthPrefix:
+				pc.writeClient(data[:2])
+				return
+			case faultGarbageResponseCode:
+				data[4] = garbageCode
+			case faultBlackhole:
+				continue
+			}
+		}
+		if err = pc.writeClient(data); err != nil {
+			return
+		}
+	}
+}
+
+// readFrame reads a frame, including its length prefix
+func readFrame(r io.Reader) ([]byte, error) {
+	var prefix [4]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(prefix[:])
+	if size == 0 {
+		return nil, errEmptyFrame
+	}
+	data := make([]byte, 4+size)
+	copy(data, prefix[:])
+	if _, err := io.ReadFull(r, data[4:]); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,163 @@
+package riaktest_test
+
+import (
+	"testing"
+	"time"
+
+	riak "github.com/basho/riak-go-client"
+	"github.com/basho/riak-go-client/riaktest"
+)
+
+func startProxiedCluster(t *testing.T, opts *riak.NodeOptions) (*riaktest.Server, *riaktest.Proxy, *riak.Cluster) {
+	server, err := riaktest.NewServer()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	proxy, err := riaktest.NewProxy(server.Addr())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	opts.RemoteAddress = proxy.Addr()
+	opts.HealthCheckInterval = 10 * time.Millisecond
+	node, err := riak.NewNode(opts)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	cluster, err := riak.NewCluster(&riak.ClusterOptions{
+		Nodes:             []*riak.Node{node},
+		ExecutionAttempts: 1,
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := cluster.Start(); err != nil {
+		t.Fatal(err.Error())
+	}
+	return server, proxy, cluster
+}
+
+func stopProxiedCluster(server *riaktest.Server, proxy *riaktest.Proxy, cluster *riak.Cluster) {
+	cluster.Stop()
+	proxy.Close()
+	server.Close()
+}
+
+func ping(cluster *riak.Cluster) error {
+	return cluster.Execute(&riak.PingCommand{})
+}
+
+func TestProxyRelaysTraffic(t *testing.T) {
+	server, proxy, cluster := startProxiedCluster(t, &riak.NodeOptions{})
+	defer stopProxiedCluster(server, proxy, cluster)
+	store(t, cluster, "default", "key", "value", nil)
+	resp := fetch(t, cluster, "default", "key")
+	if expected, actual := 1, len(resp.Values); expected != actual {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := "value", string(resp.Values[0].Value); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestProxyInjectsFaultGivenNumberOfTimes(t *testing.T) {
+	server, proxy, cluster := startProxiedCluster(t, &riak.NodeOptions{})
+	defer stopProxiedCluster(server, proxy, cluster)
+	proxy.Inject(riaktest.ErrorResponse("overload", 0), 2)
+	for i := 0; i < 2; i++ {
+		err := ping(cluster)
+		if riakErr, ok := err.(riak.RiakError); !ok {
+			t.Fatalf("expected RiakError, got %v", err)
+		} else if expected, actual := "overload", riakErr.Errmsg; expected != actual {
+			t.Errorf("expected %v, got %v", expected, actual)
+		}
+	}
+	if err := ping(cluster); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestProxyErrorResponseIsNotSentToTarget(t *testing.T) {
+	server, proxy, cluster := startProxiedCluster(t, &riak.NodeOptions{})
+	defer stopProxiedCluster(server, proxy, cluster)
+	proxy.Inject(riaktest.ErrorResponse("overload", 10*time.Millisecond), 1)
+	cmd, err := riak.NewStoreValueCommandBuilder().
+		WithBucket("bucket").
+		WithKey("key").
+		WithContent(&riak.Object{Value: []byte("value")}).
+		Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := cluster.Execute(cmd); err == nil {
+		t.Fatal("expected error")
+	}
+	if expected, actual := true, fetch(t, cluster, "default", "key").IsNotFound; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestProxyClearRemovesFaults(t *testing.T) {
+	server, proxy, cluster := startProxiedCluster(t, &riak.NodeOptions{})
+	defer stopProxiedCluster(server, proxy, cluster)
+	proxy.Inject(riaktest.ErrorResponse("overload", 0), 0)
+	for i := 0; i < 3; i++ {
+		if err := ping(cluster); err == nil {
+			t.Fatal("expected error")
+		}
+	}
+	proxy.Clear()
+	if err := ping(cluster); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestProxyLatencyDelaysResponse(t *testing.T) {
+	server, proxy, cluster := startProxiedCluster(t, &riak.NodeOptions{})
+	defer stopProxiedCluster(server, proxy, cluster)
+	proxy.Inject(riaktest.Latency(50*time.Millisecond), 1)
+	start := time.Now()
+	if err := ping(cluster); err != nil {
+		t.Fatal(err.Error())
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("expected a delay of at least 50ms, got %v", elapsed)
+	}
+}
+
+func TestProxyGarbageResponseCode(t *testing.T) {
+	server, proxy, cluster := startProxiedCluster(t, &riak.NodeOptions{})
+	defer stopProxiedCluster(server, proxy, cluster)
+	proxy.Inject(riaktest.GarbageResponseCode(), 1)
+	if err := ping(cluster); err == nil {
+		t.Fatal("expected error")
+	} else if _, ok := err.(riak.ClientError); !ok {
+		t.Errorf("expected ClientError, got %v", err)
+	}
+}
+
+func TestProxyBlackholeTimesOut(t *testing.T) {
+	server, proxy, cluster := startProxiedCluster(t, &riak.NodeOptions{
+		RequestTimeout: 50 * time.Millisecond,
+	})
+	defer stopProxiedCluster(server, proxy, cluster)
+	proxy.Inject(riaktest.Blackhole(), 1)
+	if err := ping(cluster); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestProxyCloseConnections(t *testing.T) {
+	server, proxy, cluster := startProxiedCluster(t, &riak.NodeOptions{})
+	defer stopProxiedCluster(server, proxy, cluster)
+	proxy.CloseConnections()
+	if err := ping(cluster); err == nil {
+		t.Fatal("expected error")
+	}
+	for i := 0; i < 100; i++ {
+		if err := ping(cluster); err == nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("expected the node to recover")
+}
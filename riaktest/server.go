@@ -0,0 +1,348 @@
+// Package riaktest provides an in-memory Riak server speaking the protocol
+// buffers wire protocol, for testing applications that use the Riak client
+// without a running Riak cluster.
+//
+//	server, err := riaktest.NewServer()
+//	if err != nil {
+//	    t.Fatal(err)
+//	}
+//	defer server.Close()
+//
+//	node, err := riak.NewNode(&riak.NodeOptions{
+//	    RemoteAddress: server.Addr(),
+//	})
+//
+// The server implements ping, server info, client ids, fetch / store / delete
+// of values with vclocks, siblings and tombstones, key and bucket listing,
+// secondary index queries, key range folds, counters, sets and maps, Riak 1.4
+// counters, and bucket and bucket type properties. It does not emulate
+// replication, quorums or timeouts; the related request options are accepted
+// and ignored. To test how an application copes with partial failure, put a
+// Proxy between the node and the server and inject faults.
+package riaktest
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	rpbRiak "github.com/basho/riak-go-client/rpb/riak"
+	rpbRiakKV "github.com/basho/riak-go-client/rpb/riak_kv"
+	proto "github.com/golang/protobuf/proto"
+)
+
+// message codes, see messages.go in the riak package
+const (
+	rpbCode_RpbErrorResp         byte = 0
+	rpbCode_RpbPingReq           byte = 1
+	rpbCode_RpbPingResp          byte = 2
+	rpbCode_RpbGetClientIdReq    byte = 3
+	rpbCode_RpbGetClientIdResp   byte = 4
+	rpbCode_RpbSetClientIdReq    byte = 5
+	rpbCode_RpbSetClientIdResp   byte = 6
+	rpbCode_RpbGetServerInfoReq  byte = 7
+	rpbCode_RpbGetServerInfoResp byte = 8
+	rpbCode_RpbGetReq            byte = 9
+	rpbCode_RpbGetResp           byte = 10
+	rpbCode_RpbPutReq            byte = 11
+	rpbCode_RpbPutResp           byte = 12
+	rpbCode_RpbDelReq            byte = 13
+	rpbCode_RpbDelResp           byte = 14
+	rpbCode_RpbListBucketsReq    byte = 15
+	rpbCode_RpbListBucketsResp   byte = 16
+	rpbCode_RpbListKeysReq       byte = 17
+	rpbCode_RpbListKeysResp      byte = 18
+	rpbCode_RpbGetBucketReq      byte = 19
+	rpbCode_RpbGetBucketResp     byte = 20
+	rpbCode_RpbSetBucketReq      byte = 21
+	rpbCode_RpbSetBucketResp     byte = 22
+	rpbCode_RpbIndexReq          byte = 25
+	rpbCode_RpbIndexResp         byte = 26
+	rpbCode_RpbResetBucketReq    byte = 29
+	rpbCode_RpbResetBucketResp   byte = 30
+	rpbCode_RpbGetBucketTypeReq  byte = 31
+	rpbCode_RpbSetBucketTypeReq  byte = 32
+	rpbCode_RpbCSBucketReq       byte = 40
+	rpbCode_RpbCSBucketResp      byte = 41
+	rpbCode_RpbCounterUpdateReq  byte = 50
+	rpbCode_RpbCounterUpdateResp byte = 51
+	rpbCode_RpbCounterGetReq     byte = 52
+	rpbCode_RpbCounterGetResp    byte = 53
+	rpbCode_DtFetchReq           byte = 80
+	rpbCode_DtFetchResp          byte = 81
+	rpbCode_DtUpdateReq          byte = 82
+	rpbCode_DtUpdateResp         byte = 83
+)
+
+// defaultServerVersion is the Riak version reported by a new server
+const defaultServerVersion = "2.2.3"
+
+// maxFrameSize is the largest request frame the server accepts
+const maxFrameSize = 64 * 1024 * 1024
+
+// Server errors
+var (
+	ErrBucketTypeExists = errors.New("[riaktest] bucket type already exists")
+	ErrUnknownDatatype  = errors.New("[riaktest] datatype must be one of 'counter', 'set' or 'map'")
+)
+
+// frame is a response message and its message code. A nil message is sent as
+// a frame with no body.
+type frame struct {
+	code byte
+	msg  proto.Message
+}
+
+// handler decodes the request data and returns the response frames, or an
+// error that is sent to the client as an RpbErrorResp
+type handler func(s *Server, data []byte) ([]frame, error)
+
+var handlers = map[byte]handler{
+	rpbCode_RpbPingReq:          handlePing,
+	rpbCode_RpbGetServerInfoReq: handleGetServerInfo,
+	rpbCode_RpbGetReq:           handleGet,
+	rpbCode_RpbPutReq:           handlePut,
+	rpbCode_RpbDelReq:           handleDel,
+	rpbCode_RpbListBucketsReq:   handleListBuckets,
+	rpbCode_RpbListKeysReq:      handleListKeys,
+	rpbCode_RpbGetBucketReq:     handleGetBucket,
+	rpbCode_RpbSetBucketReq:     handleSetBucket,
+	rpbCode_RpbIndexReq:         handleIndex,
+	rpbCode_RpbResetBucketReq:   handleResetBucket,
+	rpbCode_RpbGetBucketTypeReq: handleGetBucketType,
+	rpbCode_RpbSetBucketTypeReq: handleSetBucketType,
+	rpbCode_RpbCSBucketReq:      handleCSBucket,
+	rpbCode_RpbCounterUpdateReq: handleCounterUpdate,
+	rpbCode_RpbCounterGetReq:    handleCounterGet,
+	rpbCode_DtFetchReq:          handleDtFetch,
+	rpbCode_DtUpdateReq:         handleDtUpdate,
+}
+
+// connHandler is a handler for requests that use the state of the client
+// connection
+type connHandler func(s *Server, c *connState, data []byte) ([]frame, error)
+
+var connHandlers = map[byte]connHandler{
+	rpbCode_RpbGetClientIdReq: handleGetClientId,
+	rpbCode_RpbSetClientIdReq: handleSetClientId,
+}
+
+// connState is the state of a client connection
+type connState struct {
+	clientId []byte
+}
+
+// Server is an in-memory Riak node. It is safe for concurrent use by many
+// client connections.
+type Server struct {
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mtx         sync.Mutex
+	conns       map[net.Conn]bool
+	bucketTypes map[string]*rpbRiak.RpbBucketProps
+	buckets     map[bucketId]*bucket
+	counter     uint64
+	version     string
+}
+
+// NewServer starts a Server listening on a random port of the loopback interface
+func NewServer() (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		listener: listener,
+		conns:    make(map[net.Conn]bool),
+		bucketTypes: map[string]*rpbRiak.RpbBucketProps{
+			defaultBucketType: defaultBucketTypeProps(),
+		},
+		buckets: make(map[bucketId]*bucket),
+		version: defaultServerVersion,
+	}
+	s.wg.Add(1)
+	go s.accept()
+	return s, nil
+}
+
+// Addr returns the address the server is listening on, for use as NodeOptions.RemoteAddress
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close stops the server, closing every client connection
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.mtx.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mtx.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// CreateBucketType creates and activates a bucket type. Like Riak, buckets of
+// the new type allow siblings. The datatype may be "counter", "set" or "map" to
+// create a bucket type for Riak data types, or empty.
+func (s *Server) CreateBucketType(name string, datatype string) error {
+	switch datatype {
+	case "", datatypeCounter, datatypeSet, datatypeMap:
+	default:
+		return ErrUnknownDatatype
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if _, ok := s.bucketTypes[name]; ok {
+		return ErrBucketTypeExists
+	}
+	props := newBucketTypeProps()
+	if datatype != "" {
+		props.Datatype = []byte(datatype)
+	}
+	s.bucketTypes[name] = props
+	return nil
+}
+
+// SetServerVersion sets the Riak version the server reports, which defaults
+// to 2.2.3. Only the reported version changes; the server still implements
+// every request it supports.
+func (s *Server) SetServerVersion(version string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.version = version
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mtx.Lock()
+		s.conns[conn] = true
+		s.mtx.Unlock()
+		s.wg.Add(1)
+		go s.serve(conn)
+	}
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer func() {
+		s.mtx.Lock()
+		delete(s.conns, conn)
+		s.mtx.Unlock()
+		conn.Close()
+		s.wg.Done()
+	}()
+	state := &connState{}
+	sizeBuf := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(conn, sizeBuf); err != nil {
+			return
+		}
+		size := binary.BigEndian.Uint32(sizeBuf)
+		if size == 0 || size > maxFrameSize {
+			return
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return
+		}
+		for _, f := range s.handle(state, data[0], data[1:]) {
+			if err := writeFrame(conn, f); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) handle(state *connState, code byte, data []byte) []frame {
+	var frames []frame
+	var err error
+	if h, ok := handlers[code]; ok {
+		frames, err = h(s, data)
+	} else if h, ok := connHandlers[code]; ok {
+		frames, err = h(s, state, data)
+	} else {
+		return errorFrames(fmt.Errorf("unknown message code: %d", code))
+	}
+	if err != nil {
+		return errorFrames(err)
+	}
+	return frames
+}
+
+func errorFrames(err error) []frame {
+	return []frame{{
+		code: rpbCode_RpbErrorResp,
+		msg: &rpbRiak.RpbErrorResp{
+			Errmsg:  []byte(err.Error()),
+			Errcode: proto.Uint32(0),
+		},
+	}}
+}
+
+func writeFrame(w io.Writer, f frame) error {
+	var data []byte
+	if f.msg != nil {
+		var err error
+		if data, err = proto.Marshal(f.msg); err != nil {
+			return err
+		}
+	}
+	buf := make([]byte, 5+len(data))
+	binary.BigEndian.PutUint32(buf, uint32(len(data)+1))
+	buf[4] = f.code
+	copy(buf[5:], data)
+	_, err := w.Write(buf)
+	return err
+}
+
+func handlePing(s *Server, data []byte) ([]frame, error) {
+	return []frame{{code: rpbCode_RpbPingResp}}, nil
+}
+
+func handleGetServerInfo(s *Server, data []byte) ([]frame, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	resp := &rpbRiak.RpbGetServerInfoResp{
+		Node:          []byte("riaktest@127.0.0.1"),
+		ServerVersion: []byte(s.version),
+	}
+	return []frame{{code: rpbCode_RpbGetServerInfoResp, msg: resp}}, nil
+}
+
+func handleGetClientId(s *Server, c *connState, data []byte) ([]frame, error) {
+	if c.clientId == nil {
+		// NB: like Riak, assign a random looking client id to new connections
+		s.mtx.Lock()
+		id := s.nextCounter()
+		s.mtx.Unlock()
+		c.clientId = make([]byte, 4)
+		binary.BigEndian.PutUint32(c.clientId, uint32(id)*2654435761)
+	}
+	resp := &rpbRiakKV.RpbGetClientIdResp{ClientId: c.clientId}
+	return []frame{{code: rpbCode_RpbGetClientIdResp, msg: resp}}, nil
+}
+
+func handleSetClientId(s *Server, c *connState, data []byte) ([]frame, error) {
+	req := &rpbRiakKV.RpbSetClientIdReq{}
+	if err := proto.Unmarshal(data, req); err != nil {
+		return nil, err
+	}
+	c.clientId = req.ClientId
+	return []frame{{code: rpbCode_RpbSetClientIdResp}}, nil
+}
+
+// nextCounter returns a server-wide increasing number used for vtags, generated
+// keys and data type contexts. The server mutex must be held.
+func (s *Server) nextCounter() uint64 {
+	s.counter++
+	return s.counter
+}
@@ -0,0 +1,748 @@
+package riaktest_test
+
+import (
+	"fmt"
+	"testing"
+
+	riak "github.com/basho/riak-go-client"
+	"github.com/basho/riak-go-client/riaktest"
+)
+
+func startCluster(t *testing.T) (*riaktest.Server, *riak.Cluster) {
+	server, err := riaktest.NewServer()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	node, err := riak.NewNode(&riak.NodeOptions{
+		RemoteAddress: server.Addr(),
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	cluster, err := riak.NewCluster(&riak.ClusterOptions{
+		Nodes: []*riak.Node{node},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := cluster.Start(); err != nil {
+		t.Fatal(err.Error())
+	}
+	return server, cluster
+}
+
+func stopCluster(server *riaktest.Server, cluster *riak.Cluster) {
+	cluster.Stop()
+	server.Close()
+}
+
+func execute(t *testing.T, cluster *riak.Cluster, builder riak.CommandBuilder) riak.Command {
+	cmd, err := builder.Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := cluster.Execute(cmd); err != nil {
+		t.Fatal(err.Error())
+	}
+	return cmd
+}
+
+func store(t *testing.T, cluster *riak.Cluster, bucketType, key, value string, vclock []byte) *riak.StoreValueResponse {
+	obj := &riak.Object{
+		ContentType: "text/plain",
+		Value:       []byte(value),
+		VClock:      vclock,
+	}
+	cmd := execute(t, cluster, riak.NewStoreValueCommandBuilder().
+		WithBucketType(bucketType).
+		WithBucket("bucket").
+		WithKey(key).
+		WithContent(obj).
+		WithReturnBody(true))
+	return cmd.(*riak.StoreValueCommand).Response
+}
+
+func fetch(t *testing.T, cluster *riak.Cluster, bucketType, key string) *riak.FetchValueResponse {
+	cmd := execute(t, cluster, riak.NewFetchValueCommandBuilder().
+		WithBucketType(bucketType).
+		WithBucket("bucket").
+		WithKey(key))
+	return cmd.(*riak.FetchValueCommand).Response
+}
+
+func TestPing(t *testing.T) {
+	server, cluster := startCluster(t)
+	defer stopCluster(server, cluster)
+	cmd := &riak.PingCommand{}
+	if err := cluster.Execute(cmd); err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := true, cmd.Successful(); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestServerInfo(t *testing.T) {
+	server, cluster := startCluster(t)
+	defer stopCluster(server, cluster)
+	server.SetServerVersion("2.0.7")
+	cmd := execute(t, cluster, riak.NewFetchServerInfoCommandBuilder())
+	resp := cmd.(*riak.FetchServerInfoCommand).Response
+	if expected, actual := "2.0.7", resp.ServerVersion; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if resp.Node == "" {
+		t.Error("expected node name")
+	}
+}
+
+func TestClientId(t *testing.T) {
+	server, cluster := startCluster(t)
+	defer stopCluster(server, cluster)
+	cmd := execute(t, cluster, riak.NewFetchClientIdCommandBuilder())
+	if cmd.(*riak.FetchClientIdCommand).Response == "" {
+		t.Error("expected generated client id")
+	}
+	execute(t, cluster, riak.NewStoreClientIdCommandBuilder().WithClientId("client-1"))
+	cmd = execute(t, cluster, riak.NewFetchClientIdCommandBuilder())
+	if expected, actual := "client-1", cmd.(*riak.FetchClientIdCommand).Response; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestFetchNotFound(t *testing.T) {
+	server, cluster := startCluster(t)
+	defer stopCluster(server, cluster)
+	if expected, actual := true, fetch(t, cluster, "default", "missing").IsNotFound; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestDefaultBucketTypeDoesNotCreateSiblings(t *testing.T) {
+	server, cluster := startCluster(t)
+	defer stopCluster(server, cluster)
+	store(t, cluster, "default", "key", "one", nil)
+	store(t, cluster, "default", "key", "two", nil)
+	resp := fetch(t, cluster, "default", "key")
+	if expected, actual := 1, len(resp.Values); expected != actual {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := "two", string(resp.Values[0].Value); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := "text/plain", resp.Values[0].ContentType; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if resp.Values[0].VTag == "" {
+		t.Error("expected vtag")
+	}
+	if resp.Values[0].LastModified.IsZero() {
+		t.Error("expected last modified time")
+	}
+}
+
+func TestConcurrentWritesCreateSiblings(t *testing.T) {
+	server, cluster := startCluster(t)
+	defer stopCluster(server, cluster)
+	if err := server.CreateBucketType("siblings", ""); err != nil {
+		t.Fatal(err.Error())
+	}
+	first := store(t, cluster, "siblings", "key", "one", nil)
+	store(t, cluster, "siblings", "key", "two", nil)
+	resp := fetch(t, cluster, "siblings", "key")
+	if expected, actual := 2, len(resp.Values); expected != actual {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+
+	// a write based on the first vclock only replaces the first sibling
+	store(t, cluster, "siblings", "key", "three", first.VClock)
+	resp = fetch(t, cluster, "siblings", "key")
+	if expected, actual := 2, len(resp.Values); expected != actual {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := "two", string(resp.Values[0].Value); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := "three", string(resp.Values[1].Value); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	// a write with the latest vclock resolves the siblings
+	store(t, cluster, "siblings", "key", "resolved", resp.VClock)
+	resp = fetch(t, cluster, "siblings", "key")
+	if expected, actual := 1, len(resp.Values); expected != actual {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := "resolved", string(resp.Values[0].Value); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestDeleteCreatesTombstone(t *testing.T) {
+	server, cluster := startCluster(t)
+	defer stopCluster(server, cluster)
+	if err := server.CreateBucketType("siblings", ""); err != nil {
+		t.Fatal(err.Error())
+	}
+	stored := store(t, cluster, "siblings", "key", "one", nil)
+	execute(t, cluster, riak.NewDeleteValueCommandBuilder().
+		WithBucketType("siblings").
+		WithBucket("bucket").
+		WithKey("key").
+		WithVClock(stored.VClock))
+	if expected, actual := true, fetch(t, cluster, "siblings", "key").IsNotFound; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	cmd := execute(t, cluster, riak.NewFetchValueCommandBuilder().
+		WithBucketType("siblings").
+		WithBucket("bucket").
+		WithKey("key").
+		WithReturnDeletedVClock(true))
+	resp := cmd.(*riak.FetchValueCommand).Response
+	if len(resp.VClock) == 0 {
+		t.Error("expected vclock of deleted object")
+	}
+
+	// a write with a vclock from before the delete results in a tombstone sibling
+	store(t, cluster, "siblings", "key", "two", stored.VClock)
+	resp = fetch(t, cluster, "siblings", "key")
+	if expected, actual := 2, len(resp.Values); expected != actual {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := true, resp.Values[0].IsTombstone; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := "two", string(resp.Values[1].Value); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestStoreIfNotModified(t *testing.T) {
+	server, cluster := startCluster(t)
+	defer stopCluster(server, cluster)
+	first := store(t, cluster, "default", "key", "one", nil)
+	store(t, cluster, "default", "key", "two", first.VClock)
+	cmd, err := riak.NewStoreValueCommandBuilder().
+		WithBucket("bucket").
+		WithKey("key").
+		WithContent(&riak.Object{Value: []byte("three"), VClock: first.VClock}).
+		WithIfNotModified(true).
+		Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := cluster.Execute(cmd); err == nil {
+		t.Error("expected modified error")
+	}
+}
+
+func TestStoreGeneratesKey(t *testing.T) {
+	server, cluster := startCluster(t)
+	defer stopCluster(server, cluster)
+	resp := store(t, cluster, "default", "", "value", nil)
+	if resp.GeneratedKey == "" {
+		t.Fatal("expected generated key")
+	}
+	if expected, actual := "value", string(fetch(t, cluster, "default", resp.GeneratedKey).Values[0].Value); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestListKeysAndBuckets(t *testing.T) {
+	server, cluster := startCluster(t)
+	defer stopCluster(server, cluster)
+	for i := 0; i < 250; i++ {
+		store(t, cluster, "default", fmt.Sprintf("key%03d", i), "value", nil)
+	}
+
+	cmd := execute(t, cluster, riak.NewListKeysCommandBuilder().
+		WithBucket("bucket"))
+	keys := cmd.(*riak.ListKeysCommand).Response.Keys
+	if expected, actual := 250, len(keys); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	var streamed []string
+	execute(t, cluster, riak.NewListKeysCommandBuilder().
+		WithBucket("bucket").
+		WithStreaming(true).
+		WithCallback(func(keys []string) error {
+			streamed = append(streamed, keys...)
+			return nil
+		}))
+	if expected, actual := 250, len(streamed); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	cmd = execute(t, cluster, riak.NewListBucketsCommandBuilder().
+		WithBucketType("default"))
+	buckets := cmd.(*riak.ListBucketsCommand).Response.Buckets
+	if expected, actual := 1, len(buckets); expected != actual {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := "bucket", buckets[0]; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestSecondaryIndexQueries(t *testing.T) {
+	server, cluster := startCluster(t)
+	defer stopCluster(server, cluster)
+	for i := 0; i < 20; i++ {
+		obj := &riak.Object{Value: []byte("value")}
+		obj.AddToIntIndex("age_int", i)
+		obj.AddToIndex("parity_bin", []string{"even", "odd"}[i%2])
+		execute(t, cluster, riak.NewStoreValueCommandBuilder().
+			WithBucket("bucket").
+			WithKey(fmt.Sprintf("key%02d", i)).
+			WithContent(obj))
+	}
+
+	query := func(builder *riak.SecondaryIndexQueryCommandBuilder) *riak.SecondaryIndexQueryResponse {
+		cmd := execute(t, cluster, builder.WithBucket("bucket"))
+		return cmd.(*riak.SecondaryIndexQueryCommand).Response
+	}
+
+	// integer ranges compare numerically
+	resp := query(riak.NewSecondaryIndexQueryCommandBuilder().
+		WithIndexName("age_int").
+		WithIntRange(2, 10).
+		WithReturnKeyAndIndex(true))
+	if expected, actual := 9, len(resp.Results); expected != actual {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := "10", string(resp.Results[8].IndexKey); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	resp = query(riak.NewSecondaryIndexQueryCommandBuilder().
+		WithIndexName("parity_bin").
+		WithIndexKey("odd"))
+	if expected, actual := 10, len(resp.Results); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	resp = query(riak.NewSecondaryIndexQueryCommandBuilder().
+		WithIndexName("$key").
+		WithRange("key05", "key07"))
+	if expected, actual := 3, len(resp.Results); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	resp = query(riak.NewSecondaryIndexQueryCommandBuilder().
+		WithIndexName("$bucket").
+		WithIndexKey("bucket"))
+	if expected, actual := 20, len(resp.Results); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	// pagination
+	var keys []string
+	var continuation []byte
+	pages := 0
+	for {
+		resp = query(riak.NewSecondaryIndexQueryCommandBuilder().
+			WithIndexName("age_int").
+			WithIntRange(0, 100).
+			WithMaxResults(6).
+			WithContinuation(continuation))
+		pages++
+		for _, result := range resp.Results {
+			keys = append(keys, string(result.ObjectKey))
+		}
+		if continuation = resp.Continuation; continuation == nil {
+			break
+		}
+	}
+	if expected, actual := 4, pages; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := 20, len(keys); expected != actual {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := "key19", keys[19]; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	var streamed []*riak.SecondaryIndexQueryResult
+	query(riak.NewSecondaryIndexQueryCommandBuilder().
+		WithIndexName("parity_bin").
+		WithIndexKey("even").
+		WithStreaming(true).
+		WithCallback(func(results []*riak.SecondaryIndexQueryResult) error {
+			streamed = append(streamed, results...)
+			return nil
+		}))
+	if expected, actual := 10, len(streamed); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestKeyRangeFolds(t *testing.T) {
+	server, cluster := startCluster(t)
+	defer stopCluster(server, cluster)
+	for i := 0; i < 20; i++ {
+		store(t, cluster, "default", fmt.Sprintf("key%02d", i), fmt.Sprintf("value%02d", i), nil)
+	}
+	execute(t, cluster, riak.NewDeleteValueCommandBuilder().
+		WithBucket("bucket").
+		WithKey("key05"))
+
+	fold := func(builder *riak.ListObjectsByKeyRangeCommandBuilder) *riak.ListObjectsByKeyRangeResponse {
+		cmd := execute(t, cluster, builder.WithBucket("bucket"))
+		return cmd.(*riak.ListObjectsByKeyRangeCommand).Response
+	}
+
+	// the start key is included and the end key excluded by default, and
+	// tombstones are skipped
+	resp := fold(riak.NewListObjectsByKeyRangeCommandBuilder().
+		WithStartKey("key03").
+		WithEndKey("key08"))
+	if expected, actual := 4, len(resp.Objects); expected != actual {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := "value03", string(resp.Objects[0].Value); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if resp.Objects[0].VClock == nil {
+		t.Error("expected vclock")
+	}
+
+	resp = fold(riak.NewListObjectsByKeyRangeCommandBuilder().
+		WithStartKey("key03").
+		WithStartInclusive(false).
+		WithEndKey("key08").
+		WithEndInclusive(true))
+	if expected, actual := "key04", resp.Objects[0].Key; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := "key08", resp.Objects[len(resp.Objects)-1].Key; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	// pagination
+	var keys []string
+	var continuation []byte
+	pages := 0
+	for {
+		resp = fold(riak.NewListObjectsByKeyRangeCommandBuilder().
+			WithStartKey("key10").
+			WithMaxResults(4).
+			WithContinuation(continuation))
+		pages++
+		for _, o := range resp.Objects {
+			keys = append(keys, o.Key)
+		}
+		if continuation = resp.Continuation; continuation == nil {
+			break
+		}
+	}
+	if expected, actual := 3, pages; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := 10, len(keys); expected != actual {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := "key19", keys[9]; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestCounters(t *testing.T) {
+	server, cluster := startCluster(t)
+	defer stopCluster(server, cluster)
+	if err := server.CreateBucketType("counters", "counter"); err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, increment := range []int64{5, -2} {
+		execute(t, cluster, riak.NewUpdateCounterCommandBuilder().
+			WithBucketType("counters").
+			WithBucket("bucket").
+			WithKey("counter").
+			WithIncrement(increment))
+	}
+	cmd := execute(t, cluster, riak.NewFetchCounterCommandBuilder().
+		WithBucketType("counters").
+		WithBucket("bucket").
+		WithKey("counter"))
+	if expected, actual := int64(3), cmd.(*riak.FetchCounterCommand).Response.CounterValue; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	cmd = execute(t, cluster, riak.NewFetchCounterCommandBuilder().
+		WithBucketType("counters").
+		WithBucket("bucket").
+		WithKey("missing"))
+	if expected, actual := true, cmd.(*riak.FetchCounterCommand).Response.IsNotFound; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestLegacyCounters(t *testing.T) {
+	server, cluster := startCluster(t)
+	defer stopCluster(server, cluster)
+	update := riak.NewUpdateLegacyCounterCommandBuilder().
+		WithBucket("bucket").
+		WithKey("counter").
+		WithIncrement(5)
+	cmd, err := update.Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := cluster.Execute(cmd); err == nil {
+		t.Error("expected error without allow_mult")
+	}
+
+	execute(t, cluster, riak.NewStoreBucketPropsCommandBuilder().
+		WithBucket("bucket").
+		WithAllowMult(true))
+	execute(t, cluster, update)
+	cmd = execute(t, cluster, update.WithIncrement(-2).WithReturnValue(true))
+	if expected, actual := int64(3), cmd.(*riak.UpdateLegacyCounterCommand).Response.CounterValue; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	cmd = execute(t, cluster, riak.NewFetchLegacyCounterCommandBuilder().
+		WithBucket("bucket").
+		WithKey("counter"))
+	if expected, actual := int64(3), cmd.(*riak.FetchLegacyCounterCommand).Response.CounterValue; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	cmd = execute(t, cluster, riak.NewFetchLegacyCounterCommandBuilder().
+		WithBucket("bucket").
+		WithKey("missing"))
+	if expected, actual := true, cmd.(*riak.FetchLegacyCounterCommand).Response.IsNotFound; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestSets(t *testing.T) {
+	server, cluster := startCluster(t)
+	defer stopCluster(server, cluster)
+	if err := server.CreateBucketType("sets", "set"); err != nil {
+		t.Fatal(err.Error())
+	}
+	cmd := execute(t, cluster, riak.NewUpdateSetCommandBuilder().
+		WithBucketType("sets").
+		WithBucket("bucket").
+		WithKey("set").
+		WithAdditions([]byte("a"), []byte("b"), []byte("c")).
+		WithReturnBody(true))
+	context := cmd.(*riak.UpdateSetCommand).Response.Context
+	if context == nil {
+		t.Error("expected context")
+	}
+	execute(t, cluster, riak.NewUpdateSetCommandBuilder().
+		WithBucketType("sets").
+		WithBucket("bucket").
+		WithKey("set").
+		WithContext(context).
+		WithRemovals([]byte("b")))
+	cmd = execute(t, cluster, riak.NewFetchSetCommandBuilder().
+		WithBucketType("sets").
+		WithBucket("bucket").
+		WithKey("set"))
+	values := cmd.(*riak.FetchSetCommand).Response.SetValue
+	if expected, actual := "[a c]", fmt.Sprintf("%s", values); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	// removing a value that is not present fails
+	removal, err := riak.NewUpdateSetCommandBuilder().
+		WithBucketType("sets").
+		WithBucket("bucket").
+		WithKey("set").
+		WithContext(context).
+		WithRemovals([]byte("z")).
+		Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := cluster.Execute(removal); err == nil {
+		t.Error("expected precondition error")
+	}
+}
+
+func TestMaps(t *testing.T) {
+	server, cluster := startCluster(t)
+	defer stopCluster(server, cluster)
+	if err := server.CreateBucketType("maps", "map"); err != nil {
+		t.Fatal(err.Error())
+	}
+	mapOp := &riak.MapOperation{}
+	mapOp.IncrementCounter("visits", 3).
+		AddToSet("tags", []byte("blue")).
+		SetRegister("name", []byte("Ada")).
+		SetFlag("enabled", true)
+	mapOp.Map("address").SetRegister("city", []byte("London"))
+	execute(t, cluster, riak.NewUpdateMapCommandBuilder().
+		WithBucketType("maps").
+		WithBucket("bucket").
+		WithKey("map").
+		WithMapOperation(mapOp))
+
+	cmd := execute(t, cluster, riak.NewFetchMapCommandBuilder().
+		WithBucketType("maps").
+		WithBucket("bucket").
+		WithKey("map"))
+	m := cmd.(*riak.FetchMapCommand).Response.Map
+	if expected, actual := int64(3), m.Counters["visits"]; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := "blue", string(m.Sets["tags"][0]); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := "Ada", string(m.Registers["name"]); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := true, m.Flags["enabled"]; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := "London", string(m.Maps["address"].Registers["city"]); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestDatatypeRequiresDatatypeBucket(t *testing.T) {
+	server, cluster := startCluster(t)
+	defer stopCluster(server, cluster)
+	cmd, err := riak.NewUpdateCounterCommandBuilder().
+		WithBucket("bucket").
+		WithKey("counter").
+		WithIncrement(1).
+		Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := cluster.Execute(cmd); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestBucketProps(t *testing.T) {
+	server, cluster := startCluster(t)
+	defer stopCluster(server, cluster)
+	execute(t, cluster, riak.NewStoreBucketPropsCommandBuilder().
+		WithBucket("bucket").
+		WithAllowMult(true).
+		WithNVal(5))
+	cmd := execute(t, cluster, riak.NewFetchBucketPropsCommandBuilder().
+		WithBucket("bucket"))
+	props := cmd.(*riak.FetchBucketPropsCommand).Response
+	if expected, actual := true, props.AllowMult; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := uint32(5), props.NVal; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	// the bucket now allows siblings
+	store(t, cluster, "default", "key", "one", nil)
+	store(t, cluster, "default", "key", "two", nil)
+	if expected, actual := 2, len(fetch(t, cluster, "default", "key").Values); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestBucketTypeProps(t *testing.T) {
+	server, cluster := startCluster(t)
+	defer stopCluster(server, cluster)
+	if err := server.CreateBucketType("sets", "set"); err != nil {
+		t.Fatal(err.Error())
+	}
+	execute(t, cluster, riak.NewStoreBucketTypePropsCommandBuilder().
+		WithBucketType("sets").
+		WithNVal(5))
+	cmd := execute(t, cluster, riak.NewFetchBucketTypePropsCommandBuilder().
+		WithBucketType("sets"))
+	props := cmd.(*riak.FetchBucketTypePropsCommand).Response
+	if expected, actual := uint32(5), props.NVal; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := "set", props.DataType; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	// buckets inherit the bucket type properties
+	cmd = execute(t, cluster, riak.NewFetchBucketPropsCommandBuilder().
+		WithBucketType("sets").
+		WithBucket("bucket"))
+	if expected, actual := uint32(5), cmd.(*riak.FetchBucketPropsCommand).Response.NVal; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	missing, err := riak.NewFetchBucketTypePropsCommandBuilder().WithBucketType("missing").Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := cluster.Execute(missing); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestResetBucketProps(t *testing.T) {
+	server, cluster := startCluster(t)
+	defer stopCluster(server, cluster)
+	execute(t, cluster, riak.NewStoreBucketPropsCommandBuilder().
+		WithBucket("bucket").
+		WithNVal(5))
+	execute(t, cluster, riak.NewResetBucketPropsCommandBuilder().
+		WithBucket("bucket"))
+	cmd := execute(t, cluster, riak.NewFetchBucketPropsCommandBuilder().
+		WithBucket("bucket"))
+	if expected, actual := uint32(3), cmd.(*riak.FetchBucketPropsCommand).Response.NVal; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestBucketPropertiesRoundTrip(t *testing.T) {
+	server, cluster := startCluster(t)
+	defer stopCluster(server, cluster)
+	if err := server.CreateBucketType("sets", "set"); err != nil {
+		t.Fatal(err.Error())
+	}
+	fetchProps := func() *riak.BucketProperties {
+		cmd := execute(t, cluster, riak.NewFetchBucketPropsCommandBuilder().
+			WithBucketType("sets").
+			WithBucket("bucket"))
+		return &cmd.(*riak.FetchBucketPropsCommand).Response.BucketProperties
+	}
+	before := fetchProps()
+	modified := *before
+	modified.NVal = 5
+	modified.R = riak.QuorumAll
+	modified.PreCommit = []*riak.CommitHook{{Name: "validate"}}
+	execute(t, cluster, riak.NewStoreBucketPropsCommandBuilder().
+		WithBucketType("sets").
+		WithBucket("bucket").
+		WithProperties(&modified))
+	after := fetchProps()
+	if changes := riak.Diff(&modified, after); len(changes) != 0 {
+		t.Errorf("expected no changes, got %v", changes)
+	}
+	if expected, actual := 3, len(riak.Diff(before, after)); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	// storing the hooks again replaces them
+	execute(t, cluster, riak.NewStoreBucketPropsCommandBuilder().
+		WithBucketType("sets").
+		WithBucket("bucket").
+		WithProperties(after))
+	if expected, actual := 1, len(fetchProps().PreCommit); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	changeDatatype, err := riak.NewStoreBucketPropsCommandBuilder().
+		WithBucketType("sets").
+		WithBucket("bucket").
+		WithDataType("map").
+		Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := cluster.Execute(changeDatatype); err == nil {
+		t.Error("expected error")
+	}
+}
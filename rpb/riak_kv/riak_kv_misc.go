@@ -109,3 +109,22 @@ func (m *RpbIndexReq) BucketIsRequired() bool {
 func (m *RpbIndexReq) KeyIsRequired() bool {
 	return false
 }
+
+// RpbCSBucketReq
+
+func (m *RpbCSBucketReq) SetType(bt []byte) {
+	m.Type = bt
+}
+
+func (m *RpbCSBucketReq) BucketIsRequired() bool {
+	return true
+}
+
+// GetKey returns the start of the key range
+func (m *RpbCSBucketReq) GetKey() []byte {
+	return m.GetStartKey()
+}
+
+func (m *RpbCSBucketReq) KeyIsRequired() bool {
+	return false
+}
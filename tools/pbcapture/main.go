@@ -0,0 +1,129 @@
+// pbcapture decodes, replays and serves PB captures recorded with riak.Capture
+//
+//	pbcapture decode <capture>
+//	pbcapture replay [-addr 127.0.0.1:8087] [-out <capture>] <capture>
+//	pbcapture serve [-listen 127.0.0.1:8087] <capture>
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	riak "github.com/basho/riak-go-client"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	var err error
+	switch os.Args[1] {
+	case "decode":
+		err = decode(os.Args[2:])
+	case "replay":
+		err = replay(os.Args[2:])
+	case "serve":
+		err = serve(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: pbcapture decode|replay|serve [flags] <capture>")
+	os.Exit(2)
+}
+
+func readCapture(path string) ([]*riak.CaptureFrame, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return riak.ReadCapture(f)
+}
+
+func decode(args []string) error {
+	fs := flag.NewFlagSet("decode", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+	}
+	frames, err := readCapture(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	for _, frame := range frames {
+		fmt.Println(frame)
+	}
+	return nil
+}
+
+func replay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	addr := fs.String("addr", "127.0.0.1:8087", "address of the Riak node to replay against")
+	out := fs.String("out", "", "file to write the replayed frames to")
+	timeout := fs.Duration("timeout", 5*time.Second, "timeout for each request")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+	}
+	frames, err := readCapture(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	replayed, replayErr := riak.ReplayCapture(*addr, frames, *timeout)
+
+	for _, mismatch := range riak.CompareReplay(frames, replayed) {
+		if mismatch.Expected == nil {
+			fmt.Printf("unexpected: %v\n", mismatch.Got)
+		} else {
+			fmt.Printf("expected: %v\n     got: %v\n", mismatch.Expected, mismatch.Got)
+		}
+	}
+
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		capture := riak.NewCapture(f)
+		for _, frame := range replayed {
+			capture.WriteFrame(frame)
+		}
+		if err = capture.Err(); err != nil {
+			return err
+		}
+	}
+	return replayErr
+}
+
+func serve(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	listen := fs.String("listen", "127.0.0.1:8087", "address to listen on")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+	}
+	frames, err := readCapture(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	server, err := riak.StartCaptureServer(*listen, frames)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("serving %d frames on %s\n", len(frames), server.Addr())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	<-signals
+	return server.Close()
+}
@@ -0,0 +1,279 @@
+package riak
+
+import (
+	"fmt"
+	"time"
+)
+
+// UpdateFunc modifies the object fetched by UpdateValue, or returns an error to
+// abort the update. The object is new, with a nil Value, if there is no object
+// to update. It may be modified and returned, or replaced.
+type UpdateFunc func(o *Object) (*Object, error)
+
+// UpdateValue errors
+var (
+	ErrUpdateFuncRequired = newClientError("[UpdateValue] an update function is required")
+	ErrUpdateSiblings     = newClientError("[UpdateValue] siblings were not resolved")
+	ErrUpdateConflict     = newClientError("[UpdateValue] object kept changing, no more attempts")
+)
+
+// UpdateValue is a read-modify-write of a value. It fetches the object,
+// resolves its siblings, applies an UpdateFunc and stores the result with the
+// fetched vclock, only if the object was not modified in the meantime. When it
+// was, the update is tried again from the fetch. When the store creates
+// siblings anyway, such as with concurrent writes on another node, they are
+// resolved and stored again, unless they resolve to a tombstone of a concurrent
+// delete: then the update is applied again to the deleted object.
+type UpdateValue struct {
+	Response *UpdateValueResponse
+
+	bucketType  string
+	bucket      string
+	key         string
+	update      UpdateFunc
+	resolver    ConflictResolver
+	maxAttempts int
+	timeout     time.Duration
+}
+
+// UpdateValueResponse contains the response data of an UpdateValue
+type UpdateValueResponse struct {
+	// Object is the stored object, with its new vclock
+	Object *Object
+	// Attempts is the number of stores it took, one without conflicts
+	Attempts int
+}
+
+// UpdateValueBuilder is required for creating new instances of UpdateValue
+//
+//	update, err := NewUpdateValueBuilder().
+//	    WithBucketType("myBucketType").
+//	    WithBucket("myBucket").
+//	    WithKey("myKey").
+//	    WithConflictResolver(NewLastModifiedResolver()).
+//	    WithUpdateFunc(func(o *Object) (*Object, error) {
+//	        o.Value = []byte("updated")
+//	        return o, nil
+//	    }).
+//	    Build()
+//	err = update.Execute(cluster)
+type UpdateValueBuilder struct {
+	update *UpdateValue
+}
+
+// NewUpdateValueBuilder is a factory function for generating the builder struct
+func NewUpdateValueBuilder() *UpdateValueBuilder {
+	return &UpdateValueBuilder{update: &UpdateValue{
+		bucketType:  defaultBucketType,
+		maxAttempts: 3,
+	}}
+}
+
+// WithBucketType sets the bucket-type to be used. If omitted, 'default' is used
+func (builder *UpdateValueBuilder) WithBucketType(bucketType string) *UpdateValueBuilder {
+	builder.update.bucketType = bucketType
+	return builder
+}
+
+// WithBucket sets the bucket to be used
+func (builder *UpdateValueBuilder) WithBucket(bucket string) *UpdateValueBuilder {
+	builder.update.bucket = bucket
+	return builder
+}
+
+// WithKey sets the key of the value to update
+func (builder *UpdateValueBuilder) WithKey(key string) *UpdateValueBuilder {
+	builder.update.key = key
+	return builder
+}
+
+// WithUpdateFunc sets the function modifying the value
+func (builder *UpdateValueBuilder) WithUpdateFunc(update UpdateFunc) *UpdateValueBuilder {
+	builder.update.update = update
+	return builder
+}
+
+// WithConflictResolver sets the resolver for siblings. It must resolve them to
+// a single object, if necessary with NewTombstoneResolver. Without a resolver
+// the update fails when there are siblings.
+func (builder *UpdateValueBuilder) WithConflictResolver(resolver ConflictResolver) *UpdateValueBuilder {
+	builder.update.resolver = resolver
+	return builder
+}
+
+// WithMaxAttempts sets the number of times the value is stored before giving up
+// with ErrUpdateConflict. If omitted, 3 is used.
+func (builder *UpdateValueBuilder) WithMaxAttempts(maxAttempts int) *UpdateValueBuilder {
+	builder.update.maxAttempts = maxAttempts
+	return builder
+}
+
+// WithTimeout sets a timeout to be used for each fetch and store
+func (builder *UpdateValueBuilder) WithTimeout(timeout time.Duration) *UpdateValueBuilder {
+	builder.update.timeout = timeout
+	return builder
+}
+
+// Build validates the configuration options provided then builds the UpdateValue
+func (builder *UpdateValueBuilder) Build() (*UpdateValue, error) {
+	update := *builder.update
+	if update.bucket == "" {
+		return nil, ErrBucketRequired
+	}
+	if update.key == "" {
+		return nil, ErrKeyRequired
+	}
+	if update.update == nil {
+		return nil, ErrUpdateFuncRequired
+	}
+	if update.maxAttempts < 1 {
+		return nil, newClientError("[UpdateValue] maxAttempts must be positive")
+	}
+	return &update, nil
+}
+
+// isPreconditionFailure returns whether err is Riak refusing a conditional
+// store because the object changed since it was fetched
+func isPreconditionFailure(err error) bool {
+	if riakError, ok := err.(RiakError); ok {
+		switch riakError.Errmsg {
+		case "modified", "match_found", "notfound":
+			return true
+		}
+	}
+	return false
+}
+
+// Execute executes the update with the executor, usually a Cluster, setting
+// Response if it succeeds
+func (update *UpdateValue) Execute(executor Executor) error {
+	update.Response = nil
+	// NB: set when a store created siblings, to resolve them instead of
+	// fetching the object and applying the update again
+	var siblings []*Object
+	var vclock []byte
+	for attempt := 1; attempt <= update.maxAttempts; attempt++ {
+		var o *Object
+		var live bool
+		if siblings != nil {
+			if o = update.resolve(siblings); o == nil {
+				return ErrUpdateSiblings
+			}
+			siblings = nil
+			live = true
+			if o.IsTombstone {
+				// NB: the object was deleted concurrently, so the update is
+				// applied again to what a fetch returns, as a new object
+				logDebug("[UpdateValue]", "'%s' resolved to a tombstone, fetching", update.key)
+				o = nil
+			}
+		}
+		if o == nil {
+			var err error
+			if o, vclock, live, err = update.fetch(executor); err != nil {
+				return err
+			}
+			if o, err = update.update(o); err != nil {
+				return err
+			}
+			if o == nil {
+				return newClientError("[UpdateValue] the update function returned no object")
+			}
+		}
+
+		stored, err := update.store(executor, o, vclock, live)
+		if isPreconditionFailure(err) {
+			logDebug("[UpdateValue]", "'%s' was modified, retrying", update.key)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if len(stored.Values) > 1 {
+			logDebug("[UpdateValue]", "'%s' has siblings, resolving", update.key)
+			siblings, vclock = stored.Values, stored.VClock
+			continue
+		}
+		result := *o
+		result.VClock = stored.VClock
+		update.Response = &UpdateValueResponse{Object: &result, Attempts: attempt}
+		return nil
+	}
+	return ErrUpdateConflict
+}
+
+// resolve returns the object the siblings resolve to, or nil
+func (update *UpdateValue) resolve(siblings []*Object) *Object {
+	if len(siblings) > 1 && update.resolver != nil {
+		siblings = update.resolver.Resolve(siblings)
+	}
+	if len(siblings) != 1 {
+		return nil
+	}
+	return siblings[0]
+}
+
+// fetch returns the object to update with its vclock, and whether it is live
+// rather than deleted or not found
+func (update *UpdateValue) fetch(executor Executor) (*Object, []byte, bool, error) {
+	builder := NewFetchValueCommandBuilder().
+		WithBucketType(update.bucketType).
+		WithBucket(update.bucket).
+		WithKey(update.key)
+	if update.timeout > 0 {
+		builder.WithTimeout(update.timeout)
+	}
+	cmd, err := builder.Build()
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if err = executor.Execute(cmd); err != nil {
+		return nil, nil, false, err
+	}
+	response := cmd.(*FetchValueCommand).Response
+	newObject := &Object{BucketType: update.bucketType, Bucket: update.bucket, Key: update.key}
+	if response.IsNotFound || len(response.Values) == 0 {
+		return newObject, nil, false, nil
+	}
+	o := update.resolve(response.Values)
+	if o == nil {
+		return nil, nil, false, ErrUpdateSiblings
+	}
+	if o.IsTombstone {
+		return newObject, response.VClock, false, nil
+	}
+	return o, response.VClock, true, nil
+}
+
+// store stores the object if the object in Riak still has the vclock, or if
+// there is no live object in Riak when live is false
+func (update *UpdateValue) store(executor Executor, o *Object, vclock []byte, live bool) (*StoreValueResponse, error) {
+	o.BucketType, o.Bucket, o.Key = update.bucketType, update.bucket, update.key
+	o.VClock = vclock
+	builder := NewStoreValueCommandBuilder().
+		WithBucketType(update.bucketType).
+		WithBucket(update.bucket).
+		WithKey(update.key).
+		WithContent(o).
+		WithReturnBody(true)
+	if live {
+		builder.WithIfNotModified(true)
+	} else {
+		builder.WithIfNoneMatch(true)
+	}
+	if update.timeout > 0 {
+		builder.WithTimeout(update.timeout)
+	}
+	cmd, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
+	if err = executor.Execute(cmd); err != nil {
+		return nil, err
+	}
+	response := cmd.(*StoreValueCommand).Response
+	if response == nil {
+		return nil, fmt.Errorf("[UpdateValue] no response storing '%s'", update.key)
+	}
+	return response, nil
+}
@@ -0,0 +1,293 @@
+package riak
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// increment is an UpdateFunc incrementing an integer stored as text
+func increment(o *Object) (*Object, error) {
+	n := 0
+	if o.Value != nil {
+		var err error
+		if n, err = strconv.Atoi(string(o.Value)); err != nil {
+			return nil, err
+		}
+	}
+	o.ContentType = ContentTypeText
+	o.Value = []byte(strconv.Itoa(n + 1))
+	return o, nil
+}
+
+func buildUpdateValue(t *testing.T, update UpdateFunc) *UpdateValue {
+	u, err := NewUpdateValueBuilder().
+		WithBucketType("users").
+		WithBucket("counts").
+		WithKey("visits").
+		WithConflictResolver(NewLastModifiedResolver()).
+		WithUpdateFunc(update).
+		Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return u
+}
+
+func storeTestValue(t *testing.T, executor Executor, value string, vclock []byte) {
+	cmd, err := NewStoreValueCommandBuilder().
+		WithBucketType("users").
+		WithBucket("counts").
+		WithKey("visits").
+		WithContent(&Object{ContentType: ContentTypeText, Value: []byte(value), VClock: vclock}).
+		Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := executor.Execute(cmd); err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+func fetchTestValue(t *testing.T, executor Executor) *FetchValueResponse {
+	cmd, err := NewFetchValueCommandBuilder().
+		WithBucketType("users").
+		WithBucket("counts").
+		WithKey("visits").
+		Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := executor.Execute(cmd); err != nil {
+		t.Fatal(err.Error())
+	}
+	return cmd.(*FetchValueCommand).Response
+}
+
+func TestBuildUpdateValue(t *testing.T) {
+	if _, err := NewUpdateValueBuilder().WithBucket("b").WithKey("k").Build(); err != ErrUpdateFuncRequired {
+		t.Errorf("expected %v, got %v", ErrUpdateFuncRequired, err)
+	}
+	if _, err := NewUpdateValueBuilder().WithBucket("b").WithUpdateFunc(increment).Build(); err != ErrKeyRequired {
+		t.Errorf("expected %v, got %v", ErrKeyRequired, err)
+	}
+	if _, err := NewUpdateValueBuilder().WithBucket("b").WithKey("k").WithUpdateFunc(increment).WithMaxAttempts(0).Build(); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestUpdateValueCreatesAndUpdates(t *testing.T) {
+	server, cluster := startTestServerCluster(t)
+	defer stopTestServerCluster(server, cluster)
+	if err := server.CreateBucketType("users", ""); err != nil {
+		t.Fatal(err.Error())
+	}
+	for i := 1; i <= 3; i++ {
+		u := buildUpdateValue(t, increment)
+		if err := u.Execute(cluster); err != nil {
+			t.Fatal(err.Error())
+		}
+		if expected, actual := strconv.Itoa(i), string(u.Response.Object.Value); expected != actual {
+			t.Errorf("expected %v, got %v", expected, actual)
+		}
+		if expected, actual := 1, u.Response.Attempts; expected != actual {
+			t.Errorf("expected %v, got %v", expected, actual)
+		}
+	}
+	response := fetchTestValue(t, cluster)
+	if expected, actual := 1, len(response.Values); expected != actual {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := "3", string(response.Values[0].Value); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestUpdateValueResolvesSiblings(t *testing.T) {
+	server, cluster := startTestServerCluster(t)
+	defer stopTestServerCluster(server, cluster)
+	if err := server.CreateBucketType("users", ""); err != nil {
+		t.Fatal(err.Error())
+	}
+	storeTestValue(t, cluster, "1", nil)
+	storeTestValue(t, cluster, "5", nil)
+	storeTestValue(t, cluster, "3", nil)
+	u := buildUpdateValue(t, increment)
+	if err := u.Execute(cluster); err != nil {
+		t.Fatal(err.Error())
+	}
+	response := fetchTestValue(t, cluster)
+	if expected, actual := 1, len(response.Values); expected != actual {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := "4", string(response.Values[0].Value); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	// without a resolver, siblings fail the update
+	storeTestValue(t, cluster, "1", nil)
+	u, err := NewUpdateValueBuilder().
+		WithBucketType("users").
+		WithBucket("counts").
+		WithKey("visits").
+		WithUpdateFunc(increment).
+		Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := ErrUpdateSiblings, u.Execute(cluster); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestUpdateValueRetriesConcurrentModification(t *testing.T) {
+	server, cluster := startTestServerCluster(t)
+	defer stopTestServerCluster(server, cluster)
+	if err := server.CreateBucketType("users", ""); err != nil {
+		t.Fatal(err.Error())
+	}
+	storeTestValue(t, cluster, "1", nil)
+	calls := 0
+	u := buildUpdateValue(t, func(o *Object) (*Object, error) {
+		calls++
+		if calls == 1 {
+			// NB: another client updates the value first
+			storeTestValue(t, cluster, "10", o.VClock)
+		}
+		return increment(o)
+	})
+	if err := u.Execute(cluster); err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := 2, u.Response.Attempts; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	response := fetchTestValue(t, cluster)
+	if expected, actual := 1, len(response.Values); expected != actual {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := "11", string(response.Values[0].Value); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	// a value that keeps changing exhausts the attempts
+	u = buildUpdateValue(t, func(o *Object) (*Object, error) {
+		storeTestValue(t, cluster, "0", o.VClock)
+		return increment(o)
+	})
+	if expected, actual := ErrUpdateConflict, u.Execute(cluster); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestUpdateValueRetriesConcurrentCreation(t *testing.T) {
+	server, cluster := startTestServerCluster(t)
+	defer stopTestServerCluster(server, cluster)
+	if err := server.CreateBucketType("users", ""); err != nil {
+		t.Fatal(err.Error())
+	}
+	calls := 0
+	u := buildUpdateValue(t, func(o *Object) (*Object, error) {
+		calls++
+		if calls == 1 {
+			storeTestValue(t, cluster, "10", nil)
+		}
+		return increment(o)
+	})
+	if err := u.Execute(cluster); err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected, actual := "11", string(u.Response.Object.Value); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestUpdateValueResolvesSiblingsCreatedByStore(t *testing.T) {
+	executor := NewMockExecutor()
+	executor.On(&FetchValueCommand{}).Respond(&FetchValueResponse{
+		VClock: []byte("vclock1"),
+		Values: []*Object{{ContentType: ContentTypeText, Value: []byte("1"), VTag: "a"}},
+	})
+	executor.On(&StoreValueCommand{}).Times(1).Respond(&StoreValueResponse{
+		VClock: []byte("vclock2"),
+		Values: []*Object{
+			{ContentType: ContentTypeText, Value: []byte("2"), VTag: "a"},
+			{ContentType: ContentTypeText, Value: []byte("7"), VTag: "b"},
+		},
+	})
+	executor.On(&StoreValueCommand{}).Respond(&StoreValueResponse{
+		VClock: []byte("vclock3"),
+		Values: []*Object{{ContentType: ContentTypeText, Value: []byte("7")}},
+	})
+	u := buildUpdateValue(t, increment)
+	if err := u.Execute(executor); err != nil {
+		t.Fatal(err.Error())
+	}
+	executed := executor.Executed()
+	if expected, actual := 3, len(executed); expected != actual {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+	first, second := executed[1].(*StoreValueCommand), executed[2].(*StoreValueCommand)
+	if expected, actual := "vclock1", string(first.value.VClock); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := true, first.protobuf.GetIfNotModified(); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := "vclock2", string(second.value.VClock); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := "7", string(second.value.Value); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := "vclock3", string(u.Response.Object.VClock); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := 2, u.Response.Attempts; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestUpdateValueFetchesAgainWhenSiblingsResolveToTombstone(t *testing.T) {
+	executor := NewMockExecutor()
+	executor.On(&FetchValueCommand{}).Times(1).Respond(&FetchValueResponse{
+		VClock: []byte("vclock1"),
+		Values: []*Object{{ContentType: ContentTypeText, Value: []byte("1"), LastModified: time.Unix(1, 0)}},
+	})
+	executor.On(&StoreValueCommand{}).Times(1).Respond(&StoreValueResponse{
+		VClock: []byte("vclock2"),
+		Values: []*Object{
+			{ContentType: ContentTypeText, Value: []byte("2"), LastModified: time.Unix(2, 0)},
+			{IsTombstone: true, LastModified: time.Unix(3, 0)},
+		},
+	})
+	executor.On(&FetchValueCommand{}).Respond(&FetchValueResponse{
+		VClock: []byte("vclock3"),
+		Values: []*Object{{IsTombstone: true, LastModified: time.Unix(3, 0)}},
+	})
+	executor.On(&StoreValueCommand{}).Respond(&StoreValueResponse{
+		VClock: []byte("vclock4"),
+		Values: []*Object{{ContentType: ContentTypeText, Value: []byte("1")}},
+	})
+	u := buildUpdateValue(t, increment)
+	if err := u.Execute(executor); err != nil {
+		t.Fatal(err.Error())
+	}
+	executed := executor.Executed()
+	if expected, actual := 4, len(executed); expected != actual {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+	store := executed[3].(*StoreValueCommand)
+	if store.value.IsTombstone {
+		t.Error("expected the tombstone not to be stored")
+	}
+	if expected, actual := "1", string(store.value.Value); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := true, store.protobuf.GetIfNoneMatch(); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if expected, actual := "vclock3", string(store.value.VClock); expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
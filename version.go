@@ -0,0 +1,79 @@
+package riak
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a Riak server version, as reported by FetchServerInfoCommand
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+// Riak versions that introduced features used by the client
+var (
+	version1_4 = Version{Major: 1, Minor: 4}
+	version2_0 = Version{Major: 2, Minor: 0}
+	version2_1 = Version{Major: 2, Minor: 1}
+)
+
+// ParseVersion parses a Riak server version such as "2.1.4". Missing minor and
+// patch numbers are zero, and anything following the last number, such as the
+// "p1" in "2.0.0p1" or a git description, is ignored.
+func ParseVersion(s string) (v Version, err error) {
+	parts := strings.SplitN(s, ".", 3)
+	nums := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, part := range parts {
+		end := 0
+		for end < len(part) && part[end] >= '0' && part[end] <= '9' {
+			end++
+		}
+		if end == 0 {
+			if i == 0 {
+				return Version{}, newClientError(fmt.Sprintf("[Version] invalid version '%s'", s))
+			}
+			break
+		}
+		if *nums[i], err = strconv.Atoi(part[:end]); err != nil {
+			return Version{}, newClientError(fmt.Sprintf("[Version] invalid version '%s'", s))
+		}
+		if end < len(part) {
+			break
+		}
+	}
+	return v, nil
+}
+
+// Less reports whether v is an earlier version than other
+func (v Version) Less(other Version) bool {
+	if v.Major != other.Major {
+		return v.Major < other.Major
+	}
+	if v.Minor != other.Minor {
+		return v.Minor < other.Minor
+	}
+	return v.Patch < other.Patch
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// versionedCommand is implemented by commands that use a feature introduced in
+// a later Riak version than the oldest one supported by the client. Nodes with
+// a known, older version refuse to execute them.
+type versionedCommand interface {
+	minimumVersion() Version
+}
+
+func checkServerVersion(cmd Command, v Version) error {
+	if vc, ok := cmd.(versionedCommand); ok {
+		if min := vc.minimumVersion(); v.Less(min) {
+			return newClientError(fmt.Sprintf("[Command] %s requires Riak %v or later, node runs %v", cmd.Name(), min, v))
+		}
+	}
+	return nil
+}
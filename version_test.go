@@ -0,0 +1,127 @@
+package riak
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected Version
+	}{
+		{"2.1.4", Version{2, 1, 4}},
+		{"2.0.0p5", Version{2, 0, 0}},
+		{"1.4", Version{1, 4, 0}},
+		{"2.2.3-0-g123abc", Version{2, 2, 3}},
+		{"2", Version{2, 0, 0}},
+	}
+	for _, test := range tests {
+		v, err := ParseVersion(test.s)
+		if err != nil {
+			t.Errorf("%v: %v", test.s, err)
+			continue
+		}
+		if expected, actual := test.expected, v; expected != actual {
+			t.Errorf("expected %v, got %v", expected, actual)
+		}
+	}
+	if _, err := ParseVersion("riak"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestVersionLess(t *testing.T) {
+	if !(Version{1, 4, 12}).Less(Version{2, 0, 0}) {
+		t.Error("expected 1.4.12 < 2.0.0")
+	}
+	if !(Version{2, 1, 3}).Less(Version{2, 1, 4}) {
+		t.Error("expected 2.1.3 < 2.1.4")
+	}
+	if (Version{2, 1, 0}).Less(Version{2, 1, 0}) {
+		t.Error("expected 2.1.0 not < 2.1.0")
+	}
+}
+
+func TestNodeRefusesCommandsNewerThanServerVersion(t *testing.T) {
+	server, cluster := startTestServerCluster(t)
+	defer stopTestServerCluster(server, cluster)
+	server.SetServerVersion("1.4.12")
+
+	versions, err := cluster.ServerVersions()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	node := cluster.nodes[0]
+	if expected, actual := "1.4.12", versions[node.addr.String()]; expected != actual {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if v, ok := node.ServerVersion(); !ok || v != (Version{1, 4, 12}) {
+		t.Errorf("expected 1.4.12, got %v, %v", v, ok)
+	}
+
+	cmd, err := NewUpdateCounterCommandBuilder().
+		WithBucketType("counters").
+		WithBucket("b").
+		WithKey("k").
+		WithIncrement(1).
+		Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = cluster.Execute(cmd)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if _, ok := err.(ClientError); !ok || !strings.Contains(err.Error(), "UpdateCounter requires Riak 2.0.0 or later, node runs 1.4.12") {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	legacy, err := NewUpdateLegacyCounterCommandBuilder().
+		WithBucket("b").
+		WithKey("k").
+		WithIncrement(1).
+		Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	// NB: the command is sent, and fails since the bucket does not allow siblings
+	if err := cluster.Execute(legacy); err == nil {
+		t.Error("expected error")
+	} else if _, ok := err.(RiakError); !ok {
+		t.Errorf("expected RiakError, got %v", err)
+	}
+}
+
+func TestNodeDetectsServerVersionOnStart(t *testing.T) {
+	server, cluster := startTestServerCluster(t)
+	defer stopTestServerCluster(server, cluster)
+	server.SetServerVersion("2.1.4")
+
+	node, err := NewNode(&NodeOptions{RemoteAddress: server.Addr()})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := node.start(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer node.stop()
+	if v, ok := node.ServerVersion(); !ok || v != (Version{2, 1, 4}) {
+		t.Errorf("expected 2.1.4, got %v, %v", v, ok)
+	}
+
+	disabled, err := NewNode(&NodeOptions{
+		RemoteAddress:                 server.Addr(),
+		DisableServerVersionDetection: true,
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := disabled.start(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer disabled.stop()
+	if v, ok := disabled.ServerVersion(); ok {
+		t.Errorf("expected unknown version, got %v", v)
+	}
+}
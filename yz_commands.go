@@ -36,6 +36,10 @@ func (cmd *StoreIndexCommand) Name() string {
 	return "StoreIndex"
 }
 
+func (cmd *StoreIndexCommand) minimumVersion() Version {
+	return version2_0
+}
+
 func (cmd *StoreIndexCommand) constructPbRequest() (proto.Message, error) {
 	return cmd.protobuf, nil
 }
@@ -127,6 +131,10 @@ func (cmd *FetchIndexCommand) Name() string {
 	return "FetchIndex"
 }
 
+func (cmd *FetchIndexCommand) minimumVersion() Version {
+	return version2_0
+}
+
 func (cmd *FetchIndexCommand) constructPbRequest() (proto.Message, error) {
 	return cmd.protobuf, nil
 }
@@ -210,6 +218,10 @@ func (cmd *DeleteIndexCommand) Name() string {
 	return "DeleteIndex"
 }
 
+func (cmd *DeleteIndexCommand) minimumVersion() Version {
+	return version2_0
+}
+
 func (cmd *DeleteIndexCommand) constructPbRequest() (proto.Message, error) {
 	return cmd.protobuf, nil
 }
@@ -271,6 +283,10 @@ func (cmd *StoreSchemaCommand) Name() string {
 	return "StoreSchema"
 }
 
+func (cmd *StoreSchemaCommand) minimumVersion() Version {
+	return version2_0
+}
+
 func (cmd *StoreSchemaCommand) constructPbRequest() (proto.Message, error) {
 	return cmd.protobuf, nil
 }
@@ -339,6 +355,10 @@ func (cmd *FetchSchemaCommand) Name() string {
 	return "FetchSchema"
 }
 
+func (cmd *FetchSchemaCommand) minimumVersion() Version {
+	return version2_0
+}
+
 func (cmd *FetchSchemaCommand) constructPbRequest() (proto.Message, error) {
 	return cmd.protobuf, nil
 }